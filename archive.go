@@ -0,0 +1,94 @@
+package tokenizers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path"
+)
+
+// This file handles loading a Tokenizer from an entry in an archive file.
+
+// FromArchive creates a Tokenizer from the entry entryName (typically a `tokenizer.json` file) of the archive
+// in archivePath. The entry is extracted in memory, and then loaded with FromBytes.
+//
+// Supported archive formats are zip and tar.gz (or .tgz), detected from the contents of the file.
+// The entryName is matched against the full path of the entries in the archive, after cleaning (so
+// "./model/tokenizer.json" matches "model/tokenizer.json").
+func FromArchive(archivePath, entryName string) (*Tokenizer, error) {
+	contents, err := os.ReadFile(archivePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "FromArchive(%q, %q) can't read archive file", archivePath, entryName)
+	}
+	var data []byte
+	switch {
+	case bytes.HasPrefix(contents, []byte("PK\x03\x04")):
+		data, err = readZipEntry(contents, entryName)
+	case bytes.HasPrefix(contents, []byte{0x1f, 0x8b}):
+		data, err = readTarGzEntry(contents, entryName)
+	default:
+		err = errors.New("unknown archive format, only zip and tar.gz are supported")
+	}
+	if err != nil {
+		return nil, errors.WithMessagef(err, "FromArchive(%q, %q)", archivePath, entryName)
+	}
+	return FromBytes(data)
+}
+
+// readZipEntry returns the contents of the entry entryName from the zip archive in contents.
+func readZipEntry(contents []byte, entryName string) ([]byte, error) {
+	reader, err := zip.NewReader(bytes.NewReader(contents), int64(len(contents)))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read zip archive")
+	}
+	entryName = path.Clean(entryName)
+	for _, file := range reader.File {
+		if path.Clean(file.Name) != entryName || file.FileInfo().IsDir() {
+			continue
+		}
+		entry, err := file.Open()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to open zip entry %q", file.Name)
+		}
+		defer func() { _ = entry.Close() }()
+		data, err := io.ReadAll(entry)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read zip entry %q", file.Name)
+		}
+		return data, nil
+	}
+	return nil, errors.Errorf("entry %q not found in zip archive", entryName)
+}
+
+// readTarGzEntry returns the contents of the entry entryName from the tar.gz archive in contents.
+func readTarGzEntry(contents []byte, entryName string) ([]byte, error) {
+	gzReader, err := gzip.NewReader(bytes.NewReader(contents))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read gzip compressed archive")
+	}
+	defer func() { _ = gzReader.Close() }()
+	reader := tar.NewReader(gzReader)
+	entryName = path.Clean(entryName)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read tar archive")
+		}
+		if header.Typeflag != tar.TypeReg || path.Clean(header.Name) != entryName {
+			continue
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read tar entry %q", header.Name)
+		}
+		return data, nil
+	}
+	return nil, errors.Errorf("entry %q not found in tar.gz archive", entryName)
+}
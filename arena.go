@@ -0,0 +1,62 @@
+package tokenizers
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// This file implements encoding into reusable arenas, to avoid allocations in hot loops.
+
+// encodingArena holds an Encoding whose storage is reused across calls to Tokenizer.EncodeThreadLocal.
+type encodingArena struct {
+	encoding Encoding
+
+	// generation is incremented when the arena is taken from the pool (becoming odd) and when it is released
+	// (becoming even again). Each release function only releases the generation it was created for, so a stale
+	// release (e.g. called twice) can't release the arena while it is used by a later call.
+	generation atomic.Uint64
+}
+
+// newRelease takes the arena for a new generation, and returns the function that releases it.
+func (arena *encodingArena) newRelease() func() {
+	generation := arena.generation.Add(1)
+	return func() {
+		if arena.generation.CompareAndSwap(generation, generation+1) {
+			encodingArenas.Put(arena)
+		}
+	}
+}
+
+// encodingArenas is the pool of arenas used by Tokenizer.EncodeThreadLocal. sync.Pool keeps per-processor
+// caches, so in steady state each goroutine gets back the arena it last released.
+var encodingArenas = sync.Pool{New: func() any { return &encodingArena{} }}
+
+// EncodeThreadLocal is like Encode, but the returned Encoding is backed by a reusable arena, and it returns a
+// release function that must be called once the caller is done with the Encoding.
+//
+// After release is called, the arena is reused by future calls to EncodeThreadLocal (typically the next call
+// in the same goroutine, hence the name), so in steady state encoding does (almost) no Go allocations. This is
+// an advanced API for hot loops, and its lifetime rules must be observed:
+//
+//   - The returned Encoding (and all its slices) is only valid until release is called. Copy whatever is needed
+//     out of it before calling release: any access to it afterward will see data from some other encoding.
+//   - Release must be called at most once per call; extra calls are ignored. Not calling it is safe, but the
+//     arena is then left to the garbage collector and the benefit is lost.
+//   - The returned Encoding must not be shared with other goroutines.
+//
+// If ReturnTokens is configured, each token string is still allocated. In case of error no arena is returned,
+// and release is nil.
+func (t *Tokenizer) EncodeThreadLocal(sentence string) (encoding *Encoding, release func(), err error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	arena := encodingArenas.Get().(*encodingArena)
+	release = arena.newRelease()
+	if err = t.encodeInto(sentence, &arena.encoding); err != nil {
+		release()
+		return nil, nil, err
+	}
+	return &arena.encoding, release, nil
+}
@@ -0,0 +1,644 @@
+package tokenizers
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/gomlx/tokenizers/internal/rs"
+	"github.com/pkg/errors"
+	"io"
+	"math"
+	"sort"
+)
+
+// This file holds variations of EncodeBatch.
+
+// DefaultSortedBatchBucketSize is the default number of sentences encoded together in each sub-batch (bucket) by
+// Tokenizer.EncodeBatchSorted, see Tokenizer.WithSortedBatchBucketSize.
+const DefaultSortedBatchBucketSize = 32
+
+// WithSortedBatchBucketSize sets the number of sentences encoded together in each sub-batch (bucket) by
+// EncodeBatchSorted. If size <= 0, all sentences are encoded in one bucket.
+// Default is DefaultSortedBatchBucketSize.
+//
+// It returns itself (the Tokenizer), to allow cascaded configuration calls.
+func (t *Tokenizer) WithSortedBatchBucketSize(size int) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	t.sortedBatchBucketSize = size
+	return t
+}
+
+// EncodeBatchSorted is like EncodeBatch, but it first sorts the sentences by length, and then encodes them in
+// sub-batches (buckets) of sentences of similar length (see WithSortedBatchBucketSize).
+//
+// This matters when padding to the longest sentence (PadLongest): each bucket is padded only to its own longest
+// sentence, so one very long outlier in the batch doesn't force padding of every other sentence to its length.
+// The length used for sorting is the length of the sentence in bytes, a cheap proxy of the number of tokens.
+//
+// The returned encodings are in the original order of the sentences. It also returns the permutation used:
+// `permutation[i]` is the index (in sentences) of the i-th shortest sentence. Both are empty for an empty batch.
+func (t *Tokenizer) EncodeBatchSorted(sentences []string) ([]Encoding, []int, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	if len(sentences) == 0 {
+		return nil, nil, nil
+	}
+	permutation := make([]int, len(sentences))
+	for ii := range permutation {
+		permutation[ii] = ii
+	}
+	sort.SliceStable(permutation, func(i, j int) bool {
+		return len(sentences[permutation[i]]) < len(sentences[permutation[j]])
+	})
+
+	bucketSize := t.sortedBatchBucketSize
+	if bucketSize <= 0 {
+		bucketSize = len(sentences)
+	}
+	results := make([]Encoding, len(sentences))
+	bucket := make([]string, 0, bucketSize)
+	for start := 0; start < len(sentences); start += bucketSize {
+		end := min(start+bucketSize, len(sentences))
+		bucket = bucket[:0]
+		for _, idx := range permutation[start:end] {
+			bucket = append(bucket, sentences[idx])
+		}
+		preprocessed := t.preprocessBatch(bucket)
+		encodings, err := t.tokenizer.EncodeBatch(preprocessed, t.internalEncodeParams())
+		if err != nil {
+			return nil, nil, errors.WithMessagef(err, "Tokenizer.EncodeBatchSorted(): encoding sentences %d to %d (sorted order)", start, end)
+		}
+		for ii, idx := range permutation[start:end] {
+			if err = t.completeEncoding(&encodings[ii], bucket[ii], preprocessed[ii]); err != nil {
+				return nil, nil, errors.WithMessagef(err, "Tokenizer.EncodeBatchSorted(): sentence #%d", idx)
+			}
+			results[idx] = encodings[ii]
+		}
+	}
+	return results, permutation, nil
+}
+
+// DefaultEncodeBatchChunkSize is the default number of sentences encoded at a time by Tokenizer.EncodeBatchContext
+// and Tokenizer.EncodeBatchWithProgress, see Tokenizer.WithEncodeBatchChunkSize.
+const DefaultEncodeBatchChunkSize = 1024
+
+// WithEncodeBatchChunkSize sets the number of sentences encoded at a time by EncodeBatchContext (between checks for
+// the cancellation of the context) and EncodeBatchWithProgress (between calls to the progress function).
+// If size <= 0, all sentences are encoded at once.
+// Default is DefaultEncodeBatchChunkSize.
+//
+// It returns itself (the Tokenizer), to allow cascaded configuration calls.
+func (t *Tokenizer) WithEncodeBatchChunkSize(size int) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	t.encodeBatchChunkSize = size
+	return t
+}
+
+// EncodeBatchContext is like EncodeBatch, but the sentences are encoded in chunks (see WithEncodeBatchChunkSize),
+// and ctx is checked between chunks: if it is cancelled (or times out), the remaining chunks are not processed,
+// and it returns ctx.Err() (wrapped with the position where it stopped, use errors.Is to check for it),
+// discarding the encodings done so far.
+//
+// Chunking is the cancellation mechanism: the call to the underlying (Rust) library that encodes a chunk can't
+// be preempted, so cancellation takes effect only once the chunk in progress finishes. Use a smaller
+// chunk size for a faster response to cancellation, at the cost of some throughput.
+//
+// With PadLongest (see WithPadToLongest) all encodings are padded to the longest one, as in EncodeBatch.
+func (t *Tokenizer) EncodeBatchContext(ctx context.Context, sentences []string) ([]Encoding, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	encodings, err := t.encodeBatchChunked(ctx, sentences, nil)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Tokenizer.EncodeBatchContext():")
+	}
+	return encodings, nil
+}
+
+// EncodeBatchCtx is the same as EncodeBatchContext.
+//
+// Deprecated: use EncodeBatchContext, named after the convention of the standard library (e.g.
+// `net.Dialer.DialContext`) for the context-aware variants of a method.
+func (t *Tokenizer) EncodeBatchCtx(ctx context.Context, sentences []string) ([]Encoding, error) {
+	return t.EncodeBatchContext(ctx, sentences)
+}
+
+// EncodeBatchWithProgress is like EncodeBatch, but the sentences are encoded in chunks (see
+// WithEncodeBatchChunkSize), and fn is called after each chunk with the number of sentences encoded so far and
+// the total, e.g. to display a progress bar. The last call has done == total.
+//
+// fn is called while the Tokenizer is read-locked, so it must not change the Tokenizer configuration.
+//
+// With PadLongest (see WithPadToLongest) all encodings are padded to the longest one, as in EncodeBatch.
+func (t *Tokenizer) EncodeBatchWithProgress(sentences []string, fn func(done, total int)) ([]Encoding, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	encodings, err := t.encodeBatchChunked(context.Background(), sentences, fn)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Tokenizer.EncodeBatchWithProgress():")
+	}
+	return encodings, nil
+}
+
+// encodeBatchChunked implements EncodeBatchContext and EncodeBatchWithProgress, without locking.
+// progressFn is optional.
+//
+// With PadLongest each chunk is padded by the underlying library to its own longest sentence, so the encodings
+// are padded again at the end to the longest of all chunks.
+func (t *Tokenizer) encodeBatchChunked(ctx context.Context, sentences []string,
+	progressFn func(done, total int)) ([]Encoding, error) {
+	chunkSize := t.encodeBatchChunkSize
+	if chunkSize <= 0 {
+		chunkSize = max(len(sentences), 1)
+	}
+	results := make([]Encoding, 0, len(sentences))
+	for start := 0; start < len(sentences); start += chunkSize {
+		if err := ctx.Err(); err != nil {
+			return nil, errors.Wrapf(err, "cancelled after encoding %d of %d sentences", start, len(sentences))
+		}
+		end := min(start+chunkSize, len(sentences))
+		encodings, err := t.encodeBatch(sentences[start:end])
+		if err != nil {
+			return nil, errors.WithMessagef(err, "encoding sentences %d to %d", start, end)
+		}
+		results = append(results, encodings...)
+		if progressFn != nil {
+			progressFn(end, len(sentences))
+		}
+	}
+	if t.isPaddingSet && t.paddingStrategy == PadLongest {
+		longest := 0
+		for ii := range results {
+			longest = max(longest, len(results[ii].TokenIds))
+		}
+		for ii := range results {
+			t.padEncoding(&results[ii], longest)
+		}
+	}
+	return results, nil
+}
+
+// padEncoding pads the encoding (already padded, or not) to the given length with the configured padding, as the
+// underlying library does: the padding tokens are special tokens, not attended to, and not associated to any word
+// or sequence. Only the fields configured to be returned are padded.
+func (t *Tokenizer) padEncoding(encoding *Encoding, length int) {
+	numPadding := length - len(encoding.TokenIds)
+	if numPadding <= 0 {
+		return
+	}
+	left := t.paddingDirection == Left
+	params := t.encodeParams
+	encoding.TokenIds = padSlice(encoding.TokenIds, true, numPadding, t.padId, left)
+	encoding.TypeIds = padSlice(encoding.TypeIds, params.ReturnTypeIds, numPadding, t.padTypeId, left)
+	encoding.SpecialTokensMask = padSlice(encoding.SpecialTokensMask, params.ReturnSpecialTokensMask, numPadding, 1, left)
+	encoding.AttentionMask = padSlice(encoding.AttentionMask, params.ReturnAttentionMask, numPadding, 0, left)
+	encoding.Tokens = padSlice(encoding.Tokens, params.ReturnTokens, numPadding, t.padToken, left)
+	encoding.Offsets = padSlice(encoding.Offsets, params.ReturnOffsets || t.returnBothOffsets, numPadding, Offset{}, left)
+	encoding.WordIds = padSlice(encoding.WordIds, params.ReturnWordIds, numPadding, -1, left)
+	encoding.SequenceIds = padSlice(encoding.SequenceIds, params.ReturnSequenceIds, numPadding, -1, left)
+	encoding.ContinuationMask = padSlice(encoding.ContinuationMask, t.returnContinuationMask, numPadding, false, left)
+	encoding.FirstSubwordMask = padSlice(encoding.FirstSubwordMask, t.returnFirstSubwordMask, numPadding, false, left)
+	if t.returnTokenHashes {
+		encoding.TokenHashes = padSlice(encoding.TokenHashes, true, numPadding, tokenHashes([]string{t.padToken})[0], left)
+	}
+	encoding.CharOffsets = padSlice(encoding.CharOffsets, t.returnBothOffsets, numPadding, Offset{}, left)
+	encoding.TokenKinds = padSlice(encoding.TokenKinds, t.returnTokenKinds, numPadding, TokenKindSpecial, left)
+}
+
+// padSlice returns values padded with numPadding copies of value, on the left or on the right. values is returned
+// unchanged if the field is not present.
+func padSlice[T any](values []T, present bool, numPadding int, value T, left bool) []T {
+	if !present {
+		return values
+	}
+	padded := make([]T, 0, len(values)+numPadding)
+	if !left {
+		padded = append(padded, values...)
+	}
+	for ii := 0; ii < numPadding; ii++ {
+		padded = append(padded, value)
+	}
+	if left {
+		padded = append(padded, values...)
+	}
+	return padded
+}
+
+// DefaultEncodeStreamChunkSize is the default number of lines encoded at a time by Tokenizer.EncodeStream, see
+// Tokenizer.WithEncodeStreamChunkSize.
+const DefaultEncodeStreamChunkSize = 256
+
+// DefaultEncodeStreamMaxLineSize is the default maximum size of a line, in bytes, read by Tokenizer.EncodeStream,
+// see Tokenizer.WithEncodeStreamMaxLineSize.
+const DefaultEncodeStreamMaxLineSize = 1024 * 1024
+
+// WithEncodeStreamChunkSize sets the number of lines encoded at a time by EncodeStream. Values < 1 are taken
+// as 1.
+// Default is DefaultEncodeStreamChunkSize.
+//
+// It returns itself (the Tokenizer), to allow cascaded configuration calls.
+func (t *Tokenizer) WithEncodeStreamChunkSize(size int) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	t.encodeStreamChunkSize = size
+	return t
+}
+
+// WithEncodeStreamMaxLineSize sets the maximum size of a line, in bytes, read by EncodeStream. Longer lines make
+// it fail with bufio.ErrTooLong.
+// Default is DefaultEncodeStreamMaxLineSize.
+//
+// It returns itself (the Tokenizer), to allow cascaded configuration calls.
+func (t *Tokenizer) WithEncodeStreamMaxLineSize(size int) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	t.encodeStreamMaxLineSize = size
+	return t
+}
+
+// EncodeStream reads r line by line, e.g. a large corpus file, encodes each line and calls fn with its line number
+// (starting from 1) and its encoding, in order. The line terminators ("\n" or "\r\n") are not encoded.
+//
+// The lines are encoded in chunks (see WithEncodeStreamChunkSize), reusing the same buffer, to amortize the cost of the
+// calls to the underlying (Rust) library, so memory usage doesn't grow with the size of r. The encoding passed to
+// fn is not reused, and fn can use the Tokenizer.
+//
+// It stops at the first error returned by fn, and returns it. Errors reading r (including lines longer than
+// the limit set with WithEncodeStreamMaxLineSize) or encoding are returned with the line number where they happened.
+func (t *Tokenizer) EncodeStream(r io.Reader, fn func(lineNum int, enc *Encoding) error) error {
+	t.mu.RLock()
+	chunkSize, maxLineSize := max(t.encodeStreamChunkSize, 1), t.encodeStreamMaxLineSize
+	t.mu.RUnlock()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, min(64*1024, maxLineSize)), maxLineSize)
+	lines := make([]string, 0, chunkSize)
+	firstLineNum := 1
+	encodeLines := func() error {
+		if len(lines) == 0 {
+			return nil
+		}
+		t.mu.RLock()
+		if t.tokenizer == nil {
+			t.mu.RUnlock()
+			panicf("Tokenizer already finalized, one cannot change or use it any longer")
+		}
+		encodings, err := t.encodeBatch(lines)
+		t.mu.RUnlock()
+		if err != nil {
+			return errors.WithMessagef(err, "Tokenizer.EncodeStream(): encoding lines %d to %d",
+				firstLineNum, firstLineNum+len(lines)-1)
+		}
+		for ii := range encodings {
+			if err = fn(firstLineNum+ii, &encodings[ii]); err != nil {
+				return err
+			}
+		}
+		firstLineNum += len(lines)
+		lines = lines[:0]
+		return nil
+	}
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		if len(lines) == chunkSize {
+			if err := encodeLines(); err != nil {
+				return err
+			}
+		}
+	}
+	// Lines read before a reading error are still encoded.
+	if err := encodeLines(); err != nil {
+		return err
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrapf(err, "Tokenizer.EncodeStream(): reading line %d", firstLineNum)
+	}
+	return nil
+}
+
+// WithDedupCopies configures whether EncodeBatchDedup returns independent copies (see Encoding.Clone) for the
+// repeated sentences. If false (the default), they share the same slices.
+//
+// It returns itself (the Tokenizer), to allow cascaded configuration calls.
+func (t *Tokenizer) WithDedupCopies(value bool) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	t.dedupCopies = value
+	return t
+}
+
+// EncodeBatchDedup is like EncodeBatch, but it encodes each distinct sentence only once, and maps the results
+// back to all the positions where it appears. It saves work for batches with many repeated sentences.
+//
+// By default, the encodings of repeated sentences share the same underlying slices, so modifying one (e.g. the
+// TokenIds) modifies the others: treat them as read-only, or use WithDedupCopies to get independent copies.
+//
+// Errors about a sentence refer to the index of its first occurrence in sentences.
+func (t *Tokenizer) EncodeBatchDedup(sentences []string) ([]Encoding, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	uniqueIndices := make(map[string]int, len(sentences))
+	uniqueSentences := make([]string, 0, len(sentences))
+	firstPositions := make([]int, 0, len(sentences)) // Index in sentences of the first occurrence of each unique.
+	positions := make([]int, len(sentences))
+	for ii, sentence := range sentences {
+		idx, found := uniqueIndices[sentence]
+		if !found {
+			idx = len(uniqueSentences)
+			uniqueIndices[sentence] = idx
+			uniqueSentences = append(uniqueSentences, sentence)
+			firstPositions = append(firstPositions, ii)
+		}
+		positions[ii] = idx
+	}
+	preprocessed := t.preprocessBatch(uniqueSentences)
+	encodings, err := t.tokenizer.EncodeBatch(preprocessed, t.internalEncodeParams())
+	if err != nil {
+		return nil, errors.WithMessage(err, "Tokenizer.EncodeBatchDedup():")
+	}
+	for idx := range encodings {
+		if err = t.completeEncoding(&encodings[idx], uniqueSentences[idx], preprocessed[idx]); err != nil {
+			return nil, errors.WithMessagef(err, "Tokenizer.EncodeBatchDedup(): sentence #%d", firstPositions[idx])
+		}
+	}
+	results := make([]Encoding, len(sentences))
+	used := make([]bool, len(encodings))
+	for ii, idx := range positions {
+		if used[idx] && t.dedupCopies {
+			results[ii] = encodings[idx].Clone()
+		} else {
+			results[ii] = encodings[idx]
+		}
+		used[idx] = true
+	}
+	return results, nil
+}
+
+// DefaultTokenCountsChunkSize is the default number of sentences encoded at a time by Tokenizer.TokenCounts and
+// Tokenizer.TokenCountsInto, see Tokenizer.WithTokenCountsChunkSize.
+const DefaultTokenCountsChunkSize = 1024
+
+// WithTokenCountsChunkSize sets the number of sentences encoded at a time by TokenCounts and TokenCountsInto.
+// If size <= 0, all sentences are encoded at once.
+// Default is DefaultTokenCountsChunkSize.
+//
+// It returns itself (the Tokenizer), to allow cascaded configuration calls.
+func (t *Tokenizer) WithTokenCountsChunkSize(size int) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	t.tokenCountsChunkSize = size
+	return t
+}
+
+// TokenCounts encodes the sentences and returns the number of occurrences of each token id.
+// Padding tokens are not counted, and special tokens are counted only if configured to be added (see
+// AddSpecialTokens).
+//
+// The sentences are encoded in chunks (see WithTokenCountsChunkSize), reusing the same buffers, so memory usage
+// doesn't grow with the number of sentences. See TokenCountsInto to accumulate counts over a large corpus
+// read in parts.
+func (t *Tokenizer) TokenCounts(sentences []string) (map[uint32]int, error) {
+	counts := make(map[uint32]int)
+	if err := t.TokenCountsInto(counts, sentences); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// TokenCountsInto is like TokenCounts, but it adds the counts to the given counts map. It can be used to
+// stream a large corpus, calling it once per part.
+func (t *Tokenizer) TokenCountsInto(counts map[uint32]int, sentences []string) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	chunkSize := t.tokenCountsChunkSize
+	if chunkSize <= 0 {
+		chunkSize = len(sentences)
+	}
+	encodeParams := rs.EncodeParams{
+		AddSpecialTokens:    t.encodeParams.AddSpecialTokens,
+		ReturnAttentionMask: true,
+	}
+	encodings := make([]Encoding, min(chunkSize, len(sentences)))
+	for start := 0; start < len(sentences); start += chunkSize {
+		end := min(start+chunkSize, len(sentences))
+		err := t.tokenizer.EncodeBatchInto(t.preprocessBatch(sentences[start:end]), encodeParams, encodings)
+		if err != nil {
+			return errors.WithMessagef(err, "Tokenizer.TokenCounts(): encoding sentences %d to %d", start, end)
+		}
+		for _, encoding := range encodings[:end-start] {
+			for ii, id := range encoding.TokenIds {
+				if encoding.AttentionMask[ii] != 0 {
+					counts[id]++
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// BatchSummary returns a compact one-line summary of the shape of a batch of encodings, for logging. E.g.:
+//
+//	batch=32 minLen=5 maxLen=128 meanLen=37.2 padded=128 paddingTokens=2904 totalTokens=4096
+//
+// Where the lengths (min, max and mean) are the number of real tokens (see Encoding.NumRealTokens), padded is the
+// length of the longest encoding including padding, and totalTokens is the number of tokens in the batch,
+// including padding. Padding is derived from the attention mask, so it is only accounted for if it was returned.
+func (t *Tokenizer) BatchSummary(encodings []Encoding) string {
+	if len(encodings) == 0 {
+		return "batch=0"
+	}
+	minLen, maxLen, sumLen, padded, totalTokens := len(encodings[0].TokenIds), 0, 0, 0, 0
+	for ii := range encodings {
+		length := encodings[ii].NumRealTokens()
+		minLen = min(minLen, length)
+		maxLen = max(maxLen, length)
+		sumLen += length
+		padded = max(padded, len(encodings[ii].TokenIds))
+		totalTokens += len(encodings[ii].TokenIds)
+	}
+	return fmt.Sprintf("batch=%d minLen=%d maxLen=%d meanLen=%.1f padded=%d paddingTokens=%d totalTokens=%d",
+		len(encodings), minLen, maxLen, float64(sumLen)/float64(len(encodings)), padded, totalTokens-sumLen, totalTokens)
+}
+
+// PaddedLengthFor returns the length the encodings of the batch of sentences would have with the current
+// padding configuration, e.g. to preallocate tensors before encoding:
+//
+//   - With PadLongest (WithPadToLongest), it's the length of the longest encoding, rounded up to the multiple
+//     configured with WithPaddingToMultipleOf.
+//   - With PadFixed (WithPadToLength), it's the fixed length, or the length of the longest encoding if longer
+//     (e.g. if truncation is not configured), also rounded up.
+//   - Without padding, it's the length of the longest encoding.
+//
+// The sentences are encoded (with truncation, if configured), but only their lengths are collected.
+func (t *Tokenizer) PaddedLengthFor(sentences []string) (int, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	encodings, err := t.tokenizer.EncodeBatch(t.preprocessBatch(sentences), rs.EncodeParams{
+		AddSpecialTokens:    t.encodeParams.AddSpecialTokens,
+		ReturnAttentionMask: true,
+	})
+	if err != nil {
+		return 0, errors.WithMessage(err, "Tokenizer.PaddedLengthFor():")
+	}
+	length := 0
+	for ii := range encodings {
+		length = max(length, encodings[ii].NumRealTokens())
+	}
+	if !t.isPaddingSet {
+		return length, nil
+	}
+	if t.paddingStrategy == PadFixed {
+		length = max(length, int(t.paddingLength))
+	}
+	if multiple := int(t.padToMultipleOf); multiple > 1 && length%multiple != 0 {
+		length += multiple - length%multiple
+	}
+	return length, nil
+}
+
+// EncodeBatchToArrays encodes the sentences and returns the token ids, type ids and attention mask as contiguous
+// row-major buffers of shape `[batch, seqLen]`, ready to be used as tensors, e.g.: the token id of the token j of
+// the sentence i is `ids[i*seqLen+j]`.
+//
+// seqLen is the length of the longest encoding or, with PadFixed (see WithPadToLength), the fixed length if
+// longer, rounded up to the multiple configured with WithPaddingToMultipleOf. Shorter encodings are padded
+// with the configured padding (see WithPadId, WithPadTypeId and WithPaddingDirection) or, if padding is not
+// configured, on the right with the id 0. The attention mask is 0 for padding tokens.
+//
+// The fields configured to be returned (see ReturnTypeIds and ReturnAttentionMask) are not relevant: the type ids
+// and the attention mask are always returned.
+func (t *Tokenizer) EncodeBatchToArrays(sentences []string) (ids, typeIds, attentionMask []uint32,
+	batch, seqLen int, err error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	encodings, err := t.tokenizer.EncodeBatch(t.preprocessBatch(sentences), rs.EncodeParams{
+		AddSpecialTokens:    t.encodeParams.AddSpecialTokens,
+		ReturnTypeIds:       true,
+		ReturnAttentionMask: true,
+	})
+	if err != nil {
+		return nil, nil, nil, 0, 0, errors.WithMessage(err, "Tokenizer.EncodeBatchToArrays():")
+	}
+	batch = len(encodings)
+	for ii := range encodings {
+		seqLen = max(seqLen, len(encodings[ii].TokenIds))
+	}
+	var padId, padTypeId uint32
+	padLeft := false
+	if t.isPaddingSet {
+		if t.paddingStrategy == PadFixed {
+			seqLen = max(seqLen, int(t.paddingLength))
+		}
+		if multiple := int(t.padToMultipleOf); multiple > 1 && seqLen%multiple != 0 {
+			seqLen += multiple - seqLen%multiple
+		}
+		padId, padTypeId, padLeft = t.padId, t.padTypeId, t.paddingDirection == Left
+	}
+
+	ids = make([]uint32, batch*seqLen)
+	typeIds = make([]uint32, batch*seqLen)
+	attentionMask = make([]uint32, batch*seqLen)
+	for ii := range encodings {
+		encoding := &encodings[ii]
+		row := ii * seqLen
+		numPadding := seqLen - len(encoding.TokenIds)
+		start := row
+		if padLeft {
+			start += numPadding
+		}
+		copy(ids[start:], encoding.TokenIds)
+		copy(typeIds[start:], encoding.TypeIds)
+		copy(attentionMask[start:], encoding.AttentionMask)
+		padStart := row + len(encoding.TokenIds)
+		if padLeft {
+			padStart = row
+		}
+		for jj := padStart; jj < padStart+numPadding; jj++ {
+			ids[jj], typeIds[jj] = padId, padTypeId
+		}
+	}
+	return ids, typeIds, attentionMask, batch, seqLen, nil
+}
+
+// AdditiveAttentionMask converts the attention masks of a (padded) batch of encodings to the additive form used
+// by Transformer models: 0 for the tokens attended to, and the lowest finite value of T (e.g. `-math.MaxFloat32`)
+// for the masked (padding) tokens, to be added to the attention logits. T is the float type of the mask.
+//
+// It returns the mask flattened in row-major order, and its shape `[batchSize, 1, 1, seqLen]`, ready to be
+// broadcast over the attention heads and query positions.
+//
+// The encodings must have the attention mask (see Tokenizer.ReturnAttentionMask) and all have the same
+// length (see Tokenizer.WithPadToLongest), otherwise an error is returned.
+func AdditiveAttentionMask[T float32 | float64](encodings []Encoding) (mask []T, shape []int, err error) {
+	seqLen := 0
+	if len(encodings) > 0 {
+		seqLen = len(encodings[0].TokenIds)
+	}
+	var masked T
+	switch any(masked).(type) {
+	case float32:
+		masked = T(-math.MaxFloat32)
+	default:
+		maxFloat64 := math.MaxFloat64 // Variable, so the conversion is not checked for float32 at compile time.
+		masked = T(-maxFloat64)
+	}
+	mask = make([]T, 0, len(encodings)*seqLen)
+	for ii := range encodings {
+		attentionMask := encodings[ii].AttentionMask
+		if len(encodings[ii].TokenIds) != seqLen {
+			return nil, nil, errors.Errorf("AdditiveAttentionMask(): encoding #%d has length %d, but encoding #0 "+
+				"has length %d -- pad the batch to the same length", ii, len(encodings[ii].TokenIds), seqLen)
+		}
+		if len(attentionMask) != seqLen {
+			return nil, nil, errors.Errorf("AdditiveAttentionMask(): encoding #%d has no attention mask -- "+
+				"configure the Tokenizer with ReturnAttentionMask(true)", ii)
+		}
+		for _, value := range attentionMask {
+			if value != 0 {
+				mask = append(mask, 0)
+			} else {
+				mask = append(mask, masked)
+			}
+		}
+	}
+	return mask, []int{len(encodings), 1, 1, seqLen}, nil
+}
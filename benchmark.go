@@ -0,0 +1,88 @@
+package tokenizers
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// This file implements Benchmark, to measure the performance of a Tokenizer in a standard way.
+
+// DefaultBenchmarkDuration is the minimum time Benchmark spends encoding the inputs, if none is given.
+const DefaultBenchmarkDuration = time.Second
+
+// BenchmarkResult holds the performance of a Tokenizer measured by Benchmark.
+type BenchmarkResult struct {
+	// Encodes is the number of sentences encoded, and Tokens the total number of tokens they produced.
+	Encodes, Tokens int
+
+	// Elapsed is the total time spent encoding.
+	Elapsed time.Duration
+
+	// EncodesPerSec and TokensPerSec are the throughput, in sentences and in tokens per second.
+	EncodesPerSec, TokensPerSec float64
+
+	// AllocsPerEncode and BytesPerEncode are the average number of Go heap allocations, and the bytes allocated,
+	// per encoded sentence. Memory allocated by the Rust library is not included.
+	AllocsPerEncode, BytesPerEncode float64
+
+	// Err is set if encoding one of the inputs failed, in which case the benchmark is interrupted and the other
+	// fields are not set.
+	Err error
+}
+
+// String implements fmt.Stringer, in the format of the Go benchmarks.
+func (r BenchmarkResult) String() string {
+	if r.Err != nil {
+		return fmt.Sprintf("Benchmark failed: %v", r.Err)
+	}
+	return fmt.Sprintf("%d encodes in %s\t%.1f encodes/s\t%.1f tokens/s\t%.1f allocs/encode\t%.1f B/encode",
+		r.Encodes, r.Elapsed, r.EncodesPerSec, r.TokensPerSec, r.AllocsPerEncode, r.BytesPerEncode)
+}
+
+// Benchmark measures the performance of encoding the inputs with the Tokenizer t, with its current configuration.
+// It can be used to compare different tokenizers, or configurations of the same one, and to report performance
+// in a standard way.
+//
+// The inputs are encoded once to warm up, and then repeatedly, one at a time, for at least minDuration, or
+// DefaultBenchmarkDuration if minDuration <= 0.
+// The allocations are measured for the whole process, so other goroutines running concurrently affect them.
+func Benchmark(t *Tokenizer, inputs []string, minDuration time.Duration) BenchmarkResult {
+	var r BenchmarkResult
+	if len(inputs) == 0 {
+		return r
+	}
+	if minDuration <= 0 {
+		minDuration = DefaultBenchmarkDuration
+	}
+	for _, input := range inputs {
+		if _, err := t.Encode(input); err != nil {
+			r.Err = err
+			return r
+		}
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for r.Elapsed < minDuration {
+		for _, input := range inputs {
+			encoding, err := t.Encode(input)
+			if err != nil {
+				return BenchmarkResult{Err: err}
+			}
+			r.Tokens += len(encoding.TokenIds)
+		}
+		r.Encodes += len(inputs)
+		r.Elapsed = time.Since(start)
+	}
+	runtime.ReadMemStats(&after)
+
+	seconds := r.Elapsed.Seconds()
+	r.EncodesPerSec = float64(r.Encodes) / seconds
+	r.TokensPerSec = float64(r.Tokens) / seconds
+	r.AllocsPerEncode = float64(after.Mallocs-before.Mallocs) / float64(r.Encodes)
+	r.BytesPerEncode = float64(after.TotalAlloc-before.TotalAlloc) / float64(r.Encodes)
+	return r
+}
@@ -0,0 +1,188 @@
+package tokenizers
+
+import (
+	"github.com/pkg/errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// This file implements the garbage collection of the cache of downloaded files.
+
+// CleanOptions configures CleanCache.
+type CleanOptions struct {
+	// RemoveUnreferencedSnapshots also removes the snapshots not referenced by any ref (e.g. "main"),
+	// typically left behind when a revision moves on to a newer commit. Their blobs are then removed as well,
+	// if not used by other snapshots.
+	RemoveUnreferencedSnapshots bool
+
+	// DryRun only reports the number of bytes that would be freed, without removing anything.
+	DryRun bool
+}
+
+// CleanCache removes from the cache in cacheDir (see DefaultCacheDir) the blobs that are not referenced by any
+// snapshot and, if configured in opts, the snapshots not referenced by any ref. It mirrors
+// `huggingface-cli delete-cache`.
+//
+// Blobs being downloaded (whose lock is held) are not removed. Notice only locks next to the blobs are checked:
+// if downloads use a separate lock directory (see PretrainedConfig.LockDir), don't run it concurrently with them.
+//
+// It returns the number of bytes freed, or that would be freed if opts.DryRun is set.
+func CleanCache(cacheDir string, opts CleanOptions) (freed int64, err error) {
+	if cacheDir == "" {
+		return 0, errors.New("CleanCache() requires a cacheDir")
+	}
+	cacheDir = path.Clean(cacheDir)
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		return 0, errors.Wrapf(err, "CleanCache(%q) failed to read cache directory", cacheDir)
+	}
+	for _, entry := range entries {
+		storageDir := path.Join(cacheDir, entry.Name())
+		if !entry.IsDir() || !FileExists(path.Join(storageDir, "blobs")) {
+			continue
+		}
+		var repoFreed int64
+		repoFreed, err = cleanRepoCache(storageDir, opts)
+		freed += repoFreed
+		if err != nil {
+			return freed, errors.WithMessagef(err, "CleanCache(%q)", cacheDir)
+		}
+	}
+	return freed, nil
+}
+
+// cleanRepoCache implements CleanCache for the storage directory of one repository.
+func cleanRepoCache(storageDir string, opts CleanOptions) (freed int64, err error) {
+	snapshotsDir := path.Join(storageDir, "snapshots")
+	blobsDir := path.Join(storageDir, "blobs")
+
+	// Remove snapshots not referenced by any ref.
+	var referenced map[string]bool
+	if opts.RemoveUnreferencedSnapshots {
+		referenced, err = readReferencedCommits(storageDir)
+		if err != nil {
+			return 0, err
+		}
+		snapshots, err := os.ReadDir(snapshotsDir)
+		if err != nil && !os.IsNotExist(err) {
+			return 0, errors.Wrapf(err, "failed reading %q", snapshotsDir)
+		}
+		for _, snapshot := range snapshots {
+			if referenced[snapshot.Name()] || opts.DryRun {
+				continue
+			}
+			snapshotPath := path.Join(snapshotsDir, snapshot.Name())
+			if err = os.RemoveAll(snapshotPath); err != nil {
+				return 0, errors.Wrapf(err, "failed removing unreferenced snapshot %q", snapshotPath)
+			}
+		}
+	}
+
+	// Collect the blobs used by the (remaining) snapshots.
+	used := make(map[string]bool)
+	err = filepath.WalkDir(snapshotsDir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if referenced != nil && filepath.Dir(filePath) == filepath.Clean(snapshotsDir) && !referenced[d.Name()] {
+				// Unreferenced snapshot (only still there in DryRun mode).
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+		target, err := os.Readlink(filePath)
+		if err != nil {
+			return errors.Wrapf(err, "failed reading link %q", filePath)
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(filePath), target)
+		}
+		if filepath.Dir(target) == filepath.Clean(blobsDir) {
+			used[filepath.Base(target)] = true
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(errors.Cause(err)) {
+		return 0, err
+	}
+
+	// Remove the blobs not used.
+	blobs, err := os.ReadDir(blobsDir)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed reading %q", blobsDir)
+	}
+	for _, blob := range blobs {
+		if blob.IsDir() || used[blob.Name()] || strings.HasSuffix(blob.Name(), ".lock") {
+			continue
+		}
+		info, err := blob.Info()
+		if err != nil {
+			return freed, errors.Wrapf(err, "failed reading %q", blob.Name())
+		}
+		removed, err := removeBlobIfUnlocked(path.Join(blobsDir, blob.Name()), opts.DryRun)
+		if err != nil {
+			return freed, err
+		}
+		if removed {
+			freed += info.Size()
+		}
+	}
+	return freed, nil
+}
+
+// readReferencedCommits returns the set of commit hashes referenced by the refs of the repository in storageDir.
+func readReferencedCommits(storageDir string) (map[string]bool, error) {
+	referenced := make(map[string]bool)
+	refsDir := path.Join(storageDir, "refs")
+	err := filepath.WalkDir(refsDir, func(refPath string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		contents, err := os.ReadFile(refPath)
+		if err != nil {
+			return errors.Wrapf(err, "failed reading %q", refPath)
+		}
+		referenced[strings.TrimSpace(string(contents))] = true
+		return nil
+	})
+	if err != nil && !os.IsNotExist(errors.Cause(err)) {
+		return nil, err
+	}
+	return referenced, nil
+}
+
+// removeBlobIfUnlocked removes the blob, unless it is being downloaded, that is, its lock is held by someone else.
+// It returns whether the blob was removed (or would be, if dryRun is set).
+func removeBlobIfUnlocked(blobPath string, dryRun bool) (removed bool, err error) {
+	lockPath := blobPath + ".lock"
+	if FileExists(lockPath) {
+		f, err := os.OpenFile(lockPath, os.O_APPEND|os.O_WRONLY, DefaultFileCreationPerm)
+		if err != nil {
+			return false, errors.Wrapf(err, "while locking %q", lockPath)
+		}
+		defer f.Close()
+		locked, err := tryLockFile(f)
+		if err != nil {
+			return false, errors.Wrapf(err, "while locking %q", lockPath)
+		}
+		if !locked {
+			// Being downloaded, leave it alone.
+			return false, nil
+		}
+		defer func() { _ = unlockFile(f) }()
+	}
+	if dryRun {
+		return true, nil
+	}
+	if err = os.Remove(blobPath); err != nil {
+		return false, errors.Wrapf(err, "failed removing unused blob %q", blobPath)
+	}
+	return true, nil
+}
@@ -0,0 +1,314 @@
+package tokenizers
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"math"
+)
+
+// This file handles parsing of the `tokenizer_config.json` files used by HuggingFace's pretrained tokenizers.
+
+// TokenizerConfig holds the fields of a HuggingFace `tokenizer_config.json` file.
+//
+// Only the most commonly used fields are parsed, the others are ignored. Fields that are not present in
+// the file are left with their zero value.
+type TokenizerConfig struct {
+	TokenizerClass string
+
+	// ModelMaxLength is the maximum length of the model inputs. It is 0 if not set, or if it is set to the
+	// "very large integer" sentinel (`int(1e30)`) used by HuggingFace Transformers to mean unbounded.
+	ModelMaxLength int
+
+	// PaddingSide and TruncationSide are either "left", "right" or "" if not set.
+	PaddingSide, TruncationSide string
+
+	AddBosToken, AddEosToken  bool
+	DoLowerCase               bool
+	CleanUpTokenizationSpaces bool
+
+	// Special tokens. They can be given in the file as a string or as an "AddedToken" object, in which
+	// case only its "content" is kept.
+	BosToken, EosToken, UnkToken, SepToken, PadToken, ClsToken, MaskToken string
+
+	// AdditionalSpecialTokens lists the other special tokens (e.g. "<ent>" markers), from the
+	// "additional_special_tokens" field. It is nil if not set.
+	AdditionalSpecialTokens []string
+
+	// ChatTemplate is the Jinja template used to format chats. If the file defines a list of named templates,
+	// this holds the one named "default", if present.
+	ChatTemplate string
+
+	// fromSpecialTokensMap holds the names of the special tokens fields (e.g. "bos_token") that were set from
+	// `special_tokens_map.json` (see mergeSpecialTokensMap), used to report their source.
+	fromSpecialTokensMap map[string]bool
+}
+
+// tokenizerConfigJSON is the raw format of TokenizerConfig, used for parsing fields that may come
+// in different formats.
+type tokenizerConfigJSON struct {
+	TokenizerClass            string            `json:"tokenizer_class"`
+	ModelMaxLength            json.Number       `json:"model_max_length"`
+	PaddingSide               string            `json:"padding_side"`
+	TruncationSide            string            `json:"truncation_side"`
+	AddBosToken               bool              `json:"add_bos_token"`
+	AddEosToken               bool              `json:"add_eos_token"`
+	DoLowerCase               bool              `json:"do_lower_case"`
+	CleanUpTokenizationSpaces bool              `json:"clean_up_tokenization_spaces"`
+	BosToken                  json.RawMessage   `json:"bos_token"`
+	EosToken                  json.RawMessage   `json:"eos_token"`
+	UnkToken                  json.RawMessage   `json:"unk_token"`
+	SepToken                  json.RawMessage   `json:"sep_token"`
+	PadToken                  json.RawMessage   `json:"pad_token"`
+	ClsToken                  json.RawMessage   `json:"cls_token"`
+	MaskToken                 json.RawMessage   `json:"mask_token"`
+	AdditionalSpecialTokens   []json.RawMessage `json:"additional_special_tokens"`
+	ChatTemplate              json.RawMessage   `json:"chat_template"`
+}
+
+// ParseTokenizerConfig parses the contents of a `tokenizer_config.json` file.
+func ParseTokenizerConfig(data []byte) (*TokenizerConfig, error) {
+	config := &TokenizerConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (c *TokenizerConfig) UnmarshalJSON(data []byte) error {
+	var raw tokenizerConfigJSON
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return errors.Wrap(err, "failed to parse tokenizer configuration")
+	}
+	*c = TokenizerConfig{
+		TokenizerClass:            raw.TokenizerClass,
+		PaddingSide:               raw.PaddingSide,
+		TruncationSide:            raw.TruncationSide,
+		AddBosToken:               raw.AddBosToken,
+		AddEosToken:               raw.AddEosToken,
+		DoLowerCase:               raw.DoLowerCase,
+		CleanUpTokenizationSpaces: raw.CleanUpTokenizationSpaces,
+	}
+
+	if raw.ModelMaxLength != "" {
+		// It may be written as a float (e.g. `2048.0`), so it's parsed as one, as long as it is integer-valued.
+		// The sentinel value int(1e30) (and any other value too large) is taken as "not set".
+		maxLength, err := raw.ModelMaxLength.Float64()
+		if err != nil || maxLength != math.Trunc(maxLength) {
+			return errors.Errorf("invalid model_max_length %q in tokenizer configuration", raw.ModelMaxLength)
+		}
+		if maxLength > 0 && maxLength <= math.MaxInt32 {
+			c.ModelMaxLength = int(maxLength)
+		}
+	}
+
+	for _, token := range []struct {
+		name string
+		raw  json.RawMessage
+		dst  *string
+	}{
+		{"bos_token", raw.BosToken, &c.BosToken},
+		{"eos_token", raw.EosToken, &c.EosToken},
+		{"unk_token", raw.UnkToken, &c.UnkToken},
+		{"sep_token", raw.SepToken, &c.SepToken},
+		{"pad_token", raw.PadToken, &c.PadToken},
+		{"cls_token", raw.ClsToken, &c.ClsToken},
+		{"mask_token", raw.MaskToken, &c.MaskToken},
+	} {
+		var err error
+		*token.dst, err = parseConfigToken(token.raw)
+		if err != nil {
+			return errors.WithMessagef(err, "invalid %q in tokenizer configuration", token.name)
+		}
+	}
+
+	for _, rawToken := range raw.AdditionalSpecialTokens {
+		token, err := parseConfigToken(rawToken)
+		if err != nil {
+			return errors.WithMessage(err, "invalid \"additional_special_tokens\" in tokenizer configuration")
+		}
+		if token != "" {
+			c.AdditionalSpecialTokens = append(c.AdditionalSpecialTokens, token)
+		}
+	}
+
+	var err error
+	c.ChatTemplate, err = parseChatTemplate(raw.ChatTemplate)
+	if err != nil {
+		return errors.WithMessage(err, "invalid \"chat_template\" in tokenizer configuration")
+	}
+	return nil
+}
+
+// mergeSpecialTokensMap sets the special tokens (including the additional ones) of the configuration not yet set
+// to the ones defined in the contents of a `special_tokens_map.json` file, which uses the same format as the
+// configuration for them.
+func (c *TokenizerConfig) mergeSpecialTokensMap(data []byte) error {
+	specialTokens, err := ParseTokenizerConfig(data)
+	if err != nil {
+		return err
+	}
+	if c.fromSpecialTokensMap == nil {
+		c.fromSpecialTokensMap = make(map[string]bool)
+	}
+	for _, token := range []struct {
+		name string
+		dst  *string
+		src  string
+	}{
+		{"bos_token", &c.BosToken, specialTokens.BosToken},
+		{"eos_token", &c.EosToken, specialTokens.EosToken},
+		{"unk_token", &c.UnkToken, specialTokens.UnkToken},
+		{"sep_token", &c.SepToken, specialTokens.SepToken},
+		{"pad_token", &c.PadToken, specialTokens.PadToken},
+		{"cls_token", &c.ClsToken, specialTokens.ClsToken},
+		{"mask_token", &c.MaskToken, specialTokens.MaskToken},
+	} {
+		if *token.dst == "" && token.src != "" {
+			*token.dst = token.src
+			c.fromSpecialTokensMap[token.name] = true
+		}
+	}
+	if len(c.AdditionalSpecialTokens) == 0 && len(specialTokens.AdditionalSpecialTokens) > 0 {
+		c.AdditionalSpecialTokens = specialTokens.AdditionalSpecialTokens
+		c.fromSpecialTokensMap["additional_special_tokens"] = true
+	}
+	return nil
+}
+
+// parseConfigToken parses a special token, that can be either `null`, a string or an "AddedToken" object with a
+// "content" field.
+func parseConfigToken(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	var token string
+	if err := json.Unmarshal(raw, &token); err == nil {
+		return token, nil
+	}
+	var addedToken struct {
+		Content string `json:"content"`
+	}
+	if err := json.Unmarshal(raw, &addedToken); err != nil {
+		return "", errors.Wrapf(err, "expected a string or an object with \"content\", got %s", raw)
+	}
+	return addedToken.Content, nil
+}
+
+// parseChatTemplate parses the chat template, that can be either `null`, a string or a list of named templates,
+// in which case the one named "default" is returned.
+func parseChatTemplate(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	var template string
+	if err := json.Unmarshal(raw, &template); err == nil {
+		return template, nil
+	}
+	var namedTemplates []struct {
+		Name     string `json:"name"`
+		Template string `json:"template"`
+	}
+	if err := json.Unmarshal(raw, &namedTemplates); err != nil {
+		return "", errors.Wrapf(err, "expected a string or a list of named templates")
+	}
+	for _, named := range namedTemplates {
+		if named.Name == "default" {
+			return named.Template, nil
+		}
+	}
+	return "", nil
+}
+
+// Config returns the configuration read from `tokenizer_config.json` when the Tokenizer was loaded with
+// FromPretrainedWith or FromDir. It returns nil if the Tokenizer was created some other way (e.g. FromFile).
+func (t *Tokenizer) Config() *TokenizerConfig {
+	return t.config
+}
+
+// WithTruncationFromConfig enables truncation to the `model_max_length` of the given configuration, in the direction
+// of its `truncation_side` (if set). If config is nil, the configuration loaded with the Tokenizer is used (see
+// Config), e.g. by FromPretrainedWith or FromDir, which otherwise don't enable truncation.
+//
+// If the configuration has no `model_max_length`, or it is the "very large integer" HuggingFace Transformers uses to
+// mean unbounded, truncation is left unchanged. Use ParseTokenizerConfig to read a configuration from its JSON.
+//
+// It returns itself (the Tokenizer), to allow cascaded configuration calls.
+func (t *Tokenizer) WithTruncationFromConfig(config *TokenizerConfig) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	if config == nil {
+		config = t.config
+	}
+	if config == nil {
+		return t
+	}
+	maxLength, ok := config.truncationLength()
+	if !ok {
+		return t
+	}
+	if direction, ok := sideToDirection(config.TruncationSide); ok {
+		t.truncationDirection = direction
+	}
+	t.isTruncationSet = true
+	t.truncationMaxLength = maxLength
+	t.setTruncation()
+	return t
+}
+
+// truncationLength returns the ModelMaxLength to use as truncation length, and whether it is set. Values too
+// large (e.g. the sentinel used by HuggingFace Transformers, if set directly in the TokenizerConfig) are taken as
+// unbounded, instead of wrapping around when converted.
+func (c *TokenizerConfig) truncationLength() (uint32, bool) {
+	if c.ModelMaxLength <= 0 || c.ModelMaxLength > math.MaxInt32 {
+		return 0, false
+	}
+	return uint32(c.ModelMaxLength), true
+}
+
+// sideToDirection converts the "side" names used by HuggingFace Transformers ("left" or "right") to a Direction.
+// It returns false if side is not set or not valid.
+func sideToDirection(side string) (Direction, bool) {
+	switch side {
+	case "left":
+		return Left, true
+	case "right":
+		return Right, true
+	}
+	return Left, false
+}
+
+// applyConfig configures the Tokenizer with the defaults read from `tokenizer_config.json`:
+//
+//   - PaddingSide and TruncationSide set the directions used for padding and truncation. They don't enable
+//     padding or truncation, but are used if they are enabled (in `tokenizer.json`, or later with the
+//     configuration methods).
+//   - ModelMaxLength is used as the truncation length, if truncation is not configured in `tokenizer.json`.
+//     Truncation is not enabled, but if it is later enabled (e.g. with WithTruncationDirection) this
+//     length is used.
+func (t *Tokenizer) applyConfig(config *TokenizerConfig) {
+	if config == nil {
+		return
+	}
+	if direction, ok := sideToDirection(config.PaddingSide); ok {
+		t.paddingDirection = direction
+		if t.isPaddingSet {
+			t.setPadding()
+		}
+	}
+	if direction, ok := sideToDirection(config.TruncationSide); ok {
+		t.truncationDirection = direction
+		if t.isTruncationSet {
+			t.setTruncation()
+		}
+	}
+	if maxLength, ok := config.truncationLength(); ok && !t.isTruncationSet {
+		t.truncationMaxLength = maxLength
+	}
+}
@@ -0,0 +1,96 @@
+package tokenizers
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// This file implements the ContinuationMask of the Encoding, see Tokenizer.ReturnContinuationMask and
+// fillDerivedFields.
+
+const (
+	// byteLevelWordStart is the marker of tokens starting with a space in byte-level (GPT-2 like) tokenizers.
+	byteLevelWordStart = "\u0120"
+
+	// metaspaceWordStart is the default marker of tokens starting a word in SentencePiece (T5, Llama) tokenizers.
+	metaspaceWordStart = "\u2581"
+)
+
+// continuationMarkers returns how to identify tokens that continue the word of the previous token: either by the
+// model's "continuing_subword_prefix" (e.g. "##" in WordPiece), or by the absence of the marker of tokens that
+// start a word (e.g. "Ġ" in byte-level BPE, or "▁" in SentencePiece models).
+//
+// Both are empty if the model uses no marker, in which case no token is taken as a continuation.
+// It doesn't lock the Tokenizer, so it can be used while holding the lock.
+func (t *Tokenizer) continuationMarkers() (prefix, wordStart string) {
+	data, err := t.toBytes()
+	if err != nil {
+		return "", ""
+	}
+	var definition struct {
+		Normalizer   any `json:"normalizer"`
+		PreTokenizer any `json:"pre_tokenizer"`
+		Model        struct {
+			ContinuingSubwordPrefix *string `json:"continuing_subword_prefix"`
+		} `json:"model"`
+	}
+	if err = json.Unmarshal(data, &definition); err != nil {
+		return "", ""
+	}
+	if definition.Model.ContinuingSubwordPrefix != nil && *definition.Model.ContinuingSubwordPrefix != "" {
+		return *definition.Model.ContinuingSubwordPrefix, ""
+	}
+	switch {
+	case hasComponentType(definition.PreTokenizer, "ByteLevel"):
+		return "", byteLevelWordStart
+	case hasComponentType(definition.PreTokenizer, "Metaspace"), hasComponentType(definition.Normalizer, "Prepend"):
+		return "", metaspaceWordStart
+	}
+	return "", ""
+}
+
+// ReturnContinuationMask sets whether Encode (and EncodeBatch) should return the Encoding.ContinuationMask,
+// flagging for each token whether it continues the word of the previous token. This allows reconstructing
+// words without relying on offsets or word ids.
+//
+// It's derived from the tokens: WordPiece subwords (e.g. "##ing") are continuations, and for byte-level BPE
+// (e.g. GPT-2) or SentencePiece models (e.g. T5, Llama), tokens lacking the leading space marker ("Ġ" or "▁")
+// are continuations. Special tokens, and the first token after them, are never continuations.
+// Models without such markers have all tokens flagged as false.
+// Default is false.
+//
+// It returns itself (the Tokenizer), to allow cascaded configuration calls.
+func (t *Tokenizer) ReturnContinuationMask(value bool) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	t.returnContinuationMask = value
+	if value {
+		t.continuationPrefix, t.continuationWordStart = t.continuationMarkers()
+	}
+	return t
+}
+
+// continuationMask returns the ContinuationMask of the encoding, derived from its Tokens and SpecialTokensMask.
+func (t *Tokenizer) continuationMask(encoding *Encoding) []bool {
+	mask := make([]bool, len(encoding.Tokens))
+	if t.continuationPrefix == "" && t.continuationWordStart == "" {
+		return mask
+	}
+	afterWord := false // Whether the previous token is part of a word, as opposed to special or the start.
+	for ii, token := range encoding.Tokens {
+		if encoding.SpecialTokensMask[ii] != 0 {
+			afterWord = false
+			continue
+		}
+		if t.continuationPrefix != "" {
+			mask[ii] = afterWord && strings.HasPrefix(token, t.continuationPrefix)
+		} else {
+			mask[ii] = afterWord && !strings.HasPrefix(token, t.continuationWordStart)
+		}
+		afterWord = true
+	}
+	return mask
+}
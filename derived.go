@@ -0,0 +1,212 @@
+package tokenizers
+
+import (
+	"github.com/gomlx/tokenizers/internal/rs"
+	"hash/fnv"
+	"unicode/utf8"
+)
+
+// This file implements the fields of Encoding derived (in Go) from the fields returned by the underlying
+// tokenizer: ContinuationMask, FirstSubwordMask, TokenHashes, CharOffsets and TokenKinds.
+
+// internalEncodeParams returns the encoding parameters to use: besides the fields configured to be returned,
+// it requests the fields needed to check for unknown tokens (see WithRejectUnknown) and to derive other
+// fields. The fields not configured are removed afterward by fillDerivedFields.
+//
+// If a seed was set (see WithSeed), it also takes the seed of the next encoding call: it should be called once
+// per call.
+func (t *Tokenizer) internalEncodeParams() rs.EncodeParams {
+	encodeParams := t.encodeParams
+	if t.rejectUnknown {
+		encodeParams.ReturnOffsets = true
+	}
+	if t.returnContinuationMask {
+		encodeParams.ReturnTokens = true
+		encodeParams.ReturnSpecialTokensMask = true
+	}
+	if t.returnFirstSubwordMask {
+		encodeParams.ReturnWordIds = true
+	}
+	if t.returnTokenHashes {
+		encodeParams.ReturnTokens = true
+	}
+	if t.returnDroppedTokens {
+		encodeParams.ReturnTokens = true
+		encodeParams.ReturnSpecialTokensMask = true
+	}
+	if t.returnTokenKinds {
+		encodeParams.ReturnSpecialTokensMask = true
+	}
+	if t.returnBothOffsets {
+		encodeParams.ReturnOffsets = true
+		encodeParams.WithOffsetsCharMode = false
+		encodeParams.ReturnSequenceIds = true
+	}
+	if t.isDropoutSeedSet {
+		encodeParams.WithDropoutSeed = true
+		encodeParams.DropoutSeed = t.dropoutSeed + t.dropoutCalls.Add(1) - 1
+	}
+	return encodeParams
+}
+
+// offsetsInChars returns whether the offsets returned by the underlying tokenizer, with the parameters of
+// internalEncodeParams, are in Unicode code points (as opposed to bytes).
+func (t *Tokenizer) offsetsInChars() bool {
+	return t.encodeParams.WithOffsetsCharMode && !t.returnBothOffsets
+}
+
+// fillDerivedFields fills the fields of the encoding derived from the ones returned by the tokenizer, if
+// configured to be returned. It then removes the fields requested only by internalEncodeParams.
+//
+// textOf returns the (preprocessed) text the offsets of the token ii refer to, see sentenceText.
+func (t *Tokenizer) fillDerivedFields(encoding *Encoding, textOf func(ii int) string) {
+	if t.returnBothOffsets {
+		encoding.CharOffsets = charOffsets(encoding.Offsets, textOf)
+	}
+	if t.returnContinuationMask {
+		encoding.ContinuationMask = t.continuationMask(encoding)
+	}
+	if t.returnFirstSubwordMask {
+		encoding.FirstSubwordMask = firstSubwordMask(encoding.WordIds)
+	}
+	if t.returnTokenHashes {
+		encoding.TokenHashes = tokenHashes(encoding.Tokens)
+	}
+	if t.returnTokenKinds {
+		encoding.TokenKinds = t.tokenKinds(encoding)
+	}
+	if !t.encodeParams.ReturnOffsets && !t.returnBothOffsets {
+		encoding.Offsets = nil
+	}
+	if !t.encodeParams.ReturnTokens {
+		encoding.Tokens = nil
+	}
+	if !t.encodeParams.ReturnSpecialTokensMask {
+		encoding.SpecialTokensMask = nil
+	}
+	if !t.encodeParams.ReturnWordIds {
+		encoding.WordIds = nil
+	}
+	if !t.encodeParams.ReturnSequenceIds {
+		encoding.SequenceIds = nil
+	}
+}
+
+// ReturnFirstSubwordMask sets whether Encode (and EncodeBatch) should return the Encoding.FirstSubwordMask,
+// marking the first token of each word. Used for pooling subword representations into word representations,
+// e.g. in NER.
+//
+// It's derived from the word ids (see ReturnWordIds): a token is marked if its word id differs from the one of
+// the previous token. Special tokens are never marked, and the word after a special token (e.g. the first word
+// of the second sentence of a pair) is always marked.
+// Default is false.
+//
+// It returns itself (the Tokenizer), to allow cascaded configuration calls.
+func (t *Tokenizer) ReturnFirstSubwordMask(value bool) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	t.returnFirstSubwordMask = value
+	return t
+}
+
+// firstSubwordMask returns the FirstSubwordMask derived from the word ids.
+func firstSubwordMask(wordIds []int32) []bool {
+	mask := make([]bool, len(wordIds))
+	previous := int32(-1)
+	for ii, wordId := range wordIds {
+		mask[ii] = wordId >= 0 && wordId != previous
+		previous = wordId
+	}
+	return mask
+}
+
+// ReturnTokenHashes sets whether Encode (and EncodeBatch) should return the Encoding.TokenHashes, a 64-bit
+// FNV-1a hash of the bytes of each token (see Encoding.Tokens). The hashes are stable across calls, processes
+// and versions, so they can be stored and compared: e.g. to find which tokens changed after an edit of the
+// input, for incremental re-rendering. Notice that the hash depends only on the token, not on its position.
+// Default is false.
+//
+// It returns itself (the Tokenizer), to allow cascaded configuration calls.
+func (t *Tokenizer) ReturnTokenHashes(value bool) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	t.returnTokenHashes = value
+	return t
+}
+
+// tokenHashes returns the TokenHashes of the tokens.
+func tokenHashes(tokens []string) []uint64 {
+	hashes := make([]uint64, len(tokens))
+	hasher := fnv.New64a()
+	for ii, token := range tokens {
+		hasher.Reset()
+		_, _ = hasher.Write([]byte(token))
+		hashes[ii] = hasher.Sum64()
+	}
+	return hashes
+}
+
+// ReturnBothOffsets sets whether Encode (and EncodeBatch) should return the offsets of the tokens both in bytes
+// and in Unicode code points: Encoding.Offsets are then in bytes (e.g. to slice the original sentence),
+// regardless of WithOffsetsCharMode, and Encoding.CharOffsets in code points (e.g. for display).
+// The code points offsets are derived from the byte offsets, so it doesn't require encoding twice.
+// Default is false.
+//
+// It returns itself (the Tokenizer), to allow cascaded configuration calls.
+func (t *Tokenizer) ReturnBothOffsets(value bool) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	t.returnBothOffsets = value
+	return t
+}
+
+// sentenceText returns the textOf function used by fillDerivedFields for the encoding of a single sentence.
+func sentenceText(sentence string) func(ii int) string {
+	return func(int) string { return sentence }
+}
+
+// charOffsets converts the byte offsets of the tokens to Unicode code points offsets. textOf returns the text the
+// offsets of the token ii refer to.
+func charOffsets(offsets []Offset, textOf func(ii int) string) []Offset {
+	if len(offsets) == 0 {
+		return nil
+	}
+	converted := make([]Offset, len(offsets))
+	var text string
+	var runeIndices []uint32
+	for ii, offset := range offsets {
+		if newText := textOf(ii); runeIndices == nil || newText != text {
+			text = newText
+			runeIndices = runeIndicesOfBytes(text)
+		}
+		converted[ii] = Offset{
+			Start: runeIndices[min(int(offset.Start), len(text))],
+			End:   runeIndices[min(int(offset.End), len(text))],
+		}
+	}
+	return converted
+}
+
+// runeIndicesOfBytes returns the index of the Unicode code point at each byte position of text, plus the number of
+// code points at position len(text).
+func runeIndicesOfBytes(text string) []uint32 {
+	indices := make([]uint32, len(text)+1)
+	var count uint32
+	for ii := 0; ii < len(text); ii++ {
+		indices[ii] = count
+		if utf8.RuneStart(text[ii]) {
+			count++
+		}
+	}
+	indices[len(text)] = count
+	return indices
+}
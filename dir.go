@@ -0,0 +1,121 @@
+package tokenizers
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"path"
+)
+
+// sentencePieceModelFileName is the model file of the SentencePiece "slow" tokenizers, not supported.
+const sentencePieceModelFileName = "spiece.model"
+
+// FromDir loads a Tokenizer from a local directory with the files of a HuggingFace repository, e.g. a model
+// repository cloned with `git clone`, or a directory written by ExportDir. It mirrors the Python
+// `AutoTokenizer.from_pretrained(dir)`.
+//
+// It loads `tokenizer.json` if present, or otherwise assembles the tokenizer from the vocabulary files of the
+// "slow" tokenizers: `vocab.txt` (WordPiece, see FromWordPieceVocab) or `vocab.json` and `merges.txt`
+// (byte-level BPE). SentencePiece models (`spiece.model`) are not supported.
+//
+// If present, `tokenizer_config.json` is applied as in FromPretrainedWith (including the model family defaults,
+// see ModelFamily), and `special_tokens_map.json` provides the special tokens not defined in it. When assembled
+// from the vocabulary files, the tokens of `added_tokens.json` are added as in FromPretrainedWith.
+func FromDir(dir string) (*Tokenizer, error) {
+	readFile := func(name string) (contents []byte, found bool, err error) {
+		filePath := path.Join(dir, name)
+		contents, err = os.ReadFile(filePath)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, errors.Wrapf(err, "FromDir(%q) failed to read %q", dir, filePath)
+		}
+		return contents, true, nil
+	}
+
+	// Configuration.
+	var config *TokenizerConfig
+	contents, found, err := readFile(tokenizerConfigFileName)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		config, err = ParseTokenizerConfig(contents)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "FromDir(%q) failed to parse %q", dir, tokenizerConfigFileName)
+		}
+	}
+	contents, found, err = readFile(specialTokensMapFileName)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		if config == nil {
+			config = &TokenizerConfig{DoLowerCase: true} // Default of the BERT tokenizer.
+		}
+		if err = config.mergeSpecialTokensMap(contents); err != nil {
+			return nil, errors.WithMessagef(err, "FromDir(%q) failed to parse %q", dir, specialTokensMapFileName)
+		}
+	}
+
+	// Tokenizer definition.
+	data, fromVocabFiles, err := tokenizerJSONFromDir(dir, config, readFile)
+	if err != nil {
+		return nil, err
+	}
+	t, err := FromBytes(data)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "FromDir(%q)", dir)
+	}
+	if fromVocabFiles {
+		contents, _, err = readFile(addedTokensFileName)
+		if err == nil {
+			err = t.addVocabFilesAddedTokens(contents, config)
+		}
+		if err != nil {
+			t.Finalize()
+			return nil, errors.WithMessagef(err, "FromDir(%q)", dir)
+		}
+	}
+	if config != nil {
+		t.config = config
+		t.applyModelFamilyDefaults(t.ModelFamily())
+		t.applyConfig(config)
+	}
+	return t, nil
+}
+
+// tokenizerJSONFromDir returns the contents of `tokenizer.json` in dir, or a tokenizer definition assembled
+// from the vocabulary files, if there is no `tokenizer.json`, in which case fromVocabFiles is true.
+func tokenizerJSONFromDir(dir string, config *TokenizerConfig,
+	readFile func(name string) ([]byte, bool, error)) (data []byte, fromVocabFiles bool, err error) {
+	if contents, found, err := readFile(tokenizerFileName); err != nil || found {
+		return contents, false, err
+	}
+	files := make(map[string][]byte)
+	for _, name := range []string{wordPieceVocabFileName, bpeVocabFileName, bpeMergesFileName} {
+		contents, found, err := readFile(name)
+		if err != nil {
+			return nil, false, err
+		}
+		if found {
+			files[name] = contents
+		}
+	}
+	if vocabTxt, found := files[wordPieceVocabFileName]; found {
+		data, err = wordPieceTokenizerJSON(vocabTxt, wordPieceOptionsFromConfig(config))
+		return data, true, err
+	}
+	vocabJSON, foundVocab := files[bpeVocabFileName]
+	merges, foundMerges := files[bpeMergesFileName]
+	if foundVocab && foundMerges {
+		data, err = bpeTokenizerJSON(vocabJSON, merges, config)
+		return data, true, err
+	}
+	if _, found, _ := readFile(sentencePieceModelFileName); found {
+		return nil, false, errors.Errorf("FromDir(%q): SentencePiece models (%q) are not supported, a %q is needed",
+			dir, sentencePieceModelFileName, tokenizerFileName)
+	}
+	return nil, false, errors.Errorf("FromDir(%q): directory has no %q, nor %q, nor %q and %q to assemble a tokenizer from",
+		dir, tokenizerFileName, wordPieceVocabFileName, bpeVocabFileName, bpeMergesFileName)
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package tokenizers
+
+import "github.com/pkg/errors"
+
+// availableDiskBytes is not supported in this platform: it always returns an error, and the disk space is not
+// checked before downloading.
+func availableDiskBytes(dir string) (uint64, error) {
+	return 0, errors.Errorf("disk space of %q not available in this platform", dir)
+}
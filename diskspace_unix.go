@@ -0,0 +1,17 @@
+//go:build linux || darwin
+
+package tokenizers
+
+import (
+	"github.com/pkg/errors"
+	"syscall"
+)
+
+// availableDiskBytes returns the disk space available (to unprivileged users) in the filesystem of dir.
+func availableDiskBytes(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, errors.Wrapf(err, "failed to get disk space of %q", dir)
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
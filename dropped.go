@@ -0,0 +1,70 @@
+package tokenizers
+
+// This file implements the reporting of the tokens dropped by truncation, see ReturnDroppedTokens.
+
+// ReturnDroppedTokens sets whether Encode should return in Encoding.DroppedIds and Encoding.DroppedTokens the
+// tokens removed from the input by truncation (see WithTruncation), e.g. for audit logging of truncated inputs.
+//
+// The dropped tokens are in the order they appear in the input, don't include special tokens, and don't repeat
+// the tokens shared with the kept ones because of the stride (see WithTruncationStride). They are left empty
+// if truncation is not configured or if the input fits. It is not supported by EncodeBatch.
+// Default is false.
+//
+// It returns itself (the Tokenizer), to allow cascaded configuration calls.
+func (t *Tokenizer) ReturnDroppedTokens(value bool) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	t.returnDroppedTokens = value
+	return t
+}
+
+// encodeWithDropped encodes the sentence along with its overflowing tokens, and fills the Encoding.DroppedIds
+// and Encoding.DroppedTokens with them.
+func (t *Tokenizer) encodeWithDropped(sentence string) (*Encoding, error) {
+	encodings, err := t.tokenizer.EncodeOverflowing(sentence, t.internalEncodeParams())
+	if err != nil {
+		return nil, err
+	}
+	encoding := &encodings[0]
+	encoding.DroppedIds, encoding.DroppedTokens = t.droppedTokens(encodings[1:])
+	return encoding, nil
+}
+
+// droppedTokens returns the ids and tokens of the overflowing windows (see EncodeAuto), without special tokens
+// and without the overlap of each window with the previous one.
+//
+// The windows move away from the kept tokens: forward when truncating on the right, and backward (towards the
+// start of the input) when truncating on the left. Each window shares truncationStride tokens with the
+// previous one, at its start or at its end respectively.
+func (t *Tokenizer) droppedTokens(overflowing []Encoding) (ids []uint32, tokens []string) {
+	stride := int(t.truncationStride)
+	fromLeft := t.truncationDirection == Left
+	for ii := range overflowing {
+		window := &overflowing[ii]
+		if fromLeft {
+			// Windows are visited backwards: dropped tokens are collected in reverse.
+			window = &overflowing[len(overflowing)-1-ii]
+		}
+		var windowIds []uint32
+		var windowTokens []string
+		for jj, id := range window.TokenIds {
+			if window.SpecialTokensMask[jj] != 0 {
+				continue
+			}
+			windowIds = append(windowIds, id)
+			windowTokens = append(windowTokens, window.Tokens[jj])
+		}
+		overlap := min(stride, len(windowIds))
+		if fromLeft {
+			windowIds, windowTokens = windowIds[:len(windowIds)-overlap], windowTokens[:len(windowTokens)-overlap]
+		} else {
+			windowIds, windowTokens = windowIds[overlap:], windowTokens[overlap:]
+		}
+		ids = append(ids, windowIds...)
+		tokens = append(tokens, windowTokens...)
+	}
+	return
+}
@@ -0,0 +1,133 @@
+package tokenizers
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"os"
+	"path"
+)
+
+// This file handles exporting a Tokenizer to a directory, in the format used by HuggingFace's
+// Python libraries.
+
+// exportedTokenizerConfig is the minimal `tokenizer_config.json` written by ExportDir.
+// The field names follow the ones used by HuggingFace Transformers.
+type exportedTokenizerConfig struct {
+	TokenizerClass string `json:"tokenizer_class"`
+	ModelMaxLength uint32 `json:"model_max_length,omitempty"`
+	PaddingSide    string `json:"padding_side"`
+	TruncationSide string `json:"truncation_side"`
+	PadToken       string `json:"pad_token,omitempty"`
+}
+
+// directionToSide converts a Direction to the "side" names used by HuggingFace Transformers.
+func directionToSide(direction Direction) string {
+	if direction == Left {
+		return "left"
+	}
+	return "right"
+}
+
+// ExportDir writes the Tokenizer to the directory dir, in a format that can be loaded by HuggingFace's
+// Python `AutoTokenizer.from_pretrained(dir)`.
+//
+// It writes a `tokenizer.json` (see ToBytes) and a minimal `tokenizer_config.json` reflecting the current
+// padding and truncation configuration. The directory is created if it doesn't exist, and existing
+// files are overwritten.
+func (t *Tokenizer) ExportDir(dir string) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	data, err := t.toBytes()
+	if err != nil {
+		return errors.WithMessagef(err, "Tokenizer.ExportDir(%q):", dir)
+	}
+	config := exportedTokenizerConfig{
+		TokenizerClass: "PreTrainedTokenizerFast",
+		PaddingSide:    directionToSide(t.paddingDirection),
+		TruncationSide: directionToSide(t.truncationDirection),
+	}
+	if t.isTruncationSet {
+		config.ModelMaxLength = t.truncationMaxLength
+	}
+	if t.isPaddingSet {
+		config.PadToken = t.padToken
+	}
+	configData, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return errors.Wrapf(err, "Tokenizer.ExportDir(%q) failed to serialize %q", dir, tokenizerConfigFileName)
+	}
+
+	if err = os.MkdirAll(dir, DefaultDirCreationPerm); err != nil {
+		return errors.Wrapf(err, "Tokenizer.ExportDir(%q) failed to create directory", dir)
+	}
+	for _, file := range []struct {
+		name     string
+		contents []byte
+	}{
+		{tokenizerFileName, data},
+		{tokenizerConfigFileName, configData},
+	} {
+		filePath := path.Join(dir, file.name)
+		if err = os.WriteFile(filePath, file.contents, DefaultFileCreationPerm); err != nil {
+			return errors.Wrapf(err, "Tokenizer.ExportDir(%q) failed to write %q", dir, filePath)
+		}
+	}
+	return nil
+}
+
+// Task is the use an exported tokenizer is meant for, see ExportMinimal.
+type Task uint8
+
+const (
+	// TaskEncode is for tokenizers that only encode text, e.g. in embedding services.
+	// The decoder is dropped.
+	TaskEncode Task = iota
+
+	// TaskDecode is for tokenizers that only decode token ids back to text.
+	// The normalizer, pre-tokenizer and post-processor are dropped, as well as the truncation and padding
+	// configuration.
+	TaskDecode
+)
+
+// minimalDroppedFields lists the fields of the `tokenizer.json` format not needed for each Task.
+var minimalDroppedFields = map[Task][]string{
+	TaskEncode: {"decoder"},
+	TaskDecode: {"normalizer", "pre_tokenizer", "post_processor", "truncation", "padding"},
+}
+
+// ExportMinimal serializes the Tokenizer like ToBytes, but with only the components needed for the given task,
+// producing a smaller `tokenizer.json` that can still be loaded with FromBytes. For instance, TaskEncode drops
+// the decoder, for services that never decode.
+//
+// Tokens added at runtime (e.g. AddTokens) are kept, since they are needed both to encode and to decode.
+func (t *Tokenizer) ExportMinimal(task Task) ([]byte, error) {
+	dropped, found := minimalDroppedFields[task]
+	if !found {
+		return nil, errors.Errorf("Tokenizer.ExportMinimal(%s): unknown task", task)
+	}
+	t.mu.RLock()
+	data, err := t.toBytes()
+	t.mu.RUnlock()
+	if err != nil {
+		return nil, errors.WithMessagef(err, "Tokenizer.ExportMinimal(%s):", task)
+	}
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return nil, errors.Wrapf(err, "Tokenizer.ExportMinimal(%s) failed to parse serialized tokenizer", task)
+	}
+	for _, field := range dropped {
+		if _, found := fields[field]; found {
+			fields[field] = json.RawMessage("null")
+		}
+	}
+
+	// Tokens like "<s>" are kept as is, instead of HTML escaped, to keep it small.
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err = encoder.Encode(fields); err != nil {
+		return nil, errors.Wrapf(err, "Tokenizer.ExportMinimal(%s) failed to serialize tokenizer", task)
+	}
+	return bytes.TrimSpace(buf.Bytes()), nil
+}
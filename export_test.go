@@ -0,0 +1,6 @@
+package tokenizers
+
+// Unexported functions exported for the tests of the tokenizers_test package.
+
+// TryLockFile exports tryLockFile, to hold the lock of a file being downloaded.
+var TryLockFile = tryLockFile
@@ -0,0 +1,148 @@
+package tokenizers
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// This file implements the detection of the model family of a tokenizer, used to pick sensible defaults.
+
+// ModelFamily is a family of models that share the same tokenizer conventions, see Tokenizer.ModelFamily.
+type ModelFamily uint8
+
+const (
+	FamilyUnknown ModelFamily = iota
+	FamilyBERT
+	FamilyRoBERTa
+	FamilyGPT2
+	FamilyT5
+	FamilyLlama
+)
+
+// tokenizerJSONSummary holds the fields of a `tokenizer.json` file used to detect the model family.
+type tokenizerJSONSummary struct {
+	Normalizer    any `json:"normalizer"`
+	PreTokenizer  any `json:"pre_tokenizer"`
+	PostProcessor any `json:"post_processor"`
+	Decoder       any `json:"decoder"`
+	Model         struct {
+		Type         string `json:"type"`
+		ByteFallback bool   `json:"byte_fallback"`
+	} `json:"model"`
+}
+
+// componentTypes returns the "type" of the component (normalizer, pre-tokenizer, etc.) parsed from JSON, along
+// with the types of all its sub-components (e.g. of a "Sequence").
+func componentTypes(component any) []string {
+	var types []string
+	switch c := component.(type) {
+	case map[string]any:
+		if t, ok := c["type"].(string); ok {
+			types = append(types, t)
+		}
+		for _, value := range c {
+			types = append(types, componentTypes(value)...)
+		}
+	case []any:
+		for _, value := range c {
+			types = append(types, componentTypes(value)...)
+		}
+	}
+	return types
+}
+
+// hasComponentType returns whether the component, or any of its sub-components, has the given type.
+func hasComponentType(component any, componentType string) bool {
+	for _, t := range componentTypes(component) {
+		if t == componentType {
+			return true
+		}
+	}
+	return false
+}
+
+// detectModelFamily from the contents of the `tokenizer.json` and, if available (config may be nil), from its
+// `tokenizer_config.json`.
+//
+// The heuristics used, in order:
+//
+//   - The `tokenizer_class` in the configuration, e.g. "LlamaTokenizerFast" or "BertTokenizer".
+//   - A "BertProcessing" post-processor or a "WordPiece" model: BERT.
+//   - A "RobertaProcessing" post-processor: RoBERTa.
+//   - A "Unigram" model with a "Metaspace" pre-tokenizer: T5.
+//   - A "BPE" model with byte fallback: Llama.
+//   - A "BPE" model with a "ByteLevel" pre-tokenizer: GPT-2.
+func detectModelFamily(tokenizerJSON []byte, config *TokenizerConfig) ModelFamily {
+	if config != nil {
+		class := strings.ToLower(config.TokenizerClass)
+		for _, candidate := range []struct {
+			prefix string
+			family ModelFamily
+		}{
+			{"llama", FamilyLlama},
+			{"codellama", FamilyLlama},
+			{"t5", FamilyT5},
+			{"roberta", FamilyRoBERTa},
+			{"gpt2", FamilyGPT2},
+			{"bert", FamilyBERT},
+			{"distilbert", FamilyBERT},
+		} {
+			if strings.HasPrefix(class, candidate.prefix) {
+				return candidate.family
+			}
+		}
+	}
+
+	var summary tokenizerJSONSummary
+	if err := json.Unmarshal(tokenizerJSON, &summary); err != nil {
+		return FamilyUnknown
+	}
+	switch {
+	case hasComponentType(summary.PostProcessor, "BertProcessing") || summary.Model.Type == "WordPiece":
+		return FamilyBERT
+	case hasComponentType(summary.PostProcessor, "RobertaProcessing"):
+		return FamilyRoBERTa
+	case summary.Model.Type == "Unigram" && hasComponentType(summary.PreTokenizer, "Metaspace"):
+		return FamilyT5
+	case summary.Model.Type == "BPE" && summary.Model.ByteFallback:
+		return FamilyLlama
+	case summary.Model.Type == "BPE" && hasComponentType(summary.PreTokenizer, "ByteLevel"):
+		return FamilyGPT2
+	}
+	return FamilyUnknown
+}
+
+// ModelFamily returns the family of models the Tokenizer belongs to, detected with heuristics from its
+// definition and, if loaded with FromPretrainedWith, its configuration. It returns FamilyUnknown if the family
+// could not be detected.
+//
+// It serializes the Tokenizer (see ToBytes) to inspect it, so it's not a cheap call.
+func (t *Tokenizer) ModelFamily() ModelFamily {
+	data, err := t.ToBytes()
+	if err != nil {
+		return FamilyUnknown
+	}
+	return detectModelFamily(data, t.config)
+}
+
+// applyModelFamilyDefaults configures the Tokenizer with the conventional defaults of its model family,
+// so it behaves like its Python counterpart:
+//
+//   - Special tokens are added (AddSpecialTokens(true)), the default in HuggingFace Transformers.
+//   - Padding direction is Left for Llama (decoder-only models padded for generation), Right for the others.
+//     It's not changed if the padding was configured in the tokenizer definition.
+//
+// Nothing is changed for FamilyUnknown.
+func (t *Tokenizer) applyModelFamilyDefaults(family ModelFamily) {
+	if family == FamilyUnknown {
+		return
+	}
+	t.encodeParams.AddSpecialTokens = true
+	if !t.isPaddingSet {
+		if family == FamilyLlama {
+			t.paddingDirection = Left
+		} else {
+			t.paddingDirection = Right
+		}
+	}
+}
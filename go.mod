@@ -6,8 +6,11 @@ require (
 	github.com/google/uuid v1.4.0
 	github.com/magefile/mage v1.15.0
 	github.com/pkg/errors v0.9.1
+	github.com/rivo/uniseg v0.2.0
 	github.com/schollz/progressbar/v3 v3.13.1
 	github.com/stretchr/testify v1.8.4
+	golang.org/x/sys v0.6.0
+	golang.org/x/text v0.14.0
 )
 
 require (
@@ -15,8 +18,6 @@ require (
 	github.com/mattn/go-runewidth v0.0.14 // indirect
 	github.com/mitchellh/colorstring v0.0.0-20190213212951-d06e56a500db // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/rivo/uniseg v0.2.0 // indirect
-	golang.org/x/sys v0.6.0 // indirect
 	golang.org/x/term v0.6.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
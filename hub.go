@@ -3,9 +3,7 @@ package tokenizers
 // HuggingFace Hub related functionality.
 //
 // TODOs:
-// * Support for authentication tokens.
 // * Resume downloads from interrupted connections.
-// * Check disk-space before starting to download.
 
 import (
 	"bytes"
@@ -16,13 +14,13 @@ import (
 	"io"
 	"math/rand"
 	"net/http"
+	neturl "net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
-	"syscall"
 	"text/template"
 	"time"
 )
@@ -43,6 +41,25 @@ var (
 
 	// DefaultFileCreationPerm is used when creating files inside the cache subdirectories.
 	DefaultFileCreationPerm = os.FileMode(0644)
+
+	// DiskSpaceMargin is the free disk space, in bytes, required in the cache directory besides the size of the
+	// file to download. If there is not enough space, Download fails before starting the download.
+	DiskSpaceMargin uint64 = 16 << 20
+
+	// DefaultMaxRetries is the number of times a request to the HuggingFace Hub (for the metadata or the contents
+	// of a file) is retried after a transient failure: a 5xx or 429 status, or a network error.
+	// See also PretrainedConfig.MaxRetries.
+	DefaultMaxRetries = 2
+
+	// RetryBaseDelay is the delay before the first retry of a failed request to the HuggingFace Hub. It doubles at
+	// each following retry, and a random jitter of up to half of it is added. A `Retry-After` header sent by the
+	// server takes precedence.
+	RetryBaseDelay = 500 * time.Millisecond
+
+	// RetryMaxDelay is the maximum delay before a retry of a failed request to the HuggingFace Hub. Longer delays,
+	// including the ones requested by the server with `Retry-After`, are clamped to it, so a misbehaving server
+	// can't stall a download for hours. If 0 or negative, the delays are not clamped.
+	RetryMaxDelay = time.Minute
 )
 
 const (
@@ -52,6 +69,20 @@ const (
 	tokenizersVersion   = "0.0.1"
 )
 
+var (
+	// ErrHubFileNotFound is returned (wrapped) by Download if the file, or its repository or revision, is not found
+	// on the HuggingFace Hub (status 404). Use errors.Is to check for it, e.g. to try an alternative file.
+	ErrHubFileNotFound = errors.New("file not found on HuggingFace Hub")
+
+	// ErrHubUnauthorized is returned (wrapped) by Download if the access to the file is denied (status 401 or 403):
+	// e.g. a private or gated repository, accessed without a token or with an invalid one (see AuthTokenEnvVars).
+	// Use errors.Is to check for it.
+	ErrHubUnauthorized = errors.New("unauthorized access to HuggingFace Hub")
+
+	// errNotInCache is returned (wrapped) by Download with forceLocal if the file is not in the cache.
+	errNotInCache = errors.New("file not found in cache")
+)
+
 const (
 	HeaderXRepoCommit = "X-Repo-Commit"
 	HeaderXLinkedETag = "X-Linked-Etag"
@@ -66,6 +97,20 @@ func getEnvOr(key, defaultValue string) string {
 	return v
 }
 
+// OfflineEnvVar is the environment variable that, if set to a truthy value ("1", "ON", "YES" or "TRUE", in any
+// case), forces cache-only operation, as in the Python `huggingface_hub` library. See IsOfflineMode.
+const OfflineEnvVar = "HF_HUB_OFFLINE"
+
+// IsOfflineMode returns whether the offline mode is set in the environment (see OfflineEnvVar), in which case
+// FromPretrainedWith defaults to ForceLocal.
+func IsOfflineMode() bool {
+	switch strings.ToUpper(strings.TrimSpace(os.Getenv(OfflineEnvVar))) {
+	case "1", "ON", "YES", "TRUE":
+		return true
+	}
+	return false
+}
+
 // DefaultCacheDir for HuggingFace Hub, same used by the python library.
 //
 // Its prefix is either `${XDG_CACHE_HOME}` if set, or `~/.cache` otherwise. Followed by `/huggingface/hub/`.
@@ -105,12 +150,28 @@ var (
 
 	DefaultRevision = "main"
 
+	// HubEndpoint is the base URL of the HuggingFace Hub used by GetUrl, e.g. "https://hf-mirror.com" for a
+	// mirror, or the address of a private endpoint. It defaults to the value of the environment variable
+	// `$HF_ENDPOINT` if set (as in the Python library), or "https://huggingface.co" otherwise.
+	//
+	// See also PretrainedConfig.Endpoint to configure it for one tokenizer.
+	HubEndpoint = strings.TrimRight(getEnvOr("HF_ENDPOINT", "https://huggingface.co"), "/")
+
+	// HuggingFaceUrlTemplate is the template of the URL of a file in the HuggingFace Hub, used by GetUrl.
+	// The Endpoint is HubEndpoint (or the one given to PretrainedConfig.Endpoint) and the RepoId includes the
+	// prefix of its type, see RepoTypesUrlPrefixes.
 	HuggingFaceUrlTemplate = template.Must(template.New("hf_url").Parse(
-		"https://huggingface.co/{{.RepoId}}/resolve/{{.Revision}}/{{.Filename}}"))
+		"{{.Endpoint}}/{{.RepoId}}/resolve/{{.Revision}}/{{.Filename}}"))
 )
 
 // GetUrl is based on the `hf_hub_url` function defined in the [huggingface_hub](https://github.com/huggingface/huggingface_hub) library.
+// The URL is in the HubEndpoint.
 func GetUrl(repoId, fileName, repoType, revision string) string {
+	return getUrl(HubEndpoint, repoId, fileName, repoType, revision)
+}
+
+// getUrl implements GetUrl for the given endpoint.
+func getUrl(endpoint, repoId, fileName, repoType, revision string) string {
 	if prefix, found := RepoTypesUrlPrefixes[repoType]; found {
 		repoId = prefix + repoId
 	}
@@ -119,7 +180,8 @@ func GetUrl(repoId, fileName, repoType, revision string) string {
 	}
 	var buf bytes.Buffer
 	err := HuggingFaceUrlTemplate.Execute(&buf,
-		struct{ RepoId, Revision, Filename string }{repoId, revision, fileName})
+		struct{ Endpoint, RepoId, Revision, Filename string }{
+			strings.TrimRight(endpoint, "/"), repoId, revision, fileName})
 	if err != nil {
 		panicf("HuggingFaceUrlTemplate failed (!? pls report the bug, this shouldn't happen) with %+v", err)
 	}
@@ -128,11 +190,29 @@ func GetUrl(repoId, fileName, repoType, revision string) string {
 }
 
 // GetHeaders is based on the `build_hf_headers` function defined in the [huggingface_hub](https://github.com/huggingface/huggingface_hub) library.
-// TODO: add support for authentication token.
+// If token is not empty, it's used as a bearer token in the "authorization" header.
 func GetHeaders(userAgent, token string) map[string]string {
-	return map[string]string{
+	headers := map[string]string{
 		"user-agent": userAgent,
 	}
+	if token != "" {
+		headers["authorization"] = "Bearer " + token
+	}
+	return headers
+}
+
+// AuthTokenEnvVars are the environment variables checked, in order, by DefaultAuthToken.
+var AuthTokenEnvVars = []string{"HF_TOKEN", "HUGGING_FACE_HUB_TOKEN"}
+
+// DefaultAuthToken returns the HuggingFace authentication token configured in the environment (see
+// AuthTokenEnvVars), or an empty string if none is set.
+func DefaultAuthToken() string {
+	for _, envVar := range AuthTokenEnvVars {
+		if token := os.Getenv(envVar); token != "" {
+			return token
+		}
+	}
+	return ""
 }
 
 // ProgressFn is a function called while downloading a file.
@@ -169,28 +249,53 @@ func (r *progressReader) Read(dst []byte) (n int, err error) {
 //   - `revision`: default is "main", but a commitHash can be given.
 //   - `cacheDir`: directory where to store the downloaded files, or reuse if previously downloaded.
 //     Consider using the output from `DefaultCacheDir()` if in doubt.
-//   - `token`: used for authentication. TODO: not implemented yet.
+//   - `token`: used for authentication, required for private or gated repositories. If empty no authentication
+//     is used -- see DefaultAuthToken to read it from the environment. It is not sent to the server the file is
+//     redirected to (e.g. a CDN), if different.
 //   - `forceDownload`: if set to true, it will download the contents of the file even if there is a local copy.
 //   - `localOnly`: does not use network, not even for reading the metadata.
 //   - `progressFn`: is called during the download of a file. It is called synchronously and expected to be fast/
 //     instantaneous. If the UI can be blocking, arrange it to be handled on a separate GoRoutine.
 //
 // On success it returns the `filePath` to the downloaded file, and its `commitHash`. Otherwise it returns an error.
+//
+// The files used to lock concurrent downloads are created next to the blobs in `cacheDir`, see DownloadWithLockDir
+// to store them elsewhere.
+//
+// Requests that fail with a 5xx or 429 status, or with a network error, are retried up to DefaultMaxRetries times
+// with exponential backoff, see RetryBaseDelay. Other failures (e.g. 401, 403 or 404) are returned immediately, and
+// can be identified with errors.Is(err, ErrHubFileNotFound) or errors.Is(err, ErrHubUnauthorized).
 func Download(ctx context.Context, client *http.Client,
 	repoId, repoType, revision, fileName, cacheDir, token string,
 	forceDownload, forceLocal bool, progressFn ProgressFn) (filePath, commitHash string, err error) {
+	return DownloadWithLockDir(ctx, client, repoId, repoType, revision, fileName, cacheDir, "", token,
+		forceDownload, forceLocal, progressFn)
+}
+
+// DownloadWithLockDir is like Download, but the files used to lock concurrent downloads are created under
+// `lockDir`, instead of next to the blobs in `cacheDir`. This allows the lock files to be on a separate writable
+// directory, e.g. when the cache is on a mount that doesn't allow them. If `lockDir` is empty, it behaves
+// exactly like Download.
+//
+// Lock files are organized in `lockDir` the same way as the blobs in `cacheDir`, so each blob has its own lock.
+func DownloadWithLockDir(ctx context.Context, client *http.Client,
+	repoId, repoType, revision, fileName, cacheDir, lockDir, token string,
+	forceDownload, forceLocal bool, progressFn ProgressFn) (filePath, commitHash string, err error) {
+	return download(ctx, client, repoId, repoType, revision, fileName, cacheDir, lockDir, HubEndpoint, token,
+		forceDownload, forceLocal, DefaultMaxRetries, progressFn)
+}
+
+// download implements DownloadWithLockDir, downloading from the given Hub endpoint and retrying failed requests up
+// to maxRetries times.
+func download(ctx context.Context, client *http.Client,
+	repoId, repoType, revision, fileName, cacheDir, lockDir, endpoint, token string,
+	forceDownload, forceLocal bool, maxRetries int, progressFn ProgressFn) (filePath, commitHash string, err error) {
 	if cacheDir == "" {
 		err = errors.New("Download() requires a cacheDir, even if temporary, to store the results of the download")
 		return
 	}
 	cacheDir = path.Clean(cacheDir)
 	userAgent := HttpUserAgent()
-	if token != "" {
-		// TODO, for now no token support.
-		err = errors.Errorf("no support yet for authentication token while attemption to download %q from %q",
-			fileName, repoId)
-		return
-	}
 	folderName := RepoFolderName(repoId, repoType)
 
 	// Find storage directory and if necessary create directories on disk.
@@ -213,20 +318,29 @@ func Download(ctx context.Context, client *http.Client,
 		}
 		filePath = getSnapshotPath(storageDir, commitHash, relativeFilePath)
 		if !FileExists(filePath) {
-			err = errors.Errorf("Download() with forceLocal, but file %q from repo %q not found in cache -- should be in %q", fileName, repoId, filePath)
+			if FileExists(getNoExistPath(storageDir, commitHash, relativeFilePath)) {
+				err = errors.Wrapf(ErrHubFileNotFound, "Download() with forceLocal, file %q from repo %q is cached as not existing in %q",
+					fileName, repoId, getNoExistPath(storageDir, commitHash, relativeFilePath))
+				return
+			}
+			err = errors.Wrapf(errNotInCache, "Download() with forceLocal, but file %q from repo %q not found in cache -- should be in %q", fileName, repoId, filePath)
 			return
 		}
 		return
 	}
 
 	// URL and headers for request.
-	url := GetUrl(repoId, fileName, repoType, revision)
+	url := getUrl(endpoint, repoId, fileName, repoType, revision)
 	headers := GetHeaders(userAgent, token)
 
 	// Get file Metadata.
 	var metadata *HFFileMetadata
-	metadata, err = getFileMetadata(ctx, client, url, token, headers)
+	metadata, err = getFileMetadata(ctx, client, url, token, headers, maxRetries)
 	if err != nil {
+		if errors.Is(err, ErrHubFileNotFound) && metadata != nil && metadata.CommitHash != "" {
+			// Record that the file doesn't exist in this commit, so loading from the cache doesn't need to ask again.
+			markNoExist(storageDir, metadata.CommitHash, revision, relativeFilePath)
+		}
 		return
 	}
 	commitHash = metadata.CommitHash
@@ -278,7 +392,7 @@ func Download(ctx context.Context, client *http.Client,
 	// If the generic blob is available (downloaded under a different name), link it and use it.
 	if FileExists(blobPath) && !forceDownload {
 		// ... create link
-		err = createSymLink(snapshotPath, blobPath)
+		err = linkSnapshotFile(snapshotPath, blobPath)
 		if err != nil {
 			err = errors.WithMessagef(err, "while downloading %q from %q", fileName, repoId)
 			return
@@ -287,10 +401,22 @@ func Download(ctx context.Context, client *http.Client,
 		return
 	}
 
-	// TODO: pre-check disk space availability.
+	// Fail early if there is not enough disk space, instead of leaving a partial download.
+	if err = checkDiskSpace(path.Dir(blobPath), metadata.Size); err != nil {
+		err = errors.WithMessagef(err, "while downloading %q from %q", fileName, repoId)
+		return
+	}
 
 	// Lock file to avoid parallel downloads.
 	lockPath := blobPath + ".lock"
+	if lockDir != "" {
+		lockPath = path.Join(path.Clean(lockDir), folderName, "blobs", etag+".lock")
+		if err = os.MkdirAll(path.Dir(lockPath), DefaultDirCreationPerm); err != nil {
+			err = errors.Wrapf(err, "cannot create lock directory %q for downloading %q from %q",
+				path.Dir(lockPath), fileName, repoId)
+			return
+		}
+	}
 	errLock := execOnFileLock(ctx, lockPath, func() {
 		if FileExists(blobPath) && !forceDownload {
 			// Some other process (or goroutine) already downloaded the file.
@@ -317,31 +443,17 @@ func Download(ctx context.Context, client *http.Client,
 			}
 		}()
 
-		// Connect and download with an HTTP GET.
-		var resp *http.Response
-		resp, err = client.Get(urlToDownload)
-		if err != nil {
-			err = errors.Wrapf(err, "failed request to download file to %q", urlToDownload)
-			return
-		}
-		defer resp.Body.Close()
-
-		// Replace reader with one that reports the progress, if requested.
-		var r io.Reader = resp.Body
-		if progressFn != nil {
-			r = &progressReader{
-				reader:     r,
-				downloaded: 0,
-				total:      metadata.Size,
-				progressFn: progressFn,
+		// Download with an HTTP GET, starting over in case of a retry.
+		err = withRetries(ctx, maxRetries, func() error {
+			if _, err := tmpFile.Seek(0, io.SeekStart); err != nil {
+				return errors.Wrapf(err, "failed to rewind temporary download file %q", tmpFilePath)
 			}
-			progressFn(0, 0, metadata.Size, false) // Do initial call with 0 downloaded.
-		}
-
-		// Download.
-		_, err := io.Copy(tmpFile, r)
+			if err := tmpFile.Truncate(0); err != nil {
+				return errors.Wrapf(err, "failed to truncate temporary download file %q", tmpFilePath)
+			}
+			return downloadBlob(ctx, client, urlToDownload, headers, tmpFile, metadata.Size, progressFn)
+		})
 		if err != nil {
-			err = errors.Wrapf(err, "failed to download file from %q", urlToDownload)
 			return
 		}
 
@@ -355,7 +467,7 @@ func Download(ctx context.Context, client *http.Client,
 			err = errors.Wrapf(err, "failed to move downloaded file %q to %q", tmpFilePath, blobPath)
 			return
 		}
-		if err = createSymLink(snapshotPath, blobPath); err != nil {
+		if err = linkSnapshotFile(snapshotPath, blobPath); err != nil {
 			return
 		}
 	})
@@ -370,6 +482,24 @@ func Download(ctx context.Context, client *http.Client,
 	return
 }
 
+// checkDiskSpace returns an error if there is not enough space in the filesystem of dir to download size bytes,
+// plus DiskSpaceMargin. The check is skipped if the size is unknown, or if the platform doesn't support it.
+func checkDiskSpace(dir string, size int) error {
+	if size <= 0 {
+		return nil
+	}
+	available, err := availableDiskBytes(dir)
+	if err != nil {
+		return nil
+	}
+	required := uint64(size) + DiskSpaceMargin
+	if available < required {
+		return errors.Errorf("not enough disk space in %q: %d bytes required (file size %d plus margin of %d, "+
+			"see DiskSpaceMargin), only %d available", dir, required, size, DiskSpaceMargin, available)
+	}
+	return nil
+}
+
 // HFFileMetadata used by HuggingFace Hub.
 type HFFileMetadata struct {
 	CommitHash, ETag, Location string
@@ -380,8 +510,150 @@ func removeQuotes(str string) string {
 	return strings.TrimRight(strings.TrimLeft(str, "\""), "\"")
 }
 
+// downloadBlob makes a "GET" HTTP request to url and writes the contents to w.
+// Failures that may succeed if retried are returned as a *retryableError, see withRetries.
+func downloadBlob(ctx context.Context, client *http.Client, url string, headers map[string]string,
+	w io.Writer, size int, progressFn ProgressFn) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create request to download file from %q", url)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return networkError(ctx, errors.Wrapf(err, "failed request to download file from %q", url))
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		contents, _ := io.ReadAll(resp.Body)
+		return statusError(resp, errors.Errorf("request to download file from %q failed with status %d: %q",
+			url, resp.StatusCode, contents))
+	}
+
+	// Replace reader with one that reports the progress, if requested.
+	var r io.Reader = resp.Body
+	if progressFn != nil {
+		r = &progressReader{
+			reader:     r,
+			downloaded: 0,
+			total:      size,
+			progressFn: progressFn,
+		}
+		progressFn(0, 0, size, false) // Do initial call with 0 downloaded.
+	}
+	if _, err = io.Copy(w, r); err != nil {
+		return networkError(ctx, errors.Wrapf(err, "failed to download file from %q", url))
+	}
+	return nil
+}
+
+// retryableError is returned by a request to the HuggingFace Hub that failed, but may succeed if retried.
+type retryableError struct {
+	err error
+
+	// retryAfter is the delay requested by the server before retrying, or 0 if none.
+	retryAfter time.Duration
+}
+
+// Error implements error.
+func (e *retryableError) Error() string { return e.err.Error() }
+
+// Unwrap returns the underlying error.
+func (e *retryableError) Unwrap() error { return e.err }
+
+// networkError returns err as a *retryableError, unless the failure was caused by ctx being done.
+func networkError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return err
+	}
+	return &retryableError{err: err}
+}
+
+// statusError returns err as a *retryableError if the status of resp is a 5xx or 429 (Too Many Requests), with the
+// delay of its `Retry-After` header. Other statuses (e.g. 401, 403 or 404) are not retried, and are returned
+// wrapping ErrHubFileNotFound or ErrHubUnauthorized, if applicable.
+func statusError(resp *http.Response, err error) error {
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return errors.Wrap(ErrHubFileNotFound, err.Error())
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return errors.Wrap(ErrHubUnauthorized, err.Error())
+	}
+	if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return err
+	}
+	return &retryableError{err: err, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+}
+
+// parseRetryAfter parses the value of a `Retry-After` header, either in seconds or an HTTP date.
+// It returns 0 if it is empty, invalid or in the past.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return 0
+}
+
+// withRetries calls fn, and calls it again up to maxRetries times while it fails with a *retryableError.
+//
+// Before each retry it waits for the delay requested by the server, or otherwise for RetryBaseDelay doubled at
+// each retry, plus a random jitter, at most RetryMaxDelay. It gives up if ctx is done while waiting.
+func withRetries(ctx context.Context, maxRetries int, fn func() error) error {
+	for retry := 0; ; retry++ {
+		err := fn()
+		var retryable *retryableError
+		if !errors.As(err, &retryable) {
+			return err
+		}
+		if retry >= maxRetries {
+			if retry == 0 {
+				return retryable.err
+			}
+			return errors.WithMessagef(retryable.err, "failed after %d attempts", retry+1)
+		}
+		delay := retryable.retryAfter
+		if delay <= 0 {
+			delay = RetryBaseDelay << retry
+			if delay > 0 {
+				delay += time.Duration(rand.Int63n(int64(delay)/2 + 1))
+			}
+		}
+		if RetryMaxDelay > 0 && (delay > RetryMaxDelay || delay < 0) {
+			delay = RetryMaxDelay
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Wrapf(ctx.Err(), "cancelled while waiting to retry (attempt %d failed with: %v)",
+				retry+1, retryable.err)
+		case <-timer.C:
+		}
+	}
+}
+
 // getFileMetadata: make a "HEAD" HTTP request and return the response with the header.
-func getFileMetadata(ctx context.Context, client *http.Client, url, token string, headers map[string]string) (metadata *HFFileMetadata, err error) {
+// Failed requests are retried up to maxRetries times, see withRetries.
+func getFileMetadata(ctx context.Context, client *http.Client, url, token string, headers map[string]string,
+	maxRetries int) (metadata *HFFileMetadata, err error) {
+	err = withRetries(ctx, maxRetries, func() error {
+		var err error
+		metadata, err = getFileMetadataOnce(ctx, client, url, token, headers)
+		return err
+	})
+	return
+}
+
+// getFileMetadataOnce implements one attempt of getFileMetadata.
+func getFileMetadataOnce(ctx context.Context, client *http.Client, url, token string, headers map[string]string) (metadata *HFFileMetadata, err error) {
 	// Create a request to download the tokenizer.
 	var req *http.Request
 	req, err = http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
@@ -396,26 +668,44 @@ func getFileMetadata(ctx context.Context, client *http.Client, url, token string
 	}
 	req.Header.Set("Accept-Encoding", "identity")
 
+	// Redirects within the Hub (e.g. renamed repositories) are followed, but not redirects to other servers
+	// (e.g. a CDN): the metadata headers are in the response of the Hub, and the authorization token must not be
+	// sent elsewhere. The file is later downloaded from metadata.Location.
+	noRedirectClient := *client
+	noRedirectClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if req.URL.Host != via[0].URL.Host {
+			return http.ErrUseLastResponse
+		}
+		if client.CheckRedirect != nil {
+			return client.CheckRedirect(req, via)
+		}
+		return nil
+	}
+
 	// Make the request and download the tokenizer.
-	resp, err := client.Do(req)
+	resp, err := noRedirectClient.Do(req)
 	if err != nil {
-		err = errors.Wrap(err, "failed request for metadata: ")
+		err = networkError(ctx, errors.Wrap(err, "failed request for metadata: "))
 		return
 	}
-
-	// TODO: handle redirects.
 	defer func() { _ = resp.Body.Close() }()
 	var contents []byte
 	contents, err = io.ReadAll(resp.Body)
 	if err != nil {
-		err = errors.Wrapf(err, "failed reading response (%d) for metadata: ", resp.StatusCode)
+		err = networkError(ctx, errors.Wrapf(err, "failed reading response (%d) for metadata: ", resp.StatusCode))
 		return
 	}
 
-	// Check status code.
-	if resp.StatusCode != 200 {
-		err = errors.Errorf("request for metadata from %q failed with the following message: %q",
-			url, contents)
+	// Check status code: redirects to other servers are handled by downloading from the Location.
+	location := resp.Header.Get("Location")
+	isRedirect := resp.StatusCode >= 300 && resp.StatusCode < 400 && location != ""
+	if resp.StatusCode != 200 && !isRedirect {
+		if resp.StatusCode == http.StatusNotFound {
+			// The Hub still reports the commit of the revision for files not in an existing repository.
+			metadata = &HFFileMetadata{CommitHash: resp.Header.Get(HeaderXRepoCommit)}
+		}
+		err = statusError(resp, errors.Errorf("request for metadata from %q failed with the following message: %q",
+			url, contents))
 		return
 	}
 
@@ -427,9 +717,15 @@ func getFileMetadata(ctx context.Context, client *http.Client, url, token string
 		metadata.ETag = resp.Header.Get("ETag")
 	}
 	metadata.ETag = removeQuotes(metadata.ETag)
-	metadata.Location = resp.Header.Get("Location")
-	if metadata.Location == "" {
-		metadata.Location = resp.Request.URL.String()
+	metadata.Location = resp.Request.URL.String()
+	if isRedirect {
+		var locationUrl *neturl.URL
+		locationUrl, err = resp.Request.URL.Parse(location)
+		if err != nil {
+			err = errors.Wrapf(err, "invalid redirect location %q for %q", location, url)
+			return
+		}
+		metadata.Location = locationUrl.String()
 	}
 
 	if sizeStr := resp.Header.Get(HeaderXLinkedSize); sizeStr != "" {
@@ -451,6 +747,25 @@ func getSnapshotPath(storageDir, commitHash, relativeFilePath string) string {
 	return path.Join(snapshotPath, commitHash, relativeFilePath)
 }
 
+// getNoExistPath returns the path of the marker recording that relativeFilePath doesn't exist in the given
+// commit, following the same convention (".no_exist" directory) as the HuggingFace Hub Python library.
+func getNoExistPath(storageDir, commitHash, relativeFilePath string) string {
+	return path.Join(storageDir, ".no_exist", commitHash, relativeFilePath)
+}
+
+// markNoExist records that relativeFilePath doesn't exist in the given commit, and maps the revision to it.
+// It is best-effort: failures only mean the file will be requested again.
+func markNoExist(storageDir, commitHash, revision, relativeFilePath string) {
+	noExistPath := getNoExistPath(storageDir, commitHash, relativeFilePath)
+	if err := os.MkdirAll(path.Dir(noExistPath), DefaultDirCreationPerm); err != nil {
+		return
+	}
+	if f, err := os.OpenFile(noExistPath, os.O_CREATE|os.O_WRONLY, 0644); err == nil {
+		_ = f.Close()
+	}
+	_ = cacheCommitHashForSpecificRevision(storageDir, commitHash, revision)
+}
+
 // cacheCommitHashForSpecificRevision creates reference between a revision (tag, branch or truncated commit hash)
 // and the corresponding commit hash.
 //
@@ -486,6 +801,17 @@ func cacheCommitHashForSpecificRevision(storageDir, commitHash, revision string)
 	return nil
 }
 
+// isSnapshotCached returns whether the snapshot of the repository for the given revision is present in cacheDir.
+// It doesn't check which files the snapshot holds.
+func isSnapshotCached(cacheDir, repoId, repoType, revision string) bool {
+	storageDir := path.Join(path.Clean(cacheDir), RepoFolderName(repoId, repoType))
+	commitHash, err := readCommitHashForRevision(storageDir, revision)
+	if err != nil || commitHash == "" {
+		return false
+	}
+	return FileExists(getSnapshotPath(storageDir, commitHash, ""))
+}
+
 // readCommitHashForRevision from disk.
 // Notice revision can be a commitHash: if we don't find a revision file, we assume that is the case.
 func readCommitHashForRevision(storageDir, revision string) (commitHash string, err error) {
@@ -541,6 +867,70 @@ func createSymLink(dst, src string) error {
 	return err
 }
 
+// LinkType is how Download places a downloaded file (blob) in the snapshot of a revision, see LinkStrategy.
+type LinkType uint8
+
+const (
+	// LinkSymlink creates a relative symbolic link to the blob, as the Python `huggingface_hub` library does.
+	LinkSymlink LinkType = iota
+
+	// LinkHardlink creates a hard link to the blob: the cache directory must be in a single filesystem.
+	LinkHardlink
+
+	// LinkCopy copies the blob, doubling the space used by the cache.
+	LinkCopy
+)
+
+// LinkStrategy configures how Download places the downloaded files (blobs) in the snapshots of the cache.
+// The default, LinkSymlink, is compatible with the Python library. LinkHardlink or LinkCopy can be used on
+// filesystems where symbolic links are not supported or undesirable (e.g. some container overlays or network
+// mounts).
+//
+// Notice CleanCache only tracks the blobs used through symbolic links: the blobs of hard linked or copied
+// snapshot files are taken as unused and removed, which is safe since the snapshot files keep their contents.
+var LinkStrategy = LinkSymlink
+
+// linkSnapshotFile places the blob src in the snapshot file dst, according to LinkStrategy.
+func linkSnapshotFile(dst, src string) error {
+	switch LinkStrategy {
+	case LinkHardlink:
+		if err := os.Link(src, dst); err != nil {
+			return errors.Wrapf(err, "while hard linking %q to %q", src, dst)
+		}
+		return nil
+	case LinkCopy:
+		return copyFile(dst, src)
+	default:
+		return createSymLink(dst, src)
+	}
+}
+
+// copyFile copies src to dst, through a temporary file, so dst is never left partially written.
+func copyFile(dst, src string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return errors.Wrapf(err, "while copying %q to %q", src, dst)
+	}
+	defer srcFile.Close()
+	tmpFile, err := os.CreateTemp(path.Dir(dst), "tmp_copy")
+	if err != nil {
+		return errors.Wrapf(err, "while copying %q to %q", src, dst)
+	}
+	tmpFilePath := tmpFile.Name()
+	_, err = io.Copy(tmpFile, srcFile)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFilePath, dst)
+	}
+	if err != nil {
+		_ = os.Remove(tmpFilePath)
+		return errors.Wrapf(err, "while copying %q to %q", src, dst)
+	}
+	return nil
+}
+
 // onFileLock locks the given file, executes the function, unlocks again and returns.
 func execOnFileLock(ctx context.Context, lockPath string, fn func()) error {
 	f, err := os.OpenFile(lockPath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, DefaultFileCreationPerm)
@@ -551,13 +941,13 @@ func execOnFileLock(ctx context.Context, lockPath string, fn func()) error {
 
 	// Acquire lock or return an error if context is canceled (due to time out).
 	for {
-		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
-		if err == nil {
-			break
-		}
-		if !errors.Is(err, syscall.EAGAIN) {
+		locked, err := tryLockFile(f)
+		if err != nil {
 			return errors.Wrapf(err, "while locking %q", lockPath)
 		}
+		if locked {
+			break
+		}
 
 		// Wait from 1 to 2 seconds.
 		timeDuration := time.Millisecond * time.Duration(1000+rand.Intn(1000))
@@ -573,9 +963,20 @@ func execOnFileLock(ctx context.Context, lockPath string, fn func()) error {
 	fn()
 
 	// Unlock and return.
-	err = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	err = unlockFile(f)
 	if err != nil {
 		return errors.Wrapf(err, "while unlocking %q", lockPath)
 	}
 	return nil
 }
+
+// CheckUpToDate checks whether the files of the repository repoId at the given revision (a branch, tag or commit
+// hash) cached in cacheDir are up-to-date with HuggingFace Hub, without downloading anything.
+//
+// It uses the default authentication token (see DefaultAuthToken) and endpoint (see HubEndpoint). Use
+// PretrainedConfig.CheckUpToDate to configure them, e.g. for private repositories or mirrors.
+//
+// If client is nil, a default http.Client is used.
+func CheckUpToDate(ctx context.Context, client *http.Client, repoId, revision, cacheDir string) (bool, error) {
+	return FromPretrainedWith(repoId).HttpClient(client).Context(ctx).CacheDir(cacheDir).CheckUpToDate(revision)
+}
@@ -0,0 +1,416 @@
+package tokenizers_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/gomlx/tokenizers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withTestHub redirects HuggingFace Hub requests to the given handler, for the duration of the test.
+func withTestHub(t *testing.T, handler http.HandlerFunc) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	previous := tokenizers.HuggingFaceUrlTemplate
+	t.Cleanup(func() { tokenizers.HuggingFaceUrlTemplate = previous })
+	tokenizers.HuggingFaceUrlTemplate = template.Must(template.New("hf_url").Parse(
+		server.URL + "/{{.RepoId}}/resolve/{{.Revision}}/{{.Filename}}"))
+}
+
+// hubFilesHandler serves the given files (indexed by file name) for any repository, at the given commit.
+// Files not in the map return 404.
+func hubFilesHandler(t *testing.T, commit string, files map[string][]byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		contents, found := files[path.Base(r.URL.Path)]
+		if !found {
+			// Like the Hub, the commit is reported also for files not in the repository.
+			w.Header().Set(tokenizers.HeaderXRepoCommit, commit)
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set(tokenizers.HeaderXRepoCommit, commit)
+		w.Header().Set("ETag", fmt.Sprintf("%q", fmt.Sprintf("%x", sha256.Sum256(contents))))
+		w.Header().Set("Content-Length", strconv.Itoa(len(contents)))
+		if r.Method == http.MethodGet {
+			_, err := w.Write(contents)
+			assert.NoError(t, err)
+		}
+	}
+}
+
+func TestCheckUpToDate(t *testing.T) {
+	const repoId, authToken = "gomlx/test-tokenizer", "secret-token"
+	const cachedCommit = "0123456789abcdef"
+	// The state of the repository is read by the server goroutine, so it is changed atomically.
+	var remoteCommit atomic.Value
+	remoteCommit.Store(cachedCommit)
+	var onlyConfig atomic.Bool // If set, the repository has no `tokenizer.json`, and the commit is not reported.
+	var (
+		mu             sync.Mutex
+		requestedFiles []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodHead, r.Method)
+		assert.Equal(t, "Bearer "+authToken, r.Header.Get("Authorization"))
+		fileName := path.Base(r.URL.Path)
+		assert.Equal(t, "/"+repoId+"/resolve/main/"+fileName, r.URL.Path)
+		mu.Lock()
+		requestedFiles = append(requestedFiles, fileName)
+		mu.Unlock()
+		if fileName == "tokenizer.json" && onlyConfig.Load() {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set(tokenizers.HeaderXRepoCommit, remoteCommit.Load().(string))
+		w.Header().Set("ETag", `"some-etag"`)
+	}))
+	t.Cleanup(server.Close)
+	cacheDir := t.TempDir()
+	checkUpToDate := func() bool {
+		mu.Lock()
+		requestedFiles = nil
+		mu.Unlock()
+		upToDate, err := tokenizers.FromPretrainedWith(repoId).CacheDir(cacheDir).Endpoint(server.URL).
+			AuthToken(authToken).CheckUpToDate("main")
+		require.NoError(t, err)
+		return upToDate
+	}
+
+	// Nothing cached yet.
+	assert.False(t, checkUpToDate())
+	assert.Empty(t, requestedFiles)
+
+	// Cache pointing to the same commit.
+	storageDir := path.Join(cacheDir, tokenizers.RepoFolderName(repoId, "model"))
+	require.NoError(t, os.MkdirAll(path.Join(storageDir, "refs"), 0755))
+	require.NoError(t, os.MkdirAll(path.Join(storageDir, "snapshots", cachedCommit), 0755))
+	require.NoError(t, os.WriteFile(path.Join(storageDir, "refs", "main"), []byte(cachedCommit), 0644))
+	assert.True(t, checkUpToDate())
+	assert.Equal(t, []string{"tokenizer.json"}, requestedFiles)
+
+	// Repository with only `tokenizer_config.json`: it falls back to it.
+	onlyConfig.Store(true)
+	assert.True(t, checkUpToDate())
+	assert.Equal(t, []string{"tokenizer.json", "tokenizer_config.json"}, requestedFiles)
+
+	// Upstream moved on.
+	remoteCommit.Store("fedcba9876543210")
+	assert.False(t, checkUpToDate())
+	onlyConfig.Store(false)
+	assert.False(t, checkUpToDate())
+
+	// CheckUpToDate uses the default endpoint and authentication token.
+	previousEndpoint := tokenizers.HubEndpoint
+	t.Cleanup(func() { tokenizers.HubEndpoint = previousEndpoint })
+	tokenizers.HubEndpoint = server.URL
+	for _, envVar := range tokenizers.AuthTokenEnvVars {
+		t.Setenv(envVar, authToken)
+	}
+	remoteCommit.Store(cachedCommit)
+	upToDate, err := tokenizers.CheckUpToDate(context.Background(), nil, repoId, "main", cacheDir)
+	require.NoError(t, err)
+	assert.True(t, upToDate)
+}
+
+func TestDownloadWithLockDir(t *testing.T) {
+	const repoId = "gomlx/test-tokenizer"
+	contents := []byte(`{"tokenizer_class": "BertTokenizer"}`)
+	withTestHub(t, hubFilesHandler(t, "0123456789abcdef", map[string][]byte{"tokenizer_config.json": contents}))
+	cacheDir, lockDir := t.TempDir(), t.TempDir()
+
+	filePath, commitHash, err := tokenizers.DownloadWithLockDir(context.Background(), &http.Client{},
+		repoId, "model", "main", "tokenizer_config.json", cacheDir, lockDir, "", false, false, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789abcdef", commitHash)
+	got, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	assert.Equal(t, contents, got)
+
+	// Lock file created in lockDir, organized per blob, and not in the cache.
+	folderName := tokenizers.RepoFolderName(repoId, "model")
+	etag := fmt.Sprintf("%x", sha256.Sum256(contents))
+	assert.FileExists(t, path.Join(lockDir, folderName, "blobs", etag+".lock"))
+	assert.NoFileExists(t, path.Join(cacheDir, folderName, "blobs", etag+".lock"))
+}
+
+func TestCleanCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	storageDir := path.Join(cacheDir, tokenizers.RepoFolderName("gomlx/test-tokenizer", "model"))
+	writeFile := func(filePath, contents string) {
+		require.NoError(t, os.MkdirAll(path.Dir(filePath), 0755))
+		require.NoError(t, os.WriteFile(filePath, []byte(contents), 0644))
+	}
+	link := func(commit, fileName, blob string) {
+		linkPath := path.Join(storageDir, "snapshots", commit, fileName)
+		require.NoError(t, os.MkdirAll(path.Dir(linkPath), 0755))
+		require.NoError(t, os.Symlink(path.Join("..", "..", "blobs", blob), linkPath))
+	}
+	writeFile(path.Join(storageDir, "refs", "main"), "new-commit")
+	writeFile(path.Join(storageDir, "blobs", "current"), "current tokenizer")
+	writeFile(path.Join(storageDir, "blobs", "old"), "old tokenizer")
+	writeFile(path.Join(storageDir, "blobs", "orphan"), "orphan")
+	writeFile(path.Join(storageDir, "blobs", "downloading"), "partial")
+	link("new-commit", "tokenizer.json", "current")
+	link("old-commit", "tokenizer.json", "old")
+
+	// Hold the lock of a blob being downloaded.
+	lockFile, err := os.Create(path.Join(storageDir, "blobs", "downloading.lock"))
+	require.NoError(t, err)
+	defer lockFile.Close()
+	locked, err := tokenizers.TryLockFile(lockFile)
+	require.NoError(t, err)
+	require.True(t, locked)
+
+	// Dry-run doesn't remove anything.
+	freed, err := tokenizers.CleanCache(cacheDir, tokenizers.CleanOptions{DryRun: true, RemoveUnreferencedSnapshots: true})
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("orphan")+len("old tokenizer")), freed)
+	assert.FileExists(t, path.Join(storageDir, "blobs", "orphan"))
+
+	// Only the orphan blob is removed.
+	freed, err = tokenizers.CleanCache(cacheDir, tokenizers.CleanOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("orphan")), freed)
+	assert.NoFileExists(t, path.Join(storageDir, "blobs", "orphan"))
+	assert.FileExists(t, path.Join(storageDir, "blobs", "old"))
+	assert.FileExists(t, path.Join(storageDir, "blobs", "downloading"))
+
+	// Old snapshot and its blob removed.
+	freed, err = tokenizers.CleanCache(cacheDir, tokenizers.CleanOptions{RemoveUnreferencedSnapshots: true})
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("old tokenizer")), freed)
+	assert.NoDirExists(t, path.Join(storageDir, "snapshots", "old-commit"))
+	assert.FileExists(t, path.Join(storageDir, "snapshots", "new-commit", "tokenizer.json"))
+	assert.FileExists(t, path.Join(storageDir, "blobs", "downloading"))
+}
+
+func TestAuthToken(t *testing.T) {
+	assert.Equal(t, map[string]string{"user-agent": "agent"}, tokenizers.GetHeaders("agent", ""))
+	assert.Equal(t, "Bearer secret", tokenizers.GetHeaders("agent", "secret")["authorization"])
+
+	// Gated repository: only serves files with the right token.
+	const repoId = "gomlx/gated"
+	serveFiles := hubFilesHandler(t, "0123456789abcdef", map[string][]byte{"tokenizer.json": []byte("{}")})
+	withTestHub(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "gated repository", http.StatusUnauthorized)
+			return
+		}
+		serveFiles(w, r)
+	})
+	ctx := context.Background()
+	_, _, err := tokenizers.Download(ctx, &http.Client{}, repoId, "model", "main", "tokenizer.json",
+		t.TempDir(), "", false, false, nil)
+	require.ErrorIs(t, err, tokenizers.ErrHubUnauthorized)
+	assert.NotErrorIs(t, err, tokenizers.ErrHubFileNotFound)
+	filePath, _, err := tokenizers.Download(ctx, &http.Client{}, repoId, "model", "main", "tokenizer.json",
+		t.TempDir(), "secret", false, false, nil)
+	require.NoError(t, err)
+	contents, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	assert.Equal(t, "{}", string(contents))
+
+	// Token read from the environment.
+	t.Setenv("HUGGING_FACE_HUB_TOKEN", "")
+	t.Setenv("HF_TOKEN", "secret")
+	assert.Equal(t, "secret", tokenizers.DefaultAuthToken())
+}
+
+func TestAuthTokenRedirect(t *testing.T) {
+	// The CDN must not receive the token.
+	contents := []byte("{}")
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get("Authorization"), "token sent to CDN in %s request", r.Method)
+		_, _ = w.Write(contents)
+	}))
+	t.Cleanup(cdn.Close)
+	withTestHub(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		w.Header().Set(tokenizers.HeaderXRepoCommit, "0123456789abcdef")
+		w.Header().Set(tokenizers.HeaderXLinkedETag, `"some-etag"`)
+		http.Redirect(w, r, cdn.URL+"/blob", http.StatusFound)
+	})
+	filePath, _, err := tokenizers.Download(context.Background(), &http.Client{}, "gomlx/gated", "model", "main",
+		"tokenizer.json", t.TempDir(), "secret", false, false, nil)
+	require.NoError(t, err)
+	got, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	assert.Equal(t, contents, got)
+}
+
+func TestDownloadDiskSpace(t *testing.T) {
+	withTestHub(t, hubFilesHandler(t, "0123456789abcdef", map[string][]byte{"tokenizer.json": []byte("{}")}))
+	previous := tokenizers.DiskSpaceMargin
+	t.Cleanup(func() { tokenizers.DiskSpaceMargin = previous })
+
+	// Requiring an impossible margin fails before downloading anything.
+	tokenizers.DiskSpaceMargin = 1 << 62
+	cacheDir := t.TempDir()
+	_, _, err := tokenizers.Download(context.Background(), &http.Client{}, "gomlx/test", "model", "main",
+		"tokenizer.json", cacheDir, "", false, false, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not enough disk space")
+	blobs, err := os.ReadDir(path.Join(cacheDir, tokenizers.RepoFolderName("gomlx/test", "model"), "blobs"))
+	require.NoError(t, err)
+	assert.Empty(t, blobs)
+
+	// Default margin.
+	tokenizers.DiskSpaceMargin = previous
+	_, _, err = tokenizers.Download(context.Background(), &http.Client{}, "gomlx/test", "model", "main",
+		"tokenizer.json", cacheDir, "", false, false, nil)
+	require.NoError(t, err)
+}
+
+func TestDownloadRetries(t *testing.T) {
+	previous := tokenizers.RetryBaseDelay
+	t.Cleanup(func() { tokenizers.RetryBaseDelay = previous })
+	tokenizers.RetryBaseDelay = time.Millisecond
+
+	// Each request fails once with a transient error, before being served.
+	contents := []byte("{}")
+	serveFiles := hubFilesHandler(t, "0123456789abcdef", map[string][]byte{"tokenizer.json": contents})
+	var (
+		mu       sync.Mutex
+		requests = map[string]int{}
+	)
+	withTestHub(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		key := r.Method + " " + path.Base(r.URL.Path)
+		requests[key]++
+		count := requests[key]
+		mu.Unlock()
+		if count == 1 && path.Base(r.URL.Path) == "tokenizer.json" {
+			status := http.StatusServiceUnavailable
+			if r.Method == http.MethodGet {
+				status = http.StatusTooManyRequests
+			}
+			http.Error(w, "try again", status)
+			return
+		}
+		serveFiles(w, r)
+	})
+	ctx := context.Background()
+	filePath, _, err := tokenizers.Download(ctx, &http.Client{}, "gomlx/test", "model", "main", "tokenizer.json",
+		t.TempDir(), "", false, false, nil)
+	require.NoError(t, err)
+	got, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	assert.Equal(t, contents, got)
+	assert.Equal(t, map[string]int{"HEAD tokenizer.json": 2, "GET tokenizer.json": 2}, requests)
+
+	// 404 is not retried.
+	_, _, err = tokenizers.Download(ctx, &http.Client{}, "gomlx/test", "model", "main", "missing.json",
+		t.TempDir(), "", false, false, nil)
+	require.ErrorIs(t, err, tokenizers.ErrHubFileNotFound)
+	assert.NotErrorIs(t, err, tokenizers.ErrHubUnauthorized)
+	assert.Equal(t, 1, requests["HEAD missing.json"])
+
+	// Retry-After is respected, and a context done while waiting aborts the retries.
+	withTestHub(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3600")
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+	})
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, _, err = tokenizers.Download(ctx, &http.Client{}, "gomlx/test", "model", "main", "tokenizer.json",
+		t.TempDir(), "", false, false, nil)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Contains(t, err.Error(), "cancelled while waiting to retry")
+
+	// A Retry-After longer than RetryMaxDelay is clamped to it.
+	previousMax := tokenizers.RetryMaxDelay
+	t.Cleanup(func() { tokenizers.RetryMaxDelay = previousMax })
+	tokenizers.RetryMaxDelay = time.Millisecond
+	var numRequests atomic.Int32
+	withTestHub(t, func(w http.ResponseWriter, r *http.Request) {
+		if numRequests.Add(1) == 1 {
+			w.Header().Set("Retry-After", "3600")
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+			return
+		}
+		serveFiles(w, r)
+	})
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filePath, _, err = tokenizers.Download(ctx, &http.Client{}, "gomlx/test", "model", "main", "tokenizer.json",
+		t.TempDir(), "", false, false, nil)
+	require.NoError(t, err)
+	got, err = os.ReadFile(filePath)
+	require.NoError(t, err)
+	assert.Equal(t, contents, got)
+}
+
+func TestHubEndpoint(t *testing.T) {
+	previous := tokenizers.HubEndpoint
+	t.Cleanup(func() { tokenizers.HubEndpoint = previous })
+	assert.Equal(t, "https://huggingface.co/gomlx/test/resolve/main/tokenizer.json",
+		tokenizers.GetUrl("gomlx/test", "tokenizer.json", "model", ""))
+
+	tokenizers.HubEndpoint = "https://hf-mirror.com"
+	assert.Equal(t, "https://hf-mirror.com/datasets/gomlx/test/resolve/v1/tokenizer.json",
+		tokenizers.GetUrl("gomlx/test", "tokenizer.json", "dataset", "v1"))
+
+	// Download from a mirror.
+	contents := []byte("{}")
+	var requestedPaths []string
+	serveFiles := hubFilesHandler(t, "0123456789abcdef", map[string][]byte{"tokenizer.json": contents})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedPaths = append(requestedPaths, r.URL.Path)
+		serveFiles(w, r)
+	}))
+	t.Cleanup(server.Close)
+	tokenizers.HubEndpoint = server.URL + "/"
+	filePath, _, err := tokenizers.Download(context.Background(), &http.Client{}, "gomlx/test", "space", "main",
+		"tokenizer.json", t.TempDir(), "", false, false, nil)
+	require.NoError(t, err)
+	got, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	assert.Equal(t, contents, got)
+	assert.Equal(t, []string{"/spaces/gomlx/test/resolve/main/tokenizer.json",
+		"/spaces/gomlx/test/resolve/main/tokenizer.json"}, requestedPaths)
+}
+
+func TestLinkStrategy(t *testing.T) {
+	previous := tokenizers.LinkStrategy
+	t.Cleanup(func() { tokenizers.LinkStrategy = previous })
+	contents := []byte(`{"tokenizer_class": "BertTokenizer"}`)
+	withTestHub(t, hubFilesHandler(t, "0123456789abcdef", map[string][]byte{"tokenizer_config.json": contents}))
+	etag := fmt.Sprintf("%x", sha256.Sum256(contents))
+
+	for _, strategy := range []tokenizers.LinkType{tokenizers.LinkSymlink, tokenizers.LinkHardlink, tokenizers.LinkCopy} {
+		t.Run(strategy.String(), func(t *testing.T) {
+			tokenizers.LinkStrategy = strategy
+			cacheDir := t.TempDir()
+			filePath, _, err := tokenizers.Download(context.Background(), &http.Client{}, "gomlx/test", "model", "main",
+				"tokenizer_config.json", cacheDir, "", false, false, nil)
+			require.NoError(t, err)
+			got, err := os.ReadFile(filePath)
+			require.NoError(t, err)
+			assert.Equal(t, contents, got)
+
+			fileInfo, err := os.Lstat(filePath)
+			require.NoError(t, err)
+			assert.Equal(t, strategy == tokenizers.LinkSymlink, fileInfo.Mode()&os.ModeSymlink != 0)
+			blobInfo, err := os.Stat(path.Join(cacheDir, tokenizers.RepoFolderName("gomlx/test", "model"), "blobs", etag))
+			require.NoError(t, err)
+			if strategy != tokenizers.LinkSymlink {
+				// Only the hard link shares the contents with the blob.
+				assert.Equal(t, strategy == tokenizers.LinkHardlink, os.SameFile(fileInfo, blobInfo))
+			}
+		})
+	}
+}
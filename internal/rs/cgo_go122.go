@@ -14,14 +14,20 @@ package rs
 
 #cgo noescape from_bytes
 #cgo nocallback from_bytes
-#cgo noescape from_bytes_with_truncation
-#cgo nocallback from_bytes_with_truncation
+#cgo noescape to_bytes
+#cgo nocallback to_bytes
 #cgo noescape free_tokenizer
 #cgo nocallback free_tokenizer
 #cgo noescape encode
 #cgo nocallback encode
-#cgo noescape free_buffer
-#cgo nocallback free_buffer
+#cgo noescape encode_pair
+#cgo nocallback encode_pair
+#cgo noescape encode_pretokenized
+#cgo nocallback encode_pretokenized
+#cgo noescape encode_overflowing
+#cgo nocallback encode_overflowing
+#cgo noescape free_encode_results
+#cgo nocallback free_encode_results
 #cgo noescape encode_batch
 #cgo nocallback encode_batch
 #cgo noescape decode
@@ -30,6 +36,22 @@ package rs
 #cgo nocallback free_string
 #cgo noescape vocab_size
 #cgo nocallback vocab_size
+#cgo noescape tokens_to_ids
+#cgo nocallback tokens_to_ids
+#cgo noescape ids_to_tokens
+#cgo nocallback ids_to_tokens
+#cgo noescape token_to_id
+#cgo nocallback token_to_id
+#cgo noescape id_to_token
+#cgo nocallback id_to_token
+#cgo noescape model_id_to_token
+#cgo nocallback model_id_to_token
+#cgo noescape native_memory_bytes
+#cgo nocallback native_memory_bytes
+#cgo noescape add_tokens
+#cgo nocallback add_tokens
+#cgo noescape set_bpe_dropout
+#cgo nocallback set_bpe_dropout
 
 */
 import "C"
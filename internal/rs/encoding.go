@@ -0,0 +1,212 @@
+package rs
+
+import (
+	"github.com/rivo/uniseg"
+	"slices"
+	"sort"
+)
+
+// This file holds helper methods for Encoding.
+
+// Clone returns a deep copy of the encoding, that doesn't share any of its slices.
+func (e *Encoding) Clone() Encoding {
+	clone := *e
+	clone.TokenIds = slices.Clone(e.TokenIds)
+	clone.TypeIds = slices.Clone(e.TypeIds)
+	clone.SpecialTokensMask = slices.Clone(e.SpecialTokensMask)
+	clone.AttentionMask = slices.Clone(e.AttentionMask)
+	clone.Tokens = slices.Clone(e.Tokens)
+	clone.Offsets = slices.Clone(e.Offsets)
+	clone.WordIds = slices.Clone(e.WordIds)
+	clone.SequenceIds = slices.Clone(e.SequenceIds)
+	clone.ContinuationMask = slices.Clone(e.ContinuationMask)
+	clone.FirstSubwordMask = slices.Clone(e.FirstSubwordMask)
+	clone.TokenHashes = slices.Clone(e.TokenHashes)
+	clone.CharOffsets = slices.Clone(e.CharOffsets)
+	clone.TokenKinds = slices.Clone(e.TokenKinds)
+	clone.DroppedIds = slices.Clone(e.DroppedIds)
+	clone.DroppedTokens = slices.Clone(e.DroppedTokens)
+	return clone
+}
+
+// NumPadded returns the number of padding tokens added to the encoding.
+//
+// It is derived from the AttentionMask (the number of masked positions), so it requires the attention mask to
+// have been returned. If it is not present, it returns 0.
+func (e *Encoding) NumPadded() int {
+	count := 0
+	for _, mask := range e.AttentionMask {
+		if mask == 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// NumRealTokens returns the number of tokens in the encoding that are not padding.
+//
+// It is derived from the AttentionMask, so it requires the attention mask to have been returned. If it is not
+// present, it returns the number of tokens (`len(e.TokenIds)`).
+func (e *Encoding) NumRealTokens() int {
+	return len(e.TokenIds) - e.NumPadded()
+}
+
+// GraphemeOffsets returns the Offsets of the tokens snapped outward to the boundaries of the grapheme clusters
+// (user-perceived characters) of input, the sentence that was encoded.
+//
+// So a token that covers only part of a grapheme cluster (e.g. "e" followed by a combining accent) is extended
+// to cover the whole cluster. Useful for highlighting tokens in a UI.
+//
+// It requires the offsets to have been returned in bytes (OffsetsCharModeByte). It returns nil if the offsets
+// were not returned.
+func (e *Encoding) GraphemeOffsets(input string) []Offset {
+	if len(e.Offsets) == 0 {
+		return nil
+	}
+	boundaries := []int{0}
+	graphemes := uniseg.NewGraphemes(input)
+	for graphemes.Next() {
+		_, to := graphemes.Positions()
+		boundaries = append(boundaries, to)
+	}
+
+	offsets := make([]Offset, len(e.Offsets))
+	for ii, offset := range e.Offsets {
+		start, end := int(offset.Start), int(offset.End)
+		// Start snaps down to the last boundary <= start.
+		idx := sort.SearchInts(boundaries, start)
+		if idx == len(boundaries) || boundaries[idx] > start {
+			idx--
+		}
+		offsets[ii].Start = uint32(boundaries[idx])
+		// End snaps up to the first boundary >= end.
+		idx = sort.SearchInts(boundaries, end)
+		if idx == len(boundaries) {
+			offsets[ii].End = uint32(len(input))
+		} else {
+			offsets[ii].End = uint32(boundaries[idx])
+		}
+	}
+	return offsets
+}
+
+// TokenWords returns, for each token, the text of the word in input (the sentence that was encoded) it came
+// from. Special tokens (and other tokens not associated to a word) get an empty string.
+//
+// Since the text is taken from input using the Offsets, it is the original text, before normalization (e.g.:
+// not lower-cased). Consecutive tokens with the same word id are taken to be the same word.
+//
+// It requires WordIds and the Offsets in bytes (OffsetsCharModeByte) to have been returned, and it returns nil
+// otherwise.
+func (e *Encoding) TokenWords(input string) []string {
+	if len(e.WordIds) == 0 || len(e.Offsets) != len(e.WordIds) {
+		return nil
+	}
+	words := make([]string, len(e.WordIds))
+	for start := 0; start < len(e.WordIds); {
+		wordId := e.WordIds[start]
+		end := start + 1
+		for end < len(e.WordIds) && e.WordIds[end] == wordId {
+			end++
+		}
+		if wordId >= 0 {
+			// The word spans from the first to the last of its tokens.
+			from, to := int(e.Offsets[start].Start), int(e.Offsets[start].End)
+			for _, offset := range e.Offsets[start+1 : end] {
+				from = min(from, int(offset.Start))
+				to = max(to, int(offset.End))
+			}
+			to = min(to, len(input))
+			from = min(from, to)
+			for ii := start; ii < end; ii++ {
+				words[ii] = input[from:to]
+			}
+		}
+		start = end
+	}
+	return words
+}
+
+// CharToToken returns the index of the token that covers the position charPos of the input, and true.
+// It returns false if no token covers it (e.g. whitespace removed by the pre-tokenizer) or if the offsets
+// were not returned.
+//
+// charPos is in the same unit as the Offsets: bytes or Unicode code points, according to the
+// WithOffsetsCharMode used when encoding. For pairs of sentences, only the tokens of the first sentence are
+// considered if SequenceIds was returned.
+func (e *Encoding) CharToToken(charPos int) (tokenIndex int, ok bool) {
+	if charPos < 0 {
+		return 0, false
+	}
+	for ii, offset := range e.Offsets {
+		if len(e.SequenceIds) == len(e.Offsets) && e.SequenceIds[ii] != 0 {
+			continue
+		}
+		if int(offset.Start) <= charPos && charPos < int(offset.End) {
+			return ii, true
+		}
+	}
+	return 0, false
+}
+
+// TokenToChars returns the span [start, end) of the input covered by the token at tokenIndex, and true.
+// It returns false if tokenIndex is out of range, if the token doesn't cover any of the input (e.g. special
+// or padding tokens) or if the offsets were not returned.
+//
+// The span is in the same unit as the Offsets: bytes or Unicode code points, according to the
+// WithOffsetsCharMode used when encoding.
+func (e *Encoding) TokenToChars(tokenIndex int) (start, end int, ok bool) {
+	if tokenIndex < 0 || tokenIndex >= len(e.Offsets) {
+		return 0, 0, false
+	}
+	offset := e.Offsets[tokenIndex]
+	if offset.Start == offset.End {
+		return 0, 0, false
+	}
+	return int(offset.Start), int(offset.End), true
+}
+
+// WordToTokens returns the span [startTok, endTok) of the tokens of the word wordIdx (as split by the
+// pre-tokenizer, see WordIds), and true. It returns false if no token belongs to the word, or if the WordIds
+// were not returned (see ReturnWordIds). It matches the Rust `Encoding::word_to_tokens`.
+//
+// For pairs of sentences, only the words of the first sentence are considered if SequenceIds was returned.
+func (e *Encoding) WordToTokens(wordIdx int) (startTok, endTok int, ok bool) {
+	if wordIdx < 0 {
+		return 0, 0, false
+	}
+	for ii, wordId := range e.WordIds {
+		if int(wordId) != wordIdx || (len(e.SequenceIds) == len(e.WordIds) && e.SequenceIds[ii] != 0) {
+			continue
+		}
+		if !ok {
+			startTok, ok = ii, true
+		}
+		endTok = ii + 1
+	}
+	return startTok, endTok, ok
+}
+
+// WordToChars returns the span [startChar, endChar) of the input covered by the word wordIdx (as split by the
+// pre-tokenizer, see WordIds), and true. It returns false if no token belongs to the word, or if the WordIds or
+// the Offsets were not returned (see ReturnWordIds and ReturnOffsets). It matches the Rust
+// `Encoding::word_to_chars`.
+//
+// The span is in the same unit as the Offsets: bytes or Unicode code points, according to the
+// WithOffsetsCharMode used when encoding. For pairs of sentences, only the words of the first sentence are
+// considered if SequenceIds was returned.
+func (e *Encoding) WordToChars(wordIdx int) (startChar, endChar int, ok bool) {
+	if len(e.Offsets) != len(e.WordIds) {
+		return 0, 0, false
+	}
+	startTok, endTok, ok := e.WordToTokens(wordIdx)
+	if !ok {
+		return 0, 0, false
+	}
+	startChar, endChar = int(e.Offsets[startTok].Start), int(e.Offsets[startTok].End)
+	for _, offset := range e.Offsets[startTok+1 : endTok] {
+		startChar = min(startChar, int(offset.Start))
+		endChar = max(endChar, int(offset.End))
+	}
+	return startChar, endChar, true
+}
@@ -0,0 +1,111 @@
+package rs_test
+
+import (
+	"testing"
+
+	"github.com/gomlx/tokenizers/internal/rs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphemeOffsets(t *testing.T) {
+	// "e" + combining acute accent (2 bytes) form one grapheme cluster: bytes [3, 6).
+	const input = "cafe\u0301 x"
+	encoding := &rs.Encoding{
+		Offsets: []rs.Offset{{Start: 0, End: 4}, {Start: 4, End: 6}, {Start: 7, End: 8}},
+	}
+	assert.Equal(t, []rs.Offset{{Start: 0, End: 6}, {Start: 3, End: 6}, {Start: 7, End: 8}},
+		encoding.GraphemeOffsets(input))
+
+	// Offsets not returned.
+	assert.Nil(t, (&rs.Encoding{}).GraphemeOffsets(input))
+}
+
+func TestTokenWords(t *testing.T) {
+	const input = "New Yorkers"
+	encoding := &rs.Encoding{
+		WordIds: []int32{-1, 0, 1, 1, -1},
+		Offsets: []rs.Offset{{0, 0}, {0, 3}, {4, 8}, {8, 11}, {0, 0}},
+	}
+	assert.Equal(t, []string{"", "New", "Yorkers", "Yorkers", ""}, encoding.TokenWords(input))
+	assert.Nil(t, (&rs.Encoding{}).TokenWords(input))
+}
+
+func TestClone(t *testing.T) {
+	encoding := &rs.Encoding{
+		TokenIds: []uint32{101, 2829, 102},
+		Tokens:   []string{"[CLS]", "brown", "[SEP]"},
+		Offsets:  []rs.Offset{{0, 0}, {0, 5}, {0, 0}},
+	}
+	clone := encoding.Clone()
+	assert.Equal(t, *encoding, clone)
+	clone.TokenIds[1] = 0
+	clone.Tokens[1] = "fox"
+	assert.Equal(t, uint32(2829), encoding.TokenIds[1])
+	assert.Equal(t, "brown", encoding.Tokens[1])
+	assert.Nil(t, clone.AttentionMask)
+}
+
+func TestCharToToken(t *testing.T) {
+	// "[CLS] new york ##ers [SEP]" for input "New Yorkers".
+	encoding := &rs.Encoding{
+		Offsets: []rs.Offset{{0, 0}, {0, 3}, {4, 8}, {8, 11}, {0, 0}},
+	}
+	for charPos, want := range []int{1, 1, 1, -1, 2, 2, 2, 2, 3, 3, 3, -1} {
+		tokenIndex, ok := encoding.CharToToken(charPos)
+		if want < 0 {
+			assert.False(t, ok, "charPos=%d", charPos)
+			continue
+		}
+		assert.True(t, ok, "charPos=%d", charPos)
+		assert.Equal(t, want, tokenIndex, "charPos=%d", charPos)
+	}
+	_, ok := encoding.CharToToken(-1)
+	assert.False(t, ok)
+
+	start, end, ok := encoding.TokenToChars(2)
+	assert.True(t, ok)
+	assert.Equal(t, []int{4, 8}, []int{start, end})
+	for _, tokenIndex := range []int{-1, 0, 4, 5} {
+		_, _, ok = encoding.TokenToChars(tokenIndex)
+		assert.False(t, ok, "tokenIndex=%d", tokenIndex)
+	}
+
+	// Only the first sentence of a pair.
+	encoding.SequenceIds = []int32{-1, 0, 0, 1, -1}
+	_, ok = encoding.CharToToken(9)
+	assert.False(t, ok)
+}
+
+func TestWordToTokens(t *testing.T) {
+	// "[CLS] new york ##ers [SEP]" for input "New Yorkers".
+	encoding := &rs.Encoding{
+		WordIds: []int32{-1, 0, 1, 1, -1},
+		Offsets: []rs.Offset{{0, 0}, {0, 3}, {4, 8}, {8, 11}, {0, 0}},
+	}
+	startTok, endTok, ok := encoding.WordToTokens(1)
+	assert.True(t, ok)
+	assert.Equal(t, []int{2, 4}, []int{startTok, endTok})
+	startChar, endChar, ok := encoding.WordToChars(1)
+	assert.True(t, ok)
+	assert.Equal(t, []int{4, 11}, []int{startChar, endChar})
+	startChar, endChar, ok = encoding.WordToChars(0)
+	assert.True(t, ok)
+	assert.Equal(t, []int{0, 3}, []int{startChar, endChar})
+	for _, wordIdx := range []int{-1, 2} {
+		_, _, ok = encoding.WordToTokens(wordIdx)
+		assert.False(t, ok, "wordIdx=%d", wordIdx)
+		_, _, ok = encoding.WordToChars(wordIdx)
+		assert.False(t, ok, "wordIdx=%d", wordIdx)
+	}
+
+	// Only the first sentence of a pair.
+	encoding.SequenceIds = []int32{-1, 0, 1, 1, -1}
+	_, _, ok = encoding.WordToTokens(1)
+	assert.False(t, ok)
+
+	// WordIds or Offsets not returned.
+	_, _, ok = (&rs.Encoding{Offsets: encoding.Offsets}).WordToTokens(0)
+	assert.False(t, ok)
+	_, _, ok = (&rs.Encoding{WordIds: encoding.WordIds}).WordToChars(0)
+	assert.False(t, ok)
+}
@@ -65,7 +65,10 @@ typedef struct Buffer {
   uint32_t *attention_mask;
   char **tokens;
   struct Offset *offsets;
+  int32_t *word_ids;
+  int32_t *sequence_ids;
   uint32_t len;
+  uint32_t unpadded_len;
 } Buffer;
 
 /**
@@ -96,8 +99,23 @@ typedef struct EncodeParams {
   bool return_attention_mask;
   bool return_offsets;
   bool with_offsets_char_mode;
+  bool return_word_ids;
+  bool return_sequence_ids;
+  bool return_lengths;
+  bool with_dropout_seed;
+  uint64_t dropout_seed;
 } EncodeParams;
 
+/**
+ * AddedTokenSpec defines a token to be added to the vocabulary, with its options.
+ * It maps to tokenizers::AddedToken.
+ */
+typedef struct AddedTokenSpec {
+  const char *content;
+  bool single_word;
+  bool normalized;
+} AddedTokenSpec;
+
 /**
  * This function returns a Tokenizer reference to Golang (casted as a C `void*` in the `value` field) or
  * an error.
@@ -112,6 +130,19 @@ typedef struct EncodeParams {
 struct PointerOrError from_bytes(const uint8_t *bytes,
                                  uint32_t len);
 
+/**
+ * to_bytes serializes the Tokenizer to the json format of a `tokenizer.json` file -- the same
+ * format read by `from_bytes`. It includes the current truncation and padding parameters.
+ *
+ * It returns the json contents as a C string (`char *`) in the `value` field, or an error.
+ *
+ * # Safety
+ *
+ * Ownership of the returned `value` (or `error`) is transferred to the caller, who must free it
+ * with `free_string`.
+ */
+struct PointerOrError to_bytes(void *tokenizer_ptr, bool pretty);
+
 /**
  * Frees a Tokenizer allocated by Rust and returned to Golang.
  */
@@ -123,9 +154,9 @@ void free_tokenizer(void *ptr);
 void free_string(char *ptr);
 
 /**
- * Returns the vocab size.
+ * Returns the vocab size, including the added tokens if `with_added_tokens` is set.
  */
-uint32_t vocab_size(void *ptr);
+uint32_t vocab_size(void *ptr, bool with_added_tokens);
 
 /**
  * set_truncation modifies the tokenizer with the given truncation parameters.
@@ -169,6 +200,34 @@ bool get_padding(void *tokenizer_ptr,
  */
 struct EncodeResults encode(void *tokenizer_ptr, const char *message, struct EncodeParams options);
 
+/**
+ * Encodes a pair of strings (sequences A and B) using given tokenizer and EncodeParams.
+ * The post-processor of the tokenizer defines how they are combined, and the type ids of each.
+ */
+struct EncodeResults encode_pair(void *tokenizer_ptr,
+                                 const char *message,
+                                 const char *pair,
+                                 struct EncodeParams options);
+
+/**
+ * Encodes a pre-tokenized sentence, given as `num_words` words, using given tokenizer and EncodeParams.
+ * The words are not merged across their boundaries, and the offsets are relative to each word.
+ */
+struct EncodeResults encode_pretokenized(void *tokenizer_ptr,
+                                         uint32_t num_words,
+                                         const char *const *words,
+                                         struct EncodeParams options);
+
+/**
+ * Encodes string using given tokenizer and EncodeParams, and returns the encoding followed by its
+ * overflowing encodings: the windows of tokens that didn't fit the truncation length.
+ *
+ * If truncation is not configured, or if the string fits, only one result is returned.
+ */
+struct EncodeResults encode_overflowing(void *tokenizer_ptr,
+                                        const char *message,
+                                        struct EncodeParams options);
+
 /**
  * Encode a batch of strings using given tokenizer and EncodeParams.
  * The
@@ -189,4 +248,78 @@ void free_encode_results(struct EncodeResults results);
  */
 char *decode(void *tokenizer_ptr, const uint32_t *ids, uint32_t len, bool skip_special_tokens);
 
+/**
+ * tokens_to_ids converts each of the `len` tokens to its id, including added tokens, all in one call.
+ *
+ * The ids are stored in `ids` and whether each token was found in `found`, both arrays of length `len`
+ * owned by the caller. Tokens not found are given id 0.
+ *
+ * It returns false if the tokenizer is invalid, in which case `ids` and `found` are not changed.
+ */
+bool tokens_to_ids(void *tokenizer_ptr,
+                   const char *const *tokens,
+                   uint32_t len,
+                   uint32_t *ids,
+                   bool *found);
+
+/**
+ * ids_to_tokens converts each of the `len` ids to its token string, including added tokens, all in one call.
+ *
+ * The tokens are stored in `tokens`, an array of length `len` owned by the caller. Each token string
+ * is owned by the caller, and must be freed with `free_string`. Unknown ids are set to null.
+ *
+ * It returns false if the tokenizer is invalid, in which case `tokens` is not changed.
+ */
+bool ids_to_tokens(void *tokenizer_ptr, const uint32_t *ids, uint32_t len, char **tokens);
+
+/**
+ * token_to_id converts a single token to its id, including added tokens. The id is stored in `id`.
+ *
+ * It returns whether the token was found, or false if the tokenizer is invalid, in which case `id` is not changed.
+ */
+bool token_to_id(void *tokenizer_ptr, const char *token, uint32_t *id);
+
+/**
+ * id_to_token converts a single id to its token string, including added tokens.
+ *
+ * The returned string is owned by the caller, and must be freed with `free_string`. It returns null if the id
+ * is not in the vocabulary, or if the tokenizer is invalid.
+ */
+char *id_to_token(void *tokenizer_ptr, uint32_t id);
+
+/**
+ * model_id_to_token converts a single id to the token string stored in the vocabulary of the model, ignoring
+ * added tokens.
+ *
+ * The returned string is owned by the caller, and must be freed with `free_string`. It returns null if the id
+ * is not in the vocabulary of the model, or if the tokenizer is invalid.
+ */
+char *model_id_to_token(void *tokenizer_ptr, uint32_t id);
+
+/**
+ * add_tokens adds the `len` tokens defined by `specs` to the vocabulary of the tokenizer, as special tokens
+ * if `special` is set. Tokens already in the vocabulary are not added again.
+ *
+ * It returns the number of tokens actually added, or 0 if the tokenizer is invalid.
+ */
+uint32_t add_tokens(void *tokenizer_ptr,
+                    const struct AddedTokenSpec *specs,
+                    uint32_t len,
+                    bool special);
+
+/**
+ * set_bpe_dropout sets the dropout probability of the merges of a BPE model. A dropout of 0 disables it.
+ * It returns null if ok, or a string with an error message (owned by caller) if the model is not BPE.
+ * The returned string needs to be freed with `free_string`.
+ */
+char *set_bpe_dropout(void *tokenizer_ptr, float dropout);
+
+/**
+ * native_memory_bytes stores in `bytes` a rough estimate of the memory used by the tokenizer: the vocabulary
+ * (stored in both directions, token to id and id to token), the merges of BPE models and the tokenizer structure.
+ * It returns null if ok, or a string with an error message (owned by caller).
+ * The returned string needs to be freed with `free_string`.
+ */
+char *native_memory_bytes(void *tokenizer_ptr, uint64_t *bytes);
+
 /* File generated with cbindgen from the Rust library -- don't change it directly */
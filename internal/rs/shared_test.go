@@ -0,0 +1,27 @@
+//go:build tokenizers_shared
+
+package rs_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gomlx/tokenizers/internal/rs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSharedLibrary is a smoke test that the shared library `libgomlx_tokenizers.so` is dynamically loaded
+// and functional. It only runs with the build tag `tokenizers_shared`.
+func TestSharedLibrary(t *testing.T) {
+	maps, err := os.ReadFile("/proc/self/maps")
+	require.NoError(t, err)
+	assert.True(t, strings.Contains(string(maps), "libgomlx_tokenizers.so"),
+		"libgomlx_tokenizers.so not mapped in memory")
+
+	tk, err := rs.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	assert.Equal(t, uint32(30522), tk.VocabSize())
+}
@@ -12,9 +12,18 @@ package rs
 // The first lines below link the pre-built library according to the platform configured.
 // If adding support for another platform, please also add the building rules in `magefile.go`, in
 // the project's root directory.
+//
+// With the build tag `tokenizers_shared` it links the shared library `libgomlx_tokenizers.so` instead (built
+// with `mage shared`), see `lib/README.md`. On Windows only the static library is supported: it is built for
+// the GNU toolchain (MinGW), and it also needs some of the Windows system libraries used by the Rust standard
+// library (sockets, user environment and cryptographic random numbers).
 
 /*
-#cgo linux&&amd64 LDFLAGS: ${SRCDIR}/../../lib/linux_amd64/libgomlx_tokenizers.a -ldl -lm -lstdc++
+#cgo linux&&amd64&&!tokenizers_shared LDFLAGS: ${SRCDIR}/../../lib/linux_amd64/libgomlx_tokenizers.a -ldl -lm -lstdc++
+#cgo linux&&amd64&&tokenizers_shared LDFLAGS: -L${SRCDIR}/../../lib/linux_amd64 -lgomlx_tokenizers -Wl,-rpath,${SRCDIR}/../../lib/linux_amd64
+#cgo linux&&arm64&&!tokenizers_shared LDFLAGS: ${SRCDIR}/../../lib/linux_arm64/libgomlx_tokenizers.a -ldl -lm -lstdc++
+#cgo linux&&arm64&&tokenizers_shared LDFLAGS: -L${SRCDIR}/../../lib/linux_arm64 -lgomlx_tokenizers -Wl,-rpath,${SRCDIR}/../../lib/linux_arm64
+#cgo windows&&amd64 LDFLAGS: ${SRCDIR}/../../lib/windows_amd64/libgomlx_tokenizers.a -lws2_32 -luserenv -lbcrypt -lntdll -lstdc++
 #include <stdlib.h>
 #include "gomlx_tokenizers.h"
 */
@@ -34,6 +43,22 @@ type Offset struct {
 	Start, End uint32
 }
 
+// TokenKind is the kind of a token in the vocabulary, see Encoding.TokenKinds.
+type TokenKind uint8
+
+const (
+	// TokenKindNormal is a token of the vocabulary of the model.
+	TokenKindNormal TokenKind = iota
+
+	// TokenKindAdded is a (non-special) token added to the vocabulary, e.g. with tokenizers.Tokenizer.AddTokens.
+	TokenKindAdded
+
+	// TokenKindSpecial is a special token, e.g. "[CLS]" or a padding token.
+	TokenKindSpecial
+)
+
+//go:generate stringer -type=TokenKind -output=tokenkind_string.go
+
 // Encoding is the result of a Tokenizer.Encode.
 //
 // Only TokenIds is always present, all other fields
@@ -45,13 +70,62 @@ type Encoding struct {
 	AttentionMask     []uint32
 	Tokens            []string
 	Offsets           []Offset
+
+	// WordIds holds the index of the word (as split by the pre-tokenizer) each token belongs to, or -1 for tokens
+	// not associated to a word (e.g.: special tokens).
+	WordIds []int32
+
+	// SequenceIds holds the index of the sequence each token belongs to: 0 for the first sentence and 1 for the
+	// second sentence of a pair, or -1 for tokens not associated to a sentence (e.g.: special tokens).
+	SequenceIds []int32
+
+	// Length is the number of tokens before padding was applied, only set if EncodeParams.ReturnLengths.
+	Length int
+
+	// ContinuationMask holds for each token whether it continues the word of the previous token (e.g. WordPiece
+	// "##" subwords). It is not filled by this package, see the tokenizers.Tokenizer.ReturnContinuationMask.
+	ContinuationMask []bool
+
+	// FirstSubwordMask marks the first token of each word. It is not filled by this package, see the
+	// tokenizers.Tokenizer.ReturnFirstSubwordMask.
+	FirstSubwordMask []bool
+
+	// TokenHashes holds a stable hash of each token. It is not filled by this package, see the
+	// tokenizers.Tokenizer.ReturnTokenHashes.
+	TokenHashes []uint64
+
+	// CharOffsets holds the offsets of the tokens in Unicode code points, while Offsets holds them in bytes. It is
+	// not filled by this package, see the tokenizers.Tokenizer.ReturnBothOffsets.
+	CharOffsets []Offset
+
+	// TokenKinds holds the kind of each token: normal, added or special. It is not filled by this package, see the
+	// tokenizers.Tokenizer.ReturnTokenKinds.
+	TokenKinds []TokenKind
+
+	// ConsumedBytes is the number of bytes of the input that were encoded. It is not filled by this package,
+	// see the tokenizers.Tokenizer.WithMaxInputBytes.
+	ConsumedBytes int
+
+	// InputTruncated is set if the input was cut before encoding, that is, if ConsumedBytes is smaller than the
+	// input length. It is not filled by this package, see the tokenizers.Tokenizer.WithMaxInputBytes.
+	InputTruncated bool
+
+	// DroppedIds and DroppedTokens hold the tokens removed from the input by truncation. They are not filled by
+	// this package, see the tokenizers.Tokenizer.ReturnDroppedTokens.
+	DroppedIds    []uint32
+	DroppedTokens []string
 }
 
 // EncodeParams are passed at `Encode` or `EncodeBatch` calls.
 //
 // It's copy of the underlying C.EncodeParams.
 type EncodeParams struct {
-	AddSpecialTokens, ReturnTokens, ReturnTypeIds, ReturnSpecialTokensMask, ReturnAttentionMask, ReturnOffsets, WithOffsetsCharMode bool
+	AddSpecialTokens, ReturnTokens, ReturnTypeIds, ReturnSpecialTokensMask, ReturnAttentionMask, ReturnOffsets, WithOffsetsCharMode, ReturnWordIds, ReturnSequenceIds, ReturnLengths bool
+
+	// WithDropoutSeed makes the BPE dropout (if enabled) draw from a random number generator seeded with
+	// DropoutSeed, so the encoding can be reproduced.
+	WithDropoutSeed bool
+	DropoutSeed     uint64
 }
 
 func encodeParamsToC(p EncodeParams) C.EncodeParams {
@@ -63,6 +137,11 @@ func encodeParamsToC(p EncodeParams) C.EncodeParams {
 		return_attention_mask:      C.bool(p.ReturnAttentionMask),
 		return_offsets:             C.bool(p.ReturnOffsets),
 		with_offsets_char_mode:     C.bool(p.WithOffsetsCharMode),
+		return_word_ids:            C.bool(p.ReturnWordIds),
+		return_sequence_ids:        C.bool(p.ReturnSequenceIds),
+		return_lengths:             C.bool(p.ReturnLengths),
+		with_dropout_seed:          C.bool(p.WithDropoutSeed),
+		dropout_seed:               C.uint64_t(p.DropoutSeed),
 	}
 }
 
@@ -75,17 +154,38 @@ func ReturnAll(addSpecialTokens, withCharMode bool) EncodeParams {
 		ReturnAttentionMask:     true,
 		ReturnOffsets:           true,
 		WithOffsetsCharMode:     withCharMode,
+		ReturnWordIds:           true,
+		ReturnSequenceIds:       true,
+		ReturnLengths:           true,
 	}
 }
 
-// uint vector to golang slice
-func uint32VecToSlice(arrPtr *C.uint32_t, arrLen int) []uint32 {
+// uint vector to golang slice.
+// It reuses dst storage if it has enough capacity, otherwise it allocates a new slice.
+func uint32VecToSlice(dst []uint32, arrPtr *C.uint32_t, arrLen int) []uint32 {
 	uint32Vec := unsafe.Slice((*uint32)(unsafe.Pointer(arrPtr)), arrLen)
-	slice := make([]uint32, arrLen)
+	slice := resize(dst, arrLen)
 	copy(slice, uint32Vec)
 	return slice
 }
 
+// int32VecToSlice is like uint32VecToSlice, for int32 values.
+func int32VecToSlice(dst []int32, arrPtr *C.int32_t, arrLen int) []int32 {
+	int32Vec := unsafe.Slice((*int32)(unsafe.Pointer(arrPtr)), arrLen)
+	slice := resize(dst, arrLen)
+	copy(slice, int32Vec)
+	return slice
+}
+
+// resize returns a slice with length n, reusing the storage of s if it has enough capacity.
+// It always returns a non-nil slice, even if n == 0.
+func resize[T any](s []T, n int) []T {
+	if s == nil || cap(s) < n {
+		return make([]T, n)
+	}
+	return s[:n]
+}
+
 type Tokenizer struct {
 	tokenizer unsafe.Pointer
 }
@@ -137,6 +237,23 @@ func FromFile(path string) (*Tokenizer, error) {
 	return FromBytes(contents)
 }
 
+// ToBytes serializes the tokenizer to JSon, the same format accepted by FromBytes.
+// It includes the current truncation and padding parameters.
+func (t *Tokenizer) ToBytes() ([]byte, error) {
+	if t.tokenizer == nil {
+		return nil, errors.New("tokenizer has already finalized and is now invalid")
+	}
+	pointerOrError := C.to_bytes(t.tokenizer, C.bool(false))
+	runtime.KeepAlive(t)
+	err := errorFromCStr(pointerOrError.error)
+	if err != nil {
+		return nil, err
+	}
+	cStr := (*C.char)(pointerOrError.value)
+	defer C.free_string(cStr)
+	return []byte(C.GoString(cStr)), nil
+}
+
 // errorFromCStr checks whether there is an error string and creates one accordingly.
 // It frees cStr after converting it to an error.
 // It can be used with `PointerOrError.error`.
@@ -167,6 +284,30 @@ func (t *Tokenizer) SetTruncation(
 		C.set_truncation(t.tokenizer, params))
 }
 
+// SetBPEDropout sets the dropout probability of the merges of a BPE model, 0 disables it.
+// It returns an error if the model is not BPE.
+func (t *Tokenizer) SetBPEDropout(dropout float32) error {
+	if t.tokenizer == nil {
+		return errors.New("tokenizer has already finalized and is now invalid")
+	}
+	defer runtime.KeepAlive(t)
+	return errorFromCStr(
+		C.set_bpe_dropout(t.tokenizer, C.float(dropout)))
+}
+
+// NativeMemoryBytes returns a rough estimate of the memory used by the Rust tokenizer, in bytes.
+func (t *Tokenizer) NativeMemoryBytes() (uint64, error) {
+	if t.tokenizer == nil {
+		return 0, errors.New("tokenizer has already finalized and is now invalid")
+	}
+	defer runtime.KeepAlive(t)
+	var bytes C.uint64_t
+	if err := errorFromCStr(C.native_memory_bytes(t.tokenizer, &bytes)); err != nil {
+		return 0, err
+	}
+	return uint64(bytes), nil
+}
+
 // SetNoTruncation changes the tokenizer to not use truncation.
 func (t *Tokenizer) SetNoTruncation() error {
 	if t.tokenizer == nil {
@@ -266,27 +407,140 @@ func (t *Tokenizer) Encode(str string, encParams EncodeParams) (*Encoding, error
 
 	// We expected an EncodedResults with only one result.
 	res := C.encode(t.tokenizer, cStr, encodeParamsToC(encParams))
+	runtime.KeepAlive(t)
+	return t.parseSingleResult("Tokenizer.Encode", encParams, res, &Encoding{})
+}
+
+// EncodeInto is like Encode, but it stores the result in dst.
+//
+// The slices in dst are reused if they have enough capacity, otherwise they are reallocated.
+// Fields not requested in encParams are set to zero length (but their storage is preserved for future calls).
+func (t *Tokenizer) EncodeInto(str string, encParams EncodeParams, dst *Encoding) error {
+	if t.tokenizer == nil {
+		return errors.New("tokenizer has already finalized and is now invalid")
+	}
+	cStr := C.CString(str)
+	defer C.free(unsafe.Pointer(cStr))
+
+	// We expected an EncodedResults with only one result.
+	res := C.encode(t.tokenizer, cStr, encodeParamsToC(encParams))
+	runtime.KeepAlive(t)
+	_, err := t.parseSingleResult("Tokenizer.EncodeInto", encParams, res, dst)
+	return err
+}
+
+// EncodePair encodes the pair of sentences (sequences A and B): how they are combined (special tokens
+// in between) and their type ids are defined by the tokenizer's post-processor.
+func (t *Tokenizer) EncodePair(str, pair string, encParams EncodeParams) (*Encoding, error) {
+	if t.tokenizer == nil {
+		return nil, errors.New("tokenizer has already finalized and is now invalid")
+	}
+	cStr := C.CString(str)
+	defer C.free(unsafe.Pointer(cStr))
+	cPair := C.CString(pair)
+	defer C.free(unsafe.Pointer(cPair))
+
+	// We expected an EncodedResults with only one result.
+	res := C.encode_pair(t.tokenizer, cStr, cPair, encodeParamsToC(encParams))
+	runtime.KeepAlive(t)
+	return t.parseSingleResult("Tokenizer.EncodePair", encParams, res, &Encoding{})
+}
+
+// EncodePretokenized encodes a sentence already split into words: the tokenizer doesn't merge tokens across
+// the words boundaries, and the offsets are relative to each word.
+func (t *Tokenizer) EncodePretokenized(words []string, encParams EncodeParams) (*Encoding, error) {
+	if t.tokenizer == nil {
+		return nil, errors.New("tokenizer has already finalized and is now invalid")
+	}
+	cWords := make([]*C.char, len(words))
+	for ii, word := range words {
+		cWords[ii] = C.CString(word)
+	}
+	defer func() {
+		for _, cWord := range cWords {
+			C.free(unsafe.Pointer(cWord))
+		}
+	}()
+	var cWordsPtr **C.char
+	if len(cWords) > 0 {
+		cWordsPtr = &cWords[0]
+	}
+
+	// We expected an EncodedResults with only one result.
+	res := C.encode_pretokenized(t.tokenizer, C.uint32_t(len(words)), cWordsPtr, encodeParamsToC(encParams))
+	runtime.KeepAlive(t)
+	return t.parseSingleResult("Tokenizer.EncodePretokenized", encParams, res, &Encoding{})
+}
+
+// EncodeOverflowing encodes the string, and returns its encoding followed by the overflowing encodings -- windows
+// of the tokens that didn't fit the truncation length.
+//
+// If truncation is not set, or the string fits in the truncation length, only one encoding is returned.
+func (t *Tokenizer) EncodeOverflowing(str string, encParams EncodeParams) ([]Encoding, error) {
+	if t.tokenizer == nil {
+		return nil, errors.New("tokenizer has already finalized and is now invalid")
+	}
+	cStr := C.CString(str)
+	defer C.free(unsafe.Pointer(cStr))
+
+	results := C.encode_overflowing(t.tokenizer, cStr, encodeParamsToC(encParams))
+	runtime.KeepAlive(t)
+	defer C.free_encode_results(results)
+	if results.len == 0 || results.error != nil {
+		if results.error != nil {
+			return nil, errors.New(C.GoString(results.error))
+		} else {
+			return nil, errors.New("Tokenizer.EncodeOverflowing failed, got 0 results.")
+		}
+	}
+	encodings := make([]Encoding, int(results.len))
+	buffers := unsafe.Slice((*C.Buffer)(unsafe.Pointer(results.encoded)), len(encodings))
+	for ii, buffer := range buffers {
+		t.parseResult(encParams, buffer, &encodings[ii])
+	}
+	return encodings, nil
+}
+
+// parseSingleResult converts the results of an encode call that is expected to return only one result, and
+// stores it in dst, which is also returned.
+// It frees `res` before returning.
+func (t *Tokenizer) parseSingleResult(caller string, encParams EncodeParams, res C.EncodeResults, dst *Encoding) (*Encoding, error) {
 	defer C.free_encode_results(res)
 	if res.len != 1 || res.error != nil {
 		if res.error != nil {
 			return nil, errors.New(C.GoString(res.error))
 		} else {
-			return nil, errors.Errorf("Tokenizer.Encode failed, got %d results, wanted 1.", res.len)
+			return nil, errors.Errorf("%s failed, got %d results, wanted 1.", caller, res.len)
 		}
 	}
 
-	encodeResult := &Encoding{}
-	t.parseResult(encParams, *res.encoded, encodeResult)
-	return encodeResult, nil
+	t.parseResult(encParams, *res.encoded, dst)
+	return dst, nil
 }
 
 func (t *Tokenizer) EncodeBatch(strArr []string, encParams EncodeParams) ([]Encoding, error) {
+	batchResults := make([]Encoding, len(strArr))
+	err := t.EncodeBatchInto(strArr, encParams, batchResults)
+	if err != nil {
+		return nil, err
+	}
+	return batchResults, nil
+}
+
+// EncodeBatchInto is like EncodeBatch, but it stores the results in dst, which must have length >= len(strArr).
+//
+// The slices in each dst element are reused if they have enough capacity, otherwise they are reallocated.
+// Fields not requested in encParams are set to zero length (but their storage is preserved for future calls).
+func (t *Tokenizer) EncodeBatchInto(strArr []string, encParams EncodeParams, dst []Encoding) error {
 	if t.tokenizer == nil {
-		return nil, errors.New("tokenizer has already finalized and is now invalid")
+		return errors.New("tokenizer has already finalized and is now invalid")
 	}
 	batchLen := len(strArr)
 	if batchLen == 0 {
-		return nil, errors.New("empty batch given to EncodeBatch")
+		return errors.New("empty batch given to EncodeBatch")
+	}
+	if len(dst) < batchLen {
+		return errors.Errorf("EncodeBatchInto given dst of length %d, smaller than the batch length %d", len(dst), batchLen)
 	}
 
 	// Make string vector to Rust
@@ -311,43 +565,46 @@ func (t *Tokenizer) EncodeBatch(strArr []string, encParams EncodeParams) ([]Enco
 	defer C.free_encode_results(results)
 	if int(results.len) != batchLen || results.error != nil {
 		if results.error != nil {
-			return nil, errors.New(C.GoString(results.error))
+			return errors.New(C.GoString(results.error))
 		} else {
-			return nil, errors.Errorf("Tokenizer.EncodeBatch failed, got %d results, but batch length given was %d.", results.len, batchLen)
+			return errors.Errorf("Tokenizer.EncodeBatch failed, got %d results, but batch length given was %d.", results.len, batchLen)
 		}
 	}
 	runtime.KeepAlive(encParams)
 
 	// parse tokenizer encode result
-	batchResults := make([]Encoding, batchLen)
 	buffers := unsafe.Slice((*C.Buffer)(unsafe.Pointer(results.encoded)), batchLen)
 	for ii, buffer := range buffers {
-		t.parseResult(encParams, buffer, &batchResults[ii])
+		t.parseResult(encParams, buffer, &dst[ii])
 	}
-
-	return batchResults, nil
+	return nil
 }
 
 // parseResult takes a `*C.Buffer` and copies content to the given `*Encoding`.
 // It also requires the `C.EncodeParams` used to encode.
+//
+// The slices in output are reused if they have enough capacity. Fields not returned are set to zero length,
+// which keeps them nil for a freshly created `Encoding`.
 func (t *Tokenizer) parseResult(params EncodeParams, buffer C.Buffer, output *Encoding) {
 	entryLen := int(buffer.len)
 
 	// Tokens
 	if buffer.tokens != nil && params.ReturnTokens {
-		output.Tokens = make([]string, entryLen)
+		output.Tokens = resize(output.Tokens, entryLen)
 		cStrTokens := unsafe.Slice((**C.char)(unsafe.Pointer(buffer.tokens)), entryLen)
 		for j, cStr := range cStrTokens {
 			output.Tokens[j] = C.GoString(cStr)
 		}
+	} else {
+		output.Tokens = output.Tokens[:0]
 	}
 
 	// TokenIds
-	output.TokenIds = uint32VecToSlice(buffer.ids, entryLen)
+	output.TokenIds = uint32VecToSlice(output.TokenIds, buffer.ids, entryLen)
 
 	// Token offsets
 	if params.ReturnOffsets && buffer.offsets != nil {
-		output.Offsets = make([]Offset, entryLen)
+		output.Offsets = resize(output.Offsets, entryLen)
 		cOffsets := (*[1 << 30]C.struct_Offset)(unsafe.Pointer(buffer.offsets))
 		for j := 0; j < entryLen; j++ {
 			output.Offsets[j] = Offset{
@@ -355,21 +612,50 @@ func (t *Tokenizer) parseResult(params EncodeParams, buffer C.Buffer, output *En
 				End:   uint32(cOffsets[j].end),
 			}
 		}
+	} else {
+		output.Offsets = output.Offsets[:0]
 	}
 
 	// TypeIds
 	if params.ReturnTypeIds && buffer.type_ids != nil {
-		output.TypeIds = uint32VecToSlice(buffer.type_ids, entryLen)
+		output.TypeIds = uint32VecToSlice(output.TypeIds, buffer.type_ids, entryLen)
+	} else {
+		output.TypeIds = output.TypeIds[:0]
 	}
 
 	// SpecialTokensMask
 	if params.ReturnSpecialTokensMask && buffer.special_tokens_mask != nil {
-		output.SpecialTokensMask = uint32VecToSlice(buffer.special_tokens_mask, entryLen)
+		output.SpecialTokensMask = uint32VecToSlice(output.SpecialTokensMask, buffer.special_tokens_mask, entryLen)
+	} else {
+		output.SpecialTokensMask = output.SpecialTokensMask[:0]
 	}
 
 	// AttentionMask
 	if params.ReturnAttentionMask && buffer.attention_mask != nil {
-		output.AttentionMask = uint32VecToSlice(buffer.attention_mask, entryLen)
+		output.AttentionMask = uint32VecToSlice(output.AttentionMask, buffer.attention_mask, entryLen)
+	} else {
+		output.AttentionMask = output.AttentionMask[:0]
+	}
+
+	// WordIds
+	if params.ReturnWordIds && buffer.word_ids != nil {
+		output.WordIds = int32VecToSlice(output.WordIds, buffer.word_ids, entryLen)
+	} else {
+		output.WordIds = output.WordIds[:0]
+	}
+
+	// SequenceIds
+	if params.ReturnSequenceIds && buffer.sequence_ids != nil {
+		output.SequenceIds = int32VecToSlice(output.SequenceIds, buffer.sequence_ids, entryLen)
+	} else {
+		output.SequenceIds = output.SequenceIds[:0]
+	}
+
+	// Length
+	if params.ReturnLengths {
+		output.Length = int(buffer.unpadded_len)
+	} else {
+		output.Length = 0
 	}
 }
 
@@ -387,9 +673,18 @@ func (t *Tokenizer) Decode(tokenIDs []uint32, skipSpecialTokens bool) string {
 	return C.GoString(res)
 }
 
+// VocabSize returns the size of the vocabulary, including the added tokens.
 func (t *Tokenizer) VocabSize() uint32 {
 	if t.tokenizer == nil {
 		return 0
 	}
-	return uint32(C.vocab_size(t.tokenizer))
+	return uint32(C.vocab_size(t.tokenizer, C.bool(true)))
+}
+
+// BaseVocabSize returns the size of the vocabulary of the model, without the added tokens.
+func (t *Tokenizer) BaseVocabSize() uint32 {
+	if t.tokenizer == nil {
+		return 0
+	}
+	return uint32(C.vocab_size(t.tokenizer, C.bool(false)))
 }
@@ -315,6 +315,59 @@ func TestEncodeBatch(t *testing.T) {
 	}
 }
 
+func TestEncodePair(t *testing.T) {
+	tk, err := rs.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+
+	encParams := rs.EncodeParams{
+		AddSpecialTokens: true,
+		ReturnTokens:     true,
+		ReturnTypeIds:    true,
+	}
+	encoding, err := tk.EncodePair("brown fox", "lazy dog", encParams)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{101, 2829, 4419, 102, 13971, 3899, 102}, encoding.TokenIds)
+	assert.Equal(t, []uint32{0, 0, 0, 0, 1, 1, 1}, encoding.TypeIds)
+	assert.Equal(t, []string{"[CLS]", "brown", "fox", "[SEP]", "lazy", "dog", "[SEP]"}, encoding.Tokens)
+
+	encParams.AddSpecialTokens = false
+	encoding, err = tk.EncodePair("brown fox", "lazy dog", encParams)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{2829, 4419, 13971, 3899}, encoding.TokenIds)
+	assert.Equal(t, []uint32{0, 0, 1, 1}, encoding.TypeIds)
+}
+
+func TestEncodeBatchInto(t *testing.T) {
+	tk, err := rs.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+
+	sentences := []string{"brown fox jumps over the lazy dog", "lazy dog"}
+	encParams := rs.ReturnAll(true, false)
+	want, err := tk.EncodeBatch(sentences, encParams)
+	require.NoError(t, err)
+
+	dst := make([]rs.Encoding, 3)
+	require.Error(t, tk.EncodeBatchInto(append(sentences, "a", "b"), encParams, dst))
+	require.NoError(t, tk.EncodeBatchInto(sentences, encParams, dst))
+	assert.Equal(t, want, dst[:2])
+
+	// Reuse, with a shorter first sentence: storage must be reused.
+	storage := &dst[0].TokenIds[0]
+	require.NoError(t, tk.EncodeBatchInto([]string{"lazy dog", "brown fox jumps over the lazy dog"}, encParams, dst))
+	assert.Equal(t, want[1], dst[0])
+	assert.Equal(t, want[0], dst[1])
+	assert.Same(t, storage, &dst[0].TokenIds[0])
+
+	// Fields not requested are emptied.
+	require.NoError(t, tk.EncodeBatchInto(sentences, rs.EncodeParams{}, dst))
+	assert.Equal(t, want[0].TokenIds[1:8], dst[0].TokenIds)
+	assert.Empty(t, dst[0].Tokens)
+	assert.Empty(t, dst[0].Offsets)
+	assert.Empty(t, dst[0].AttentionMask)
+}
+
 // TestEncodeWithTruncation tests truncation, but it's also used to verify that GC is properly finalizing
 // the Tokenizers.
 func TestEncodeWithTruncation(t *testing.T) {
@@ -611,3 +664,41 @@ func BenchmarkDecodeNTimes(b *testing.B) {
 		assert.Equal(b, "brown fox jumps over the lazy dog", str)
 	}
 }
+
+var benchmarkBatch = []string{
+	"brown fox jumps over the lazy dog",
+	"the lazy dog sleeps",
+	"Ohne UTF-8, ist alles Käse!",
+	"brown fox",
+}
+
+func BenchmarkEncodeBatch(b *testing.B) {
+	tk, err := rs.FromFile(bertJson)
+	require.NoError(b, err)
+	defer tk.Finalize()
+	encParams := rs.ReturnAll(false, false)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err = tk.EncodeBatch(benchmarkBatch, encParams)
+		if err != nil {
+			require.NoError(b, err)
+		}
+	}
+}
+
+func BenchmarkEncodeBatchInto(b *testing.B) {
+	tk, err := rs.FromFile(bertJson)
+	require.NoError(b, err)
+	defer tk.Finalize()
+	encParams := rs.ReturnAll(false, false)
+	dst := make([]rs.Encoding, len(benchmarkBatch))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err = tk.EncodeBatchInto(benchmarkBatch, encParams, dst)
+		if err != nil {
+			require.NoError(b, err)
+		}
+	}
+}
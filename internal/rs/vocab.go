@@ -0,0 +1,160 @@
+package rs
+
+/*
+#include <stdlib.h>
+#include "gomlx_tokenizers.h"
+*/
+import "C"
+
+import (
+	"runtime"
+	"unsafe"
+)
+
+// This file holds the conversions between tokens and ids, and changes to the vocabulary.
+
+// TokensToIds converts tokens to their ids, in one call to the Rust library. Tokens not found have id 0, and
+// found is set to false for them.
+//
+// It returns nil slices if the tokenizer has been finalized.
+func (t *Tokenizer) TokensToIds(tokens []string) (ids []uint32, found []bool) {
+	if t.tokenizer == nil {
+		return
+	}
+	ids = make([]uint32, len(tokens))
+	found = make([]bool, len(tokens))
+	if len(tokens) == 0 {
+		return
+	}
+	cTokens := make([]*C.char, len(tokens))
+	for ii, token := range tokens {
+		cTokens[ii] = C.CString(token)
+	}
+	defer func() {
+		for _, cStr := range cTokens {
+			C.free(unsafe.Pointer(cStr))
+		}
+	}()
+	ok := C.tokens_to_ids(t.tokenizer, (**C.char)(unsafe.Pointer(&cTokens[0])), C.uint32_t(len(tokens)),
+		(*C.uint32_t)(unsafe.Pointer(&ids[0])), (*C.bool)(unsafe.Pointer(&found[0])))
+	runtime.KeepAlive(t)
+	if !bool(ok) {
+		return nil, nil
+	}
+	return
+}
+
+// IdsToTokens converts ids to their token strings, in one call to the Rust library. Unknown ids are converted
+// to empty strings.
+//
+// It returns nil if the tokenizer has been finalized.
+func (t *Tokenizer) IdsToTokens(ids []uint32) []string {
+	if t.tokenizer == nil {
+		return nil
+	}
+	tokens := make([]string, len(ids))
+	if len(ids) == 0 {
+		return tokens
+	}
+	cTokens := make([]*C.char, len(ids))
+	ok := C.ids_to_tokens(t.tokenizer, (*C.uint32_t)(unsafe.Pointer(&ids[0])), C.uint32_t(len(ids)),
+		(**C.char)(unsafe.Pointer(&cTokens[0])))
+	runtime.KeepAlive(t)
+	if !bool(ok) {
+		return nil
+	}
+	for ii, cStr := range cTokens {
+		if cStr != nil {
+			tokens[ii] = C.GoString(cStr)
+			C.free_string(cStr)
+		}
+	}
+	return tokens
+}
+
+// TokenToId converts a single token to its id. It returns false if the token is not in the vocabulary, or if
+// the tokenizer has been finalized.
+func (t *Tokenizer) TokenToId(token string) (id uint32, found bool) {
+	if t.tokenizer == nil {
+		return 0, false
+	}
+	cToken := C.CString(token)
+	defer C.free(unsafe.Pointer(cToken))
+	var cId C.uint32_t
+	found = bool(C.token_to_id(t.tokenizer, cToken, &cId))
+	runtime.KeepAlive(t)
+	if !found {
+		return 0, false
+	}
+	return uint32(cId), true
+}
+
+// IdToToken converts a single id to its token string. It returns false if the id is not in the vocabulary, or
+// if the tokenizer has been finalized.
+func (t *Tokenizer) IdToToken(id uint32) (token string, found bool) {
+	if t.tokenizer == nil {
+		return "", false
+	}
+	cToken := C.id_to_token(t.tokenizer, C.uint32_t(id))
+	runtime.KeepAlive(t)
+	if cToken == nil {
+		return "", false
+	}
+	defer C.free_string(cToken)
+	return C.GoString(cToken), true
+}
+
+// ModelIdToToken converts a single id to the token string stored in the vocabulary of the model, ignoring the
+// added tokens. It returns false if the id is not in the vocabulary of the model, or if the tokenizer has been
+// finalized.
+func (t *Tokenizer) ModelIdToToken(id uint32) (token string, found bool) {
+	if t.tokenizer == nil {
+		return "", false
+	}
+	cToken := C.model_id_to_token(t.tokenizer, C.uint32_t(id))
+	runtime.KeepAlive(t)
+	if cToken == nil {
+		return "", false
+	}
+	defer C.free_string(cToken)
+	return C.GoString(cToken), true
+}
+
+// AddedTokenSpec defines a token to be added to the vocabulary with AddTokens, along with its options.
+type AddedTokenSpec struct {
+	// Content of the token.
+	Content string
+
+	// SingleWord indicates the token must match a whole word only, so it is never matched inside another word.
+	SingleWord bool
+
+	// Normalized indicates the token is matched against the normalized input (e.g. lower-cased), as opposed
+	// to the original input.
+	Normalized bool
+}
+
+// AddTokens adds the tokens to the vocabulary, as special tokens if special is set. Tokens already in the
+// vocabulary are not added again.
+//
+// It returns the number of tokens actually added, or 0 if the tokenizer has been finalized.
+func (t *Tokenizer) AddTokens(specs []AddedTokenSpec, special bool) int {
+	if t.tokenizer == nil || len(specs) == 0 {
+		return 0
+	}
+	cSpecs := make([]C.AddedTokenSpec, len(specs))
+	for ii, spec := range specs {
+		cSpecs[ii] = C.AddedTokenSpec{
+			content:     C.CString(spec.Content),
+			single_word: C.bool(spec.SingleWord),
+			normalized:  C.bool(spec.Normalized),
+		}
+	}
+	defer func() {
+		for _, cSpec := range cSpecs {
+			C.free(unsafe.Pointer(cSpec.content))
+		}
+	}()
+	added := C.add_tokens(t.tokenizer, &cSpecs[0], C.uint32_t(len(specs)), C.bool(special))
+	runtime.KeepAlive(t)
+	return int(added)
+}
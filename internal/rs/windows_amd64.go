@@ -0,0 +1,7 @@
+//go:build windows && amd64
+
+package rs
+
+// Empty dependency, just make sure the directory is retrieved with `go get`,
+// since it will hold the `libgomlx_tokenizers.a` file, needed by CGO.
+import _ "github.com/gomlx/tokenizers/lib/windows_amd64"
@@ -0,0 +1,177 @@
+package tokenizers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// This file implements introspection of the tokenizer definition: information about the model and
+// the components of its pipeline.
+
+// NumMerges returns the number of merge rules of a BPE model, and true. For other models (e.g. WordPiece)
+// it returns 0 and false.
+//
+// It serializes the Tokenizer (see ToBytes) to inspect it, so it's not a cheap call.
+func (t *Tokenizer) NumMerges() (int, bool) {
+	data, err := t.ToBytes()
+	if err != nil {
+		return 0, false
+	}
+	var definition struct {
+		Model struct {
+			Type   string            `json:"type"`
+			Merges []json.RawMessage `json:"merges"`
+		} `json:"model"`
+	}
+	if err = json.Unmarshal(data, &definition); err != nil || definition.Model.Type != "BPE" {
+		return 0, false
+	}
+	return len(definition.Model.Merges), true
+}
+
+// PipelineInfo lists, in the order they are applied, the components of the normalizer and of the pre-tokenizer
+// of a Tokenizer, see Tokenizer.Pipeline.
+type PipelineInfo struct {
+	// Normalizers and PreTokenizers hold the type names of the components, e.g. "NFD" or "Whitespace".
+	// Sequences of components are flattened.
+	Normalizers, PreTokenizers []string
+}
+
+// String implements fmt.Stringer, e.g.: "normalizers: NFD → Lowercase; pre-tokenizers: Whitespace".
+func (p PipelineInfo) String() string {
+	join := func(names []string) string {
+		if len(names) == 0 {
+			return "none"
+		}
+		return strings.Join(names, " \u2192 ")
+	}
+	return fmt.Sprintf("normalizers: %s; pre-tokenizers: %s", join(p.Normalizers), join(p.PreTokenizers))
+}
+
+// Pipeline returns the components of the normalizer and pre-tokenizer of the Tokenizer, in the order they are
+// applied. Useful when debugging unexpected offsets or tokens.
+//
+// It serializes the Tokenizer (see ToBytes) to inspect it, so it's not a cheap call.
+func (t *Tokenizer) Pipeline() PipelineInfo {
+	var info PipelineInfo
+	data, err := t.ToBytes()
+	if err != nil {
+		return info
+	}
+	var definition struct {
+		Normalizer   any `json:"normalizer"`
+		PreTokenizer any `json:"pre_tokenizer"`
+	}
+	if err = json.Unmarshal(data, &definition); err != nil {
+		return info
+	}
+	info.Normalizers = sequenceComponentTypes(definition.Normalizer, "normalizers")
+	info.PreTokenizers = sequenceComponentTypes(definition.PreTokenizer, "pretokenizers")
+	return info
+}
+
+// sequenceComponentTypes returns the types of the component parsed from JSon, in order, flattening components
+// of type "Sequence", whose sub-components are listed under the given key.
+func sequenceComponentTypes(component any, key string) []string {
+	var types []string
+	for _, c := range flattenSequence(component, key) {
+		componentType, _ := c["type"].(string)
+		types = append(types, componentType)
+	}
+	return types
+}
+
+// flattenSequence returns the component parsed from JSon, or its sub-components, in order, if it is of type
+// "Sequence" (recursively). The sub-components are listed under the given key.
+func flattenSequence(component any, key string) []map[string]any {
+	c, ok := component.(map[string]any)
+	if !ok {
+		return nil
+	}
+	if componentType, _ := c["type"].(string); componentType != "Sequence" {
+		return []map[string]any{c}
+	}
+	var components []map[string]any
+	subComponents, _ := c[key].([]any)
+	for _, subComponent := range subComponents {
+		components = append(components, flattenSequence(subComponent, key)...)
+	}
+	return components
+}
+
+// AddsPrefixSpace returns whether the pre-tokenizer adds a space before the input (so the first word is
+// tokenized like any other word), and whether that is applicable, that is, whether the pre-tokenizer is (or
+// includes) a "ByteLevel" (e.g. GPT-2, RoBERTa) or a "Metaspace" (e.g. T5, Llama) one. Embedding models must
+// use the same setting used in training, or the ids drift.
+//
+// It serializes the Tokenizer (see ToBytes) to inspect it, so it's not a cheap call.
+func (t *Tokenizer) AddsPrefixSpace() (value bool, applicable bool) {
+	data, err := t.ToBytes()
+	if err != nil {
+		return false, false
+	}
+	var definition struct {
+		PreTokenizer any `json:"pre_tokenizer"`
+	}
+	if err = json.Unmarshal(data, &definition); err != nil {
+		return false, false
+	}
+	for _, c := range flattenSequence(definition.PreTokenizer, "pretokenizers") {
+		switch c["type"] {
+		case "ByteLevel":
+			value, _ = c["add_prefix_space"].(bool)
+			return value, true
+		case "Metaspace":
+			// Newer versions use "prepend_scheme" ("always", "first" or "never") instead of "add_prefix_space".
+			if scheme, found := c["prepend_scheme"].(string); found {
+				return scheme != "never", true
+			}
+			value, _ = c["add_prefix_space"].(bool)
+			return value, true
+		}
+	}
+	return false, false
+}
+
+// ByteLevelAlphabet returns the mapping of each of the 256 bytes to the Unicode character that represents it in the
+// tokens of byte-level tokenizers (e.g. GPT-2, RoBERTa): printable characters map to themselves, and the others
+// (e.g. space, control characters) to characters starting at U+0100, e.g. space is mapped to 'Ġ' (U+0120).
+//
+// It's only applicable (ok is true) if the pre-tokenizer is (or includes) a "ByteLevel" one. The returned map is
+// a new copy, and can be modified by the caller.
+//
+// It serializes the Tokenizer (see ToBytes) to inspect it, so it's not a cheap call.
+func (t *Tokenizer) ByteLevelAlphabet() (alphabet map[byte]rune, ok bool) {
+	data, err := t.ToBytes()
+	if err != nil {
+		return nil, false
+	}
+	var definition struct {
+		PreTokenizer any `json:"pre_tokenizer"`
+	}
+	if err = json.Unmarshal(data, &definition); err != nil {
+		return nil, false
+	}
+	if !hasComponentType(definition.PreTokenizer, "ByteLevel") {
+		return nil, false
+	}
+	return byteLevelAlphabet(), true
+}
+
+// byteLevelAlphabet returns the mapping of bytes to Unicode characters used by the "ByteLevel" pre-tokenizer, the
+// same as `bytes_to_unicode()` in GPT-2.
+func byteLevelAlphabet() map[byte]rune {
+	alphabet := make(map[byte]rune, 256)
+	next := rune(256)
+	for b := 0; b < 256; b++ {
+		r := rune(b)
+		printable := (r >= '!' && r <= '~') || (r >= '\u00a1' && r <= '\u00ac') || (r >= '\u00ae' && r <= '\u00ff')
+		if !printable {
+			r = next
+			next++
+		}
+		alphabet[byte(b)] = r
+	}
+	return alphabet
+}
@@ -0,0 +1,54 @@
+package tokenizers
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+)
+
+// This file implements the lenient handling of the tokenizer JSON definitions given to FromBytes.
+
+// utf8BOM is the UTF-8 encoding of the byte-order mark (BOM).
+var utf8BOM = []byte("\uFEFF")
+
+// jsonSnippetLength is the maximum number of bytes of the JSON content included in errors.
+const jsonSnippetLength = 64
+
+// trimTokenizerJSON removes a leading UTF-8 byte-order mark (BOM) and trailing whitespace from a tokenizer
+// JSON definition. These are sometimes added by proxies or editors, and are rejected by the strict Rust parser.
+func trimTokenizerJSON(data []byte) []byte {
+	data = bytes.TrimPrefix(data, utf8BOM)
+	return bytes.TrimRight(data, " \t\r\n")
+}
+
+// tokenizerJSONError adds to err, returned when parsing the tokenizer JSON definition in data failed, the location
+// of the syntax error, if any, and a snippet of the offending content.
+func tokenizerJSONError(err error, data []byte) error {
+	var value any
+	jsonErr := json.Unmarshal(data, &value)
+	var syntaxErr *json.SyntaxError
+	if errors.As(jsonErr, &syntaxErr) {
+		offset := int(syntaxErr.Offset)
+		return errors.WithMessagef(err, "invalid JSON at byte %d (%v), near %q",
+			offset, syntaxErr, jsonSnippet(data, offset-jsonSnippetLength/2))
+	}
+	if jsonErr != nil {
+		return errors.WithMessagef(err, "invalid JSON (%v), content starts with %q", jsonErr, jsonSnippet(data, 0))
+	}
+	return errors.WithMessagef(err, "content starts with %q", jsonSnippet(data, 0))
+}
+
+// jsonSnippet returns up to jsonSnippetLength bytes of data starting at start, with ellipsis marking where
+// data was cut.
+func jsonSnippet(data []byte, start int) string {
+	start = max(0, min(start, len(data)))
+	end := min(start+jsonSnippetLength, len(data))
+	snippet := string(data[start:end])
+	if start > 0 {
+		snippet = "..." + snippet
+	}
+	if end < len(data) {
+		snippet = snippet + "..."
+	}
+	return snippet
+}
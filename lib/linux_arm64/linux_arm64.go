@@ -0,0 +1 @@
+package linux_arm64
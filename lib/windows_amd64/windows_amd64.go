@@ -0,0 +1 @@
+package windows_amd64
@@ -0,0 +1,27 @@
+//go:build !windows
+
+package tokenizers
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"syscall"
+)
+
+// tryLockFile tries to take an exclusive lock on f, without blocking. It returns false (and no error) if the lock
+// is held by someone else.
+func tryLockFile(f *os.File) (locked bool, err error) {
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return false, nil
+	}
+	return false, err
+}
+
+// unlockFile releases the lock taken with tryLockFile.
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,29 @@
+//go:build windows
+
+package tokenizers
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/sys/windows"
+	"os"
+)
+
+// tryLockFile tries to take an exclusive lock on f, without blocking. It returns false (and no error) if the lock
+// is held by someone else.
+func tryLockFile(f *os.File) (locked bool, err error) {
+	overlapped := new(windows.Overlapped)
+	err = windows.LockFileEx(windows.Handle(f.Fd()),
+		windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, overlapped)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return false, nil
+	}
+	return false, err
+}
+
+// unlockFile releases the lock taken with tryLockFile.
+func unlockFile(f *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, new(windows.Overlapped))
+}
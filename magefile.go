@@ -39,20 +39,24 @@ var (
 	// The Rust platform name is from the list returned by `rustup target list`.
 	mapGoPlatformToRustPlatform = map[string]string{
 		"linux/amd64":  "x86_64-unknown-linux-gnu",
+		"linux/arm64":  "aarch64-unknown-linux-gnu",
 		"darwin/arm64": "aarch64-apple-darwin",
 		"darwin/amd64": "x86_64-apple-darwin",
+		// The GNU (MinGW) toolchain is the one used by CGO on Windows, the MSVC one is not supported.
+		"windows/amd64": "x86_64-pc-windows-gnu",
 	}
 )
 
 const (
-	libraryName = "libgomlx_tokenizers.a"
-	headerName  = "gomlx_tokenizers.h"
+	libraryName       = "libgomlx_tokenizers.a"
+	sharedLibraryName = "libgomlx_tokenizers.so"
+	headerName        = "gomlx_tokenizers.h"
 )
 
 // Builds the Rust library `libgomlx_tokenizers.a` for the current platform.
 // It uses the `mapGoPlatformToFunction` to map the platform to the corresponding target function.
 func Build() error {
-	err := rustBuild(false, getGoPlatform())
+	err := rustBuild(false, getGoPlatform(), libraryName)
 	if err == nil {
 		mg.Deps(Header)
 	}
@@ -71,25 +75,52 @@ func Release() error {
 	//return nil
 
 	// For now only build release version of current platform.
-	return rustBuild(true, getGoPlatform())
+	return rustBuild(true, getGoPlatform(), libraryName)
 }
 
 // Builds the Rust library `libgomlx_tokenizers.a` for linux/amd64 platform.
 func Linux_amd64() error {
 	mg.Deps(Header)
-	return rustBuild(true, "linux/amd64")
+	return rustBuild(true, "linux/amd64", libraryName)
+}
+
+// Builds the Rust library `libgomlx_tokenizers.a` for linux/arm64 platform (e.g. AWS Graviton, Raspberry Pi 4).
+// Running `mage` (Build) on a linux/arm64 machine also builds it, since the platform is taken from
+// `$GOOS/$GOARCH`.
+func Linux_arm64() error {
+	mg.Deps(Header)
+	return rustBuild(true, "linux/arm64", libraryName)
 }
 
 // Builds the Rust library `libgomlx_tokenizers.a` for darwin/amd64 platform.
 func Darwin_amd64() error {
 	mg.Deps(Header)
-	return rustBuild(true, "darwin/amd64")
+	return rustBuild(true, "darwin/amd64", libraryName)
 }
 
 // Builds the Rust library `libgomlx_tokenizers.a` for darwin/arm64 platform.
 func Darwin_arm64() error {
 	mg.Deps(Header)
-	return rustBuild(true, "darwin/arm64")
+	return rustBuild(true, "darwin/arm64", libraryName)
+}
+
+// Builds the Rust library `libgomlx_tokenizers.a` for windows/amd64 platform.
+// It requires the Rust target `x86_64-pc-windows-gnu` (`rustup target add x86_64-pc-windows-gnu`) and,
+// to build the Go package, a MinGW-w64 C toolchain for CGO.
+func Windows_amd64() error {
+	mg.Deps(Header)
+	return rustBuild(true, "windows/amd64", libraryName)
+}
+
+// Builds the shared (dynamic) library `libgomlx_tokenizers.so` for the current platform.
+//
+// The shared library is position-independent code, and it is memory-mapped shared by the dynamic loader, so
+// multiple processes using it share the same copy in memory. To link with it (instead of the static library)
+// use the build tag `tokenizers_shared`, e.g. `go build -tags tokenizers_shared ...`.
+// See `lib/README.md` for details on how the library is located at runtime.
+func Shared() error {
+	mg.Deps(Header)
+	return rustBuild(true, getGoPlatform(), sharedLibraryName)
 }
 
 // Header builds the `internal/rs/gomlx_tokenizers.h` header file from the Rust sources, using `cbindgen`.
@@ -124,11 +155,12 @@ func Header() error {
 }
 
 // rustBuild builds the rust library `libgomlx_tokenizers.a` for the corresponding Go platform.
-// The resulting binary library is stored in `lib/<goPlatform>/` subdirectory.
+// The resulting binary library (libName, either the static `libgomlx_tokenizers.a` or the shared
+// `libgomlx_tokenizers.so`) is stored in `lib/<goPlatform>/` subdirectory.
 //
 // If isRelease is false, it will not use `--release` and it will ignore the platform, instead
 // always compiling to the current platform.
-func rustBuild(isRelease bool, goPlatform string) error {
+func rustBuild(isRelease bool, goPlatform, libName string) error {
 	rustPlatform, found := mapGoPlatformToRustPlatform[goPlatform]
 	if !found {
 		return fmt.Errorf("platform %q in Rust is not configured -- "+
@@ -145,7 +177,7 @@ func rustBuild(isRelease bool, goPlatform string) error {
 	}
 
 	// Checks whether compilation is needed.
-	dst := path.Join(dstPath, libraryName)
+	dst := path.Join(dstPath, libName)
 	modified, err := target.Glob(dst, "rs/Cargo.toml", "rs/src/*.rs")
 	if err != nil {
 		return errors.WithMessagef(err, "checking whether recompilation needed")
@@ -169,9 +201,9 @@ func rustBuild(isRelease bool, goPlatform string) error {
 	}
 	var generateLibPath string
 	if isRelease {
-		generateLibPath = path.Join("rs", "target", rustPlatform, "release", libraryName)
+		generateLibPath = path.Join("rs", "target", rustPlatform, "release", libName)
 	} else {
-		generateLibPath = path.Join("rs", "target", "debug", libraryName)
+		generateLibPath = path.Join("rs", "target", "debug", libName)
 	}
 	return sh.Copy(dst, generateLibPath)
 }
@@ -0,0 +1,237 @@
+package tokenizers
+
+import (
+	"golang.org/x/text/unicode/norm"
+	"strings"
+	"unicode/utf8"
+)
+
+// This file implements the optional preprocessing of the input sentences, done in Go before
+// handing them to the (Rust) tokenizer.
+//
+// Notice that offsets returned by the encoding refer to the preprocessed sentence.
+
+const (
+	byteOrderMark = '\uFEFF'
+)
+
+// isZeroWidth returns whether r is one of the zero-width characters removed by WithStripZeroWidth.
+func isZeroWidth(r rune) bool {
+	switch r {
+	case '\u200B', // Zero-width space.
+		'\u200C',      // Zero-width non-joiner.
+		'\u200D',      // Zero-width joiner.
+		'\u2060',      // Word joiner.
+		byteOrderMark: // Zero-width no-break space, a.k.a. BOM.
+		return true
+	}
+	return false
+}
+
+// WithStripBOM configures whether to strip a leading UTF-8 byte-order mark (BOM, `U+FEFF`) from the sentences,
+// before encoding. Common in text copied from web pages or files saved by some editors.
+// Default is false.
+//
+// Offsets returned by the encoding reference the sentence after the BOM has been removed.
+//
+// It returns itself (the Tokenizer), to allow cascaded configuration calls.
+func (t *Tokenizer) WithStripBOM(value bool) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	t.stripBOM = value
+	return t
+}
+
+// WithStripZeroWidth configures whether to remove zero-width characters (zero-width space, non-joiner, joiner,
+// word joiner and no-break space/BOM) anywhere in the sentences, before encoding.
+// Default is false.
+//
+// Notice that removing zero-width joiners changes the rendering of some emoji sequences.
+// Offsets returned by the encoding reference the sentence after the characters have been removed.
+//
+// It returns itself (the Tokenizer), to allow cascaded configuration calls.
+func (t *Tokenizer) WithStripZeroWidth(value bool) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	t.stripZeroWidth = value
+	return t
+}
+
+// WithMaxInputBytes configures the maximum number of bytes of the sentences to encode: longer sentences are cut
+// at the last UTF-8 character boundary that fits, before any other preprocessing, and the remainder is dropped.
+// If n <= 0 (the default) sentences are not cut.
+//
+// The number of input bytes actually encoded is reported in Encoding.ConsumedBytes, so one can resume encoding
+// from where it stopped, and Encoding.InputTruncated is set for the sentences that were cut. With EncodeBatch
+// the cap applies to each sentence of the batch separately, which protects batch jobs from oversized
+// (e.g. adversarial) rows: one can check InputTruncated to report or discard them.
+//
+// It returns itself (the Tokenizer), to allow cascaded configuration calls.
+func (t *Tokenizer) WithMaxInputBytes(n int) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	t.maxInputBytes = max(n, 0)
+	return t
+}
+
+// WithUnicodeForm configures the sentences to be normalized to the given Unicode normalization form (norm.NFC,
+// norm.NFD, norm.NFKC or norm.NFKD) in Go, before encoding. It is applied after the other preprocessing
+// (WithStripBOM, WithStripZeroWidth), and independently of the tokenizer's own normalizer: it is useful to match
+// the preprocessing done upstream, e.g. when the model was trained on NFKC normalized text.
+// Default is no normalization, see WithNoUnicodeForm.
+//
+// Offsets returned by the encoding reference the normalized sentence.
+//
+// It returns itself (the Tokenizer), to allow cascaded configuration calls.
+func (t *Tokenizer) WithUnicodeForm(form norm.Form) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	t.normalizeUnicode = true
+	t.unicodeForm = form
+	return t
+}
+
+// WithNoUnicodeForm disables the Unicode normalization configured with WithUnicodeForm.
+//
+// It returns itself (the Tokenizer), to allow cascaded configuration calls.
+func (t *Tokenizer) WithNoUnicodeForm() *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	t.normalizeUnicode = false
+	return t
+}
+
+// WithQuoteNormalization configures whether to replace typographic ("smart") quotes and dashes, common in text from
+// word processors, by their ASCII equivalents before encoding: e.g. the curly double quotes (U+201C and U+201D) by
+// a straight double quote, the curly apostrophe (U+2019) by a straight one, and the en and em dashes by a hyphen.
+// Useful for models trained on ASCII-normalized text, where they would otherwise be tokenized poorly. It is
+// applied after the other preprocessing.
+// Default is false.
+//
+// Offsets returned by the encoding reference the normalized sentence.
+//
+// It returns itself (the Tokenizer), to allow cascaded configuration calls.
+func (t *Tokenizer) WithQuoteNormalization(value bool) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	t.normalizeQuotes = value
+	return t
+}
+
+// quotesReplacer replaces typographic quotes and dashes by their ASCII equivalents, see WithQuoteNormalization.
+var quotesReplacer = strings.NewReplacer(
+	"\u2018", "'", // Left single quotation mark.
+	"\u2019", "'", // Right single quotation mark.
+	"\u201a", "'", // Single low-9 quotation mark.
+	"\u201b", "'", // Single high-reversed-9 quotation mark.
+	"\u2032", "'", // Prime.
+	"\u201c", `"`, // Left double quotation mark.
+	"\u201d", `"`, // Right double quotation mark.
+	"\u201e", `"`, // Double low-9 quotation mark.
+	"\u201f", `"`, // Double high-reversed-9 quotation mark.
+	"\u2033", `"`, // Double prime.
+	"\u2010", "-", // Hyphen.
+	"\u2011", "-", // Non-breaking hyphen.
+	"\u2012", "-", // Figure dash.
+	"\u2013", "-", // En dash.
+	"\u2014", "-", // Em dash.
+	"\u2015", "-", // Horizontal bar.
+	"\u2212", "-", // Minus sign.
+)
+
+// unicodeFormName returns the name of the Unicode normalization form configured, or "none".
+func (t *Tokenizer) unicodeFormName() string {
+	if !t.normalizeUnicode {
+		return "none"
+	}
+	switch t.unicodeForm {
+	case norm.NFC:
+		return "NFC"
+	case norm.NFD:
+		return "NFD"
+	case norm.NFKC:
+		return "NFKC"
+	case norm.NFKD:
+		return "NFKD"
+	}
+	return "unknown"
+}
+
+// consumedBytes returns the number of bytes of the sentence that are encoded, that is, the length of the
+// sentence after it is cut to maxInputBytes (see WithMaxInputBytes).
+func (t *Tokenizer) consumedBytes(sentence string) int {
+	n := len(sentence)
+	if t.maxInputBytes == 0 || n <= t.maxInputBytes {
+		return n
+	}
+	n = t.maxInputBytes
+	for n > 0 && !utf8.RuneStart(sentence[n]) {
+		n--
+	}
+	return n
+}
+
+// setConsumedBytes sets the Encoding.ConsumedBytes and Encoding.InputTruncated of the encoding of sentence.
+func (t *Tokenizer) setConsumedBytes(encoding *Encoding, sentence string) {
+	encoding.ConsumedBytes = t.consumedBytes(sentence)
+	encoding.InputTruncated = encoding.ConsumedBytes < len(sentence)
+}
+
+// hasPreprocessing returns whether any preprocessing of the sentences is configured.
+func (t *Tokenizer) hasPreprocessing() bool {
+	return t.stripBOM || t.stripZeroWidth || t.maxInputBytes > 0 || t.normalizeUnicode || t.normalizeQuotes
+}
+
+// preprocess the sentence according to the configuration.
+func (t *Tokenizer) preprocess(sentence string) string {
+	sentence = sentence[:t.consumedBytes(sentence)]
+	if t.stripBOM {
+		sentence = strings.TrimPrefix(sentence, string(byteOrderMark))
+	}
+	if t.stripZeroWidth {
+		sentence = strings.Map(func(r rune) rune {
+			if isZeroWidth(r) {
+				return -1
+			}
+			return r
+		}, sentence)
+	}
+	if t.normalizeUnicode {
+		sentence = t.unicodeForm.String(sentence)
+	}
+	if t.normalizeQuotes {
+		sentence = quotesReplacer.Replace(sentence)
+	}
+	return sentence
+}
+
+// preprocessBatch preprocess each of the sentences. It returns the same slice if there is no preprocessing
+// configured, otherwise a new slice is returned.
+func (t *Tokenizer) preprocessBatch(sentences []string) []string {
+	if !t.hasPreprocessing() {
+		return sentences
+	}
+	preprocessed := make([]string, len(sentences))
+	for ii, sentence := range sentences {
+		preprocessed[ii] = t.preprocess(sentence)
+	}
+	return preprocessed
+}
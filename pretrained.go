@@ -1,14 +1,16 @@
 package tokenizers
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"github.com/pkg/errors"
 	progressbar "github.com/schollz/progressbar/v3"
+	"io"
 	"net/http"
 	"os"
+	"path"
+	"sync"
+	"time"
 )
 
 // This file handles loading a Tokenizer vocabulary and configuration from
@@ -16,6 +18,7 @@ import (
 
 // Filenames used for tokenizers
 const (
+	tokenizerFileName        = "tokenizer.json"
 	specialTokensMapFileName = "special_tokens_map.json"
 	addedTokensFileName      = "added_tokens.json"
 	tokenizerConfigFileName  = "tokenizer_config.json"
@@ -25,9 +28,16 @@ const (
 // It can be configured in different ways (see methods below), and when finished configuring,
 // call Done to actually download (or load from disk) the pretrained tokenizer.
 type PretrainedConfig struct {
-	name, cacheDir, authToken                   string
-	isTemporaryCache, forceDownload, forceLocal bool
-	showProgressbar                             bool
+	name, cacheDir, lockDir, authToken, endpoint string
+	isTemporaryCache, forceDownload, forceLocal  bool
+	preferLocal, offlineFromEnv                  bool
+	showProgressbar                              bool
+	progressWriter                               io.Writer
+	progressDescription                          string
+	progressCallback                             FilesProgressFn
+	filesProgress                                *filesProgress
+	maxConcurrentDownloads, maxRetries           int
+	noModelFamilyDefaults                        bool
 
 	client *http.Client
 	ctx    context.Context
@@ -40,11 +50,18 @@ type PretrainedConfig struct {
 // After that one calls Done, and it will return the Tokenizer object (or an error).
 //
 // If anything goes wrong, an error is returned instead.
+//
+// If the offline mode is set in the environment (`HF_HUB_OFFLINE=1`, see IsOfflineMode), it defaults to
+// ForceLocal. An explicit ForceDownload or AllowNetwork takes precedence over the environment.
 func FromPretrainedWith(name string) *PretrainedConfig {
 	pt := &PretrainedConfig{
-		name:     name,
-		cacheDir: DefaultCacheDir(),
-		ctx:      context.Background(),
+		name:                   name,
+		offlineFromEnv:         IsOfflineMode(),
+		cacheDir:               DefaultCacheDir(),
+		ctx:                    context.Background(),
+		maxConcurrentDownloads: DefaultMaxConcurrentDownloads,
+		maxRetries:             DefaultMaxRetries,
+		endpoint:               HubEndpoint,
 	}
 
 	// cacheDir defaults to the same used by pytorch transformers.
@@ -62,21 +79,39 @@ func (pt *PretrainedConfig) CacheDir(cacheDir string) *PretrainedConfig {
 	return pt
 }
 
+// LockDir configures a separate directory where to create the files used to lock concurrent downloads.
+// The default is "", in which case they are created next to the downloaded files in the cache directory.
+//
+// This allows the cache to live on a mount where lock files can't be created, see DownloadWithLockDir.
+func (pt *PretrainedConfig) LockDir(lockDir string) *PretrainedConfig {
+	pt.lockDir = lockDir
+	return pt
+}
+
 // NoCache to be used, no copy is kept of the downloaded tokenizer.
 func (pt *PretrainedConfig) NoCache() *PretrainedConfig {
 	pt.cacheDir = ""
 	return pt
 }
 
-// AuthToken sets the authentication token to use.
-// The default is to use no token, which works for simply downloading most tokenizers.
-// TODO: not implemented yet, it will lead to an error when calling Done.
+// Endpoint configures the base URL of the HuggingFace Hub to download from, e.g. "https://hf-mirror.com" for a
+// mirror, or the address of a private endpoint.
+// The default is HubEndpoint, which can be set with the environment variable `$HF_ENDPOINT`.
+func (pt *PretrainedConfig) Endpoint(url string) *PretrainedConfig {
+	pt.endpoint = url
+	return pt
+}
+
+// AuthToken sets the authentication token to use, required for private or gated repositories.
+// The default is to use the token set in the environment (see DefaultAuthToken), or no token if none is set,
+// which works for simply downloading most tokenizers.
 func (pt *PretrainedConfig) AuthToken(token string) *PretrainedConfig {
 	pt.authToken = token
 	return pt
 }
 
 // ForceDownload will ignore previous files in cache and force (re-)download of contents.
+// It takes precedence over the offline mode set in the environment (see IsOfflineMode).
 func (pt *PretrainedConfig) ForceDownload() *PretrainedConfig {
 	pt.forceDownload = true
 	return pt
@@ -84,11 +119,44 @@ func (pt *PretrainedConfig) ForceDownload() *PretrainedConfig {
 
 // ForceLocal won't use the internet, and will only read from the local disk.
 // Notice this prevents even reaching out for the metadata.
+//
+// It's the default if the offline mode is set in the environment (`HF_HUB_OFFLINE=1`, see IsOfflineMode).
 func (pt *PretrainedConfig) ForceLocal() *PretrainedConfig {
 	pt.forceLocal = true
 	return pt
 }
 
+// AllowNetwork ignores the offline mode set in the environment (`HF_HUB_OFFLINE=1`, see IsOfflineMode), so the
+// network is used as usual. It doesn't undo an explicit ForceLocal.
+func (pt *PretrainedConfig) AllowNetwork() *PretrainedConfig {
+	pt.offlineFromEnv = false
+	return pt
+}
+
+// PreferLocal will load the tokenizer from the cache, without reaching out to the network (not even for the
+// metadata), if the snapshot for the requested revision is already present in the cache. Optional files
+// known not to exist in the cached snapshot (recorded when they were first requested) are skipped.
+//
+// The network is only used on a cache miss: if the snapshot is not present, or a file is missing from it
+// (and not known not to exist). This reduces latency and requests to HuggingFace Hub for warm caches, at the cost of not noticing
+// updates upstream (see CheckUpToDate). ForceDownload takes precedence over it.
+func (pt *PretrainedConfig) PreferLocal() *PretrainedConfig {
+	pt.preferLocal = true
+	return pt
+}
+
+// NoModelFamilyDefaults disables configuring the Tokenizer with the conventional defaults of its model family
+// (BERT, RoBERTa, GPT-2, T5, Llama), see Tokenizer.ModelFamily.
+//
+// By default, Done detects the model family and configures the Tokenizer to behave like its Python counterpart:
+// special tokens are added, and the padding direction is Left for Llama and Right for the others (unless padding
+// is configured in `tokenizer.json`). These can also be overridden after loading, with the usual configuration
+// methods.
+func (pt *PretrainedConfig) NoModelFamilyDefaults() *PretrainedConfig {
+	pt.noModelFamilyDefaults = true
+	return pt
+}
+
 // ProgressBar will display a progress bar when downloading files from the network.
 // Only displayed if not reading from cache.
 func (pt *PretrainedConfig) ProgressBar() *PretrainedConfig {
@@ -96,6 +164,121 @@ func (pt *PretrainedConfig) ProgressBar() *PretrainedConfig {
 	return pt
 }
 
+// ProgressWriter configures where the progress bar (see ProgressBar) is written to.
+// The default is `nil`, in which case it is written to `os.Stderr`.
+func (pt *PretrainedConfig) ProgressWriter(writer io.Writer) *PretrainedConfig {
+	pt.progressWriter = writer
+	return pt
+}
+
+// ProgressDescription configures the description displayed in the progress bar (see ProgressBar).
+// The default is "", in which case the name of the file being downloaded is used.
+func (pt *PretrainedConfig) ProgressDescription(description string) *PretrainedConfig {
+	pt.progressDescription = description
+	return pt
+}
+
+// FilesProgress is the overall progress of the download of the files of a pretrained tokenizer, see
+// PretrainedConfig.ProgressCallback.
+type FilesProgress struct {
+	// Downloaded is the number of bytes downloaded so far, over all files, and Total is the sum of the sizes of the
+	// files being downloaded, which is only known for a file once its download starts. Files found in the cache are
+	// not accounted for.
+	Downloaded, Total int
+
+	// FilesDone is the number of files finished (downloaded, found in the cache, or failed), out of Files, the number
+	// of files requested so far. More files may be requested later, e.g. the vocabulary files, if there is no
+	// `tokenizer.json`.
+	FilesDone, Files int
+
+	// Eof is set on the last call, when Done has finished, even if all files were found in the cache.
+	Eof bool
+}
+
+// FilesProgressFn is called with the overall progress of the download of the files of a pretrained tokenizer,
+// see PretrainedConfig.ProgressCallback.
+type FilesProgressFn func(progress FilesProgress)
+
+// ProgressCallback configures a function called with the overall progress of the download of all the files, e.g.
+// to display progress in a program. It is called when the download of a file starts, after each chunk downloaded,
+// when each file is finished, and once more with Eof set when Done finishes.
+//
+// It is called synchronously, possibly from different goroutines, but never concurrently, and it is expected to be
+// fast. It can be used along with, or instead of, the ProgressBar.
+func (pt *PretrainedConfig) ProgressCallback(fn FilesProgressFn) *PretrainedConfig {
+	pt.progressCallback = fn
+	return pt
+}
+
+// filesProgress aggregates the progress of the files downloaded by Done, and reports it to the
+// PretrainedConfig.ProgressCallback.
+type filesProgress struct {
+	mu                 sync.Mutex
+	fn                 FilesProgressFn
+	downloaded, totals []int
+	filesDone          int
+}
+
+// addFile registers a new file, and returns the ProgressFn to track its download, and the function to call
+// once it is finished.
+func (p *filesProgress) addFile() (progressFn ProgressFn, fileDone func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ii := len(p.totals)
+	p.downloaded = append(p.downloaded, 0)
+	p.totals = append(p.totals, 0)
+	progressFn = func(_, downloaded, total int, _ bool) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.downloaded[ii], p.totals[ii] = downloaded, total
+		p.report(false)
+	}
+	fileDone = func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.filesDone++
+		p.report(false)
+	}
+	return
+}
+
+// finish reports the final progress, with Eof set.
+func (p *filesProgress) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.report(true)
+}
+
+// report calls the callback with the current progress. It must be called with the lock held.
+func (p *filesProgress) report(eof bool) {
+	progress := FilesProgress{FilesDone: p.filesDone, Files: len(p.totals), Eof: eof}
+	for ii, total := range p.totals {
+		progress.Downloaded += p.downloaded[ii]
+		progress.Total += total
+	}
+	p.fn(progress)
+}
+
+// DefaultMaxConcurrentDownloads is the default value for PretrainedConfig.MaxConcurrentDownloads.
+var DefaultMaxConcurrentDownloads = 4
+
+// MaxConcurrentDownloads configures the maximum number of files downloaded at the same time by Done.
+// If set to 1 (or less), files are downloaded sequentially.
+// The default is DefaultMaxConcurrentDownloads.
+func (pt *PretrainedConfig) MaxConcurrentDownloads(n int) *PretrainedConfig {
+	pt.maxConcurrentDownloads = n
+	return pt
+}
+
+// MaxRetries configures the number of times a failed request to the HuggingFace Hub is retried, with exponential
+// backoff. Only transient failures are retried: 5xx and 429 statuses, and network errors. If set to 0, requests are
+// not retried.
+// The default is DefaultMaxRetries.
+func (pt *PretrainedConfig) MaxRetries(n int) *PretrainedConfig {
+	pt.maxRetries = n
+	return pt
+}
+
 // HttpClient configures an http.Client to use to connect to HuggingFace Hub.
 // The default is `nil`, in which case one will be created for the requests.
 func (pt *PretrainedConfig) HttpClient(client *http.Client) *PretrainedConfig {
@@ -110,11 +293,20 @@ func (pt *PretrainedConfig) Context(ctx context.Context) *PretrainedConfig {
 	return pt
 }
 
-// makeProgressBar and returns that ProgressFn that updates it.
+// makeProgressBar for the download of fileName, writing to writer, and returns that ProgressFn that updates it.
+// It returns nil if no progress bar was configured.
+//
 // It will only display at the first call to the ProgressFn function, and it will automatically close and clean up
 // when ProgressFn is called with `eof==true`.
 // In case of error, to interrupt it, just call it with `ProgressFn(0, 0, /*eof=*/ true)`
-func makeProgressBar(name string) ProgressFn {
+func (pt *PretrainedConfig) makeProgressBar(fileName string, writer io.Writer) ProgressFn {
+	if !pt.showProgressbar {
+		return nil
+	}
+	name := fileName
+	if pt.progressDescription != "" {
+		name = pt.progressDescription
+	}
 	var data = &struct {
 		name          string
 		bar           *progressbar.ProgressBar
@@ -135,7 +327,20 @@ func makeProgressBar(name string) ProgressFn {
 			return
 		}
 		if !data.started {
-			data.bar = progressbar.DefaultBytes(int64(total), data.name)
+			// Same options as progressbar.DefaultBytes, except the writer.
+			data.bar = progressbar.NewOptions64(
+				int64(total),
+				progressbar.OptionSetDescription(data.name),
+				progressbar.OptionSetWriter(writer),
+				progressbar.OptionShowBytes(true),
+				progressbar.OptionSetWidth(10),
+				progressbar.OptionThrottle(65*time.Millisecond),
+				progressbar.OptionShowCount(),
+				progressbar.OptionOnCompletion(func() { _, _ = fmt.Fprint(writer, "\n") }),
+				progressbar.OptionSpinnerType(14),
+				progressbar.OptionFullWidth(),
+				progressbar.OptionSetRenderBlankState(true),
+			)
 			data.started = true
 		}
 		if progress != 0 {
@@ -150,11 +355,28 @@ func makeProgressBar(name string) ProgressFn {
 
 // Done concludes the configuration of FromPretrainedWith and actually downloads (or loads from disk)
 // the tokenizer.
+//
+// The files of the repository are downloaded concurrently (see MaxConcurrentDownloads): `tokenizer_config.json`
+// is required, while `tokenizer.json`, `special_tokens_map.json` and `added_tokens.json` are downloaded
+// only if available. `special_tokens_map.json` provides the special tokens not defined in the configuration.
+// If there is no `tokenizer.json`, the tokenizer is assembled from the vocabulary files of the "slow" tokenizers:
+// `vocab.txt` (WordPiece) or `vocab.json` and `merges.txt` (byte-level BPE), and the tokens in `added_tokens.json`
+// (special or not, with their ids) and the additional special tokens are added to it.
+//
+// The defaults in `tokenizer_config.json` are then applied: padding and truncation sides, and `model_max_length`
+// as the truncation length (truncation is not enabled though).
 func (pt *PretrainedConfig) Done() (*Tokenizer, error) {
 	// Sanity checking.
 	if pt.forceDownload && pt.forceLocal {
 		return nil, errors.New("cannot use ForceLocal and ForceDownload at the same time, one or the other (or none)")
 	}
+	if pt.offlineFromEnv && !pt.forceDownload {
+		pt.forceLocal = true
+	}
+	if pt.progressCallback != nil {
+		pt.filesProgress = &filesProgress{fn: pt.progressCallback}
+		defer pt.filesProgress.finish()
+	}
 
 	// Initialize unset attributes.
 	if pt.client == nil {
@@ -162,6 +384,10 @@ func (pt *PretrainedConfig) Done() (*Tokenizer, error) {
 		pt.client = &http.Client{}
 	}
 
+	if pt.authToken == "" {
+		pt.authToken = DefaultAuthToken()
+	}
+
 	// Create a temporary cacheDir is one was not configured.
 	if pt.cacheDir == "" {
 		pt.isTemporaryCache = true
@@ -177,35 +403,285 @@ func (pt *PretrainedConfig) Done() (*Tokenizer, error) {
 		}
 	}
 
+	// Download (or find in cache) the files concurrently.
+	files := []*pretrainedFile{
+		{name: tokenizerConfigFileName},
+		{name: tokenizerFileName, optional: true},
+		{name: specialTokensMapFileName, optional: true},
+		{name: addedTokensFileName, optional: true},
+	}
+	pt.downloadFiles(files)
+	for _, file := range files {
+		if file.failed() {
+			return nil, errors.WithMessagef(file.err, "tokenizers.FromPretrainedWith() failed to download %q", file.name)
+		}
+	}
+	configPath, tokenizerFile, specialTokensMapFile, addedTokensFile := files[0].path, files[1], files[2], files[3]
+
 	// Read Tokenizer configuration.
-	repoType := "model"
-	revision := "main"
-	var progressFn ProgressFn
-	if pt.showProgressbar {
-		progressFn = makeProgressBar(tokenizerConfigFileName)
-	}
-	configPath, commitHash, err := Download(
-		pt.ctx, pt.client,
-		pt.name, repoType, revision, tokenizerConfigFileName, pt.cacheDir, pt.authToken,
-		pt.forceDownload, pt.forceLocal, progressFn)
+	contents, err := os.ReadFile(configPath)
 	if err != nil {
-		if progressFn != nil {
-			progressFn(0, 0, 0, true)
+		return nil, errors.Wrapf(err, "failed to read downloaded tokenizer configuration file in %q", configPath)
+	}
+	config, err := ParseTokenizerConfig(contents)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to parse tokenizer configuration file in %q", configPath)
+	}
+	if specialTokensMapFile.err == nil {
+		contents, err = os.ReadFile(specialTokensMapFile.path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read downloaded special tokens file in %q", specialTokensMapFile.path)
+		}
+		if err = config.mergeSpecialTokensMap(contents); err != nil {
+			return nil, errors.WithMessagef(err, "failed to parse special tokens file in %q", specialTokensMapFile.path)
 		}
-		return nil, errors.WithMessagef(err, "tokenizers.FromPretrainedWith() failed to download %q", tokenizerConfigFileName)
 	}
-	var contents []byte
-	contents, err = os.ReadFile(configPath)
+
+	// Read the Tokenizer itself: from `tokenizer.json` if available, or assembled from the vocabulary files
+	// otherwise.
+	var data []byte
+	if tokenizerFile.err == nil {
+		data, err = os.ReadFile(tokenizerFile.path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read downloaded tokenizer file in %q", tokenizerFile.path)
+		}
+	} else {
+		data, err = pt.tokenizerFromVocabFiles(config, tokenizerFile.err)
+		if err != nil {
+			return nil, err
+		}
+	}
+	t, err := FromBytes(data)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to read downloaded tokenizer configuration file in %q", configPath)
+		return nil, errors.WithMessagef(err, "tokenizers.FromPretrainedWith(%q)", pt.name)
+	}
+	if tokenizerFile.err != nil {
+		// Added tokens of the vocabulary files: `tokenizer.json` already includes them.
+		var addedTokensJSON []byte
+		if addedTokensFile.err == nil {
+			addedTokensJSON, err = os.ReadFile(addedTokensFile.path)
+			if err != nil {
+				t.Finalize()
+				return nil, errors.Wrapf(err, "failed to read downloaded added tokens file in %q", addedTokensFile.path)
+			}
+		}
+		if err = t.addVocabFilesAddedTokens(addedTokensJSON, config); err != nil {
+			t.Finalize()
+			return nil, errors.WithMessagef(err, "tokenizers.FromPretrainedWith(%q)", pt.name)
+		}
+	}
+	t.config = config
+	if !pt.noModelFamilyDefaults {
+		t.applyModelFamilyDefaults(t.ModelFamily())
+	}
+	t.applyConfig(config)
+	return t, nil
+}
+
+// CheckUpToDate checks whether the files of the repository at the given revision (a branch, tag or commit hash),
+// cached in the configured cache directory, are up-to-date with HuggingFace Hub, without downloading anything.
+// It uses the same authentication token, endpoint, HTTP client, context and retries as Done.
+//
+// It makes a "HEAD" request for the repository's `tokenizer.json` (or, if it is not found and the commit is not
+// reported, for its `tokenizer_config.json`) to get the current commit hash of the revision, and compares it with
+// the one cached locally. It returns false if the revision was never cached, or if a new download would fetch a
+// different commit.
+func (pt *PretrainedConfig) CheckUpToDate(revision string) (bool, error) {
+	if pt.cacheDir == "" {
+		return false, errors.New("CheckUpToDate() requires a cacheDir")
+	}
+	if revision == "" {
+		revision = DefaultRevision
+	}
+	client := pt.client
+	if client == nil {
+		client = &http.Client{}
+	}
+	authToken := pt.authToken
+	if authToken == "" {
+		authToken = DefaultAuthToken()
+	}
+	repoType := "model"
+	storageDir := path.Join(path.Clean(pt.cacheDir), RepoFolderName(pt.name, repoType))
+	localCommitHash, err := readCommitHashForRevision(storageDir, revision)
+	if err != nil {
+		return false, errors.WithMessagef(err, "CheckUpToDate(%q, %q)", pt.name, revision)
+	}
+	if !FileExists(getSnapshotPath(storageDir, localCommitHash, "")) {
+		// Nothing cached for this revision.
+		return false, nil
+	}
+
+	for _, fileName := range []string{tokenizerFileName, tokenizerConfigFileName} {
+		url := getUrl(pt.endpoint, pt.name, fileName, repoType, revision)
+		var metadata *HFFileMetadata
+		metadata, err = getFileMetadata(pt.ctx, client, url, authToken, GetHeaders(HttpUserAgent(), authToken),
+			pt.maxRetries)
+		if metadata != nil && metadata.CommitHash != "" {
+			// The Hub reports the commit also for files not in the repository.
+			return metadata.CommitHash == localCommitHash, nil
+		}
+		if err == nil {
+			return false, errors.Errorf("CheckUpToDate(%q, %q): %q doesn't seem to be on huggingface.co (missing commit header)",
+				pt.name, revision, url)
+		}
+		if !errors.Is(err, ErrHubFileNotFound) {
+			break
+		}
+	}
+	return false, errors.WithMessagef(err, "CheckUpToDate(%q, %q)", pt.name, revision)
+}
+
+// tokenizerFromVocabFiles downloads the vocabulary files of the "slow" tokenizers, used when the repository
+// has no `tokenizer.json`, and assembles a tokenizer definition from them: a WordPiece tokenizer if there is
+// a `vocab.txt`, or a byte-level BPE tokenizer if there are `vocab.json` and `merges.txt`.
+//
+// tokenizerErr is the error downloading `tokenizer.json`, included in the error returned if the vocabulary files
+// are not available either.
+func (pt *PretrainedConfig) tokenizerFromVocabFiles(config *TokenizerConfig, tokenizerErr error) ([]byte, error) {
+	files := []*pretrainedFile{
+		{name: wordPieceVocabFileName, optional: true},
+		{name: bpeVocabFileName, optional: true},
+		{name: bpeMergesFileName, optional: true},
+	}
+	pt.downloadFiles(files)
+	for _, file := range files {
+		if file.failed() {
+			return nil, errors.WithMessagef(file.err, "tokenizers.FromPretrainedWith() failed to download %q", file.name)
+		}
+	}
+	readFiles := func(files ...*pretrainedFile) ([][]byte, error) {
+		contents := make([][]byte, len(files))
+		for ii, file := range files {
+			var err error
+			contents[ii], err = os.ReadFile(file.path)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to read downloaded file in %q", file.path)
+			}
+		}
+		return contents, nil
+	}
+	vocabTxt, vocabJSON, merges := files[0], files[1], files[2]
+	switch {
+	case vocabTxt.err == nil:
+		contents, err := readFiles(vocabTxt)
+		if err != nil {
+			return nil, err
+		}
+		return wordPieceTokenizerJSON(contents[0], wordPieceOptionsFromConfig(config))
+	case vocabJSON.err == nil && merges.err == nil:
+		contents, err := readFiles(vocabJSON, merges)
+		if err != nil {
+			return nil, err
+		}
+		return bpeTokenizerJSON(contents[0], contents[1], config)
+	}
+	return nil, errors.Errorf("tokenizers.FromPretrainedWith(%q) failed: repository has no %q (%v), "+
+		"nor %q, nor %q and %q to assemble a tokenizer from", pt.name, tokenizerFileName, tokenizerErr,
+		wordPieceVocabFileName, bpeVocabFileName, bpeMergesFileName)
+}
+
+// pretrainedFile is a file of the pretrained tokenizer repository to be downloaded by Done.
+type pretrainedFile struct {
+	name     string
+	optional bool
+
+	// Results of the download. absent is set if the error is because the file doesn't exist: not found in the
+	// repository, or not in the cache when loading only from the cache.
+	path   string
+	err    error
+	absent bool
+}
+
+// failed returns whether the file could not be downloaded for any reason other than being absent, if optional.
+// Failures of optional files (e.g. unauthorized, or a network error) must not be taken as the file not existing,
+// since it would silently change the tokenizer loaded.
+func (file *pretrainedFile) failed() bool {
+	return file.err != nil && !(file.optional && file.absent)
+}
+
+// downloadFiles downloads (or finds in the cache) the given files, with at most pt.maxConcurrentDownloads at the
+// same time. The results are stored in each file.
+//
+// Concurrent downloads to the same cache (even from different processes) are safe, since Download locks
+// each file it downloads.
+func (pt *PretrainedConfig) downloadFiles(files []*pretrainedFile) {
+	repoType := "model"
+	revision := "main"
+	maxConcurrent := pt.maxConcurrentDownloads
+	if maxConcurrent <= 0 {
+		maxConcurrent = 1
+	}
+	// Progress bars of concurrent downloads share the writer.
+	progressWriter := &syncWriter{writer: os.Stderr}
+	if pt.progressWriter != nil {
+		progressWriter.writer = pt.progressWriter
 	}
-	dec := json.NewDecoder(bytes.NewReader(contents))
-	var config = map[string]any{}
-	if err = dec.Decode(&config); err != nil {
-		return nil, errors.Wrapf(err, "failed to parse JSON from tokenizer configuration file in %q", configPath)
+	preferLocal := pt.preferLocal && !pt.forceDownload && !pt.forceLocal &&
+		isSnapshotCached(pt.cacheDir, pt.name, repoType, revision)
+	semaphore := make(chan struct{}, maxConcurrent)
+	var wg sync.WaitGroup
+	for _, file := range files {
+		var fileProgressFn ProgressFn
+		fileDone := func() {}
+		if pt.filesProgress != nil {
+			fileProgressFn, fileDone = pt.filesProgress.addFile()
+		}
+		wg.Add(1)
+		go func(file *pretrainedFile) {
+			defer wg.Done()
+			defer fileDone()
+			if preferLocal {
+				file.path, _, file.err = Download(
+					pt.ctx, pt.client,
+					pt.name, repoType, revision, file.name, pt.cacheDir, pt.authToken,
+					false, true, nil)
+				if file.err == nil || errors.Is(file.err, ErrHubFileNotFound) {
+					// Found in the cache, or known not to exist in the cached snapshot.
+					file.absent = file.err != nil
+					return
+				}
+				// File not in the cache: fall back to downloading it.
+			}
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			barFn := pt.makeProgressBar(file.name, progressWriter)
+			file.path, _, file.err = download(
+				pt.ctx, pt.client,
+				pt.name, repoType, revision, file.name, pt.cacheDir, pt.lockDir, pt.endpoint, pt.authToken,
+				pt.forceDownload, pt.forceLocal, pt.maxRetries, combineProgressFns(barFn, fileProgressFn))
+			if file.err != nil && barFn != nil {
+				barFn(0, 0, 0, true)
+			}
+			file.absent = errors.Is(file.err, ErrHubFileNotFound) || (pt.forceLocal && errors.Is(file.err, errNotInCache))
+		}(file)
 	}
+	wg.Wait()
+}
+
+// combineProgressFns returns a ProgressFn that calls both fn1 and fn2, which may be nil.
+func combineProgressFns(fn1, fn2 ProgressFn) ProgressFn {
+	if fn1 == nil {
+		return fn2
+	}
+	if fn2 == nil {
+		return fn1
+	}
+	return func(progress, downloaded, total int, eof bool) {
+		fn1(progress, downloaded, total, eof)
+		fn2(progress, downloaded, total, eof)
+	}
+}
+
+// syncWriter serializes writes to writer, so it can be shared by concurrent goroutines.
+type syncWriter struct {
+	mu     sync.Mutex
+	writer io.Writer
+}
 
-	fmt.Printf("configuration: %q\n", config)
-	_ = commitHash
-	return nil, errors.New("not implemented")
+// Write implements io.Writer.
+func (w *syncWriter) Write(p []byte) (n int, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.writer.Write(p)
 }
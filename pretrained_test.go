@@ -0,0 +1,593 @@
+package tokenizers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gomlx/tokenizers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bertHubFiles returns the files of a BERT repository, to be served by hubFilesHandler.
+func bertHubFiles(t *testing.T) map[string][]byte {
+	tokenizerJson, err := os.ReadFile(bertJson)
+	require.NoError(t, err)
+	return map[string][]byte{
+		"tokenizer.json":        tokenizerJson,
+		"tokenizer_config.json": []byte(`{"do_lower_case": true, "model_max_length": 512}`),
+	}
+}
+
+func TestProgressWriter(t *testing.T) {
+	withTestHub(t, hubFilesHandler(t, "0123456789abcdef", bertHubFiles(t)))
+	var buf bytes.Buffer
+	tk, err := tokenizers.FromPretrainedWith("google/bert").
+		CacheDir(t.TempDir()).
+		ProgressBar().
+		ProgressWriter(&buf).
+		ProgressDescription("fetching bert").
+		Done()
+	assert.Contains(t, buf.String(), "fetching bert")
+	require.NoError(t, err)
+	defer tk.Finalize()
+	assert.Equal(t, uint32(30522), tk.VocabSize())
+	assert.Equal(t, 512, tk.Config().ModelMaxLength)
+}
+
+func TestProgressCallback(t *testing.T) {
+	files := bertHubFiles(t)
+	withTestHub(t, hubFilesHandler(t, "0123456789abcdef", files))
+	totalBytes := len(files["tokenizer.json"]) + len(files["tokenizer_config.json"])
+	cacheDir := t.TempDir()
+	var calls []tokenizers.FilesProgress
+	callback := func(progress tokenizers.FilesProgress) { calls = append(calls, progress) }
+
+	tk, err := tokenizers.FromPretrainedWith("google/bert").CacheDir(cacheDir).ProgressCallback(callback).Done()
+	require.NotEmpty(t, calls)
+	for ii, progress := range calls[1:] {
+		assert.GreaterOrEqual(t, progress.Downloaded, calls[ii].Downloaded)
+		assert.GreaterOrEqual(t, progress.FilesDone, calls[ii].FilesDone)
+	}
+	last := calls[len(calls)-1]
+	assert.Equal(t, tokenizers.FilesProgress{Downloaded: totalBytes, Total: totalBytes, FilesDone: 4, Files: 4,
+		Eof: true}, last)
+	for _, progress := range calls[:len(calls)-1] {
+		assert.False(t, progress.Eof)
+	}
+	require.NoError(t, err)
+	tk.Finalize()
+
+	// From the cache: nothing downloaded, but the files are still accounted and the last call has Eof set.
+	calls = nil
+	tk, err = tokenizers.FromPretrainedWith("google/bert").CacheDir(cacheDir).ForceLocal().
+		ProgressCallback(callback).Done()
+	require.NotEmpty(t, calls)
+	assert.Equal(t, tokenizers.FilesProgress{FilesDone: 4, Files: 4, Eof: true}, calls[len(calls)-1])
+	require.NoError(t, err)
+	tk.Finalize()
+}
+
+func TestMaxConcurrentDownloads(t *testing.T) {
+	files := bertHubFiles(t)
+	files["special_tokens_map.json"] = []byte(`{"cls_token": "[CLS]", "sep_token": "[SEP]"}`)
+	serveFiles := hubFilesHandler(t, "0123456789abcdef", files)
+	var (
+		mu                  sync.Mutex
+		fetched             = map[string]int{}
+		inFlight, maxFlight int
+	)
+	withTestHub(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		maxFlight = max(maxFlight, inFlight)
+		if r.Method == http.MethodGet {
+			fetched[path.Base(r.URL.Path)]++
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		serveFiles(w, r)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	})
+
+	// added_tokens.json is missing (404), but it is optional.
+	tk, err := tokenizers.FromPretrainedWith("google/bert").
+		CacheDir(t.TempDir()).
+		MaxConcurrentDownloads(2).
+		Done()
+	assert.Equal(t, map[string]int{"tokenizer.json": 1, "tokenizer_config.json": 1, "special_tokens_map.json": 1}, fetched)
+	assert.LessOrEqual(t, maxFlight, 2)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	encoding, err := tk.Encode("brown fox jumps over the lazy dog")
+	require.NoError(t, err)
+	// BERT family defaults: special tokens are added.
+	assert.Equal(t, []uint32{101, 2829, 4419, 14523, 2058, 1996, 13971, 3899, 102}, encoding.TokenIds)
+}
+
+func TestModelFamily(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	assert.Equal(t, tokenizers.FamilyBERT, tk.ModelFamily())
+
+	gpt2, err := tokenizers.FromBytes([]byte(gpt2LikeJson))
+	require.NoError(t, err)
+	defer gpt2.Finalize()
+	assert.Equal(t, tokenizers.FamilyGPT2, gpt2.ModelFamily())
+
+	// The tokenizer_class in the configuration takes precedence.
+	files := bertHubFiles(t)
+	files["tokenizer_config.json"] = []byte(`{"tokenizer_class": "LlamaTokenizerFast"}`)
+	withTestHub(t, hubFilesHandler(t, "0123456789abcdef", files))
+	cacheDir := t.TempDir()
+	llama, err := tokenizers.FromPretrainedWith("meta/llama").CacheDir(cacheDir).Done()
+	require.NoError(t, err)
+	defer llama.Finalize()
+	assert.Equal(t, tokenizers.FamilyLlama, llama.ModelFamily())
+	assert.Contains(t, llama.String(), "PaddingDirection=Left")
+	assert.Contains(t, llama.String(), "AddSpecialTokens=true")
+
+	// Defaults can be disabled.
+	noDefaults, err := tokenizers.FromPretrainedWith("meta/llama").CacheDir(cacheDir).NoModelFamilyDefaults().Done()
+	require.NoError(t, err)
+	defer noDefaults.Finalize()
+	assert.Contains(t, noDefaults.String(), "AddSpecialTokens=false")
+}
+
+func TestPreferLocal(t *testing.T) {
+	serveFiles := hubFilesHandler(t, "0123456789abcdef", bertHubFiles(t))
+	var (
+		mu          sync.Mutex
+		numRequests int
+	)
+	withTestHub(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		numRequests++
+		mu.Unlock()
+		serveFiles(w, r)
+	})
+	cacheDir := t.TempDir()
+
+	// Cold cache: files are downloaded.
+	tk, err := tokenizers.FromPretrainedWith("google/bert").CacheDir(cacheDir).PreferLocal().Done()
+	assert.Greater(t, numRequests, 0)
+	require.NoError(t, err)
+	tk.Finalize()
+
+	// Warm cache: no requests at all.
+	numRequests = 0
+	tk, err = tokenizers.FromPretrainedWith("google/bert").CacheDir(cacheDir).PreferLocal().Done()
+	assert.Equal(t, 0, numRequests)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	assert.Equal(t, uint32(30522), tk.VocabSize())
+}
+
+func TestPreferLocalOptionalFiles(t *testing.T) {
+	files := bertHubFiles(t)
+	serveFiles := hubFilesHandler(t, "0123456789abcdef", files)
+	var (
+		mu       sync.Mutex
+		requests map[string]int
+		noCommit bool // Whether the 404 responses omit the commit, so the missing files are not recorded.
+	)
+	withTestHub(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		name := path.Base(r.URL.Path)
+		requests[name]++
+		if _, found := files[name]; !found && noCommit {
+			http.NotFound(w, r)
+			return
+		}
+		serveFiles(w, r)
+	})
+	load := func(cacheDir string) {
+		requests = make(map[string]int)
+		tk, err := tokenizers.FromPretrainedWith("google/bert").CacheDir(cacheDir).PreferLocal().Done()
+		if err == nil {
+			tk.Finalize()
+		}
+	}
+
+	// Files known not to exist in the cached snapshot are not requested again.
+	cacheDir := t.TempDir()
+	load(cacheDir)
+	assert.Equal(t, 1, requests["special_tokens_map.json"])
+	load(cacheDir)
+	assert.Empty(t, requests)
+
+	// Optional files missing from the cached snapshot are requested.
+	cacheDir = t.TempDir()
+	noCommit = true
+	load(cacheDir)
+	files["special_tokens_map.json"] = []byte(`{"cls_token": "[CLS]"}`)
+	load(cacheDir)
+	assert.Equal(t, map[string]int{"special_tokens_map.json": 2, "added_tokens.json": 1}, requests) // HEAD and GET.
+	_, _, err := tokenizers.Download(context.Background(), nil, "google/bert", "model", "main",
+		"special_tokens_map.json", cacheDir, "", false, true, nil)
+	require.NoError(t, err)
+}
+
+func TestOfflineMode(t *testing.T) {
+	for value, want := range map[string]bool{"1": true, "true": true, "ON": true, "0": false, "": false, "no": false} {
+		t.Setenv(tokenizers.OfflineEnvVar, value)
+		assert.Equal(t, want, tokenizers.IsOfflineMode(), "%s=%q", tokenizers.OfflineEnvVar, value)
+	}
+
+	serveFiles := hubFilesHandler(t, "0123456789abcdef", bertHubFiles(t))
+	var (
+		mu          sync.Mutex
+		numRequests int
+	)
+	withTestHub(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		numRequests++
+		mu.Unlock()
+		serveFiles(w, r)
+	})
+	cacheDir := t.TempDir()
+	t.Setenv(tokenizers.OfflineEnvVar, "1")
+
+	// Cold cache: offline fails without any requests.
+	_, err := tokenizers.FromPretrainedWith("google/bert").CacheDir(cacheDir).Done()
+	require.Error(t, err)
+	assert.Equal(t, 0, numRequests)
+
+	// Explicit override of the environment.
+	tk, err := tokenizers.FromPretrainedWith("google/bert").CacheDir(cacheDir).AllowNetwork().Done()
+	assert.Greater(t, numRequests, 0)
+	require.NoError(t, err)
+	tk.Finalize()
+
+	// Warm cache: offline works.
+	numRequests = 0
+	tk, err = tokenizers.FromPretrainedWith("google/bert").CacheDir(cacheDir).Done()
+	assert.Equal(t, 0, numRequests)
+	require.NoError(t, err)
+	tk.Finalize()
+
+	// ForceDownload takes precedence over the environment.
+	tk, err = tokenizers.FromPretrainedWith("google/bert").CacheDir(cacheDir).ForceDownload().Done()
+	assert.Greater(t, numRequests, 0)
+	require.NoError(t, err)
+	tk.Finalize()
+}
+
+// bertVocabTxt returns the vocabulary of the BERT test tokenizer in the `vocab.txt` format.
+func bertVocabTxt(t *testing.T) []byte {
+	data, err := os.ReadFile(bertJson)
+	require.NoError(t, err)
+	var definition struct {
+		Model struct {
+			Vocab map[string]int `json:"vocab"`
+		} `json:"model"`
+	}
+	require.NoError(t, json.Unmarshal(data, &definition))
+	tokens := make([]string, len(definition.Model.Vocab))
+	for token, id := range definition.Model.Vocab {
+		tokens[id] = token
+	}
+	return []byte(strings.Join(tokens, "\n") + "\n")
+}
+
+func TestPretrainedFromVocabFiles(t *testing.T) {
+	// Repository without `tokenizer.json`: assembled from `vocab.txt`.
+	withTestHub(t, hubFilesHandler(t, "0123456789abcdef", map[string][]byte{
+		"vocab.txt":             bertVocabTxt(t),
+		"tokenizer_config.json": []byte(`{"do_lower_case": true, "model_max_length": 512}`),
+	}))
+	tk, err := tokenizers.FromPretrainedWith("google/bert").CacheDir(t.TempDir()).Done()
+	require.NoError(t, err)
+	defer tk.Finalize()
+	assert.Equal(t, uint32(30522), tk.VocabSize())
+	encoding, err := tk.AddSpecialTokens(true).Encode("Brown fox")
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{101, 2829, 4419, 102}, encoding.TokenIds)
+}
+
+func TestPretrainedAddedTokens(t *testing.T) {
+	// Repository with only the vocabulary and the JSon sidecar files.
+	files := map[string][]byte{
+		"vocab.txt":               bertVocabTxt(t),
+		"tokenizer_config.json":   []byte(`{"do_lower_case": true}`),
+		"special_tokens_map.json": []byte(`{"cls_token": "[CLS]", "sep_token": "[SEP]", "additional_special_tokens": ["<ent>"]}`),
+		"added_tokens.json":       []byte(`{"newword": 30523, "<ent>": 30522}`),
+	}
+	withTestHub(t, hubFilesHandler(t, "0123456789abcdef", files))
+	tk, err := tokenizers.FromPretrainedWith("google/bert").CacheDir(t.TempDir()).Done()
+	require.NoError(t, err)
+	defer tk.Finalize()
+	assert.Equal(t, "[CLS]", tk.Config().ClsToken)
+	assert.Equal(t, []string{"<ent>"}, tk.Config().AdditionalSpecialTokens)
+
+	encoding, err := tk.AddSpecialTokens(true).ReturnSpecialTokensMask(true).Encode("brown <ent> fox newword")
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{101, 2829, 30522, 4419, 30523, 102}, encoding.TokenIds)
+	assert.Equal(t, []uint32{1, 0, 1, 0, 0, 1}, encoding.SpecialTokensMask)
+	assert.Equal(t, "brown fox newword", tk.Decode(encoding.TokenIds, true))
+
+	// Ids of the added tokens must follow the vocabulary.
+	files["added_tokens.json"] = []byte(`{"newword": 40000}`)
+	withTestHub(t, hubFilesHandler(t, "0123456789abcdef", files))
+	_, err = tokenizers.FromPretrainedWith("google/bert").CacheDir(t.TempDir()).Done()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "must follow the vocabulary")
+}
+
+func TestPretrainedMissingFiles(t *testing.T) {
+	// Repository with neither `tokenizer.json` nor vocabulary files.
+	withTestHub(t, hubFilesHandler(t, "0123456789abcdef", map[string][]byte{
+		"tokenizer_config.json": []byte(`{"model_max_length": 512}`),
+	}))
+	_, err := tokenizers.FromPretrainedWith("google/empty").CacheDir(t.TempDir()).Done()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "tokenizer.json")
+	assert.Contains(t, err.Error(), "vocab.txt")
+	assert.Contains(t, err.Error(), "merges.txt")
+}
+
+func TestPretrainedOptionalFileErrors(t *testing.T) {
+	// Errors other than "not found" on optional files must not be taken as the file not existing.
+	serveFiles := hubFilesHandler(t, "0123456789abcdef", bertHubFiles(t))
+	withTestHub(t, func(w http.ResponseWriter, r *http.Request) {
+		if path.Base(r.URL.Path) == "special_tokens_map.json" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		serveFiles(w, r)
+	})
+	_, err := tokenizers.FromPretrainedWith("google/bert").CacheDir(t.TempDir()).Done()
+	require.Error(t, err)
+	assert.ErrorIs(t, err, tokenizers.ErrHubUnauthorized)
+	assert.Contains(t, err.Error(), "special_tokens_map.json")
+
+	// A server error on `tokenizer.json` must not fall back to the vocabulary files.
+	files := bertHubFiles(t)
+	delete(files, "tokenizer.json")
+	files["vocab.txt"] = bertVocabTxt(t)
+	serveFiles = hubFilesHandler(t, "0123456789abcdef", files)
+	withTestHub(t, func(w http.ResponseWriter, r *http.Request) {
+		if path.Base(r.URL.Path) == "tokenizer.json" {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		serveFiles(w, r)
+	})
+	_, err = tokenizers.FromPretrainedWith("google/bert").CacheDir(t.TempDir()).MaxRetries(0).Done()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "tokenizer.json")
+}
+
+func TestPretrainedConfigDefaults(t *testing.T) {
+	files := bertHubFiles(t)
+	files["tokenizer_config.json"] = []byte(`{"model_max_length": 4, "padding_side": "left", "truncation_side": "right"}`)
+	withTestHub(t, hubFilesHandler(t, "0123456789abcdef", files))
+	tk, err := tokenizers.FromPretrainedWith("google/bert").CacheDir(t.TempDir()).Done()
+	require.NoError(t, err)
+	defer tk.Finalize()
+	assert.Contains(t, tk.String(), "PaddingDirection=Left")
+	assert.Contains(t, tk.String(), "TruncationDirection=Right")
+
+	// Padding goes to the left.
+	tk.AddSpecialTokens(false).WithPadToLength(3)
+	encoding, err := tk.Encode("Brown fox")
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{0, 2829, 4419}, encoding.TokenIds)
+}
+
+func TestPretrainedConfigTruncationSide(t *testing.T) {
+	files := bertHubFiles(t)
+	files["tokenizer_config.json"] = []byte(`{"truncation_side": "left"}`)
+	withTestHub(t, hubFilesHandler(t, "0123456789abcdef", files))
+	tk, err := tokenizers.FromPretrainedWith("google/bert").CacheDir(t.TempDir()).Done()
+	require.NoError(t, err)
+	defer tk.Finalize()
+	assert.Contains(t, tk.String(), "TruncationDirection=Left")
+
+	// The configured side takes precedence over the default.
+	tk.AddSpecialTokens(false).WithTruncation(2)
+	encoding, err := tk.Encode("brown fox jumps over the lazy dog")
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{13971, 3899}, encoding.TokenIds)
+}
+
+func TestWithTruncationFromConfig(t *testing.T) {
+	files := bertHubFiles(t)
+	files["tokenizer_config.json"] = []byte(`{"truncation_side": "left", "model_max_length": 3}`)
+	withTestHub(t, hubFilesHandler(t, "0123456789abcdef", files))
+	tk, err := tokenizers.FromPretrainedWith("google/bert").CacheDir(t.TempDir()).Done()
+	require.NoError(t, err)
+	defer tk.Finalize()
+	tk.AddSpecialTokens(false)
+	encoding, err := tk.Encode("brown fox jumps over the lazy dog")
+	require.NoError(t, err)
+	assert.Len(t, encoding.TokenIds, 7) // Truncation is not enabled by Done.
+
+	// Configuration loaded with the tokenizer.
+	encoding, err = tk.WithTruncationFromConfig(nil).Encode("brown fox jumps over the lazy dog")
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{1996, 13971, 3899}, encoding.TokenIds)
+
+	// Given configuration.
+	config, err := tokenizers.ParseTokenizerConfig([]byte(`{"truncation_side": "right", "model_max_length": 2}`))
+	require.NoError(t, err)
+	encoding, err = tk.WithTruncationFromConfig(config).Encode("brown fox jumps over the lazy dog")
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{2829, 4419}, encoding.TokenIds)
+
+	// The "unbounded" sentinel leaves truncation unchanged.
+	config, err = tokenizers.ParseTokenizerConfig([]byte(`{"model_max_length": 1000000000000000019884624838656}`))
+	require.NoError(t, err)
+	encoding, err = tk.WithTruncationFromConfig(config).Encode("brown fox jumps over the lazy dog")
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{2829, 4419}, encoding.TokenIds)
+
+	// So do values too large set directly, instead of wrapping around to a small truncation length.
+	encoding, err = tk.WithTruncationFromConfig(&tokenizers.TokenizerConfig{ModelMaxLength: math.MaxInt}).
+		Encode("brown fox jumps over the lazy dog")
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{2829, 4419}, encoding.TokenIds)
+}
+
+func TestSpecialTokensDetailed(t *testing.T) {
+	dir := t.TempDir()
+	files := bertHubFiles(t)
+	files["tokenizer_config.json"] = []byte(`{"do_lower_case": true, "cls_token": "[CLS]", "sep_token": "[SEP]"}`)
+	files["special_tokens_map.json"] = []byte(`{"cls_token": "[unused0]", "mask_token": "[MASK]",
+		"additional_special_tokens": ["<ent>", {"content": "</ent>", "lstrip": false}]}`)
+	for name, contents := range files {
+		require.NoError(t, os.WriteFile(path.Join(dir, name), contents, 0644))
+	}
+	tk, err := tokenizers.FromDir(dir)
+	require.NoError(t, err)
+	defer tk.Finalize()
+
+	infos := tk.SpecialTokensDetailed()
+	byContent := make(map[string]tokenizers.SpecialTokenInfo)
+	for _, info := range infos {
+		byContent[info.Content] = info
+	}
+	assert.Equal(t, tokenizers.SpecialTokenInfo{Role: tokenizers.RoleCls, Content: "[CLS]", Id: 101, InVocab: true,
+		Source: "tokenizer_config.json"}, byContent["[CLS]"])
+	assert.Equal(t, tokenizers.SpecialTokenInfo{Role: tokenizers.RoleMask, Content: "[MASK]", Id: 103, InVocab: true,
+		Source: "special_tokens_map.json"}, byContent["[MASK]"])
+	assert.NotContains(t, byContent, "[unused0]") // "cls_token" was already defined in the configuration.
+	for _, content := range []string{"<ent>", "</ent>"} {
+		info := byContent[content]
+		assert.Equal(t, tokenizers.RoleAdditional, info.Role, "token %q", content)
+		assert.Equal(t, "special_tokens_map.json", info.Source, "token %q", content)
+	}
+
+	// Special tokens of the tokenizer definition, not in the configuration.
+	assert.Equal(t, tokenizers.RoleUnk, byContent["[UNK]"].Role)
+	assert.Equal(t, "tokenizer.json", byContent["[UNK]"].Source)
+	assert.Equal(t, uint32(100), byContent["[UNK]"].Id)
+	assert.Len(t, infos, len(byContent)) // Listed only once.
+}
+
+func TestExportDir(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	tk.WithTruncation(5).WithTruncationDirection(tokenizers.Right).
+		WithPadToLength(8).WithPaddingDirection(tokenizers.Left)
+
+	// Export into the snapshot of a repository in the cache, so it can be loaded offline.
+	const repoId, commit = "gomlx/exported", "0123456789abcdef"
+	cacheDir := t.TempDir()
+	storageDir := filepath.Join(cacheDir, tokenizers.RepoFolderName(repoId, "model"))
+	dir := filepath.Join(storageDir, "snapshots", commit)
+	require.NoError(t, tk.ExportDir(dir))
+	require.NoError(t, os.MkdirAll(filepath.Join(storageDir, "refs"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(storageDir, "refs", "main"), []byte(commit), 0644))
+
+	// Configuration should reflect padding and truncation.
+	contents, err := os.ReadFile(filepath.Join(dir, "tokenizer_config.json"))
+	require.NoError(t, err)
+	var config map[string]any
+	require.NoError(t, json.Unmarshal(contents, &config))
+	assert.Equal(t, "PreTrainedTokenizerFast", config["tokenizer_class"])
+	assert.Equal(t, 5.0, config["model_max_length"])
+	assert.Equal(t, "left", config["padding_side"])
+	assert.Equal(t, "right", config["truncation_side"])
+
+	// Loaded tokenizer, without reaching the network, should behave the same. The model family defaults (see
+	// ModelFamily) would enable AddSpecialTokens, which tk doesn't use.
+	withTestHub(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request to the Hub: %s %s", r.Method, r.URL.Path)
+		http.NotFound(w, r)
+	})
+	loaded, err := tokenizers.FromPretrainedWith(repoId).CacheDir(cacheDir).ForceLocal().NoModelFamilyDefaults().
+		Done()
+	require.NoError(t, err)
+	defer loaded.Finalize()
+	sentence := "brown fox jumps over the lazy dog"
+	want, err := tk.Encode(sentence)
+	require.NoError(t, err)
+	got, err := loaded.Encode(sentence)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+	assert.Equal(t, tk.String(), loaded.String())
+}
+
+func TestFromDir(t *testing.T) {
+	// Directory with `tokenizer.json` and configuration.
+	dir := t.TempDir()
+	for name, contents := range bertHubFiles(t) {
+		require.NoError(t, os.WriteFile(path.Join(dir, name), contents, 0644))
+	}
+	tk, err := tokenizers.FromDir(dir)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	assert.Equal(t, uint32(30522), tk.VocabSize())
+	require.NotNil(t, tk.Config())
+	assert.Equal(t, 512, tk.Config().ModelMaxLength)
+	encoding, err := tk.Encode("Brown fox")
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{101, 2829, 4419, 102}, encoding.TokenIds)
+
+	// Directory with only `vocab.txt` and `special_tokens_map.json`.
+	dir = t.TempDir()
+	require.NoError(t, os.WriteFile(path.Join(dir, "vocab.txt"), bertVocabTxt(t), 0644))
+	require.NoError(t, os.WriteFile(path.Join(dir, "special_tokens_map.json"), []byte(
+		`{"cls_token": "[CLS]", "sep_token": {"content": "[SEP]"}, "unk_token": "[UNK]"}`), 0644))
+	fromVocab, err := tokenizers.FromDir(dir)
+	require.NoError(t, err)
+	defer fromVocab.Finalize()
+	assert.Equal(t, "[SEP]", fromVocab.Config().SepToken)
+	encoding, err = fromVocab.Encode("Brown fox")
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{101, 2829, 4419, 102}, encoding.TokenIds)
+
+	// Empty directory.
+	_, err = tokenizers.FromDir(t.TempDir())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "tokenizer.json")
+}
+
+func TestPretrainedMaxRetries(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests int
+	)
+	withTestHub(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+
+	// Without retries, each of the files is requested once.
+	_, err := tokenizers.FromPretrainedWith("google/bert").
+		CacheDir(t.TempDir()).
+		MaxRetries(0).
+		Done()
+	require.Error(t, err)
+	first := requests
+	assert.Greater(t, first, 0)
+
+	previous := tokenizers.RetryBaseDelay
+	t.Cleanup(func() { tokenizers.RetryBaseDelay = previous })
+	tokenizers.RetryBaseDelay = time.Millisecond
+	_, err = tokenizers.FromPretrainedWith("google/bert").
+		CacheDir(t.TempDir()).
+		MaxRetries(2).
+		Done()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed after 3 attempts")
+	assert.Equal(t, 4*first, requests)
+}
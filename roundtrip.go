@@ -0,0 +1,69 @@
+package tokenizers
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"unicode/utf8"
+)
+
+// RoundTripOK is a testing helper that encodes the input, decodes the resulting token ids back and
+// checks that nothing went wrong: no panics, no errors and a valid UTF-8 decoded string.
+//
+// Notice the decoded string is not required to be equal to the input: normalization (e.g. lower casing)
+// and unknown tokens make it lossy.
+//
+// It returns true if the round trip was ok, otherwise it returns false and the reason of the failure.
+func RoundTripOK(t *Tokenizer, input string) (ok bool, reason string) {
+	defer func() {
+		if r := recover(); r != nil {
+			ok = false
+			reason = fmt.Sprintf("panic during round trip of %q: %v", input, r)
+		}
+	}()
+	encoding, err := t.Encode(input)
+	if err != nil {
+		return false, fmt.Sprintf("failed to encode %q: %+v", input, err)
+	}
+	decoded := t.Decode(encoding.TokenIds, true)
+	if !utf8.ValidString(decoded) {
+		return false, fmt.Sprintf("decoding of %q (token ids %v) generated invalid UTF-8: %q",
+			input, encoding.TokenIds, decoded)
+	}
+	return true, ""
+}
+
+// Checksum encodes the inputs with the Tokenizer t, with its current configuration, and returns a checksum
+// (hex-encoded SHA-256) of the resulting token ids, type ids and offsets (those returned).
+//
+// It is stable across runs and platforms, so it can be compared to a golden value in a test, to detect changes of
+// tokenization after upgrading the underlying (Rust) library, or after changing the tokenizer definition.
+func (t *Tokenizer) Checksum(inputs []string) (string, error) {
+	encodings, err := t.EncodeBatch(inputs)
+	if err != nil {
+		return "", errors.WithMessage(err, "Tokenizer.Checksum():")
+	}
+	hasher := sha256.New()
+	var buf []byte
+	appendUint32s := func(values ...uint32) {
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(values)))
+		for _, value := range values {
+			buf = binary.LittleEndian.AppendUint32(buf, value)
+		}
+	}
+	for ii := range encodings {
+		encoding := &encodings[ii]
+		buf = buf[:0]
+		appendUint32s(encoding.TokenIds...)
+		appendUint32s(encoding.TypeIds...)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(encoding.Offsets)))
+		for _, offset := range encoding.Offsets {
+			buf = binary.LittleEndian.AppendUint32(buf, offset.Start)
+			buf = binary.LittleEndian.AppendUint32(buf, offset.End)
+		}
+		hasher.Write(buf)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
@@ -1,11 +1,12 @@
 use std::ffi::CStr;
+use tokenizers::models::ModelWrapper;
 use tokenizers::tokenizer::Tokenizer;
 use crate::encode::convert_to_tokenizer_ref;
 
 
-/// Returns the vocab size.
+/// Returns the vocab size, including the added tokens if `with_added_tokens` is set.
 #[no_mangle]
-pub unsafe extern "C" fn vocab_size(ptr: *mut libc::c_void) -> u32 {
+pub unsafe extern "C" fn vocab_size(ptr: *mut libc::c_void, with_added_tokens: bool) -> u32 {
     let tokenizer: &Tokenizer;
     unsafe {
         tokenizer = ptr
@@ -13,7 +14,7 @@ pub unsafe extern "C" fn vocab_size(ptr: *mut libc::c_void) -> u32 {
             .as_ref()
             .expect("failed to cast tokenizer");
     }
-    tokenizer.get_vocab_size(true) as u32
+    tokenizer.get_vocab_size(with_added_tokens) as u32
 }
 
 /// TruncationParameters represents the truncation parameters
@@ -198,3 +199,56 @@ pub unsafe extern "C" fn get_padding(
     }
 }
 
+/// set_bpe_dropout sets the dropout probability of the merges of a BPE model. A dropout of 0 disables it.
+/// It returns null if ok, or a string with an error message (owned by caller) if the model is not BPE.
+/// The returned string needs to be freed with `free_string`.
+#[no_mangle]
+pub unsafe extern "C" fn set_bpe_dropout(tokenizer_ptr: *mut libc::c_void, dropout: f32) -> *mut libc::c_char {
+    let tokenizer: &mut Tokenizer = match unsafe { tokenizer_ptr.cast::<Tokenizer>().as_mut() } {
+        Some(t) => t,
+        None => return std::ffi::CString::new("failed to cast tokenizer").unwrap().into_raw(),
+    };
+    let mut model = tokenizer.get_model().clone();
+    match model {
+        ModelWrapper::BPE(ref mut bpe) => {
+            bpe.dropout = if dropout > 0.0 { Some(dropout) } else { None };
+        }
+        _ => return std::ffi::CString::new("dropout is only supported by BPE models").unwrap().into_raw(),
+    }
+    tokenizer.with_model(model);
+    crate::dropout::invalidate(tokenizer_ptr);
+    std::ptr::null_mut()
+}
+
+/// Estimated per-entry overhead of the vocabulary maps, besides the token string contents: the `String` itself,
+/// the id and the hash table bookkeeping.
+const VOCAB_ENTRY_OVERHEAD: usize = 40;
+
+/// Estimated memory per merge of a BPE model: the pair of ids, the rank and new id, and the hash table bookkeeping.
+const MERGE_ENTRY_OVERHEAD: usize = 24;
+
+/// native_memory_bytes stores in `bytes` a rough estimate of the memory used by the tokenizer: the vocabulary
+/// (stored in both directions, token to id and id to token), the merges of BPE models and the tokenizer structure.
+/// It returns null if ok, or a string with an error message (owned by caller).
+/// The returned string needs to be freed with `free_string`.
+#[no_mangle]
+pub unsafe extern "C" fn native_memory_bytes(tokenizer_ptr: *mut libc::c_void, bytes: *mut u64) -> *mut libc::c_char {
+    let tokenizer: &Tokenizer = match convert_to_tokenizer_ref(tokenizer_ptr) {
+        Ok(t) => t,
+        Err(error) => return std::ffi::CString::new(error.to_string()).unwrap().into_raw(),
+    };
+    let mut total = std::mem::size_of::<Tokenizer>();
+    for token in tokenizer.get_vocab(true).keys() {
+        total += 2 * (token.len() + VOCAB_ENTRY_OVERHEAD);
+    }
+    // The merges of BPE models are not exposed by the library, so they are counted from their serialization.
+    if let ModelWrapper::BPE(_) = tokenizer.get_model() {
+        if let Ok(model) = serde_json::to_value(tokenizer.get_model()) {
+            if let Some(merges) = model.get("merges").and_then(|merges| merges.as_array()) {
+                total += merges.len() * MERGE_ENTRY_OVERHEAD;
+            }
+        }
+    }
+    unsafe { *bytes = total as u64 };
+    std::ptr::null_mut()
+}
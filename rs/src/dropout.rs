@@ -0,0 +1,381 @@
+//! Seeded BPE dropout.
+//!
+//! The `tokenizers` library draws the BPE dropout from an unseeded thread-local random number generator, so its
+//! results can't be reproduced. When a seed is given (see `EncodeParams.with_dropout_seed`), the encoding is
+//! done here instead: it follows the same pipeline (added tokens, normalization, pre-tokenization, model and
+//! post-processing), but the BPE model is replaced by a copy of its merge loop drawing from a seeded generator.
+use std::cmp::Ordering;
+use std::collections::{BinaryHeap, HashMap};
+use std::sync::{Arc, Mutex};
+use tokenizers::models::ModelWrapper;
+use tokenizers::tokenizer::Tokenizer;
+use tokenizers::{pad_encodings, Encoding, OffsetType, PreTokenizedString, PreTokenizer, Token};
+
+/// Sequence to encode: either a raw string or a pre-tokenized list of words.
+pub enum Sequence<'a> {
+    Raw(&'a str),
+    PreTokenized(&'a [&'a str]),
+}
+
+/// SplitMix64 random number generator: small, fast and good enough for dropout decisions.
+struct SplitMix64 {
+    state: u64,
+}
+
+impl SplitMix64 {
+    /// Creates the generator for the sentence `index` of an encoding call with the given seed.
+    fn new(seed: u64, index: u64) -> Self {
+        let mut rng = SplitMix64 { state: seed };
+        rng.state = rng.next_u64() ^ index.wrapping_mul(0x9E37_79B9_7F4A_7C15);
+        rng
+    }
+
+    fn next_u64(&mut self) -> u64 {
+        self.state = self.state.wrapping_add(0x9E37_79B9_7F4A_7C15);
+        let mut z = self.state;
+        z = (z ^ (z >> 30)).wrapping_mul(0xBF58_476D_1CE4_E5B9);
+        z = (z ^ (z >> 27)).wrapping_mul(0x94D0_49BB_1331_11EB);
+        z ^ (z >> 31)
+    }
+
+    /// Returns a uniform value in [0, 1).
+    fn next_f32(&mut self) -> f32 {
+        (self.next_u64() >> 40) as f32 / (1u64 << 24) as f32
+    }
+}
+
+/// Symbol of a word being merged, as in the `tokenizers` library: a doubly linked list over the symbols array.
+#[derive(Clone, Copy)]
+struct Symbol {
+    id: u32,
+    prev: isize,
+    next: isize,
+    len: usize,
+}
+
+/// Candidate merge in the priority queue: the lowest rank first, and then the leftmost.
+struct Merge {
+    pos: usize,
+    rank: u32,
+    new_id: u32,
+}
+
+impl PartialEq for Merge {
+    fn eq(&self, other: &Self) -> bool {
+        self.rank == other.rank && self.pos == other.pos
+    }
+}
+
+impl Eq for Merge {}
+
+impl PartialOrd for Merge {
+    fn partial_cmp(&self, other: &Self) -> Option<Ordering> {
+        Some(self.cmp(other))
+    }
+}
+
+impl Ord for Merge {
+    fn cmp(&self, other: &Self) -> Ordering {
+        // BinaryHeap is a max-heap, so the order is reversed.
+        other.rank.cmp(&self.rank).then_with(|| other.pos.cmp(&self.pos))
+    }
+}
+
+/// DropoutBpe holds what is needed to tokenize with a BPE model and a seeded dropout.
+pub struct DropoutBpe {
+    vocab: HashMap<String, u32>,
+    vocab_r: HashMap<u32, String>,
+    merges: HashMap<(u32, u32), (u32, u32)>,
+    dropout: f32,
+    unk_token: Option<String>,
+    continuing_subword_prefix: Option<String>,
+    end_of_word_suffix: Option<String>,
+    fuse_unk: bool,
+    byte_fallback: bool,
+}
+
+/// Cache of the DropoutBpe per tokenizer pointer, since building the merges map for each call would be too slow.
+/// Entries are invalidated by `invalidate` when the model changes or the tokenizer is freed.
+static CACHE: Mutex<Vec<(usize, Option<Arc<DropoutBpe>>)>> = Mutex::new(Vec::new());
+
+/// Returns the DropoutBpe for the tokenizer, or None if its model is not BPE or dropout is not enabled.
+pub fn for_tokenizer(tokenizer_ptr: *mut libc::c_void, tokenizer: &Tokenizer) -> Option<Arc<DropoutBpe>> {
+    let key = tokenizer_ptr as usize;
+    let mut cache = CACHE.lock().unwrap_or_else(|poisoned| poisoned.into_inner());
+    if let Some((_, bpe)) = cache.iter().find(|(k, _)| *k == key) {
+        return bpe.clone();
+    }
+    let bpe = DropoutBpe::from_model(tokenizer.get_model()).map(Arc::new);
+    cache.push((key, bpe.clone()));
+    bpe
+}
+
+/// Removes the cached DropoutBpe of the tokenizer, if any.
+pub fn invalidate(tokenizer_ptr: *mut libc::c_void) {
+    let key = tokenizer_ptr as usize;
+    let mut cache = CACHE.lock().unwrap_or_else(|poisoned| poisoned.into_inner());
+    cache.retain(|(k, _)| *k != key);
+}
+
+impl DropoutBpe {
+    /// Builds it from the model, if it is BPE with dropout enabled. The merges are not exposed by the library,
+    /// so they are read from its serialization.
+    fn from_model(model: &ModelWrapper) -> Option<DropoutBpe> {
+        let dropout = match model {
+            ModelWrapper::BPE(bpe) => bpe.dropout?,
+            _ => return None,
+        };
+        let value = serde_json::to_value(model).ok()?;
+        let string_field = |name: &str| value.get(name).and_then(|v| v.as_str()).map(|s| s.to_string());
+        let vocab: HashMap<String, u32> = value
+            .get("vocab")?
+            .as_object()?
+            .iter()
+            .filter_map(|(token, id)| Some((token.clone(), id.as_u64()? as u32)))
+            .collect();
+        let vocab_r = vocab.iter().map(|(token, id)| (*id, token.clone())).collect();
+        let continuing_subword_prefix = string_field("continuing_subword_prefix");
+        let prefix_len = continuing_subword_prefix.as_ref().map_or(0, |p| p.len());
+        let mut merges = HashMap::new();
+        for (rank, merge) in value.get("merges")?.as_array()?.iter().enumerate() {
+            let (a, b) = merge.as_str()?.split_once(' ')?;
+            let new_token = format!("{}{}", a, b.get(prefix_len..).unwrap_or(""));
+            if let (Some(a_id), Some(b_id), Some(new_id)) = (vocab.get(a), vocab.get(b), vocab.get(&new_token)) {
+                merges.insert((*a_id, *b_id), (rank as u32, *new_id));
+            }
+        }
+        Some(DropoutBpe {
+            vocab,
+            vocab_r,
+            merges,
+            dropout,
+            unk_token: string_field("unk_token"),
+            continuing_subword_prefix,
+            end_of_word_suffix: string_field("end_of_word_suffix"),
+            fuse_unk: value.get("fuse_unk").and_then(|v| v.as_bool()).unwrap_or(false),
+            byte_fallback: value.get("byte_fallback").and_then(|v| v.as_bool()).unwrap_or(false),
+        })
+    }
+
+    /// Encodes the sequence (and optional pair) as `Tokenizer::encode` does, with the dropout drawn from a
+    /// generator seeded by `seed` and `index` (the position of the sequence in a batch).
+    pub fn encode(
+        &self,
+        tokenizer: &Tokenizer,
+        sequence: Sequence,
+        pair: Option<Sequence>,
+        add_special_tokens: bool,
+        offsets_type: OffsetType,
+        seed: u64,
+        index: u64,
+    ) -> tokenizers::Result<Encoding> {
+        let mut rng = SplitMix64::new(seed, index);
+        let encoding = self.encode_sequence(tokenizer, sequence, 0, offsets_type, &mut rng)?;
+        let pair_encoding = match pair {
+            Some(pair) => Some(self.encode_sequence(tokenizer, pair, 1, offsets_type, &mut rng)?),
+            None => None,
+        };
+        tokenizer.post_process(encoding, pair_encoding, add_special_tokens)
+    }
+
+    /// Encodes a batch of raw sentences with `encode`, and pads them as `Tokenizer::encode_batch` does.
+    pub fn encode_batch(
+        &self,
+        tokenizer: &Tokenizer,
+        sentences: &[String],
+        add_special_tokens: bool,
+        offsets_type: OffsetType,
+        seed: u64,
+    ) -> tokenizers::Result<Vec<Encoding>> {
+        let mut encodings = sentences
+            .iter()
+            .enumerate()
+            .map(|(index, sentence)| {
+                self.encode(tokenizer, Sequence::Raw(sentence), None, add_special_tokens, offsets_type, seed, index as u64)
+            })
+            .collect::<tokenizers::Result<Vec<Encoding>>>()?;
+        if let Some(padding) = tokenizer.get_padding() {
+            pad_encodings(&mut encodings, padding)?;
+        }
+        Ok(encodings)
+    }
+
+    fn encode_sequence(
+        &self,
+        tokenizer: &Tokenizer,
+        sequence: Sequence,
+        type_id: u32,
+        offsets_type: OffsetType,
+        rng: &mut SplitMix64,
+    ) -> tokenizers::Result<Encoding> {
+        match sequence {
+            Sequence::Raw(s) => self.encode_subsequence(tokenizer, s, type_id, None, offsets_type, rng),
+            Sequence::PreTokenized(words) => words
+                .iter()
+                .enumerate()
+                .map(|(word_idx, word)| {
+                    self.encode_subsequence(tokenizer, word, type_id, Some(word_idx as u32), offsets_type, rng)
+                })
+                .collect(),
+        }
+    }
+
+    fn encode_subsequence(
+        &self,
+        tokenizer: &Tokenizer,
+        subsequence: &str,
+        type_id: u32,
+        word_idx: Option<u32>,
+        offsets_type: OffsetType,
+        rng: &mut SplitMix64,
+    ) -> tokenizers::Result<Encoding> {
+        let mut pretokenized: PreTokenizedString = tokenizer
+            .get_added_vocabulary()
+            .extract_and_normalize(tokenizer.get_normalizer(), subsequence);
+        if let Some(pre_tokenizer) = tokenizer.get_pre_tokenizer() {
+            pre_tokenizer.pre_tokenize(&mut pretokenized)?;
+        }
+        pretokenized.tokenize(|normalized| self.tokenize(normalized.get(), rng))?;
+        pretokenized.into_encoding(word_idx, type_id, offsets_type)
+    }
+
+    /// Tokenizes one word, as `BPE::tokenize` does with dropout.
+    fn tokenize(&self, word: &str, rng: &mut SplitMix64) -> tokenizers::Result<Vec<Token>> {
+        if word.is_empty() {
+            return Ok(vec![]);
+        }
+        let mut symbols = self.split_word(word)?;
+        self.merge_all(&mut symbols, rng);
+        let mut pos = 0;
+        Ok(symbols
+            .iter()
+            .map(|symbol| {
+                let offsets = (pos, pos + symbol.len);
+                pos += symbol.len;
+                Token::new(symbol.id, self.vocab_r[&symbol.id].clone(), offsets)
+            })
+            .collect())
+    }
+
+    /// Splits the word in its characters (or bytes, with byte fallback, or unknown tokens), as `BPE::merge_word`.
+    fn split_word(&self, word: &str) -> tokenizers::Result<Vec<Symbol>> {
+        let mut symbols: Vec<Symbol> = Vec::with_capacity(word.len());
+        let mut add = |id: u32, len: usize| {
+            let index = symbols.len() as isize;
+            if let Some(last) = symbols.last_mut() {
+                last.next = index;
+            }
+            symbols.push(Symbol { id, prev: index - 1, next: -1, len });
+        };
+        let mut unk: Option<(u32, usize)> = None;
+        let mut indices = word.char_indices().map(|(idx, _)| idx).peekable();
+        while let Some(i) = indices.next() {
+            let end = indices.peek().copied();
+            let byte_len = end.unwrap_or(word.len()) - i;
+            let mut s = word[i..i + byte_len].to_string();
+            if i != 0 {
+                if let Some(ref prefix) = self.continuing_subword_prefix {
+                    s = format!("{}{}", prefix, s);
+                }
+            }
+            if end.is_none() {
+                if let Some(ref suffix) = self.end_of_word_suffix {
+                    s = format!("{}{}", s, suffix);
+                }
+            }
+
+            if let Some(id) = self.vocab.get(&s) {
+                if let Some((unk_id, unk_len)) = unk.take() {
+                    add(unk_id, unk_len);
+                }
+                add(*id, byte_len);
+                continue;
+            }
+            if self.byte_fallback {
+                let tokens: Option<Vec<u32>> =
+                    s.bytes().map(|b| self.vocab.get(&format!("<{:#04X}>", b)).copied()).collect();
+                if let Some(tokens) = tokens {
+                    for id in tokens {
+                        add(id, 1);
+                    }
+                    continue;
+                }
+            }
+            if let Some(ref unk_token) = self.unk_token {
+                let unk_id = *self
+                    .vocab
+                    .get(unk_token)
+                    .ok_or_else(|| format!("unknown token {:?} is not in the vocabulary", unk_token))?;
+                unk = match unk {
+                    Some((unk_id, unk_len)) if self.fuse_unk => Some((unk_id, unk_len + byte_len)),
+                    Some((unk_id, unk_len)) => {
+                        add(unk_id, unk_len);
+                        Some((unk_id, byte_len))
+                    }
+                    None => Some((unk_id, byte_len)),
+                };
+            }
+        }
+        if let Some((unk_id, unk_len)) = unk {
+            add(unk_id, unk_len);
+        }
+        Ok(symbols)
+    }
+
+    /// Applies the merges in order of rank, skipping each with probability `dropout`, as `Word::merge_all`.
+    fn merge_all(&self, symbols: &mut Vec<Symbol>, rng: &mut SplitMix64) {
+        let mut queue: BinaryHeap<Merge> = symbols
+            .windows(2)
+            .enumerate()
+            .filter_map(|(pos, window)| {
+                self.merges.get(&(window[0].id, window[1].id)).map(|&(rank, new_id)| Merge { pos, rank, new_id })
+            })
+            .collect();
+        let mut skip: Vec<Merge> = Vec::with_capacity(queue.len());
+        while let Some(top) = queue.pop() {
+            if rng.next_f32() < self.dropout {
+                skip.push(top);
+                continue;
+            }
+            // Re-insert the skipped merges.
+            queue.extend(skip.drain(..));
+
+            if symbols[top.pos].len == 0 || symbols[top.pos].next == -1 {
+                continue;
+            }
+            let next_pos = symbols[top.pos].next as usize;
+            let right = symbols[next_pos];
+
+            // Make sure the queue entry has not expired.
+            let pair = (symbols[top.pos].id, right.id);
+            if !self.merges.get(&pair).map_or(false, |&(_, new_id)| new_id == top.new_id) {
+                continue;
+            }
+
+            // Merge the right symbol into this one, and tag the right one as removed.
+            symbols[top.pos].id = top.new_id;
+            symbols[top.pos].len += right.len;
+            symbols[top.pos].next = right.next;
+            symbols[next_pos].len = 0;
+            if right.next > -1 && (right.next as usize) < symbols.len() {
+                symbols[right.next as usize].prev = top.pos as isize;
+            }
+
+            // Insert the new pairs formed with the previous and the next symbols.
+            let current = symbols[top.pos];
+            if current.prev >= 0 {
+                let prev = current.prev as usize;
+                if let Some(&(rank, new_id)) = self.merges.get(&(symbols[prev].id, current.id)) {
+                    queue.push(Merge { pos: prev, rank, new_id });
+                }
+            }
+            let next = current.next as usize;
+            if current.next >= 0 && next < symbols.len() {
+                if let Some(&(rank, new_id)) = self.merges.get(&(current.id, symbols[next].id)) {
+                    queue.push(Merge { pos: top.pos, rank, new_id });
+                }
+            }
+        }
+        symbols.retain(|s| s.len != 0);
+    }
+}
@@ -1,7 +1,9 @@
+use crate::dropout;
+use crate::dropout::Sequence;
 use crate::free_string;
 use std::ffi::CStr;
 use std::ptr::null_mut;
-use tokenizers::Encoding;
+use tokenizers::{Encoding, OffsetType};
 use tokenizers::tokenizer::Tokenizer;
 use std::error::Error;
 
@@ -17,6 +19,13 @@ pub struct EncodeParams {
     return_attention_mask: bool,
     return_offsets: bool,
     with_offsets_char_mode: bool,
+    return_word_ids: bool,
+    return_sequence_ids: bool,
+    return_lengths: bool,
+    // If with_dropout_seed is set, the BPE dropout (if enabled) draws from a generator seeded with dropout_seed,
+    // making the encoding reproducible. See dropout.rs.
+    with_dropout_seed: bool,
+    dropout_seed: u64,
 }
 
 /// EncodeResult represents the result of encoding one (`encode` function)
@@ -44,7 +53,10 @@ pub struct Buffer {
     attention_mask: *mut u32,
     tokens: *mut *mut libc::c_char,
     offsets: *mut Offset,
+    word_ids: *mut i32,
+    sequence_ids: *mut i32,
     len: u32,
+    unpadded_len: u32,  // Number of tokens before padding, only set if `return_lengths`.
 }
 
 /// Offset of the toke in the sentence.
@@ -68,7 +80,7 @@ fn encode_process(encoding: Encoding, options: &EncodeParams) -> Result<Buffer,
         let tokens_string = encoding.get_tokens();
         let mut vec_tokens: Vec<*mut libc::c_char> = Vec::with_capacity(tokens_string.len());
         for token in tokens_string {
-            vec_tokens.push(std::ffi::CString::new(token.as_bytes())?.into_raw());
+            vec_tokens.push(crate::vocab::token_to_c_string(token.clone()));
         }
         vec_tokens.shrink_to_fit();
         tokens = vec_tokens.as_mut_ptr();
@@ -122,6 +134,45 @@ fn encode_process(encoding: Encoding, options: &EncodeParams) -> Result<Buffer,
         std::mem::forget(vec_offsets);
     }
 
+    // word_ids: -1 for tokens not associated to a word (e.g.: special tokens).
+    let mut word_ids: *mut i32 = null_mut();
+    if options.return_word_ids {
+        let mut vec_word_ids = encoding
+            .get_word_ids()
+            .iter()
+            .map(|w| match w {
+                Some(id) => *id as i32,
+                None => -1,
+            })
+            .collect::<Vec<_>>();
+        vec_word_ids.shrink_to_fit();
+        word_ids = vec_word_ids.as_mut_ptr();
+        std::mem::forget(vec_word_ids);
+    }
+
+    // sequence_ids: -1 for tokens not associated to a sequence (e.g.: special tokens), otherwise 0 for the
+    // first sequence and 1 for the second one.
+    let mut sequence_ids: *mut i32 = null_mut();
+    if options.return_sequence_ids {
+        let mut vec_sequence_ids = encoding
+            .get_sequence_ids()
+            .iter()
+            .map(|s| match s {
+                Some(id) => *id as i32,
+                None => -1,
+            })
+            .collect::<Vec<_>>();
+        vec_sequence_ids.shrink_to_fit();
+        sequence_ids = vec_sequence_ids.as_mut_ptr();
+        std::mem::forget(vec_sequence_ids);
+    }
+
+    // unpadded_len: padding tokens are the ones masked out in the attention mask.
+    let mut unpadded_len: u32 = 0;
+    if options.return_lengths {
+        unpadded_len = encoding.get_attention_mask().iter().filter(|m| **m != 0).count() as u32;
+    }
+
     Ok(Buffer {
         ids,
         type_ids,
@@ -129,7 +180,10 @@ fn encode_process(encoding: Encoding, options: &EncodeParams) -> Result<Buffer,
         attention_mask,
         tokens,
         offsets,
+        word_ids,
+        sequence_ids,
         len: (len as u32),
+        unpadded_len,
     })
 }
 
@@ -171,6 +225,21 @@ pub fn convert_to_tokenizer_ref<'a>(tokenizer_ptr: *mut libc::c_void) -> Result<
     }
 }
 
+// seeded_dropout returns the BPE model to encode with a seeded dropout, if a seed was given and the tokenizer
+// has a BPE model with dropout enabled. Otherwise, the tokenizer encodes as usual.
+fn seeded_dropout(tokenizer_ptr: *mut libc::c_void, tokenizer: &Tokenizer, options: &EncodeParams)
+    -> Option<std::sync::Arc<dropout::DropoutBpe>> {
+    if !options.with_dropout_seed {
+        return None;
+    }
+    dropout::for_tokenizer(tokenizer_ptr, tokenizer)
+}
+
+// offsets_type returns the type of offsets requested by the options.
+fn offsets_type(options: &EncodeParams) -> OffsetType {
+    if options.with_offsets_char_mode { OffsetType::Char } else { OffsetType::Byte }
+}
+
 fn encode_impl(tokenizer_ptr: *mut libc::c_void,
                    message: *const libc::c_char,
                    options: EncodeParams,
@@ -179,7 +248,10 @@ fn encode_impl(tokenizer_ptr: *mut libc::c_void,
     let message_cstr = unsafe { CStr::from_ptr(message) };
     let message = message_cstr.to_str().unwrap();
 
-    let encoding_res = if options.with_offsets_char_mode {
+    let encoding_res = if let Some(bpe) = seeded_dropout(tokenizer_ptr, tokenizer, &options) {
+        bpe.encode(tokenizer, Sequence::Raw(message), None, options.add_special_tokens,
+                   offsets_type(&options), options.dropout_seed, 0)
+    } else if options.with_offsets_char_mode {
         tokenizer.encode_char_offsets(message, options.add_special_tokens)
     } else {
         tokenizer.encode(message, options.add_special_tokens)
@@ -189,9 +261,13 @@ fn encode_impl(tokenizer_ptr: *mut libc::c_void,
         Ok(e) => encoding = e,
         Err(error) => return Err(err(format!("encoding failed: {}", error.to_string()))),
     }
+    single_encode_results(encoding, &options)
+}
 
+// single_encode_results packages one encoding into an EncodeResults with one Buffer.
+fn single_encode_results(encoding: Encoding, options: &EncodeParams) -> Result<EncodeResults, Box<dyn Error>> {
     // Encode it.
-    let buffer = encode_process(encoding, &options)?;
+    let buffer = encode_process(encoding, options)?;
 
     // Package one Buffer into EncodeResults.
     let mut vec_buf: Vec<Buffer> = Vec::with_capacity(1);
@@ -205,6 +281,31 @@ fn encode_impl(tokenizer_ptr: *mut libc::c_void,
     })
 }
 
+fn encode_pair_impl(tokenizer_ptr: *mut libc::c_void,
+                    message: *const libc::c_char,
+                    pair: *const libc::c_char,
+                    options: EncodeParams,
+) -> Result<EncodeResults, Box<dyn Error>> {
+    let tokenizer: &Tokenizer = convert_to_tokenizer_ref(tokenizer_ptr)?;
+    let message = unsafe { CStr::from_ptr(message) }.to_str()?;
+    let pair = unsafe { CStr::from_ptr(pair) }.to_str()?;
+
+    let encoding_res = if let Some(bpe) = seeded_dropout(tokenizer_ptr, tokenizer, &options) {
+        bpe.encode(tokenizer, Sequence::Raw(message), Some(Sequence::Raw(pair)), options.add_special_tokens,
+                   offsets_type(&options), options.dropout_seed, 0)
+    } else if options.with_offsets_char_mode {
+        tokenizer.encode_char_offsets((message, pair), options.add_special_tokens)
+    } else {
+        tokenizer.encode((message, pair), options.add_special_tokens)
+    };
+    let encoding: Encoding;
+    match encoding_res {
+        Ok(e) => encoding = e,
+        Err(error) => return Err(err(format!("encoding of pair failed: {}", error.to_string()))),
+    }
+    single_encode_results(encoding, &options)
+}
+
 
 /// Encodes string using given tokenizer and EncodeParams.
 #[no_mangle]
@@ -217,6 +318,102 @@ pub unsafe extern "C" fn encode(
         encode_impl(tokenizer_ptr, message, options))
 }
 
+/// Encodes a pair of strings (sequences A and B) using given tokenizer and EncodeParams.
+/// The post-processor of the tokenizer defines how they are combined, and the type ids of each.
+#[no_mangle]
+pub unsafe extern "C" fn encode_pair(
+    tokenizer_ptr: *mut libc::c_void,
+    message: *const libc::c_char,
+    pair: *const libc::c_char,
+    options: EncodeParams,
+) -> EncodeResults {
+    result_to_encode_results(
+        encode_pair_impl(tokenizer_ptr, message, pair, options))
+}
+
+/// Encodes a pre-tokenized sentence, given as `num_words` words, using given tokenizer and EncodeParams.
+/// The words are not merged across their boundaries, and the offsets are relative to each word.
+#[no_mangle]
+pub unsafe extern "C" fn encode_pretokenized(
+    tokenizer_ptr: *mut libc::c_void,
+    num_words: u32,
+    words: *const *const libc::c_char,
+    options: EncodeParams,
+) -> EncodeResults {
+    result_to_encode_results(
+        encode_pretokenized_impl(tokenizer_ptr, num_words, words, options))
+}
+
+fn encode_pretokenized_impl(
+    tokenizer_ptr: *mut libc::c_void,
+    num_words: u32,
+    words: *const *const libc::c_char,
+    options: EncodeParams,
+) -> Result<EncodeResults, Box<dyn Error>> {
+    let tokenizer: &Tokenizer = convert_to_tokenizer_ref(tokenizer_ptr)?;
+    let mut owned_words: Vec<String> = Vec::with_capacity(num_words as usize);
+    for index in 0..num_words {
+        let cstr_ptr = unsafe { *words.offset(index as isize) };
+        owned_words.push(unsafe { CStr::from_ptr(cstr_ptr) }.to_string_lossy().into_owned());
+    }
+    let words: Vec<&str> = owned_words.iter().map(|w| w.as_str()).collect();
+
+    let encoding_res = if let Some(bpe) = seeded_dropout(tokenizer_ptr, tokenizer, &options) {
+        bpe.encode(tokenizer, Sequence::PreTokenized(words.as_slice()), None, options.add_special_tokens,
+                   offsets_type(&options), options.dropout_seed, 0)
+    } else if options.with_offsets_char_mode {
+        tokenizer.encode_char_offsets(words.as_slice(), options.add_special_tokens)
+    } else {
+        tokenizer.encode(words.as_slice(), options.add_special_tokens)
+    };
+    let encoding: Encoding;
+    match encoding_res {
+        Ok(e) => encoding = e,
+        Err(error) => return Err(err(format!("encoding of pre-tokenized sentence failed: {}", error.to_string()))),
+    }
+    single_encode_results(encoding, &options)
+}
+
+/// Encodes string using given tokenizer and EncodeParams, and returns the encoding followed by its
+/// overflowing encodings: the windows of tokens that didn't fit the truncation length.
+///
+/// If truncation is not configured, or if the string fits, only one result is returned.
+#[no_mangle]
+pub unsafe extern "C" fn encode_overflowing(
+    tokenizer_ptr: *mut libc::c_void,
+    message: *const libc::c_char,
+    options: EncodeParams,
+) -> EncodeResults {
+    result_to_encode_results(
+        encode_overflowing_impl(tokenizer_ptr, message, options))
+}
+
+fn encode_overflowing_impl(tokenizer_ptr: *mut libc::c_void,
+                           message: *const libc::c_char,
+                           options: EncodeParams,
+) -> Result<EncodeResults, Box<dyn Error>> {
+    let tokenizer: &Tokenizer = convert_to_tokenizer_ref(tokenizer_ptr)?;
+    let message = unsafe { CStr::from_ptr(message) }.to_str()?;
+    let encoding_res = if let Some(bpe) = seeded_dropout(tokenizer_ptr, tokenizer, &options) {
+        bpe.encode(tokenizer, Sequence::Raw(message), None, options.add_special_tokens,
+                   offsets_type(&options), options.dropout_seed, 0)
+    } else if options.with_offsets_char_mode {
+        tokenizer.encode_char_offsets(message, options.add_special_tokens)
+    } else {
+        tokenizer.encode(message, options.add_special_tokens)
+    };
+    let mut encoding: Encoding;
+    match encoding_res {
+        Ok(e) => encoding = e,
+        Err(error) => return Err(err(format!("encoding failed: {}", error.to_string()))),
+    }
+    let overflowing = encoding.take_overflowing();
+    let mut encodings: Vec<Encoding> = Vec::with_capacity(1 + overflowing.len());
+    encodings.push(encoding);
+    encodings.extend(overflowing);
+    multiple_encode_results(encodings, &options)
+}
+
 /// Encode a batch of strings using given tokenizer and EncodeParams.
 /// The
 #[no_mangle]
@@ -245,7 +442,10 @@ fn encode_batch_impl(
             encode_messages.push(rust_string);
         }
     }
-    let encoding_res = if options.with_offsets_char_mode {
+    let encoding_res = if let Some(bpe) = seeded_dropout(tokenizer_ptr, tokenizer, &options) {
+        bpe.encode_batch(tokenizer, &encode_messages, options.add_special_tokens,
+                         offsets_type(&options), options.dropout_seed)
+    } else if options.with_offsets_char_mode {
         tokenizer
             .encode_batch_char_offsets(encode_messages, options.add_special_tokens)
     } else {
@@ -257,11 +457,15 @@ fn encode_batch_impl(
         Ok(e) => encoding = e,
         Err(error) => return Err(err(format!("encoding failed: {}", error.to_string()))),
     }
+    multiple_encode_results(encoding, &options)
+}
 
+// multiple_encode_results packages the encodings into an EncodeResults, with one Buffer per encoding.
+fn multiple_encode_results(encodings: Vec<Encoding>, options: &EncodeParams) -> Result<EncodeResults, Box<dyn Error>> {
     // batch process
-    let mut vec_buffers: Vec<Buffer> = Vec::with_capacity(num_messages as usize);
-    for enc in encoding {
-        vec_buffers.push(encode_process(enc, &options)?);
+    let mut vec_buffers: Vec<Buffer> = Vec::with_capacity(encodings.len());
+    for enc in encodings {
+        vec_buffers.push(encode_process(enc, options)?);
     }
     vec_buffers.shrink_to_fit();
     let encode_results = EncodeResults{
@@ -309,6 +513,16 @@ fn free_buffer(buf: Buffer) {
             Vec::from_raw_parts(buf.offsets, buf.len as usize, buf.len as usize).clear();
         }
     }
+    if !buf.word_ids.is_null() {
+        unsafe {
+            Vec::from_raw_parts(buf.word_ids, buf.len as usize, buf.len as usize);
+        }
+    }
+    if !buf.sequence_ids.is_null() {
+        unsafe {
+            Vec::from_raw_parts(buf.sequence_ids, buf.len as usize, buf.len as usize);
+        }
+    }
 }
 
 /// This function is release Vec<Buffer> from Rust returned to Golang by `encode_batch`.
@@ -1,9 +1,12 @@
 mod configure;
+mod dropout;
 mod encode;
 mod decode;
+mod vocab;
 
 use std::ptr::null_mut;
 use tokenizers::tokenizer::Tokenizer;
+use crate::encode::convert_to_tokenizer_ref;
 
 /// PointerOrError returns either a `void *` pointer or an error. 
 /// It can be used by functions interfacing with Rust from other languages (using the C binding).
@@ -43,12 +46,43 @@ pub unsafe extern "C" fn from_bytes(bytes: *const u8, len: u32) -> PointerOrErro
     }
 }
 
+/// to_bytes serializes the Tokenizer to the json format of a `tokenizer.json` file -- the same
+/// format read by `from_bytes`. It includes the current truncation and padding parameters.
+///
+/// It returns the json contents as a C string (`char *`) in the `value` field, or an error.
+///
+/// # Safety
+///
+/// Ownership of the returned `value` (or `error`) is transferred to the caller, who must free it
+/// with `free_string`.
+#[no_mangle]
+pub unsafe extern "C" fn to_bytes(tokenizer_ptr: *mut libc::c_void, pretty: bool) -> PointerOrError {
+    let tokenizer: &Tokenizer = match convert_to_tokenizer_ref(tokenizer_ptr) {
+        Ok(t) => t,
+        Err(err) => return PointerOrError{
+            value: null_mut(),
+            error: std::ffi::CString::new(err.to_string()).unwrap().into_raw(),
+        }
+    };
+    match tokenizer.to_string(pretty) {
+        Ok(json) => return PointerOrError{
+            value: std::ffi::CString::new(json).unwrap().into_raw().cast(),
+            error: null_mut(),
+        },
+        Err(err) => return PointerOrError{
+            value: null_mut(),
+            error: std::ffi::CString::new(format!("failed tokenizer.to_string: {}", err)).unwrap().into_raw(),
+        }
+    }
+}
+
 /// Frees a Tokenizer allocated by Rust and returned to Golang.
 #[no_mangle]
 pub unsafe extern "C" fn free_tokenizer(ptr: *mut libc::c_void) {
     if ptr.is_null() {
         return;
     }
+    dropout::invalidate(ptr);
     ptr.cast::<Tokenizer>();
 }
 
@@ -0,0 +1,178 @@
+use std::ffi::CStr;
+use std::ptr::null_mut;
+use tokenizers::tokenizer::Tokenizer;
+use tokenizers::{AddedToken, Model};
+use crate::encode::convert_to_tokenizer_ref;
+
+/// tokens_to_ids converts each of the `len` tokens to its id, including added tokens, all in one call.
+///
+/// The ids are stored in `ids` and whether each token was found in `found`, both arrays of length `len`
+/// owned by the caller. Tokens not found are given id 0.
+///
+/// It returns false if the tokenizer is invalid, in which case `ids` and `found` are not changed.
+#[no_mangle]
+pub unsafe extern "C" fn tokens_to_ids(
+    tokenizer_ptr: *mut libc::c_void,
+    tokens: *const *const libc::c_char,
+    len: u32,
+    ids: *mut u32,
+    found: *mut bool,
+) -> bool {
+    let tokenizer: &Tokenizer = match convert_to_tokenizer_ref(tokenizer_ptr) {
+        Ok(t) => t,
+        Err(_) => return false,
+    };
+    let len = len as usize;
+    let tokens_slice = unsafe { std::slice::from_raw_parts(tokens, len) };
+    let ids_slice = unsafe { std::slice::from_raw_parts_mut(ids, len) };
+    let found_slice = unsafe { std::slice::from_raw_parts_mut(found, len) };
+    for (ii, token_ptr) in tokens_slice.iter().enumerate() {
+        let token = unsafe { CStr::from_ptr(*token_ptr) }.to_string_lossy();
+        match tokenizer.token_to_id(&token) {
+            Some(id) => {
+                ids_slice[ii] = id;
+                found_slice[ii] = true;
+            }
+            None => {
+                ids_slice[ii] = 0;
+                found_slice[ii] = false;
+            }
+        }
+    }
+    true
+}
+
+/// token_to_c_string converts a token to a C string, owned by the caller. C strings can't hold NUL bytes, so any
+/// in the token are replaced by U+FFFD (the Unicode replacement character).
+pub(crate) fn token_to_c_string(token: String) -> *mut libc::c_char {
+    let token = if token.contains('\0') { token.replace('\0', "\u{FFFD}") } else { token };
+    std::ffi::CString::new(token).unwrap_or_default().into_raw()
+}
+
+/// ids_to_tokens converts each of the `len` ids to its token string, including added tokens, all in one call.
+///
+/// The tokens are stored in `tokens`, an array of length `len` owned by the caller. Each token string
+/// is owned by the caller, and must be freed with `free_string`. Unknown ids are set to null. NUL bytes in the
+/// tokens are replaced, see `token_to_c_string`.
+///
+/// It returns false if the tokenizer is invalid, in which case `tokens` is not changed.
+#[no_mangle]
+pub unsafe extern "C" fn ids_to_tokens(
+    tokenizer_ptr: *mut libc::c_void,
+    ids: *const u32,
+    len: u32,
+    tokens: *mut *mut libc::c_char,
+) -> bool {
+    let tokenizer: &Tokenizer = match convert_to_tokenizer_ref(tokenizer_ptr) {
+        Ok(t) => t,
+        Err(_) => return false,
+    };
+    let len = len as usize;
+    let ids_slice = unsafe { std::slice::from_raw_parts(ids, len) };
+    let tokens_slice = unsafe { std::slice::from_raw_parts_mut(tokens, len) };
+    for (ii, id) in ids_slice.iter().enumerate() {
+        tokens_slice[ii] = match tokenizer.id_to_token(*id) {
+            Some(token) => token_to_c_string(token),
+            None => null_mut(),
+        };
+    }
+    true
+}
+
+/// token_to_id converts a single token to its id, including added tokens. The id is stored in `id`.
+///
+/// It returns whether the token was found, or false if the tokenizer is invalid, in which case `id` is not changed.
+#[no_mangle]
+pub unsafe extern "C" fn token_to_id(
+    tokenizer_ptr: *mut libc::c_void,
+    token: *const libc::c_char,
+    id: *mut u32,
+) -> bool {
+    let tokenizer: &Tokenizer = match convert_to_tokenizer_ref(tokenizer_ptr) {
+        Ok(t) => t,
+        Err(_) => return false,
+    };
+    let token = unsafe { CStr::from_ptr(token) }.to_string_lossy();
+    match tokenizer.token_to_id(&token) {
+        Some(token_id) => {
+            unsafe { *id = token_id };
+            true
+        }
+        None => false,
+    }
+}
+
+/// id_to_token converts a single id to its token string, including added tokens.
+///
+/// The returned string is owned by the caller, and must be freed with `free_string`. It returns null if the id
+/// is not in the vocabulary, or if the tokenizer is invalid.
+#[no_mangle]
+pub unsafe extern "C" fn id_to_token(tokenizer_ptr: *mut libc::c_void, id: u32) -> *mut libc::c_char {
+    let tokenizer: &Tokenizer = match convert_to_tokenizer_ref(tokenizer_ptr) {
+        Ok(t) => t,
+        Err(_) => return null_mut(),
+    };
+    match tokenizer.id_to_token(id) {
+        Some(token) => token_to_c_string(token),
+        None => null_mut(),
+    }
+}
+
+/// model_id_to_token converts a single id to the token string stored in the vocabulary of the model, ignoring
+/// added tokens.
+///
+/// The returned string is owned by the caller, and must be freed with `free_string`. It returns null if the id
+/// is not in the vocabulary of the model, or if the tokenizer is invalid.
+#[no_mangle]
+pub unsafe extern "C" fn model_id_to_token(tokenizer_ptr: *mut libc::c_void, id: u32) -> *mut libc::c_char {
+    let tokenizer: &Tokenizer = match convert_to_tokenizer_ref(tokenizer_ptr) {
+        Ok(t) => t,
+        Err(_) => return null_mut(),
+    };
+    match tokenizer.get_model().id_to_token(id) {
+        Some(token) => token_to_c_string(token),
+        None => null_mut(),
+    }
+}
+
+/// AddedTokenSpec defines a token to be added to the vocabulary, with its options.
+/// It maps to tokenizers::AddedToken.
+#[repr(C)]
+pub struct AddedTokenSpec {
+    content: *const libc::c_char,
+    single_word: bool,  // Whether the token must match a whole word only, never inside another word.
+    normalized: bool,  // Whether the token is matched against the normalized input.
+}
+
+/// add_tokens adds the `len` tokens defined by `specs` to the vocabulary of the tokenizer, as special tokens
+/// if `special` is set. Tokens already in the vocabulary are not added again.
+///
+/// It returns the number of tokens actually added, or 0 if the tokenizer is invalid.
+#[no_mangle]
+pub unsafe extern "C" fn add_tokens(
+    tokenizer_ptr: *mut libc::c_void,
+    specs: *const AddedTokenSpec,
+    len: u32,
+    special: bool,
+) -> u32 {
+    let tokenizer: &mut Tokenizer = match unsafe { tokenizer_ptr.cast::<Tokenizer>().as_mut() } {
+        Some(t) => t,
+        None => return 0,
+    };
+    let specs_slice = unsafe { std::slice::from_raw_parts(specs, len as usize) };
+    let tokens: Vec<AddedToken> = specs_slice
+        .iter()
+        .map(|spec| {
+            let content = unsafe { CStr::from_ptr(spec.content) }.to_string_lossy().into_owned();
+            AddedToken::from(content, special)
+                .single_word(spec.single_word)
+                .normalized(spec.normalized)
+        })
+        .collect();
+    let added = if special {
+        tokenizer.add_special_tokens(&tokens)
+    } else {
+        tokenizer.add_tokens(&tokens)
+    };
+    added as u32
+}
@@ -0,0 +1,147 @@
+package tokenizers
+
+import "encoding/json"
+
+// This file implements the listing of the special tokens of a Tokenizer, with their roles.
+
+// SpecialTokenRole is the role of a special token, see SpecialTokenInfo.
+type SpecialTokenRole string
+
+const (
+	RolePad        SpecialTokenRole = "pad"
+	RoleUnk        SpecialTokenRole = "unk"
+	RoleCls        SpecialTokenRole = "cls"
+	RoleSep        SpecialTokenRole = "sep"
+	RoleMask       SpecialTokenRole = "mask"
+	RoleBos        SpecialTokenRole = "bos"
+	RoleEos        SpecialTokenRole = "eos"
+	RoleAdditional SpecialTokenRole = "additional"
+)
+
+// SpecialTokenInfo describes one special token of a Tokenizer, see Tokenizer.SpecialTokensDetailed.
+type SpecialTokenInfo struct {
+	Role    SpecialTokenRole
+	Content string
+
+	// Id of the token, only valid if InVocab is true: a special token named in the configuration may be missing
+	// from the vocabulary.
+	Id      uint32
+	InVocab bool
+
+	// Source is the file where the token was defined: "tokenizer_config.json", "special_tokens_map.json" or
+	// "tokenizer.json".
+	Source string
+}
+
+// SpecialTokensDetailed returns all the special tokens of the Tokenizer, with their roles resolved:
+//
+//   - The special tokens of the configuration (see Config), read from `tokenizer_config.json` or, for the ones
+//     not defined there, from `special_tokens_map.json`: "bos", "eos", "unk", "sep", "pad", "cls" and "mask", in
+//     this order, followed by the "additional_special_tokens" with role RoleAdditional.
+//   - The special tokens in the "added_tokens" of the tokenizer definition (`tokenizer.json`) not listed above.
+//     Their role is inferred from the definition: the "unk_token" of the model, the "pad_token" of the padding
+//     configuration, or the "cls" and "sep" tokens of the post-processor; otherwise it is RoleAdditional.
+//
+// Each token is listed only once, with the first role found for it.
+//
+// It serializes the Tokenizer (see ToBytes) to inspect it, so it's not a cheap call.
+func (t *Tokenizer) SpecialTokensDetailed() []SpecialTokenInfo {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	var infos []SpecialTokenInfo
+	listed := make(map[string]bool)
+	add := func(role SpecialTokenRole, content, source string) {
+		if content == "" || listed[content] {
+			return
+		}
+		listed[content] = true
+		infos = append(infos, SpecialTokenInfo{Role: role, Content: content, Source: source})
+	}
+
+	// From the configuration files.
+	if config := t.config; config != nil {
+		source := func(name string) string {
+			if config.fromSpecialTokensMap[name] {
+				return specialTokensMapFileName
+			}
+			return tokenizerConfigFileName
+		}
+		for _, token := range []struct {
+			role    SpecialTokenRole
+			name    string
+			content string
+		}{
+			{RoleBos, "bos_token", config.BosToken},
+			{RoleEos, "eos_token", config.EosToken},
+			{RoleUnk, "unk_token", config.UnkToken},
+			{RoleSep, "sep_token", config.SepToken},
+			{RolePad, "pad_token", config.PadToken},
+			{RoleCls, "cls_token", config.ClsToken},
+			{RoleMask, "mask_token", config.MaskToken},
+		} {
+			add(token.role, token.content, source(token.name))
+		}
+		for _, content := range config.AdditionalSpecialTokens {
+			add(RoleAdditional, content, source("additional_special_tokens"))
+		}
+	}
+
+	// From the tokenizer definition.
+	if data, err := t.toBytes(); err == nil {
+		var definition struct {
+			AddedTokens []struct {
+				Content string `json:"content"`
+				Special bool   `json:"special"`
+			} `json:"added_tokens"`
+			Model struct {
+				UnkToken string `json:"unk_token"`
+			} `json:"model"`
+			Padding *struct {
+				PadToken string `json:"pad_token"`
+			} `json:"padding"`
+			PostProcessor any `json:"post_processor"`
+		}
+		if json.Unmarshal(data, &definition) == nil {
+			roles := make(map[string]SpecialTokenRole)
+			roles[definition.Model.UnkToken] = RoleUnk
+			if definition.Padding != nil {
+				roles[definition.Padding.PadToken] = RolePad
+			}
+			if processor, ok := definition.PostProcessor.(map[string]any); ok {
+				for _, key := range []SpecialTokenRole{RoleCls, RoleSep} {
+					if pair, ok := processor[string(key)].([]any); ok && len(pair) == 2 {
+						if token, ok := pair[0].(string); ok {
+							roles[token] = key
+						}
+					}
+				}
+			}
+			for _, added := range definition.AddedTokens {
+				if !added.Special {
+					continue
+				}
+				role, found := roles[added.Content]
+				if !found {
+					role = RoleAdditional
+				}
+				add(role, added.Content, tokenizerFileName)
+			}
+		}
+	}
+
+	// Resolve ids.
+	contents := make([]string, len(infos))
+	for ii, info := range infos {
+		contents[ii] = info.Content
+	}
+	ids, found := t.tokenizer.TokensToIds(contents)
+	for ii := range infos {
+		if ii < len(ids) {
+			infos[ii].Id, infos[ii].InVocab = ids[ii], found[ii]
+		}
+	}
+	return infos
+}
@@ -0,0 +1,71 @@
+package tokenizers
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// This file implements the time budget of Encode, see WithEncodeTimeout.
+
+// EncodeTimeoutError is returned by Encode when WithEncodeTimeout is configured and encoding takes longer than
+// the configured timeout.
+type EncodeTimeoutError struct {
+	// Timeout is the configured time budget that was exceeded.
+	Timeout time.Duration
+}
+
+// Error implements the error interface.
+func (e *EncodeTimeoutError) Error() string {
+	return fmt.Sprintf("encoding took longer than the timeout of %s", e.Timeout)
+}
+
+// WithEncodeTimeout sets a time budget for each call to Encode: if encoding a sentence takes longer than timeout,
+// Encode returns an EncodeTimeoutError instead. A timeout of 0 (the default) disables it.
+//
+// This is a mitigation against adversarial inputs that make the regular expressions of some pre-tokenizers
+// extremely slow (denial of service).
+//
+// The encoding runs in a separate goroutine, which is abandoned on timeout: the work in the underlying (Rust)
+// tokenizer cannot be interrupted, and still runs to completion in the background, consuming CPU. In the
+// meantime it holds the Tokenizer read lock, so Finalize and Reload wait for it to finish.
+//
+// It returns itself (the Tokenizer), to allow cascaded configuration calls.
+func (t *Tokenizer) WithEncodeTimeout(timeout time.Duration) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	if timeout < 0 {
+		panicf("Tokenizer.WithEncodeTimeout(%s): timeout must be >= 0", timeout)
+	}
+	t.encodeTimeout = timeout
+	return t
+}
+
+// encodeResult is the result of an encode run in a separate goroutine.
+type encodeResult struct {
+	encoding *Encoding
+	err      error
+}
+
+// runWithTimeout runs encodeFn in a separate goroutine, and waits at most timeout for its result.
+// If it times out, it returns an EncodeTimeoutError and encodeFn is left running; done is called when
+// encodeFn finishes, in either case.
+func runWithTimeout(timeout time.Duration, encodeFn func() (*Encoding, error), done func()) (*Encoding, error) {
+	results := make(chan encodeResult, 1) // Buffered, so an abandoned goroutine doesn't block.
+	go func() {
+		defer done()
+		encoding, err := encodeFn()
+		results <- encodeResult{encoding: encoding, err: err}
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case result := <-results:
+		return result.encoding, result.err
+	case <-timer.C:
+		return nil, errors.WithStack(&EncodeTimeoutError{Timeout: timeout})
+	}
+}
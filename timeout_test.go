@@ -0,0 +1,42 @@
+package tokenizers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRunWithTimeout uses a stubbed slow encode, since it is not practical to make the actual tokenizer slow.
+func TestRunWithTimeout(t *testing.T) {
+	// Fast encode returns its results.
+	want := &Encoding{TokenIds: []uint32{1, 2, 3}}
+	doneCh := make(chan struct{}, 1)
+	done := func() { doneCh <- struct{}{} }
+	got, err := runWithTimeout(time.Second, func() (*Encoding, error) { return want, nil }, done)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+	<-doneCh
+
+	// Slow encode is abandoned, but still runs to completion (and calls done) in the background.
+	release := make(chan struct{})
+	slowEncode := func() (*Encoding, error) {
+		<-release
+		return want, nil
+	}
+	got, err = runWithTimeout(10*time.Millisecond, slowEncode, done)
+	require.Error(t, err)
+	assert.Nil(t, got)
+	var timeoutErr *EncodeTimeoutError
+	require.True(t, errors.As(err, &timeoutErr))
+	assert.Equal(t, 10*time.Millisecond, timeoutErr.Timeout)
+	select {
+	case <-doneCh:
+		t.Fatal("done called before the abandoned encode finished")
+	default:
+	}
+	close(release)
+	<-doneCh
+}
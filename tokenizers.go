@@ -10,11 +10,19 @@ package tokenizers
 
 import "C"
 import (
+	"context"
 	"fmt"
 	"github.com/gomlx/tokenizers/internal/rs"
 	"github.com/pkg/errors"
+	"golang.org/x/text/unicode/norm"
+	"log"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 // Tokenizer represents an initialized Tokenizer, including various configurations
@@ -25,7 +33,17 @@ import (
 //
 // To build a new Tokenizer from a JSon configuration, see `FromFile` or `FromBytes`.
 // To automatically load the JSon configuration from HuggingFace, use `FromPretrained`.
+//
+// A Tokenizer is safe for concurrent use: any number of goroutines can call Encode, EncodeBatch, Decode,
+// etc. at the same time. The configuration methods (`With*`, `Return*`, AddTokens, etc.) can also be called
+// concurrently, but they wait for the encodings/decodings in progress to finish, and the ones started
+// afterward use the new configuration. Notice that a configuration change between two calls affects
+// the results of the second: if different configurations are needed, use separate Tokenizers.
 type Tokenizer struct {
+	// mu protects tokenizer from being replaced (Reload) or freed (Finalize) while in use, and the configuration
+	// from being changed while encoding: encoding and decoding take the read lock, and the configuration methods
+	// take the write lock.
+	mu        sync.RWMutex
 	tokenizer *rs.Tokenizer
 
 	encodeParams                  rs.EncodeParams
@@ -41,9 +59,62 @@ type Tokenizer struct {
 	paddingStrategy                                  PaddingStrategy
 	paddingLength, padToMultipleOf, padId, padTypeId uint32
 	padToken                                         string
+
+	// Preprocessing of the sentences, done in Go before encoding.
+	stripBOM, stripZeroWidth bool
+	maxInputBytes            int
+	normalizeUnicode         bool
+	unicodeForm              norm.Form
+	normalizeQuotes          bool
+
+	// Rejection of unknown tokens, see WithRejectUnknown.
+	rejectUnknown  bool
+	unknownTokenId uint32
+
+	// Derivation of the Encoding.ContinuationMask, see ReturnContinuationMask.
+	returnContinuationMask                    bool
+	continuationPrefix, continuationWordStart string
+
+	// Derivation of the Encoding.FirstSubwordMask, see ReturnFirstSubwordMask.
+	returnFirstSubwordMask bool
+
+	// Derivation of the Encoding.TokenHashes, see ReturnTokenHashes.
+	returnTokenHashes bool
+
+	// Derivation of the Encoding.CharOffsets, see ReturnBothOffsets.
+	returnBothOffsets bool
+
+	// Derivation of the Encoding.TokenKinds, see ReturnTokenKinds.
+	returnTokenKinds bool
+	addedTokenKinds  map[uint32]TokenKind
+
+	// Reporting of the tokens dropped by truncation, see ReturnDroppedTokens.
+	returnDroppedTokens bool
+
+	// Seeding of the BPE dropout, see WithSeed. dropoutCalls counts the encoding calls since the seed was set,
+	// each one uses a different seed derived from it.
+	isDropoutSeedSet bool
+	dropoutSeed      uint64
+	dropoutCalls     atomic.Uint64
+
+	// encodeTimeout is the time budget of Encode, see WithEncodeTimeout.
+	encodeTimeout time.Duration
+
+	// dedupCopies configures EncodeBatchDedup to return independent copies, see WithDedupCopies.
+	dedupCopies bool
+
+	// Sizes of the chunks used by the variations of EncodeBatch, see batch.go.
+	encodeBatchChunkSize, sortedBatchBucketSize, tokenCountsChunkSize int
+	encodeStreamChunkSize, encodeStreamMaxLineSize                    int
+
+	// config read from `tokenizer_config.json`, only set when loaded with FromPretrainedWith.
+	config *TokenizerConfig
 }
 
 // Direction is used in truncation and padding configuration.
+//
+// For truncation it is the side from which tokens are removed: Right (the default, as in HuggingFace) keeps
+// the start of the text, Left keeps its end. For padding it is the side where the padding tokens are added.
 type Direction uint8
 
 const (
@@ -84,7 +155,7 @@ const (
 	OffsetsCharModeUnicode OffsetsCharMode = 1
 )
 
-//go:generate stringer -type=Direction,TruncationStrategy,PaddingStrategy,OffsetsCharMode -output=types_string.go .
+//go:generate stringer -type=Direction,TruncationStrategy,PaddingStrategy,OffsetsCharMode,ModelFamily,VocabFormat,Task,LinkType -output=types_string.go .
 
 // panicf generates an error message and panics with it, in one function.
 func panicf(format string, args ...any) {
@@ -105,45 +176,132 @@ func FromFile(filePath string) (*Tokenizer, error) {
 // FromBytes is the same as FromFile, but instead takes the JSon `data` and returns a Tokenizer,
 // or an error.
 // It is the same format as [HuggingFace Tokenizers](https://github.com/huggingface/tokenizers).
+//
+// A leading UTF-8 byte-order mark (BOM) and trailing whitespace, sometimes added by proxies, are ignored.
+// If parsing fails, the error includes a snippet of the offending content.
 func FromBytes(data []byte) (*Tokenizer, error) {
-	t := &Tokenizer{}
+	t := &Tokenizer{
+		encodeBatchChunkSize:    DefaultEncodeBatchChunkSize,
+		sortedBatchBucketSize:   DefaultSortedBatchBucketSize,
+		tokenCountsChunkSize:    DefaultTokenCountsChunkSize,
+		encodeStreamChunkSize:   DefaultEncodeStreamChunkSize,
+		encodeStreamMaxLineSize: DefaultEncodeStreamMaxLineSize,
+	}
 	var err error
 	t.setDefaultEncodeParams()
 
+	data = trimTokenizerJSON(data)
 	t.tokenizer, err = rs.FromBytes(data)
 	if err != nil {
-		return nil, errors.WithMessage(err, "Tokenizer.FromBytes(<json-data>):")
+		return nil, errors.WithMessage(tokenizerJSONError(err, data), "Tokenizer.FromBytes(<json-data>):")
 	}
 
 	// Parse truncation and padding:
-	var direction, truncStrategy uint8
-	t.isTruncationSet, direction, t.truncationMaxLength, truncStrategy, t.truncationStride = t.tokenizer.GetTruncation()
-	t.truncationDirection = Direction(direction)
-	t.truncationStrategy = TruncationStrategy(truncStrategy)
-	if !t.isTruncationSet {
-		t.setDefaultTruncation() // Not used, but it's safe to reset to the default.
+	t.readTruncation()
+	t.readPadding()
+	return t, nil
+}
+
+// FromBytesCtx is like FromBytes, but the Tokenizer is finalized (see Finalize) as soon as ctx is done, instead of
+// relying on the garbage collector to release its memory. It's meant for short-lived tokenizers, e.g. created on
+// the fly by a request handler, with the context of the request.
+//
+// Once ctx is done the Tokenizer can't be used any longer: as after Finalize, any call to it panics. Uses in
+// progress when ctx is done are completed first. Calling Finalize before ctx is done is also fine.
+// If ctx is already done, it returns an error.
+func FromBytesCtx(ctx context.Context, data []byte) (*Tokenizer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(err, "Tokenizer.FromBytesCtx(<json-data>):")
+	}
+	t, err := FromBytes(data)
+	if err != nil {
+		return nil, err
 	}
+	context.AfterFunc(ctx, t.finalizeIfValid)
+	return t, nil
+}
 
-	var padStrategy uint32
-	t.isPaddingSet, padStrategy, direction, t.padToMultipleOf, t.padId, t.padTypeId, t.padToken = t.tokenizer.GetPadding()
-	t.paddingDirection = Direction(direction)
-	if padStrategy == 0 {
-		t.paddingStrategy = PadLongest
-	} else {
-		t.paddingStrategy = PadFixed
-		t.paddingLength = padStrategy
+// Reload replaces in place the underlying tokenizer with the one defined by the JSon `data`, in the same format
+// as FromBytes. The old one is freed.
+//
+// The truncation and padding configuration are read from the new `data`, as in FromBytes, while the encoding
+// parameters (see the `Return*` methods) and preprocessing are preserved.
+//
+// It is safe to call while other goroutines are encoding or decoding with the Tokenizer: they will either use
+// the old or the new tokenizer. If `data` is invalid, an error is returned and the Tokenizer is left unchanged.
+func (t *Tokenizer) Reload(data []byte) error {
+	data = trimTokenizerJSON(data)
+	newTokenizer, err := rs.FromBytes(data)
+	if err != nil {
+		return errors.WithMessage(tokenizerJSONError(err, data), "Tokenizer.Reload(<json-data>):")
 	}
-	if !t.isPaddingSet {
-		t.setDefaultPadding() // Not used, but it's safe to reset to the default.
+
+	t.mu.Lock()
+	if t.tokenizer == nil {
+		t.mu.Unlock()
+		newTokenizer.Finalize()
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
 	}
+	oldTokenizer := t.tokenizer
+	t.tokenizer = newTokenizer
+	t.readTruncation()
+	t.readPadding()
+	if t.rejectUnknown {
+		t.unknownTokenId, t.rejectUnknown = t.modelUnknownTokenId()
+	}
+	if t.returnContinuationMask {
+		t.continuationPrefix, t.continuationWordStart = t.continuationMarkers()
+	}
+	if t.returnTokenKinds {
+		t.addedTokenKinds = t.readAddedTokenKinds()
+	}
+	t.mu.Unlock()
 
-	return t, nil
+	// No one can be using the old tokenizer any longer.
+	oldTokenizer.Finalize()
+	return nil
+}
+
+// ToBytes serializes the Tokenizer to JSon, in the same format as HuggingFace's `tokenizer.json` files.
+// It includes the current truncation and padding configuration, so it can be loaded back with FromBytes.
+func (t *Tokenizer) ToBytes() ([]byte, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.toBytes()
+}
+
+// SaveToFile serializes the Tokenizer (see ToBytes) and writes it to filePath, typically a `tokenizer.json` file.
+// It includes tokens added at runtime (e.g. AddTokens) and the current truncation and padding configuration,
+// so it can be loaded back with FromFile.
+func (t *Tokenizer) SaveToFile(filePath string) error {
+	data, err := t.ToBytes()
+	if err != nil {
+		return errors.WithMessagef(err, "Tokenizer.SaveToFile(%q):", filePath)
+	}
+	if err = os.WriteFile(filePath, data, DefaultFileCreationPerm); err != nil {
+		return errors.Wrapf(err, "Tokenizer.SaveToFile(%q) failed to write file", filePath)
+	}
+	return nil
+}
+
+// toBytes implements ToBytes, without locking.
+func (t *Tokenizer) toBytes() ([]byte, error) {
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	data, err := t.tokenizer.ToBytes()
+	if err != nil {
+		return nil, errors.WithMessage(err, "Tokenizer.ToBytes():")
+	}
+	return data, nil
 }
 
 // Finalize is optional, and will release immediately the memory associated with the Tokenizer, not waiting for the
 // garbage collection.
 // After calling this function, the Tokenizer is no longer valid, and any calls to it will panic.
 func (t *Tokenizer) Finalize() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	if t.tokenizer == nil {
 		panicf("Tokenizer already finalized, one cannot change or use it any longer")
 	}
@@ -151,8 +309,21 @@ func (t *Tokenizer) Finalize() {
 	t.tokenizer = nil
 }
 
+// finalizeIfValid is like Finalize, but it does nothing if the Tokenizer is already finalized.
+func (t *Tokenizer) finalizeIfValid() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokenizer == nil {
+		return
+	}
+	t.tokenizer.Finalize()
+	t.tokenizer = nil
+}
+
 // String implements fmt.Stringer.
 func (t *Tokenizer) String() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
 	if t.tokenizer == nil {
 		return "nil"
 	}
@@ -177,41 +348,121 @@ func (t *Tokenizer) String() string {
 	parts = append(parts, fmt.Sprintf("    ReturnSpecialTokensMask=%v", t.encodeParams.ReturnSpecialTokensMask))
 	parts = append(parts, fmt.Sprintf("    ReturnAttentionMask=%v", t.encodeParams.ReturnAttentionMask))
 	parts = append(parts, fmt.Sprintf("    ReturnOffsets=%v", t.encodeParams.ReturnOffsets))
+	parts = append(parts, fmt.Sprintf("    ReturnWordIds=%v", t.encodeParams.ReturnWordIds))
+	parts = append(parts, fmt.Sprintf("    ReturnSequenceIds=%v", t.encodeParams.ReturnSequenceIds))
+	parts = append(parts, fmt.Sprintf("    ReturnLengths=%v", t.encodeParams.ReturnLengths))
+	parts = append(parts, fmt.Sprintf("    ReturnContinuationMask=%v", t.returnContinuationMask))
+	parts = append(parts, fmt.Sprintf("    ReturnFirstSubwordMask=%v", t.returnFirstSubwordMask))
+	parts = append(parts, fmt.Sprintf("    ReturnTokenHashes=%v", t.returnTokenHashes))
+	parts = append(parts, fmt.Sprintf("    ReturnBothOffsets=%v", t.returnBothOffsets))
+	parts = append(parts, fmt.Sprintf("    ReturnTokenKinds=%v", t.returnTokenKinds))
+	parts = append(parts, fmt.Sprintf("    ReturnDroppedTokens=%v", t.returnDroppedTokens))
 	var offsetCharMode OffsetsCharMode
 	if t.encodeParams.WithOffsetsCharMode {
 		offsetCharMode = OffsetsCharModeUnicode
 	}
 	parts = append(parts, fmt.Sprintf("    WithOffsetsCharMode=%s", offsetCharMode))
+	parts = append(parts, fmt.Sprintf("    RejectUnknown=%v", t.rejectUnknown))
+	parts = append(parts, fmt.Sprintf("    EncodeTimeout=%s", t.encodeTimeout))
+	parts = append(parts, "  Preprocessing:")
+	parts = append(parts, fmt.Sprintf("    StripBOM=%v", t.stripBOM))
+	parts = append(parts, fmt.Sprintf("    StripZeroWidth=%v", t.stripZeroWidth))
+	parts = append(parts, fmt.Sprintf("    MaxInputBytes=%d", t.maxInputBytes))
+	parts = append(parts, fmt.Sprintf("    UnicodeForm=%s", t.unicodeFormName()))
+	parts = append(parts, fmt.Sprintf("    QuoteNormalization=%v", t.normalizeQuotes))
 	return fmt.Sprintf("Tokenizer(\n%s\n)\n", strings.Join(parts, "\n"))
 }
 
 // setTruncation updates the underlying (Rust) truncation parameters according to parameters set.
 // This is needed because they are configured as a block, while the Go API uses a fine-grained approach.
-// It panics on error -- only happens with invalid parameters.
+// It panics on error -- only happens with invalid parameters. See trySetTruncation for a version that returns
+// the error.
 func (t *Tokenizer) setTruncation() {
+	if err := t.trySetTruncation(); err != nil {
+		panic(err)
+	}
+}
+
+// trySetTruncation is like setTruncation, but it returns the error. If the truncation parameters are rejected,
+// the previous ones are kept, and a *TruncationError is returned (wrapped with a message).
+func (t *Tokenizer) trySetTruncation() error {
 	if t.tokenizer == nil {
 		panicf("Tokenizer already finalized, one cannot change or use it any longer")
 	}
-	var err error
 	if !t.isTruncationSet {
-		err = t.tokenizer.SetNoTruncation()
-		if err != nil {
-			err = errors.WithMessage(err, "while disabling truncation:")
-			panic(err)
+		if err := t.tokenizer.SetNoTruncation(); err != nil {
+			return errors.WithMessage(err, "while disabling truncation:")
 		}
-		return
+		return nil
 	}
 
-	err = t.tokenizer.SetTruncation(uint8(t.truncationDirection), t.truncationMaxLength, uint8(t.truncationStrategy), t.truncationStride)
+	err := t.tokenizer.SetTruncation(uint8(t.truncationDirection), t.truncationMaxLength, uint8(t.truncationStrategy), t.truncationStride)
 	if err != nil {
-		err = errors.WithMessage(err, "while disabling truncation:")
-		panic(err)
+		err = &TruncationError{Stride: t.truncationStride, MaxLength: t.truncationMaxLength, Err: err}
+		t.readTruncation() // Revert to the truncation parameters still in use.
+		return errors.WithMessage(err, "while setting truncation:")
+	}
+	return nil
+}
+
+// readTruncation reads the truncation parameters from the underlying (Rust) tokenizer.
+func (t *Tokenizer) readTruncation() {
+	var direction, strategy uint8
+	t.isTruncationSet, direction, t.truncationMaxLength, strategy, t.truncationStride = t.tokenizer.GetTruncation()
+	t.truncationDirection = Direction(direction)
+	t.truncationStrategy = TruncationStrategy(strategy)
+	if !t.isTruncationSet {
+		t.setDefaultTruncation() // Not used, but it's safe to reset to the default.
+	}
+}
+
+// readPadding reads the padding parameters from the underlying (Rust) tokenizer.
+func (t *Tokenizer) readPadding() {
+	var direction uint8
+	var padStrategy uint32
+	t.isPaddingSet, padStrategy, direction, t.padToMultipleOf, t.padId, t.padTypeId, t.padToken = t.tokenizer.GetPadding()
+	t.paddingDirection = Direction(direction)
+	if padStrategy == 0 {
+		t.paddingStrategy = PadLongest
+	} else {
+		t.paddingStrategy = PadFixed
+		t.paddingLength = padStrategy
+	}
+	if !t.isPaddingSet {
+		t.setDefaultPadding() // Not used, but it's safe to reset to the default.
 	}
 }
 
+// TruncationError is the error used when the truncation parameters are rejected by the tokenizer: this happens
+// when the stride is too large relative to the max length (minus the special tokens added).
+//
+// SetTruncationStride returns it (wrapped with a message), and the `WithTruncation*` methods panic with it. Use
+// `errors.As` to inspect it, and adjust the parameters programmatically.
+type TruncationError struct {
+	// Stride and MaxLength are the offending truncation parameters.
+	Stride, MaxLength uint32
+
+	// Err is the underlying error reported by the tokenizer.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *TruncationError) Error() string {
+	return fmt.Sprintf("invalid truncation parameters (stride=%d, max length=%d): %v", e.Stride, e.MaxLength, e.Err)
+}
+
+// Unwrap returns the underlying error reported by the tokenizer.
+func (e *TruncationError) Unwrap() error {
+	return e.Err
+}
+
 // setDefaultTruncation sets the default values of truncation.
+//
+// The default direction is Right (truncate the end of the text), the same default used by HuggingFace.
+// If the Tokenizer was loaded with a `tokenizer_config.json`, its "truncation_side" takes precedence
+// (see applyConfig).
 func (t *Tokenizer) setDefaultTruncation() {
-	t.truncationDirection = Left
+	t.truncationDirection = Right
 	t.truncationStrategy = TruncateLongestFirst
 	t.truncationMaxLength = 512
 	t.truncationStride = 0
@@ -219,10 +470,15 @@ func (t *Tokenizer) setDefaultTruncation() {
 
 // WithTruncation enables truncation and changes the truncation to the given length.
 //
+// If not configured otherwise (by the loaded `tokenizer.json` or `tokenizer_config.json`, or with
+// WithTruncationDirection), tokens are removed from the end (Right) of the text.
+//
 // It returns itself (the Tokenizer), to allow cascaded configuration calls.
 //
 // It may panic is an invalid value is used (negative length, etc.).
 func (t *Tokenizer) WithTruncation(length int) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	if length <= 0 {
 		panicf("Tokenizer.WithTruncation(length=%d): length must be > 0", length)
 	}
@@ -240,6 +496,8 @@ func (t *Tokenizer) WithTruncation(length int) *Tokenizer {
 //
 // It may panic is an invalid value is used (negative length, etc.).
 func (t *Tokenizer) WithTruncationStrategy(strategy TruncationStrategy) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	t.isTruncationSet = true
 	t.truncationStrategy = strategy
 	t.setTruncation()
@@ -254,15 +512,31 @@ func (t *Tokenizer) WithTruncationStrategy(strategy TruncationStrategy) *Tokeniz
 //
 // It returns itself (the Tokenizer), to allow cascaded configuration calls.
 //
-// It may panic is an invalid value is used (negative length, etc.).
+// It may panic is an invalid value is used (negative length, etc.). If the stride is too large for the truncation
+// length, it panics with a *TruncationError, and the previous truncation parameters are kept. Use
+// SetTruncationStride to get the error returned instead.
 func (t *Tokenizer) WithTruncationStride(stride int) *Tokenizer {
+	if err := t.SetTruncationStride(stride); err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// SetTruncationStride is like WithTruncationStride, but it returns an error instead of panicking if the stride is
+// invalid: negative, or too large for the truncation length, in which case the error wraps a *TruncationError
+// (see errors.As). On error the previous truncation parameters are kept.
+func (t *Tokenizer) SetTruncationStride(stride int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	if stride < 0 {
-		panicf("Tokenizer.WithTruncationStride(stride=%d): stride must be >= 0", stride)
+		return errors.Errorf("Tokenizer.SetTruncationStride(stride=%d): stride must be >= 0", stride)
 	}
 	t.isTruncationSet = true
 	t.truncationStride = uint32(stride)
-	t.setTruncation()
-	return t
+	if err := t.trySetTruncation(); err != nil {
+		return errors.WithMessagef(err, "Tokenizer.SetTruncationStride(stride=%d):", stride)
+	}
+	return nil
 }
 
 // WithTruncationDirection enables truncation (if not already) and sets the truncation to happen in the given direction.
@@ -271,6 +545,8 @@ func (t *Tokenizer) WithTruncationStride(stride int) *Tokenizer {
 //
 // It may panic is an invalid value is used (negative length, etc.).
 func (t *Tokenizer) WithTruncationDirection(direction Direction) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	t.isTruncationSet = true
 	t.truncationDirection = direction
 	t.setTruncation()
@@ -281,6 +557,8 @@ func (t *Tokenizer) WithTruncationDirection(direction Direction) *Tokenizer {
 //
 // It returns itself (the Tokenizer), to allow cascaded configuration calls.
 func (t *Tokenizer) WithNoTruncation() *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	t.isTruncationSet = false
 	t.setDefaultTruncation()
 	t.setTruncation()
@@ -325,6 +603,8 @@ func (t *Tokenizer) setDefaultPadding() {
 //
 // It may panic is an invalid value is used (e.g.: if padding length <= 0).
 func (t *Tokenizer) WithPadToLongest() *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	t.isPaddingSet = true
 	t.paddingStrategy = PadLongest
 	t.paddingLength = 0
@@ -338,6 +618,8 @@ func (t *Tokenizer) WithPadToLongest() *Tokenizer {
 //
 // It may panic is an invalid value is used (e.g.: if padding length == 0).
 func (t *Tokenizer) WithPadToLength(length uint32) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	t.isPaddingSet = true
 	t.paddingStrategy = PadFixed
 	t.paddingLength = length
@@ -351,6 +633,8 @@ func (t *Tokenizer) WithPadToLength(length uint32) *Tokenizer {
 //
 // It may panic is an invalid value is used (e.g.: if padding length == 0).
 func (t *Tokenizer) WithPadId(id uint32) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	t.isPaddingSet = true
 	t.padId = id
 	t.setPadding()
@@ -363,6 +647,8 @@ func (t *Tokenizer) WithPadId(id uint32) *Tokenizer {
 //
 // It may panic is an invalid value is used (e.g.: if padding length == 0).
 func (t *Tokenizer) WithPadTypeId(typeId uint32) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	t.isPaddingSet = true
 	t.padTypeId = typeId
 	t.setPadding()
@@ -375,6 +661,8 @@ func (t *Tokenizer) WithPadTypeId(typeId uint32) *Tokenizer {
 //
 // It may panic is an invalid value is used (e.g.: if padding length == 0).
 func (t *Tokenizer) WithPadToken(token string) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	t.isPaddingSet = true
 	t.padToken = token
 	t.setPadding()
@@ -389,6 +677,8 @@ func (t *Tokenizer) WithPadToken(token string) *Tokenizer {
 //
 // It may panic is an invalid value is used (e.g.: if padding length == 0).
 func (t *Tokenizer) WithPaddingToMultipleOf(multiple uint32) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	t.isPaddingSet = true
 	t.padToMultipleOf = multiple
 	t.setPadding()
@@ -401,6 +691,8 @@ func (t *Tokenizer) WithPaddingToMultipleOf(multiple uint32) *Tokenizer {
 //
 // It may panic is an invalid value is used (negative length, etc.).
 func (t *Tokenizer) WithPaddingDirection(direction Direction) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	t.isPaddingSet = true
 	t.paddingDirection = direction
 	t.setPadding()
@@ -413,12 +705,62 @@ func (t *Tokenizer) WithPaddingDirection(direction Direction) *Tokenizer {
 //
 // It may panic is an invalid value is used (negative length, etc.).
 func (t *Tokenizer) WithNoPadding() *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	t.isPaddingSet = false
 	t.setDefaultPadding()
 	t.setPadding()
 	return t
 }
 
+// WithBPEDropout enables BPE dropout with probability p, in [0, 1]: during encoding, each merge of the BPE model
+// is skipped with probability p, producing alternative (finer-grained) tokenizations of the same input. This is
+// used for data augmentation when training (see "BPE-Dropout", Provilkov et al., 2019). A value of 0 (the
+// default) disables it.
+//
+// Notice that with p > 0 encoding becomes non-deterministic: repeated encodes of the same input may differ.
+// Use WithSeed to make the results reproducible.
+//
+// It returns itself (the Tokenizer), to allow cascaded configuration calls.
+//
+// It panics if the model is not BPE, or if p is not in [0, 1].
+func (t *Tokenizer) WithBPEDropout(p float32) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	if p < 0 || p > 1 {
+		panicf("Tokenizer.WithBPEDropout(%g): dropout must be in the range [0, 1]", p)
+	}
+	if err := t.tokenizer.SetBPEDropout(p); err != nil {
+		panic(errors.WithMessagef(err, "Tokenizer.WithBPEDropout(%g)", p))
+	}
+	return t
+}
+
+// WithSeed seeds the random number generator used by the BPE dropout (see WithBPEDropout), making the encodings
+// reproducible: after WithSeed, the same sequence of encoding calls with the same inputs returns the same
+// results, also across Tokenizers configured the same way. Each call (Encode, EncodeBatch, etc.) draws from a
+// seed derived from the given one and the number of calls since, so repeated encodes of the same input still
+// differ, as expected for data augmentation. Calling WithSeed again restarts the sequence.
+//
+// Notice that concurrent encoding calls take their seeds in the order they start, which is not deterministic.
+// It has no effect if BPE dropout is not enabled.
+//
+// It returns itself (the Tokenizer), to allow cascaded configuration calls.
+func (t *Tokenizer) WithSeed(seed uint64) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	t.isDropoutSeedSet = true
+	t.dropoutSeed = seed
+	t.dropoutCalls.Store(0)
+	return t
+}
+
 func (t *Tokenizer) setDefaultEncodeParams() {
 	t.encodeParams = rs.EncodeParams{
 		AddSpecialTokens:        false,
@@ -437,6 +779,8 @@ func (t *Tokenizer) setDefaultEncodeParams() {
 //
 // It returns itself (the Tokenizer), to allow cascaded configuration calls.
 func (t *Tokenizer) AddSpecialTokens(value bool) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	if t.tokenizer == nil {
 		panicf("Tokenizer already finalized, one cannot change or use it any longer")
 	}
@@ -449,6 +793,8 @@ func (t *Tokenizer) AddSpecialTokens(value bool) *Tokenizer {
 //
 // It returns itself (the Tokenizer), to allow cascaded configuration calls.
 func (t *Tokenizer) ReturnTokens(value bool) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	if t.tokenizer == nil {
 		panicf("Tokenizer already finalized, one cannot change or use it any longer")
 	}
@@ -461,6 +807,8 @@ func (t *Tokenizer) ReturnTokens(value bool) *Tokenizer {
 //
 // It returns itself (the Tokenizer), to allow cascaded configuration calls.
 func (t *Tokenizer) ReturnTypeIds(value bool) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	if t.tokenizer == nil {
 		panicf("Tokenizer already finalized, one cannot change or use it any longer")
 	}
@@ -474,6 +822,8 @@ func (t *Tokenizer) ReturnTypeIds(value bool) *Tokenizer {
 //
 // It returns itself (the Tokenizer), to allow cascaded configuration calls.
 func (t *Tokenizer) ReturnSpecialTokensMask(value bool) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	if t.tokenizer == nil {
 		panicf("Tokenizer already finalized, one cannot change or use it any longer")
 	}
@@ -488,6 +838,8 @@ func (t *Tokenizer) ReturnSpecialTokensMask(value bool) *Tokenizer {
 //
 // It returns itself (the Tokenizer), to allow cascaded configuration calls.
 func (t *Tokenizer) ReturnAttentionMask(value bool) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	if t.tokenizer == nil {
 		panicf("Tokenizer already finalized, one cannot change or use it any longer")
 	}
@@ -500,6 +852,8 @@ func (t *Tokenizer) ReturnAttentionMask(value bool) *Tokenizer {
 //
 // It returns itself (the Tokenizer), to allow cascaded configuration calls.
 func (t *Tokenizer) ReturnOffsets(value bool) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	if t.tokenizer == nil {
 		panicf("Tokenizer already finalized, one cannot change or use it any longer")
 	}
@@ -507,6 +861,120 @@ func (t *Tokenizer) ReturnOffsets(value bool) *Tokenizer {
 	return t
 }
 
+// ReturnWordIds sets whether Encode (and EncodeBatch) should also return the word ids of the tokens: the index
+// of the word (as split by the pre-tokenizer) each token belongs to, or -1 for special and padding tokens.
+// Default is false.
+//
+// The subword tokens of the same word share its id, which is used, for instance, to align the labels of
+// token classification (NER) with the words. See also Encoding.WordToTokens and Encoding.TokenWords.
+//
+// It returns itself (the Tokenizer), to allow cascaded configuration calls.
+func (t *Tokenizer) ReturnWordIds(value bool) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	t.encodeParams.ReturnWordIds = value
+	return t
+}
+
+// ReturnSequenceIds sets whether Encode (and EncodeBatch, EncodePair) should also return the sequence ids of the
+// tokens: 0 for the tokens of the first sentence, 1 for the ones of the second sentence of a pair (see
+// EncodePair), or -1 for special tokens. Used, for instance, to extract answer spans in extractive QA.
+// Default is false.
+//
+// It returns itself (the Tokenizer), to allow cascaded configuration calls.
+func (t *Tokenizer) ReturnSequenceIds(value bool) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	t.encodeParams.ReturnSequenceIds = value
+	return t
+}
+
+// ReturnLengths sets whether Encode (and EncodeBatch, EncodePair) should also return the Encoding.Length: the
+// number of tokens before padding was applied. It saves scanning the attention mask to count them.
+// Default is false.
+//
+// It returns itself (the Tokenizer), to allow cascaded configuration calls.
+func (t *Tokenizer) ReturnLengths(value bool) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	t.encodeParams.ReturnLengths = value
+	return t
+}
+
+// ReturnFields is a bitmask of the optional fields of Encoding to return, see Tokenizer.WithReturnFields.
+type ReturnFields uint32
+
+const (
+	ReturnFieldTokens ReturnFields = 1 << iota
+	ReturnFieldTypeIds
+	ReturnFieldAttentionMask
+	ReturnFieldSpecialTokensMask
+	ReturnFieldOffsets
+	ReturnFieldWordIds
+	ReturnFieldSequenceIds
+	ReturnFieldLengths
+	ReturnFieldContinuationMask
+	ReturnFieldFirstSubwordMask
+	ReturnFieldTokenHashes
+	ReturnFieldBothOffsets
+	ReturnFieldTokenKinds
+	ReturnFieldDroppedTokens
+
+	// ReturnAllFields is the bitmask with all the fields.
+	ReturnAllFields = ReturnFieldTokens | ReturnFieldTypeIds | ReturnFieldAttentionMask |
+		ReturnFieldSpecialTokensMask | ReturnFieldOffsets | ReturnFieldWordIds | ReturnFieldSequenceIds |
+		ReturnFieldLengths | ReturnFieldContinuationMask | ReturnFieldFirstSubwordMask | ReturnFieldTokenHashes |
+		ReturnFieldBothOffsets | ReturnFieldTokenKinds | ReturnFieldDroppedTokens
+)
+
+// WithReturnFields sets which optional fields Encode (and EncodeBatch) should return, all at once: fields
+// in the bitmask f are returned, and the others are not.
+// It's equivalent to calling each of ReturnTokens, ReturnTypeIds, ReturnAttentionMask, ReturnSpecialTokensMask,
+// ReturnOffsets, ReturnWordIds, ReturnSequenceIds, ReturnLengths, and of the derived fields
+// ReturnContinuationMask, ReturnFirstSubwordMask, ReturnTokenHashes, ReturnBothOffsets (CharOffsets),
+// ReturnTokenKinds and ReturnDroppedTokens.
+//
+// Example: `tk.WithReturnFields(ReturnFieldTokens | ReturnFieldOffsets)`, or `tk.WithReturnFields(ReturnAllFields)`.
+//
+// It returns itself (the Tokenizer), to allow cascaded configuration calls.
+func (t *Tokenizer) WithReturnFields(f ReturnFields) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	t.encodeParams.ReturnTokens = f&ReturnFieldTokens != 0
+	t.encodeParams.ReturnTypeIds = f&ReturnFieldTypeIds != 0
+	t.encodeParams.ReturnAttentionMask = f&ReturnFieldAttentionMask != 0
+	t.encodeParams.ReturnSpecialTokensMask = f&ReturnFieldSpecialTokensMask != 0
+	t.encodeParams.ReturnOffsets = f&ReturnFieldOffsets != 0
+	t.encodeParams.ReturnWordIds = f&ReturnFieldWordIds != 0
+	t.encodeParams.ReturnSequenceIds = f&ReturnFieldSequenceIds != 0
+	t.encodeParams.ReturnLengths = f&ReturnFieldLengths != 0
+	t.returnContinuationMask = f&ReturnFieldContinuationMask != 0
+	if t.returnContinuationMask {
+		t.continuationPrefix, t.continuationWordStart = t.continuationMarkers()
+	}
+	t.returnFirstSubwordMask = f&ReturnFieldFirstSubwordMask != 0
+	t.returnTokenHashes = f&ReturnFieldTokenHashes != 0
+	t.returnBothOffsets = f&ReturnFieldBothOffsets != 0
+	t.returnTokenKinds = f&ReturnFieldTokenKinds != 0
+	if t.returnTokenKinds {
+		t.addedTokenKinds = t.readAddedTokenKinds()
+	}
+	t.returnDroppedTokens = f&ReturnFieldDroppedTokens != 0
+	return t
+}
+
 // WithOffsetsCharMode sets the character-level offset mode for the token offsets.
 // The possible values are:
 //
@@ -517,6 +985,8 @@ func (t *Tokenizer) ReturnOffsets(value bool) *Tokenizer {
 //
 // It returns itself (the Tokenizer), to allow cascaded configuration calls.
 func (t *Tokenizer) WithOffsetsCharMode(value OffsetsCharMode) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	if t.tokenizer == nil {
 		panicf("Tokenizer already finalized, one cannot change or use it any longer")
 	}
@@ -534,28 +1004,378 @@ func (t *Tokenizer) WithOffsetsCharMode(value OffsetsCharMode) *Tokenizer {
 // The AttentionMask indicates which tokens are padding and should be ignored.
 type Encoding = rs.Encoding
 
+// Offset with the range (Start and End) of a token in the original sentence.
+// Values depend on OffsetsCharMode configuration (bytes or Unicode code points).
+type Offset = rs.Offset
+
 // Encode given sentence.
 //
 // The returned Encoding object will have fields filled according to Tokenizer fields configured to be returned.
+// If WithEncodeTimeout is configured, it returns an EncodeTimeoutError if encoding takes too long.
 func (t *Tokenizer) Encode(sentence string) (*Encoding, error) {
+	t.mu.RLock()
+	if t.tokenizer == nil {
+		t.mu.RUnlock()
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	if t.encodeTimeout > 0 {
+		// The read lock is released only when encoding finishes, even if it times out.
+		return runWithTimeout(t.encodeTimeout, func() (*Encoding, error) { return t.encode(sentence) }, t.mu.RUnlock)
+	}
+	defer t.mu.RUnlock()
+	return t.encode(sentence)
+}
+
+// encode implements Encode, without locking.
+func (t *Tokenizer) encode(sentence string) (*Encoding, error) {
+	encoding := &Encoding{}
+	if err := t.encodeInto(sentence, encoding); err != nil {
+		return nil, err
+	}
+	return encoding, nil
+}
+
+// EncodeInto is like Encode, but it stores the result in dst, reusing the storage of its slices.
+//
+// The slices of dst are reused if they have enough capacity, and only grown when needed. So when repeatedly
+// encoding sentences of similar size, reusing the same dst saves most of the allocations. Fields not configured
+// to be returned are set to zero length (their storage is preserved). The derived fields (e.g.
+// ReturnContinuationMask) and the dropped tokens (ReturnDroppedTokens) are still allocated at each call.
+//
+// Unlike Encode, it doesn't support WithEncodeTimeout: dst could be written to after a timeout.
+func (t *Tokenizer) EncodeInto(sentence string, dst *Encoding) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
 	if t.tokenizer == nil {
 		panicf("Tokenizer already finalized, one cannot change or use it any longer")
 	}
-	return t.tokenizer.Encode(sentence, t.encodeParams)
+	return t.encodeInto(sentence, dst)
+}
+
+// encodeInto implements EncodeInto, without locking.
+func (t *Tokenizer) encodeInto(sentence string, dst *Encoding) error {
+	input := sentence
+	sentence = t.preprocess(sentence)
+	dst.ContinuationMask, dst.FirstSubwordMask, dst.TokenHashes, dst.CharOffsets = nil, nil, nil, nil
+	dst.TokenKinds = nil
+	dst.DroppedIds, dst.DroppedTokens = nil, nil
+	if t.returnDroppedTokens && t.isTruncationSet {
+		encoding, err := t.encodeWithDropped(sentence)
+		if err != nil {
+			return err
+		}
+		*dst = *encoding
+	} else if err := t.tokenizer.EncodeInto(sentence, t.internalEncodeParams(), dst); err != nil {
+		return err
+	}
+	return t.completeEncoding(dst, input, sentence)
+}
+
+// completeEncoding checks for unknown tokens (see WithRejectUnknown), fills the derived fields and the consumed
+// bytes of the encoding of a sentence, encoded with the parameters of internalEncodeParams.
+//
+// input is the sentence given by the user, and sentence is the preprocessed one that was encoded.
+func (t *Tokenizer) completeEncoding(encoding *Encoding, input, sentence string) error {
+	if err := t.checkUnknown(sentence, encoding); err != nil {
+		return err
+	}
+	t.fillDerivedFields(encoding, sentenceText(sentence))
+	t.setConsumedBytes(encoding, input)
+	return nil
 }
 
 // EncodeBatch list of strings.
 //
 // The returned Encoding object will have fields filled according to Tokenizer fields configured to be returned.
 func (t *Tokenizer) EncodeBatch(sentences []string) ([]Encoding, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	return t.encodeBatch(sentences)
+}
+
+// encodeBatch implements EncodeBatch, without locking.
+func (t *Tokenizer) encodeBatch(sentences []string) ([]Encoding, error) {
+	inputs := sentences
+	sentences = t.preprocessBatch(sentences)
+	encodings, err := t.tokenizer.EncodeBatch(sentences, t.internalEncodeParams())
+	if err != nil {
+		return nil, err
+	}
+	for ii := range encodings {
+		if err = t.completeEncoding(&encodings[ii], inputs[ii], sentences[ii]); err != nil {
+			return nil, errors.WithMessagef(err, "Tokenizer.EncodeBatch(): sentence #%d", ii)
+		}
+	}
+	return encodings, nil
+}
+
+// EncodeAuto encodes the sentence, and if truncation is configured (see WithTruncation) and the sentence
+// doesn't fit, it returns multiple encodings: the first is the truncated one, followed by windows with the
+// overflowing tokens (see also WithTruncationStride to control overlap).
+//
+// So the length of the returned slice indicates whether the sentence overflowed: it's 1 if it fit (or if
+// truncation is not configured), and more than 1 otherwise.
+func (t *Tokenizer) EncodeAuto(sentence string) ([]Encoding, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	if !t.isTruncationSet {
+		encoding, err := t.encode(sentence)
+		if err != nil {
+			return nil, err
+		}
+		return []Encoding{*encoding}, nil
+	}
+	input := sentence
+	sentence = t.preprocess(sentence)
+	encodings, err := t.tokenizer.EncodeOverflowing(sentence, t.internalEncodeParams())
+	if err != nil {
+		return nil, err
+	}
+	for ii := range encodings {
+		if err = t.completeEncoding(&encodings[ii], input, sentence); err != nil {
+			return nil, errors.WithMessagef(err, "Tokenizer.EncodeAuto(): window #%d", ii)
+		}
+	}
+	return encodings, nil
+}
+
+// EncodeBatchInto is like EncodeBatch, but it stores the results in dst, which must have length >= len(sentences).
+//
+// The slices of each element of dst are reused if they have enough capacity, and only grown when needed.
+// So when repeatedly encoding batches of similar size, reusing the same dst saves most of the allocations.
+// Fields not configured to be returned are set to zero length (their storage is preserved).
+func (t *Tokenizer) EncodeBatchInto(sentences []string, dst []Encoding) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	if len(dst) < len(sentences) {
+		return errors.Errorf("Tokenizer.EncodeBatchInto(): len(dst)=%d < len(sentences)=%d", len(dst), len(sentences))
+	}
+	inputs := sentences
+	sentences = t.preprocessBatch(sentences)
+	for ii := range sentences {
+		dst[ii].ContinuationMask, dst[ii].FirstSubwordMask, dst[ii].TokenHashes, dst[ii].CharOffsets = nil, nil, nil, nil
+		dst[ii].TokenKinds = nil
+		dst[ii].DroppedIds, dst[ii].DroppedTokens = nil, nil
+	}
+	if err := t.tokenizer.EncodeBatchInto(sentences, t.internalEncodeParams(), dst); err != nil {
+		return err
+	}
+	for ii := range sentences {
+		if err := t.completeEncoding(&dst[ii], inputs[ii], sentences[ii]); err != nil {
+			return errors.WithMessagef(err, "Tokenizer.EncodeBatchInto(): sentence #%d", ii)
+		}
+	}
+	return nil
+}
+
+// EncodeWithTypeIds is like Encode, but it overrides the type ids of the whole sequence (including special and
+// padding tokens) with typeId. The TypeIds are always returned, even if ReturnTypeIds is not set.
+//
+// This supports models with non-standard segment schemes.
+func (t *Tokenizer) EncodeWithTypeIds(sentence string, typeId uint32) (*Encoding, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	input := sentence
+	sentence = t.preprocess(sentence)
+	encodeParams := t.internalEncodeParams()
+	encodeParams.ReturnTypeIds = true
+	encoding, err := t.tokenizer.Encode(sentence, encodeParams)
+	if err != nil {
+		return nil, err
+	}
+	if err = t.completeEncoding(encoding, input, sentence); err != nil {
+		return nil, err
+	}
+	for ii := range encoding.TypeIds {
+		encoding.TypeIds[ii] = typeId
+	}
+	return encoding, nil
+}
+
+// EncodePair encodes the pair of sentences (sequences A and B), e.g. a question and a context for
+// question-answering, or a premise and a hypothesis for NLI. How they are combined is defined by the tokenizer's
+// post-processor, e.g. `[CLS] A [SEP] B [SEP]` for BERT if AddSpecialTokens is set.
+//
+// TypeIds are always returned: 0 for the tokens of sentenceA and 1 for the tokens of sentenceB (see also
+// EncodePairWithTypeIds). The truncation strategy (see WithTruncationStrategy) defines which of the sentences
+// is truncated if the pair doesn't fit the truncation length.
+//
+// WithRejectUnknown is not applied to pairs.
+func (t *Tokenizer) EncodePair(sentenceA, sentenceB string) (*Encoding, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	return t.encodePair(sentenceA, sentenceB)
+}
+
+// encodePair implements EncodePair, without locking.
+func (t *Tokenizer) encodePair(sentenceA, sentenceB string) (*Encoding, error) {
+	encodeParams := t.internalEncodeParams()
+	encodeParams.ReturnTypeIds = true
+	sentenceA, sentenceB = t.preprocess(sentenceA), t.preprocess(sentenceB)
+	encoding, err := t.tokenizer.EncodePair(sentenceA, sentenceB, encodeParams)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Tokenizer.EncodePair():")
+	}
+	t.fillDerivedFields(encoding, func(ii int) string {
+		switch encoding.SequenceIds[ii] {
+		case 0:
+			return sentenceA
+		case 1:
+			return sentenceB
+		}
+		return ""
+	})
+	return encoding, nil
+}
+
+// EncodePretokenized encodes a sentence already split into words (e.g. read from a CoNLL file). The words are
+// still split by the tokenizer's pre-tokenizer, but tokens are never merged across the given words, and the
+// WordIds refer to the index of the words given.
+//
+// Notice that, unlike Encode, the Offsets are relative to each word, not to the whole sentence.
+// The preprocessing (e.g. WithStripZeroWidth) is applied to each word separately, and WithRejectUnknown is not
+// applied.
+func (t *Tokenizer) EncodePretokenized(words []string) (*Encoding, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	words = t.preprocessBatch(words)
+	encodeParams := t.internalEncodeParams()
+	if t.returnBothOffsets {
+		encodeParams.ReturnWordIds = true
+	}
+	encoding, err := t.tokenizer.EncodePretokenized(words, encodeParams)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Tokenizer.EncodePretokenized():")
+	}
+	t.fillDerivedFields(encoding, func(ii int) string {
+		if wordId := encoding.WordIds[ii]; wordId >= 0 && int(wordId) < len(words) {
+			return words[wordId]
+		}
+		return ""
+	})
+	return encoding, nil
+}
+
+// EncodePairWithTypeIds encodes the pair of sentences (a, b), and maps the type ids produced by the tokenizer
+// through typeIds: each produced type id `i` is replaced by `typeIds[i]` (if `i < len(typeIds)`).
+// The TypeIds are always returned, even if ReturnTypeIds is not set.
+//
+// For the usual post-processors (e.g. BERT's) the tokens of the first sentence have type id 0 and the
+// ones of the second have type id 1, so `typeIds` holds the type id for each segment.
+func (t *Tokenizer) EncodePairWithTypeIds(a, b string, typeIds []uint32) (*Encoding, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	encoding, err := t.encodePair(a, b)
+	if err != nil {
+		return nil, err
+	}
+	for ii, typeId := range encoding.TypeIds {
+		if int(typeId) < len(typeIds) {
+			encoding.TypeIds[ii] = typeIds[typeId]
+		}
+	}
+	return encoding, nil
+}
+
+// CountTokens returns the number of tokens the sentence is encoded to, including the special tokens if
+// addSpecialTokens is true, e.g. for length-based batching (grouping sentences of similar length before padding).
+//
+// It's cheaper than Encode, since only the token ids are returned by the Rust library, and no other field is
+// converted. Truncation (if configured) is taken into account, but padding tokens are not counted.
+func (t *Tokenizer) CountTokens(sentence string, addSpecialTokens bool) (int, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	encoding, err := t.tokenizer.Encode(t.preprocess(sentence), countTokensParams(addSpecialTokens))
+	if err != nil {
+		return 0, errors.WithMessage(err, "Tokenizer.CountTokens():")
+	}
+	return encoding.Length, nil
+}
+
+// CountTokensBatch is like CountTokens, for each of the sentences, encoded in one batch.
+func (t *Tokenizer) CountTokensBatch(sentences []string, addSpecialTokens bool) ([]int, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	counts := make([]int, len(sentences))
+	if len(sentences) == 0 {
+		return counts, nil
+	}
+	encodings, err := t.tokenizer.EncodeBatch(t.preprocessBatch(sentences), countTokensParams(addSpecialTokens))
+	if err != nil {
+		return nil, errors.WithMessage(err, "Tokenizer.CountTokensBatch():")
+	}
+	for ii := range encodings {
+		counts[ii] = encodings[ii].Length
+	}
+	return counts, nil
+}
+
+// countTokensParams returns the EncodeParams used by CountTokens: only the length (without padding) is needed.
+func countTokensParams(addSpecialTokens bool) rs.EncodeParams {
+	return rs.EncodeParams{
+		AddSpecialTokens: addSpecialTokens,
+		ReturnLengths:    true,
+	}
+}
+
+// CountTokensPair returns the number of tokens the pair of sentences (a, b) is encoded to, including the special
+// tokens added in between and around them (e.g. `[CLS] a [SEP] b [SEP]`) if addSpecial is true.
+//
+// Summing the counts of the individual sentences undercounts these separators, hence this method.
+// Truncation (if configured) is taken into account, but padding tokens are not counted.
+func (t *Tokenizer) CountTokensPair(a, b string, addSpecial bool) (int, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
 	if t.tokenizer == nil {
 		panicf("Tokenizer already finalized, one cannot change or use it any longer")
 	}
-	return t.tokenizer.EncodeBatch(sentences, t.encodeParams)
+	encoding, err := t.tokenizer.EncodePair(t.preprocess(a), t.preprocess(b), rs.EncodeParams{
+		AddSpecialTokens:    addSpecial,
+		ReturnAttentionMask: true,
+	})
+	if err != nil {
+		return 0, errors.WithMessage(err, "Tokenizer.CountTokensPair():")
+	}
+	count := 0
+	for _, mask := range encoding.AttentionMask {
+		if mask != 0 {
+			count++
+		}
+	}
+	return count, nil
 }
 
 // Decode is the reverse of encode, and converts the list of tokens back to a "sentence" (string).
 func (t *Tokenizer) Decode(tokenIds []uint32, skipSpecialTokens bool) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
 	if t.tokenizer == nil {
 		panicf("Tokenizer already finalized, one cannot change or use it any longer")
 	}
@@ -565,10 +1385,242 @@ func (t *Tokenizer) Decode(tokenIds []uint32, skipSpecialTokens bool) string {
 	return t.tokenizer.Decode(tokenIds, skipSpecialTokens)
 }
 
-// VocabSize returns the number of known tokens.
-func (t *Tokenizer) VocabSize() uint32 {
+// DecodeWithLengths is like Decode, but also returns the length of the decoded text in runes (Unicode code points)
+// and in bytes, commonly needed for display and for budgeting.
+func (t *Tokenizer) DecodeWithLengths(tokenIds []uint32, skipSpecialTokens bool) (text string, runes, bytes int) {
+	text = t.Decode(tokenIds, skipSpecialTokens)
+	return text, utf8.RuneCountInString(text), len(text)
+}
+
+// DecodeJoin decodes each row of token ids in batch, and joins the decoded strings with sep.
+// It's useful to reconstruct a document that was encoded in chunks.
+//
+// Empty rows decode to empty strings, and are still separated by sep.
+func (t *Tokenizer) DecodeJoin(batch [][]uint32, sep string, skipSpecialTokens bool) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	var sb strings.Builder
+	for ii, tokenIds := range batch {
+		if ii > 0 {
+			sb.WriteString(sep)
+		}
+		if len(tokenIds) > 0 {
+			sb.WriteString(t.tokenizer.Decode(tokenIds, skipSpecialTokens))
+		}
+	}
+	return sb.String()
+}
+
+// DecodeWithUnknown is like Decode, but ids not in the vocabulary (e.g. generated by a model whose output
+// vocabulary is larger than the tokenizer's) are rendered as unknownRepr, instead of being silently dropped.
+//
+// The runs of known ids are decoded separately, and unknownRepr is separated from the surrounding text by a
+// space, unless there is already whitespace there.
+func (t *Tokenizer) DecodeWithUnknown(tokenIds []uint32, unknownRepr string, skipSpecialTokens bool) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	if len(tokenIds) == 0 {
+		return ""
+	}
+	tokens := t.tokenizer.IdsToTokens(tokenIds)
+	return t.decodeReplacing(tokenIds, skipSpecialTokens, func(ii int) (string, bool) {
+		return unknownRepr, tokens[ii] == ""
+	})
+}
+
+// DecodeWithOverrides is like Decode, but the ids in overrides are rendered as the given strings, instead of their
+// tokens, without modifying the Tokenizer. It's useful to debug a remapping of ids, or a patched vocabulary.
+//
+// As with DecodeWithUnknown, the runs of ids not overridden are decoded separately, and each override is
+// separated from the surrounding text by a space, unless there is already whitespace there. An override
+// with an empty string drops the id.
+func (t *Tokenizer) DecodeWithOverrides(tokenIds []uint32, overrides map[uint32]string, skipSpecialTokens bool) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
 	if t.tokenizer == nil {
 		panicf("Tokenizer already finalized, one cannot change or use it any longer")
 	}
+	if len(tokenIds) == 0 {
+		return ""
+	}
+	return t.decodeReplacing(tokenIds, skipSpecialTokens, func(ii int) (string, bool) {
+		text, found := overrides[tokenIds[ii]]
+		return text, found
+	})
+}
+
+// decodeReplacing decodes tokenIds, except the ones for which replace(ii) returns true, which are rendered as the
+// returned text instead. The runs of ids not replaced are decoded separately, and the replacements are separated
+// from the surrounding text by a space, unless there is already whitespace there.
+func (t *Tokenizer) decodeReplacing(tokenIds []uint32, skipSpecialTokens bool,
+	replace func(ii int) (text string, replaced bool)) string {
+	var sb strings.Builder
+	writeSpaced := func(text string) {
+		if text == "" {
+			return
+		}
+		last, _ := utf8.DecodeLastRuneInString(sb.String())
+		first, _ := utf8.DecodeRuneInString(text)
+		if sb.Len() > 0 && !unicode.IsSpace(last) && !unicode.IsSpace(first) {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(text)
+	}
+	start := 0
+	for ii := range tokenIds {
+		text, replaced := replace(ii)
+		if !replaced {
+			continue
+		}
+		// Replaced id: decode the run of ids before it.
+		if ii > start {
+			writeSpaced(t.tokenizer.Decode(tokenIds[start:ii], skipSpecialTokens))
+		}
+		writeSpaced(text)
+		start = ii + 1
+	}
+	if start < len(tokenIds) {
+		writeSpaced(t.tokenizer.Decode(tokenIds[start:], skipSpecialTokens))
+	}
+	return sb.String()
+}
+
+// VocabSize returns the number of known tokens, including the added tokens (see AddTokens): it is the same as
+// VocabSizeWithAddedTokens, and the Python `len(tokenizer)`. See BaseVocabSize for the size of the vocabulary of
+// the model only.
+//
+// It includes the added tokens (the Rust `get_vocab_size(with_added_tokens=true)`) because it always did: the
+// underlying library has counted them since the first version, and callers use it as the bound of the ids
+// returned by Encode, which include the added tokens. Changing it to the size of the model's vocabulary would
+// silently break them, hence BaseVocabSize.
+//
+// Unlike most other methods, it doesn't panic if the Tokenizer has already been finalized: it logs a warning and
+// returns 0 instead, the same as the underlying library.
+func (t *Tokenizer) VocabSize() uint32 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tokenizer == nil {
+		log.Printf("WARNING: Tokenizer.VocabSize() called on a Tokenizer already finalized, returning 0")
+		return 0
+	}
 	return t.tokenizer.VocabSize()
 }
+
+// VocabSizeWithAddedTokens returns the number of known tokens, including the added tokens (see AddTokens), that
+// is, the Rust `get_vocab_size(with_added_tokens=true)`. After adding tokens, it grows with them, while
+// BaseVocabSize stays constant.
+//
+// As VocabSize, it doesn't panic if the Tokenizer has already been finalized, and returns 0 instead.
+func (t *Tokenizer) VocabSizeWithAddedTokens() uint32 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tokenizer == nil {
+		log.Printf("WARNING: Tokenizer.VocabSizeWithAddedTokens() called on a Tokenizer already finalized, returning 0")
+		return 0
+	}
+	return t.tokenizer.VocabSize()
+}
+
+// BaseVocabSize returns the size of the vocabulary of the model, without the added tokens (see AddTokens), that
+// is, the Rust `get_vocab_size(with_added_tokens=false)` and the Python `tokenizer.vocab_size`.
+//
+// Notice the added tokens may include tokens of the vocabulary of the model (e.g. the special tokens of BERT), so
+// VocabSize - BaseVocabSize is not necessarily the number of added tokens.
+//
+// As VocabSize, it doesn't panic if the Tokenizer has already been finalized, and returns 0 instead.
+func (t *Tokenizer) BaseVocabSize() uint32 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tokenizer == nil {
+		log.Printf("WARNING: Tokenizer.BaseVocabSize() called on a Tokenizer already finalized, returning 0")
+		return 0
+	}
+	return t.tokenizer.BaseVocabSize()
+}
+
+// NativeMemoryBytes returns a rough estimate of the memory used by the underlying (Rust) tokenizer, in bytes:
+// mostly its vocabulary and, for BPE models, its merges. Useful for capacity planning, e.g. how many tokenizers
+// fit in a worker.
+func (t *Tokenizer) NativeMemoryBytes() (uint64, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	bytes, err := t.tokenizer.NativeMemoryBytes()
+	if err != nil {
+		return 0, errors.WithMessage(err, "Tokenizer.NativeMemoryBytes():")
+	}
+	return bytes, nil
+}
+
+// ConvertTokensToIds converts the token strings to their ids (including added tokens), in one call to the
+// underlying library.
+//
+// It returns the ids and whether each token was found in the vocabulary: tokens not found are given id 0 and
+// found set to false.
+func (t *Tokenizer) ConvertTokensToIds(tokens []string) (ids []uint32, found []bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	return t.tokenizer.TokensToIds(tokens)
+}
+
+// TokenToId returns the id of the token (including added tokens), and whether it is in the vocabulary.
+func (t *Tokenizer) TokenToId(token string) (id uint32, found bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	return t.tokenizer.TokenToId(token)
+}
+
+// IdToToken returns the token string of the id (including added tokens), and whether it is in the vocabulary.
+// It is cheaper than Decode when only the token of one id is needed.
+//
+// NUL bytes can't cross the boundary with the underlying library, so any in the token are replaced by U+FFFD (the
+// Unicode replacement character). The same holds for TokenString and ConvertIdsToTokens.
+func (t *Tokenizer) IdToToken(id uint32) (token string, found bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	return t.tokenizer.IdToToken(id)
+}
+
+// TokenString returns the raw token string stored for the id in the vocabulary of the model (the inverse of the
+// model's vocabulary map), and whether it is there. E.g. for byte-level BPE models (GPT-2) it is the byte-level
+// encoded string (e.g. "\u0120brown"), not the decoded text.
+//
+// It differs from IdToToken only for added tokens (see AddTokens): they are not part of the model's vocabulary,
+// so TokenString returns false for them (unless they reuse an id of the model's vocabulary, in which case it
+// returns the model's token), while IdToToken returns their content.
+func (t *Tokenizer) TokenString(id uint32) (token string, found bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	return t.tokenizer.ModelIdToToken(id)
+}
+
+// ConvertIdsToTokens converts the ids to their token strings (including added tokens), in one call to the
+// underlying library. Ids not in the vocabulary are converted to empty strings.
+func (t *Tokenizer) ConvertIdsToTokens(ids []uint32) []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	return t.tokenizer.IdsToTokens(ids)
+}
@@ -0,0 +1,2055 @@
+package tokenizers_test
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gomlx/tokenizers"
+	"github.com/gomlx/tokenizers/internal/rs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	bertJson = "examples/bert/bert-base-uncased.json"
+)
+
+// gpt2LikeJson is a minimal byte-level BPE tokenizer, as used by GPT-2.
+const gpt2LikeJson = `{
+  "version": "1.0", "truncation": null, "padding": null, "added_tokens": [], "normalizer": null,
+  "pre_tokenizer": {"type": "ByteLevel", "add_prefix_space": false, "trim_offsets": true, "use_regex": true},
+  "post_processor": {"type": "ByteLevel", "add_prefix_space": true, "trim_offsets": false, "use_regex": true},
+  "decoder": {"type": "ByteLevel", "add_prefix_space": true, "trim_offsets": true, "use_regex": true},
+  "model": {"type": "BPE", "dropout": null, "unk_token": null, "continuing_subword_prefix": null,
+    "end_of_word_suffix": null, "fuse_unk": false, "byte_fallback": false,
+    "vocab": {"a": 0, "b": 1, "ab": 2}, "merges": ["a b"]}
+}`
+
+func TestCountTokens(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	tk.ReturnTokens(true)
+
+	count, err := tk.CountTokens("brown fox jumps over the lazy dog", false)
+	require.NoError(t, err)
+	assert.Equal(t, 7, count)
+	count, err = tk.CountTokens("brown fox jumps over the lazy dog", true)
+	require.NoError(t, err)
+	assert.Equal(t, 9, count) // [CLS] ... [SEP]
+
+	// Padding is not counted, truncation is.
+	tk.WithPadToLength(16).WithTruncation(5)
+	counts, err := tk.CountTokensBatch([]string{"brown fox jumps over the lazy dog", "lazy dog", ""}, true)
+	require.NoError(t, err)
+	assert.Equal(t, []int{5, 4, 2}, counts)
+
+	counts, err = tk.CountTokensBatch(nil, true)
+	require.NoError(t, err)
+	assert.Empty(t, counts)
+}
+
+func TestCountTokensPair(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+
+	count, err := tk.CountTokensPair("brown fox", "lazy dog", true)
+	require.NoError(t, err)
+	assert.Equal(t, 7, count) // [CLS] brown fox [SEP] lazy dog [SEP]
+
+	count, err = tk.CountTokensPair("brown fox", "lazy dog", false)
+	require.NoError(t, err)
+	assert.Equal(t, 4, count)
+
+	// Padding is not counted.
+	tk.WithPadToLength(16)
+	count, err = tk.CountTokensPair("brown fox", "lazy dog", true)
+	require.NoError(t, err)
+	assert.Equal(t, 7, count)
+}
+
+func FuzzEncodeDecode(f *testing.F) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(f, err)
+	defer tk.Finalize()
+	tk.AddSpecialTokens(true)
+
+	for _, seed := range []string{"", " ", "brown fox jumps over the lazy dog", "Ohne UTF-8, ist alles Käse!",
+		"[CLS] [SEP]", "日本語のテキスト", "a\x00b", "😀 emoji"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		if !utf8.ValidString(input) {
+			t.Skip("only valid UTF-8 inputs are supported")
+		}
+		ok, reason := tokenizers.RoundTripOK(tk, input)
+		if !ok {
+			t.Error(reason)
+		}
+	})
+}
+
+func TestChecksum(t *testing.T) {
+	inputs := []string{"brown fox jumps over the lazy dog", "Ohne K\u00e4se, ohne mich!", ""}
+	checksum := func(configure func(tk *tokenizers.Tokenizer)) string {
+		tk, err := tokenizers.FromFile(bertJson)
+		require.NoError(t, err)
+		defer tk.Finalize()
+		configure(tk)
+		sum, err := tk.Checksum(inputs)
+		require.NoError(t, err)
+		return sum
+	}
+	noSpecialTokens := func(tk *tokenizers.Tokenizer) { tk.AddSpecialTokens(false) }
+	golden := checksum(noSpecialTokens)
+	assert.Len(t, golden, 64)
+	assert.Equal(t, golden, checksum(noSpecialTokens))
+	assert.NotEqual(t, golden, checksum(func(tk *tokenizers.Tokenizer) { tk.AddSpecialTokens(true) }))
+}
+
+func TestEncodeBatchSorted(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+
+	sentences := []string{
+		"brown fox jumps over the lazy dog, and then the lazy dog jumps over the brown fox",
+		"lazy dog",
+		"brown fox jumps",
+		"dog",
+	}
+	tk.WithSortedBatchBucketSize(2)
+
+	// Without padding, results must match EncodeBatch.
+	want, err := tk.EncodeBatch(sentences)
+	require.NoError(t, err)
+	got, permutation, err := tk.EncodeBatchSorted(sentences)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+	assert.Equal(t, []int{3, 1, 2, 0}, permutation)
+
+	// With padding, the short sentences are padded only to the longest in their bucket.
+	tk.WithPadToLongest()
+	want, err = tk.EncodeBatch(sentences)
+	require.NoError(t, err)
+	got, _, err = tk.EncodeBatchSorted(sentences)
+	require.NoError(t, err)
+	for ii := range sentences {
+		assert.Len(t, want[ii].TokenIds, len(want[0].TokenIds))
+	}
+	assert.Len(t, got[3].TokenIds, 2) // "dog" padded to "lazy dog".
+	assert.Len(t, got[1].TokenIds, 2)
+	assert.Len(t, got[2].TokenIds, len(got[0].TokenIds))
+	assert.Less(t, len(got[1].TokenIds), len(want[1].TokenIds))
+
+	// Derived fields and the rejection of unknown tokens apply as in EncodeBatch.
+	tk.WithNoPadding().ReturnFirstSubwordMask(true).WithRejectUnknown(true)
+	want, err = tk.EncodeBatch([]string{"ohne Käse", "dog"})
+	require.NoError(t, err)
+	got, _, err = tk.EncodeBatchSorted([]string{"ohne Käse", "dog"})
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+	assert.Equal(t, []bool{true, false, true, false}, got[0].FirstSubwordMask)
+	_, _, err = tk.EncodeBatchSorted([]string{"brown fox", "lazy \U0001F600", "dog"})
+	var unkErr *tokenizers.UnknownTokenError
+	require.True(t, errors.As(err, &unkErr), "expected *UnknownTokenError, got %v", err)
+	assert.Contains(t, err.Error(), "sentence #1")
+
+	// Empty batch, as EncodeBatch.
+	got, permutation, err = tk.EncodeBatchSorted(nil)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+	assert.Empty(t, permutation)
+}
+
+func TestDecodeJoin(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+
+	chunks := [][]uint32{
+		{101, 2829, 4419, 14523, 102},
+		{101, 2058, 1996, 13971, 3899, 102},
+	}
+	assert.Equal(t, "brown fox jumps over the lazy dog", tk.DecodeJoin(chunks, " ", true))
+	assert.Equal(t, "[CLS] brown fox jumps [SEP]\n[CLS] over the lazy dog [SEP]", tk.DecodeJoin(chunks, "\n", false))
+	assert.Equal(t, "", tk.DecodeJoin(nil, " ", true))
+}
+
+func TestTruncationError(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	tk.WithTruncation(5)
+
+	err = tk.SetTruncationStride(10)
+	require.Error(t, err)
+	var truncErr *tokenizers.TruncationError
+	require.True(t, errors.As(err, &truncErr), "expected *TruncationError, got %v", err)
+	assert.Equal(t, uint32(10), truncErr.Stride)
+	assert.Equal(t, uint32(5), truncErr.MaxLength)
+	assert.Contains(t, tk.String(), "TruncationStride=0")
+	require.Error(t, tk.SetTruncationStride(-1))
+	require.NoError(t, tk.SetTruncationStride(2))
+	assert.Contains(t, tk.String(), "TruncationStride=2")
+	require.NoError(t, tk.SetTruncationStride(0))
+
+	// WithTruncationStride panics with the same error.
+	var panicked any
+	func() {
+		defer func() { panicked = recover() }()
+		tk.WithTruncationStride(10)
+	}()
+	require.NotNil(t, panicked)
+	err, ok := panicked.(error)
+	require.True(t, ok, "expected panic with an error, got %v", panicked)
+	truncErr = nil
+	require.True(t, errors.As(err, &truncErr), "expected *TruncationError, got %v", err)
+	assert.Equal(t, uint32(10), truncErr.Stride)
+	assert.Equal(t, uint32(5), truncErr.MaxLength)
+
+	// Previous (valid) truncation parameters are kept.
+	assert.Contains(t, tk.String(), "TruncationStride=0")
+	assert.Contains(t, tk.String(), "TruncationMaxLength=5")
+}
+
+func TestDefaultTruncationDirection(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	assert.Contains(t, tk.String(), "TruncationDirection=Right")
+
+	// The end of the text is truncated, like in HuggingFace.
+	tk.WithTruncation(3)
+	encoding, err := tk.Encode("brown fox jumps over the lazy dog")
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{2829, 4419, 14523}, encoding.TokenIds)
+
+	// Resetting truncation goes back to the default.
+	tk.WithTruncationDirection(tokenizers.Left).WithNoTruncation()
+	assert.Contains(t, tk.String(), "TruncationDirection=Right")
+}
+
+func TestEncodeAuto(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	const sentence = "brown fox jumps over the lazy dog"
+
+	// No truncation set: always one encoding.
+	encodings, err := tk.EncodeAuto(sentence)
+	require.NoError(t, err)
+	require.Len(t, encodings, 1)
+	assert.Equal(t, []uint32{2829, 4419, 14523, 2058, 1996, 13971, 3899}, encodings[0].TokenIds)
+
+	// Short input fits.
+	tk.WithTruncation(5).WithTruncationDirection(tokenizers.Right)
+	encodings, err = tk.EncodeAuto("lazy dog")
+	require.NoError(t, err)
+	require.Len(t, encodings, 1)
+	assert.Equal(t, []string{"lazy", "dog"}, encodings[0].Tokens)
+
+	// Long input overflows.
+	encodings, err = tk.EncodeAuto(sentence)
+	require.NoError(t, err)
+	require.Len(t, encodings, 2)
+	assert.Equal(t, []string{"brown", "fox", "jumps", "over", "the"}, encodings[0].Tokens)
+	assert.Equal(t, []string{"lazy", "dog"}, encodings[1].Tokens)
+}
+
+func TestDroppedTokens(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	const sentence = "brown fox jumps over the lazy dog"
+	tk.ReturnDroppedTokens(true).ReturnTokens(false)
+
+	// No truncation: nothing dropped.
+	encoding, err := tk.Encode(sentence)
+	require.NoError(t, err)
+	assert.Len(t, encoding.TokenIds, 7)
+	assert.Empty(t, encoding.DroppedIds)
+
+	// Truncation on the right drops the tail.
+	tk.WithTruncation(5).WithTruncationDirection(tokenizers.Right)
+	encoding, err = tk.Encode(sentence)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{2829, 4419, 14523, 2058, 1996}, encoding.TokenIds)
+	assert.Nil(t, encoding.Tokens)
+	assert.Equal(t, []uint32{13971, 3899}, encoding.DroppedIds)
+	assert.Equal(t, []string{"lazy", "dog"}, encoding.DroppedTokens)
+
+	// Special tokens and the stride overlap are not reported.
+	tk.AddSpecialTokens(true).WithTruncationStride(1)
+	encoding, err = tk.Encode(sentence)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{101, 2829, 4419, 14523, 102}, encoding.TokenIds)
+	assert.Equal(t, []string{"over", "the", "lazy", "dog"}, encoding.DroppedTokens)
+
+	// Truncation on the left drops the head.
+	tk.AddSpecialTokens(false).WithTruncationStride(0).WithTruncationDirection(tokenizers.Left)
+	encoding, err = tk.Encode(sentence)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"brown", "fox"}, encoding.DroppedTokens)
+}
+
+func TestStripBOM(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	tk.ReturnOffsets(true)
+	const sentence = "\uFEFFbrown fox"
+
+	// Without stripping the BOM shifts the offsets.
+	encoding, err := tk.Encode(sentence)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), encoding.Offsets[0].Start)
+
+	tk.WithStripBOM(true)
+	encoding, err = tk.Encode(sentence)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"brown", "fox"}, encoding.Tokens)
+	assert.Equal(t, []tokenizers.Offset{{Start: 0, End: 5}, {Start: 6, End: 9}}, encoding.Offsets)
+
+	// Zero-width characters in the middle of a word.
+	tk.WithStripZeroWidth(true)
+	encoding, err = tk.Encode("bro\u200Bwn f\u200Dox")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"brown", "fox"}, encoding.Tokens)
+}
+
+func TestWithUnicodeForm(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	tk.ReturnTokens(true).ReturnOffsets(true)
+	const sentence = "\uff42\uff52\uff4f\uff57\uff4e fox" // Fullwidth "brown".
+
+	encoding, err := tk.Encode(sentence)
+	require.NoError(t, err)
+	assert.NotEqual(t, []string{"brown", "fox"}, encoding.Tokens)
+
+	// NFKC folds the fullwidth characters, and offsets reference the normalized sentence.
+	tk.WithUnicodeForm(norm.NFKC)
+	assert.Contains(t, tk.String(), "UnicodeForm=NFKC")
+	encoding, err = tk.Encode(sentence)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"brown", "fox"}, encoding.Tokens)
+	assert.Equal(t, []tokenizers.Offset{{Start: 0, End: 5}, {Start: 6, End: 9}}, encoding.Offsets)
+
+	tk.WithNoUnicodeForm()
+	assert.Contains(t, tk.String(), "UnicodeForm=none")
+}
+
+func TestWithQuoteNormalization(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	tk.ReturnOffsets(true).WithOffsetsCharMode(tokenizers.OffsetsCharModeByte)
+	const curly = "\u201chello\u201d \u2014 it\u2019s"
+	want, err := tk.Encode(`"hello" - it's`)
+	require.NoError(t, err)
+
+	encoding, err := tk.Encode(curly)
+	require.NoError(t, err)
+	assert.NotEqual(t, want.TokenIds, encoding.TokenIds)
+
+	// Offsets reference the normalized sentence.
+	tk.WithQuoteNormalization(true)
+	assert.Contains(t, tk.String(), "QuoteNormalization=true")
+	encoding, err = tk.Encode(curly)
+	require.NoError(t, err)
+	assert.Equal(t, want.TokenIds, encoding.TokenIds)
+	assert.Equal(t, want.Offsets, encoding.Offsets)
+}
+
+func TestEncodePretokenized(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	tk.ReturnTokens(true).ReturnWordIds(true).ReturnOffsets(true)
+
+	encoding, err := tk.EncodePretokenized([]string{"New", "Yorkers"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"new", "york", "##ers"}, encoding.Tokens)
+	assert.Equal(t, []int32{0, 1, 1}, encoding.WordIds)
+	// Offsets are relative to each word.
+	assert.Equal(t, []tokenizers.Offset{{Start: 0, End: 3}, {Start: 0, End: 4}, {Start: 4, End: 7}}, encoding.Offsets)
+
+	// Tokens are not merged across words.
+	encoding, err = tk.EncodePretokenized([]string{"bro", "wn"})
+	require.NoError(t, err)
+	assert.NotEqual(t, []string{"brown"}, encoding.Tokens)
+	assert.Equal(t, int32(0), encoding.WordIds[0])
+	assert.Equal(t, int32(1), encoding.WordIds[len(encoding.WordIds)-1])
+}
+
+func TestNumPadded(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	tk.WithPadToLength(10).ReturnAttentionMask(true)
+
+	encoding, err := tk.Encode("brown fox jumps over the lazy dog")
+	require.NoError(t, err)
+	require.Len(t, encoding.TokenIds, 10)
+	assert.Equal(t, 3, encoding.NumPadded())
+	assert.Equal(t, 7, encoding.NumRealTokens())
+
+	// Without padding.
+	tk.WithNoPadding()
+	encoding, err = tk.Encode("brown fox jumps over the lazy dog")
+	require.NoError(t, err)
+	assert.Equal(t, 0, encoding.NumPadded())
+	assert.Equal(t, 7, encoding.NumRealTokens())
+}
+
+func TestParseTokenizerConfig(t *testing.T) {
+	// Based on the configuration of a Llama model, with the sentinel model_max_length and AddedToken objects.
+	const configJson = `{
+  "add_bos_token": true,
+  "add_eos_token": false,
+  "bos_token": {"__type": "AddedToken", "content": "<s>", "lstrip": false, "normalized": false, "rstrip": false, "single_word": false},
+  "eos_token": "</s>",
+  "pad_token": null,
+  "unk_token": {"__type": "AddedToken", "content": "<unk>", "lstrip": false, "normalized": false, "rstrip": false, "single_word": false},
+  "clean_up_tokenization_spaces": false,
+  "model_max_length": 1000000000000000019884624838656,
+  "padding_side": "left",
+  "tokenizer_class": "LlamaTokenizer",
+  "chat_template": [{"name": "default", "template": "{{ messages }}"}, {"name": "tool_use", "template": "{{ tools }}"}]
+}`
+	config, err := tokenizers.ParseTokenizerConfig([]byte(configJson))
+	require.NoError(t, err)
+	assert.Equal(t, &tokenizers.TokenizerConfig{
+		TokenizerClass: "LlamaTokenizer",
+		ModelMaxLength: 0,
+		PaddingSide:    "left",
+		AddBosToken:    true,
+		BosToken:       "<s>",
+		EosToken:       "</s>",
+		UnkToken:       "<unk>",
+		ChatTemplate:   "{{ messages }}",
+	}, config)
+
+	// BERT-like configuration.
+	config, err = tokenizers.ParseTokenizerConfig([]byte(
+		`{"do_lower_case": true, "model_max_length": 512, "cls_token": "[CLS]", "sep_token": "[SEP]", "chat_template": "{{ x }}"}`))
+	require.NoError(t, err)
+	assert.Equal(t, 512, config.ModelMaxLength)
+	assert.True(t, config.DoLowerCase)
+	assert.Equal(t, "[CLS]", config.ClsToken)
+	assert.Equal(t, "[SEP]", config.SepToken)
+	assert.Equal(t, "{{ x }}", config.ChatTemplate)
+
+	// Integer-valued floats are accepted for model_max_length, other values are not.
+	config, err = tokenizers.ParseTokenizerConfig([]byte(`{"model_max_length": 2048.0}`))
+	require.NoError(t, err)
+	assert.Equal(t, 2048, config.ModelMaxLength)
+	config, err = tokenizers.ParseTokenizerConfig([]byte(`{"model_max_length": 1e30}`))
+	require.NoError(t, err)
+	assert.Equal(t, 0, config.ModelMaxLength)
+	_, err = tokenizers.ParseTokenizerConfig([]byte(`{"model_max_length": 512.5}`))
+	require.Error(t, err)
+
+	// Additional special tokens, as strings or AddedToken objects.
+	config, err = tokenizers.ParseTokenizerConfig([]byte(
+		`{"additional_special_tokens": ["<ent>", {"content": "</ent>", "special": true}]}`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"<ent>", "</ent>"}, config.AdditionalSpecialTokens)
+
+	// Invalid token.
+	_, err = tokenizers.ParseTokenizerConfig([]byte(`{"pad_token": 3}`))
+	require.Error(t, err)
+
+	// Tokenizer not loaded from a pretrained model has no configuration.
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	assert.Nil(t, tk.Config())
+}
+
+func TestEncodeThreadLocal(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+
+	encoding, release, err := tk.EncodeThreadLocal("brown fox jumps over the lazy dog")
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{2829, 4419, 14523, 2058, 1996, 13971, 3899}, encoding.TokenIds)
+	release()
+	release() // Extra calls are ignored.
+
+	encoding, release, err = tk.EncodeThreadLocal("lazy dog")
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{13971, 3899}, encoding.TokenIds)
+	assert.Equal(t, []string{"lazy", "dog"}, encoding.Tokens)
+
+	// A stale release doesn't release the arena in use by a later call.
+	staleRelease := release
+	staleRelease()
+	inUse, release, err := tk.EncodeThreadLocal("lazy dog")
+	require.NoError(t, err)
+	staleRelease()
+	other, otherRelease, err := tk.EncodeThreadLocal("brown fox")
+	require.NoError(t, err)
+	assert.NotSame(t, inUse, other)
+	assert.Equal(t, []uint32{13971, 3899}, inUse.TokenIds)
+	otherRelease()
+	release()
+
+	// Same results as Encode, including derived fields and the rejection of unknown tokens.
+	tk.ReturnFirstSubwordMask(true).WithRejectUnknown(true)
+	want, err := tk.Encode("ohne Käse")
+	require.NoError(t, err)
+	encoding, release, err = tk.EncodeThreadLocal("ohne Käse")
+	require.NoError(t, err)
+	assert.Equal(t, want, encoding)
+	assert.Equal(t, []bool{true, false, true, false}, encoding.FirstSubwordMask)
+	release()
+	_, release, err = tk.EncodeThreadLocal("lazy \U0001F600")
+	var unkErr *tokenizers.UnknownTokenError
+	require.True(t, errors.As(err, &unkErr), "expected *UnknownTokenError, got %v", err)
+	assert.Nil(t, release)
+}
+
+func BenchmarkEncodeThreadLocal(b *testing.B) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(b, err)
+	defer tk.Finalize()
+	tk.ReturnTokens(false).ReturnAttentionMask(true)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		encoding, release, err := tk.EncodeThreadLocal("brown fox jumps over the lazy dog")
+		if err != nil {
+			require.NoError(b, err)
+		}
+		if len(encoding.TokenIds) != 7 {
+			b.Fatalf("unexpected number of tokens %d", len(encoding.TokenIds))
+		}
+		release()
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(b, err)
+	defer tk.Finalize()
+	tk.ReturnTokens(false).ReturnAttentionMask(true)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		encoding, err := tk.Encode("brown fox jumps over the lazy dog")
+		if err != nil {
+			require.NoError(b, err)
+		}
+		if len(encoding.TokenIds) != 7 {
+			b.Fatalf("unexpected number of tokens %d", len(encoding.TokenIds))
+		}
+	}
+}
+
+func BenchmarkEncodeInto(b *testing.B) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(b, err)
+	defer tk.Finalize()
+	tk.ReturnTokens(false).ReturnAttentionMask(true)
+	var encoding tokenizers.Encoding
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := tk.EncodeInto("brown fox jumps over the lazy dog", &encoding)
+		if err != nil {
+			require.NoError(b, err)
+		}
+		if len(encoding.TokenIds) != 7 {
+			b.Fatalf("unexpected number of tokens %d", len(encoding.TokenIds))
+		}
+	}
+}
+
+func TestEncodeInto(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	tk.ReturnAttentionMask(true).ReturnContinuationMask(true)
+
+	var dst tokenizers.Encoding
+	for _, sentence := range []string{"brown fox jumps over the lazy dog", "lazy dog", "tokenization"} {
+		want, err := tk.Encode(sentence)
+		require.NoError(t, err)
+		require.NoError(t, tk.EncodeInto(sentence, &dst))
+		assert.Equal(t, want.TokenIds, dst.TokenIds)
+		assert.Equal(t, want.AttentionMask, dst.AttentionMask)
+		assert.Equal(t, want.ContinuationMask, dst.ContinuationMask)
+	}
+
+	// Storage is reused for a shorter sentence.
+	require.NoError(t, tk.EncodeInto("brown fox jumps over the lazy dog", &dst))
+	firstId := &dst.TokenIds[0]
+	require.NoError(t, tk.EncodeInto("lazy dog", &dst))
+	assert.Equal(t, []uint32{13971, 3899}, dst.TokenIds)
+	assert.Same(t, firstId, &dst.TokenIds[0])
+}
+
+func TestConvertTokensToIds(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+
+	ids, found := tk.ConvertTokensToIds([]string{"[CLS]", "brown", "not-a-token", "fox", "[SEP]"})
+	assert.Equal(t, []uint32{101, 2829, 0, 4419, 102}, ids)
+	assert.Equal(t, []bool{true, true, false, true, true}, found)
+
+	tokens := tk.ConvertIdsToTokens([]uint32{101, 2829, 4419, 1 << 30, 102})
+	assert.Equal(t, []string{"[CLS]", "brown", "fox", "", "[SEP]"}, tokens)
+
+	ids, found = tk.ConvertTokensToIds(nil)
+	assert.Empty(t, ids)
+	assert.Empty(t, found)
+
+	// NUL bytes in tokens are replaced, instead of crashing.
+	withNul, err := tokenizers.FromBytes([]byte(strings.Replace(gpt2LikeJson, `"ab": 2}`, `"ab": 2, "a\u0000": 3}`, 1)))
+	require.NoError(t, err)
+	defer withNul.Finalize()
+	assert.Equal(t, []string{"a", "a\uFFFD"}, withNul.ConvertIdsToTokens([]uint32{0, 3}))
+	token, ok := withNul.IdToToken(3)
+	assert.True(t, ok)
+	assert.Equal(t, "a\uFFFD", token)
+	token, ok = withNul.TokenString(3)
+	assert.True(t, ok)
+	assert.Equal(t, "a\uFFFD", token)
+}
+
+func TestDecodeWithUnknown(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	unknownId := tk.VocabSize() + 10
+
+	// Decode drops the unknown id silently, DecodeWithUnknown renders it.
+	ids := []uint32{101, 2829, unknownId, 4419, 102}
+	assert.Equal(t, "brown fox", tk.Decode(ids, true))
+	assert.Equal(t, "brown <?> fox", tk.DecodeWithUnknown(ids, "<?>", true))
+	assert.Equal(t, "[CLS] brown <?> fox [SEP]", tk.DecodeWithUnknown(ids, "<?>", false))
+	assert.Equal(t, "<?> <?>", tk.DecodeWithUnknown([]uint32{unknownId, unknownId}, "<?>", true))
+	assert.Equal(t, "", tk.DecodeWithUnknown(nil, "<?>", true))
+}
+
+func TestDecodeWithOverrides(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+
+	ids := []uint32{101, 2829, 4419, 14523, 102}
+	overrides := map[uint32]string{4419: "<fox>", 101: "<start>"}
+	assert.Equal(t, "<start> brown <fox> jumps", tk.DecodeWithOverrides(ids, overrides, true))
+	assert.Equal(t, "<start> brown <fox> jumps [SEP]", tk.DecodeWithOverrides(ids, overrides, false))
+	assert.Equal(t, "brown jumps", tk.DecodeWithOverrides(ids, map[uint32]string{4419: ""}, true))
+	assert.Equal(t, tk.Decode(ids, true), tk.DecodeWithOverrides(ids, nil, true))
+	assert.Equal(t, "", tk.DecodeWithOverrides(nil, overrides, true))
+}
+
+func TestDecodeWithLengths(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+
+	text, runes, numBytes := tk.DecodeWithLengths([]uint32{101, 2829, 4419, 102}, true)
+	assert.Equal(t, "brown fox", text)
+	assert.Equal(t, 9, runes)
+	assert.Equal(t, 9, numBytes)
+
+	// Multibyte characters.
+	encoding, err := tk.Encode("\u4e2d")
+	require.NoError(t, err)
+	text, runes, numBytes = tk.DecodeWithLengths(encoding.TokenIds, true)
+	assert.Equal(t, "\u4e2d", text)
+	assert.Equal(t, 1, runes)
+	assert.Equal(t, 3, numBytes)
+}
+
+func TestNativeMemoryBytes(t *testing.T) {
+	bert, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer bert.Finalize()
+	tiny, err := tokenizers.FromBytes([]byte(gpt2LikeJson))
+	require.NoError(t, err)
+	defer tiny.Finalize()
+
+	bertBytes, err := bert.NativeMemoryBytes()
+	require.NoError(t, err)
+	tinyBytes, err := tiny.NativeMemoryBytes()
+	require.NoError(t, err)
+	assert.Greater(t, tinyBytes, uint64(0))
+	assert.Greater(t, bertBytes, tinyBytes)
+	// At least the contents of the tokens, stored in both directions.
+	assert.Greater(t, bertBytes, uint64(2*bert.VocabSize()))
+}
+
+func TestTokenToId(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	id, found := tk.TokenToId("[CLS]")
+	assert.True(t, found)
+	assert.Equal(t, uint32(101), id)
+	token, found := tk.IdToToken(101)
+	assert.True(t, found)
+	assert.Equal(t, "[CLS]", token)
+
+	_, found = tk.TokenToId("not-a-token")
+	assert.False(t, found)
+	_, found = tk.IdToToken(1 << 30)
+	assert.False(t, found)
+}
+
+func TestTokenString(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	token, found := tk.TokenString(2829)
+	assert.True(t, found)
+	assert.Equal(t, "brown", token)
+	_, found = tk.TokenString(1 << 30)
+	assert.False(t, found)
+
+	// Added tokens are not part of the model's vocabulary.
+	require.Equal(t, 1, tk.AddTokens([]string{"xyzzy"}))
+	id, found := tk.TokenToId("xyzzy")
+	require.True(t, found)
+	token, found = tk.IdToToken(id)
+	assert.True(t, found)
+	assert.Equal(t, "xyzzy", token)
+	_, found = tk.TokenString(id)
+	assert.False(t, found)
+}
+
+func TestTokenWords(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	tk.AddSpecialTokens(true).ReturnWordIds(true).ReturnOffsets(true).
+		WithOffsetsCharMode(tokenizers.OffsetsCharModeByte)
+
+	const sentence = "New York ohne Käse!"
+	encoding, err := tk.Encode(sentence)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"[CLS]", "new", "york", "oh", "##ne", "ka", "##se", "!", "[SEP]"}, encoding.Tokens)
+	assert.Equal(t, []int32{-1, 0, 1, 2, 2, 3, 3, 4, -1}, encoding.WordIds)
+	assert.Equal(t, []string{"", "New", "York", "ohne", "ohne", "Käse", "Käse", "!", ""}, encoding.TokenWords(sentence))
+
+	// Word ids not returned.
+	tk.ReturnWordIds(false)
+	encoding, err = tk.Encode(sentence)
+	require.NoError(t, err)
+	assert.Nil(t, encoding.TokenWords(sentence))
+}
+
+func TestReturnWordIds(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+
+	// Not returned by default.
+	encoding, err := tk.Encode("ohne Käse")
+	require.NoError(t, err)
+	assert.Nil(t, encoding.WordIds)
+
+	// Subword tokens share the id of their word, special tokens are -1.
+	tk.AddSpecialTokens(true).ReturnWordIds(true)
+	encoding, err = tk.Encode("ohne Käse")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"[CLS]", "oh", "##ne", "ka", "##se", "[SEP]"}, encoding.Tokens)
+	assert.Equal(t, []int32{-1, 0, 0, 1, 1, -1}, encoding.WordIds)
+	startTok, endTok, ok := encoding.WordToTokens(1)
+	require.True(t, ok)
+	assert.Equal(t, []string{"ka", "##se"}, encoding.Tokens[startTok:endTok])
+
+	// In a batch, padding tokens are -1 too.
+	tk.WithPadToLongest()
+	encodings, err := tk.EncodeBatch([]string{"ohne Käse", "dog"})
+	require.NoError(t, err)
+	assert.Equal(t, []int32{-1, 0, 0, 1, 1, -1}, encodings[0].WordIds)
+	assert.Equal(t, []int32{-1, 0, -1, -1, -1, -1}, encodings[1].WordIds)
+
+	// Disabled again.
+	tk.ReturnWordIds(false)
+	encoding, err = tk.Encode("ohne Käse")
+	require.NoError(t, err)
+	assert.Nil(t, encoding.WordIds)
+}
+
+func TestFromArchive(t *testing.T) {
+	tokenizerJson, err := os.ReadFile(bertJson)
+	require.NoError(t, err)
+
+	// Build zip archive with the tokenizer in a subdirectory.
+	archivePath := path.Join(t.TempDir(), "model.zip")
+	f, err := os.Create(archivePath)
+	require.NoError(t, err)
+	zipWriter := zip.NewWriter(f)
+	w, err := zipWriter.Create("README.md")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("BERT model"))
+	require.NoError(t, err)
+	w, err = zipWriter.Create("bert/tokenizer.json")
+	require.NoError(t, err)
+	_, err = w.Write(tokenizerJson)
+	require.NoError(t, err)
+	require.NoError(t, zipWriter.Close())
+	require.NoError(t, f.Close())
+
+	_, err = tokenizers.FromArchive(archivePath, "tokenizer.json")
+	require.Error(t, err)
+
+	tk, err := tokenizers.FromArchive(archivePath, "./bert/tokenizer.json")
+	require.NoError(t, err)
+	defer tk.Finalize()
+	assert.Equal(t, uint32(30522), tk.VocabSize())
+}
+
+func TestVocabSizeAfterFinalize(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(30522), tk.VocabSize())
+	tk.Finalize()
+	assert.NotPanics(t, func() { assert.Equal(t, uint32(0), tk.VocabSize()) })
+	assert.Panics(t, func() { _, _ = tk.Encode("brown fox") })
+}
+
+func TestEncodeWithTypeIds(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	tk.AddSpecialTokens(true)
+
+	encoding, err := tk.EncodeWithTypeIds("brown fox", 3)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{101, 2829, 4419, 102}, encoding.TokenIds)
+	assert.Equal(t, []uint32{3, 3, 3, 3}, encoding.TypeIds)
+
+	// [CLS] brown fox [SEP] lazy dog [SEP]
+	encoding, err = tk.EncodePairWithTypeIds("brown fox", "lazy dog", []uint32{5, 7})
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{5, 5, 5, 5, 7, 7, 7}, encoding.TypeIds)
+
+	// Derived fields are filled as in Encode.
+	tk.ReturnFirstSubwordMask(true)
+	encoding, err = tk.EncodeWithTypeIds("brown fox", 3)
+	require.NoError(t, err)
+	assert.Equal(t, []bool{false, true, true, false}, encoding.FirstSubwordMask)
+	assert.Nil(t, encoding.WordIds)
+	encoding, err = tk.EncodePairWithTypeIds("brown fox", "lazy dog", []uint32{5, 7})
+	require.NoError(t, err)
+	assert.Equal(t, []bool{false, true, true, false, true, true, false}, encoding.FirstSubwordMask)
+	dst := make([]tokenizers.Encoding, 1)
+	require.NoError(t, tk.EncodeBatchInto([]string{"brown fox"}, dst))
+	assert.Equal(t, []bool{false, true, true, false}, dst[0].FirstSubwordMask)
+}
+
+func TestWithReturnFields(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	want, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer want.Finalize()
+
+	tk.WithReturnFields(tokenizers.ReturnFieldTypeIds | tokenizers.ReturnFieldOffsets)
+	want.ReturnTokens(false).ReturnTypeIds(true).ReturnOffsets(true)
+	assert.Equal(t, want.String(), tk.String())
+
+	tk.WithReturnFields(tokenizers.ReturnAllFields)
+	want.ReturnTokens(true).ReturnAttentionMask(true).ReturnSpecialTokensMask(true).ReturnWordIds(true).
+		ReturnSequenceIds(true).ReturnLengths(true).ReturnContinuationMask(true).ReturnFirstSubwordMask(true).
+		ReturnTokenHashes(true).ReturnBothOffsets(true).ReturnTokenKinds(true).ReturnDroppedTokens(true)
+	assert.Equal(t, want.String(), tk.String())
+	encoding, err := tk.Encode("brown fox")
+	require.NoError(t, err)
+	assert.Len(t, encoding.Tokens, 2)
+	assert.Len(t, encoding.TypeIds, 2)
+	assert.Len(t, encoding.AttentionMask, 2)
+	assert.Len(t, encoding.SpecialTokensMask, 2)
+	assert.Len(t, encoding.Offsets, 2)
+	assert.Len(t, encoding.WordIds, 2)
+	assert.Len(t, encoding.SequenceIds, 2)
+	assert.Equal(t, 2, encoding.Length)
+	assert.Len(t, encoding.ContinuationMask, 2)
+	assert.Len(t, encoding.FirstSubwordMask, 2)
+	assert.Len(t, encoding.TokenHashes, 2)
+	assert.Len(t, encoding.CharOffsets, 2)
+	assert.Len(t, encoding.TokenKinds, 2)
+
+	tk.WithReturnFields(0)
+	want.ReturnTokens(false).ReturnTypeIds(false).ReturnAttentionMask(false).ReturnSpecialTokensMask(false).
+		ReturnOffsets(false).ReturnWordIds(false).ReturnSequenceIds(false).ReturnLengths(false).
+		ReturnContinuationMask(false).ReturnFirstSubwordMask(false).ReturnTokenHashes(false).
+		ReturnBothOffsets(false).ReturnTokenKinds(false).ReturnDroppedTokens(false)
+	assert.Equal(t, want.String(), tk.String())
+}
+
+func TestTokenCounts(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	tk.WithPadToLongest() // Padding is not counted.
+	tk.WithTokenCountsChunkSize(2)
+
+	sentences := []string{"brown fox", "lazy dog", "brown dog", "the lazy brown fox jumps over the lazy dog"}
+	counts, err := tk.TokenCounts(sentences)
+	require.NoError(t, err)
+	assert.Equal(t, map[uint32]int{
+		2829:  3, // brown
+		4419:  2, // fox
+		13971: 3, // lazy
+		3899:  3, // dog
+		1996:  2, // the
+		14523: 1, // jumps
+		2058:  1, // over
+	}, counts)
+
+	// Accumulate more counts.
+	require.NoError(t, tk.TokenCountsInto(counts, []string{"fox"}))
+	assert.Equal(t, 3, counts[4419])
+}
+
+func TestRejectUnknown(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	const sentence = "brown \U0001F600 fox"
+
+	// By default, unknown tokens are accepted.
+	encoding, err := tk.Encode(sentence)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"brown", "[UNK]", "fox"}, encoding.Tokens)
+
+	tk.WithRejectUnknown(true)
+	_, err = tk.Encode(sentence)
+	require.Error(t, err)
+	var unkErr *tokenizers.UnknownTokenError
+	require.True(t, errors.As(err, &unkErr), "expected *UnknownTokenError, got %v", err)
+	assert.Equal(t, "\U0001F600", unkErr.Text)
+	assert.Equal(t, tokenizers.Offset{Start: 6, End: 7}, unkErr.Offset) // In Unicode code points.
+
+	_, err = tk.EncodeBatch([]string{"lazy dog", sentence})
+	require.True(t, errors.As(err, &unkErr), "expected *UnknownTokenError, got %v", err)
+
+	// Other encoding methods also reject unknown tokens.
+	_, err = tk.EncodeAuto(sentence)
+	require.True(t, errors.As(err, &unkErr), "expected *UnknownTokenError, got %v", err)
+	err = tk.EncodeBatchInto([]string{"lazy dog", sentence}, make([]tokenizers.Encoding, 2))
+	require.True(t, errors.As(err, &unkErr), "expected *UnknownTokenError, got %v", err)
+	_, err = tk.EncodeWithTypeIds(sentence, 1)
+	require.True(t, errors.As(err, &unkErr), "expected *UnknownTokenError, got %v", err)
+
+	// Known input: offsets not configured are not returned.
+	encoding, err = tk.Encode("lazy dog")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"lazy", "dog"}, encoding.Tokens)
+	assert.Nil(t, encoding.Offsets)
+}
+
+func TestNumMerges(t *testing.T) {
+	gpt2, err := tokenizers.FromBytes([]byte(gpt2LikeJson))
+	require.NoError(t, err)
+	defer gpt2.Finalize()
+	numMerges, ok := gpt2.NumMerges()
+	assert.True(t, ok)
+	assert.Equal(t, 1, numMerges)
+
+	bert, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer bert.Finalize()
+	_, ok = bert.NumMerges()
+	assert.False(t, ok)
+}
+
+func TestReload(t *testing.T) {
+	data, err := os.ReadFile(bertJson)
+	require.NoError(t, err)
+	tk, err := tokenizers.FromBytes(data)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	want := []uint32{13971, 3899}
+
+	// Concurrent encodes must either use the old or the new tokenizer, never a freed one.
+	const numEncoders = 4
+	done := make(chan struct{})
+	errs := make(chan error, numEncoders)
+	for ii := 0; ii < numEncoders; ii++ {
+		go func() {
+			for {
+				select {
+				case <-done:
+					errs <- nil
+					return
+				default:
+				}
+				encoding, err := tk.Encode("lazy dog")
+				if err != nil {
+					errs <- err
+					return
+				}
+				if !assert.Equal(t, want, encoding.TokenIds) {
+					errs <- nil
+					return
+				}
+			}
+		}()
+	}
+	for ii := 0; ii < 20; ii++ {
+		require.NoError(t, tk.Reload(data))
+	}
+	close(done)
+	for ii := 0; ii < numEncoders; ii++ {
+		require.NoError(t, <-errs)
+	}
+
+	// Invalid data leaves the Tokenizer unchanged.
+	require.Error(t, tk.Reload([]byte("{")))
+	encoding, err := tk.Encode("lazy dog")
+	require.NoError(t, err)
+	assert.Equal(t, want, encoding.TokenIds)
+}
+
+func TestContinuationMask(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	tk.AddSpecialTokens(true).ReturnTokens(false).ReturnContinuationMask(true)
+
+	// Tokens: "[CLS]", "new", "york", "oh", "##ne", "ka", "##se", "!", "[SEP]"
+	encoding, err := tk.Encode("New York ohne Käse!")
+	require.NoError(t, err)
+	assert.Equal(t, []bool{false, false, false, false, true, false, true, false, false}, encoding.ContinuationMask)
+	assert.Nil(t, encoding.Tokens)
+	assert.Nil(t, encoding.SpecialTokensMask)
+
+	// Not returned by default.
+	tk.ReturnContinuationMask(false)
+	encoding, err = tk.Encode("New York ohne Käse!")
+	require.NoError(t, err)
+	assert.Nil(t, encoding.ContinuationMask)
+}
+
+func TestConsumedBytes(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	const sentence = "brown fox jumps over the lazy dog"
+
+	// Not capped: the whole input is consumed.
+	encoding, err := tk.Encode(sentence)
+	require.NoError(t, err)
+	assert.Equal(t, len(sentence), encoding.ConsumedBytes)
+
+	tk.WithMaxInputBytes(9)
+	encodings, err := tk.EncodeBatch([]string{sentence, "lazy dog"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"brown", "fox"}, encodings[0].Tokens)
+	assert.Equal(t, 9, encodings[0].ConsumedBytes)
+	assert.Equal(t, []string{"lazy", "dog"}, encodings[1].Tokens)
+	assert.Equal(t, 8, encodings[1].ConsumedBytes)
+	assert.True(t, encodings[0].InputTruncated)
+	assert.False(t, encodings[1].InputTruncated)
+
+	// Resume from where it stopped.
+	encoding, err = tk.Encode(sentence[encodings[0].ConsumedBytes:])
+	require.NoError(t, err)
+	assert.Equal(t, "jumps", encoding.Tokens[0])
+	assert.Equal(t, 9, encoding.ConsumedBytes)
+
+	// The cap falls in the middle of "ä" (2 bytes), so it stops before it.
+	tk.WithMaxInputBytes(2)
+	encoding, err = tk.Encode("Käse")
+	require.NoError(t, err)
+	assert.Equal(t, 1, encoding.ConsumedBytes)
+	assert.Equal(t, []string{"k"}, encoding.Tokens)
+}
+
+func TestBatchSummary(t *testing.T) {
+	tk := &tokenizers.Tokenizer{}
+	encodings := []tokenizers.Encoding{
+		{TokenIds: []uint32{101, 1, 2, 102}, AttentionMask: []uint32{1, 1, 1, 1}},
+		{TokenIds: []uint32{101, 1, 102, 0}, AttentionMask: []uint32{1, 1, 1, 0}},
+		{TokenIds: []uint32{101, 102, 0, 0}, AttentionMask: []uint32{1, 1, 0, 0}},
+	}
+	assert.Equal(t, "batch=3 minLen=2 maxLen=4 meanLen=3.0 padded=4 paddingTokens=3 totalTokens=12",
+		tk.BatchSummary(encodings))
+	assert.Equal(t, "batch=0", tk.BatchSummary(nil))
+}
+
+func TestAddTokensWithSpecs(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	vocabSize := tk.VocabSize()
+
+	added := tk.AddTokensWithSpecs([]tokenizers.AddedTokenSpec{
+		{Content: "xyzzy", SingleWord: true, Normalized: true},
+		{Content: "qwqw", Normalized: true},
+	})
+	require.Equal(t, 2, added)
+	singleWordId, anywhereId := vocabSize, vocabSize+1
+
+	// Matched as a whole word.
+	encoding, err := tk.Encode("the xyzzy qwqw")
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{1996, singleWordId, anywhereId}, encoding.TokenIds)
+
+	// The single-word token doesn't match inside another word, the other one does.
+	encoding, err = tk.Encode("abcxyzzy abcqwqw")
+	require.NoError(t, err)
+	assert.NotContains(t, encoding.TokenIds, singleWordId)
+	assert.Contains(t, encoding.TokenIds, anywhereId)
+
+	// Adding again doesn't add anything.
+	assert.Equal(t, 0, tk.AddTokens([]string{"xyzzy"}))
+}
+
+func TestFromBytesCtx(t *testing.T) {
+	data, err := os.ReadFile(bertJson)
+	require.NoError(t, err)
+
+	// Cancelling the context finalizes the tokenizer.
+	ctx, cancel := context.WithCancel(context.Background())
+	tk, err := tokenizers.FromBytesCtx(ctx, data)
+	require.NoError(t, err)
+	_, err = tk.Encode("brown fox")
+	require.NoError(t, err)
+	allocs := rs.CountTokenizerAllocs.Load()
+	cancel()
+	require.Eventually(t, func() bool { return rs.CountTokenizerAllocs.Load() < allocs },
+		time.Second, time.Millisecond)
+	assert.Panics(t, func() { _, _ = tk.Encode("brown fox") })
+
+	// Finalizing before the context is done is fine.
+	ctx, cancel = context.WithCancel(context.Background())
+	tk, err = tokenizers.FromBytesCtx(ctx, data)
+	require.NoError(t, err)
+	tk.Finalize()
+	cancel()
+
+	// Context already done.
+	_, err = tokenizers.FromBytesCtx(ctx, data)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestSaveToFile(t *testing.T) {
+	tk, err := tokenizers.FromBytes([]byte(gpt2LikeJson))
+	require.NoError(t, err)
+	defer tk.Finalize()
+	require.Equal(t, 1, tk.AddTokens([]string{"xyzzy"}))
+	tk.WithPadToLength(8)
+	wantId, found := tk.TokenToId("xyzzy")
+	require.True(t, found)
+
+	// Round-trip through ToBytes.
+	data, err := tk.ToBytes()
+	require.NoError(t, err)
+	tk2, err := tokenizers.FromBytes(data)
+	require.NoError(t, err)
+	defer tk2.Finalize()
+	id, found := tk2.TokenToId("xyzzy")
+	require.True(t, found)
+	assert.Equal(t, wantId, id)
+
+	// Round-trip through SaveToFile.
+	filePath := path.Join(t.TempDir(), "tokenizer.json")
+	require.NoError(t, tk.SaveToFile(filePath))
+	tk3, err := tokenizers.FromFile(filePath)
+	require.NoError(t, err)
+	defer tk3.Finalize()
+	id, found = tk3.TokenToId("xyzzy")
+	require.True(t, found)
+	assert.Equal(t, wantId, id)
+	encoding, err := tk3.Encode("xyzzy")
+	require.NoError(t, err)
+	assert.Len(t, encoding.TokenIds, 8)
+}
+
+func TestVocabSizeWithAddedTokens(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	assert.Equal(t, uint32(30522), tk.BaseVocabSize())
+	assert.Equal(t, uint32(30522), tk.VocabSizeWithAddedTokens())
+
+	require.Equal(t, 2, tk.AddTokens([]string{"xyzzy", "qwqw"}))
+	assert.Equal(t, uint32(30522), tk.BaseVocabSize())
+	assert.Equal(t, uint32(30524), tk.VocabSizeWithAddedTokens())
+	assert.Equal(t, tk.VocabSizeWithAddedTokens(), tk.VocabSize())
+}
+
+func TestAddSpecialTokensToVocab(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	vocabSize := tk.VocabSize()
+
+	require.Equal(t, 2, tk.AddSpecialTokensToVocab([]string{"<user>", "<assistant>"}))
+	assert.Equal(t, vocabSize+2, tk.VocabSize())
+	userId, assistantId := vocabSize, vocabSize+1
+
+	// Each is encoded as a single id, marked as special.
+	tk.AddSpecialTokens(true).ReturnSpecialTokensMask(true)
+	encoding, err := tk.Encode("<user> fox <assistant>")
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{101, userId, 4419, assistantId, 102}, encoding.TokenIds)
+	assert.Equal(t, []uint32{1, 1, 0, 1, 1}, encoding.SpecialTokensMask)
+
+	// Skipped when decoding if requested.
+	assert.Equal(t, "fox", tk.Decode(encoding.TokenIds, true))
+	assert.Contains(t, tk.Decode(encoding.TokenIds, false), "<user>")
+
+	// Adding again doesn't add anything.
+	assert.Equal(t, 0, tk.AddSpecialTokensToVocab([]string{"<user>"}))
+	assert.Equal(t, 0, tk.AddTokens([]string{"<assistant>"}))
+}
+
+func TestWriteVocab(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+
+	// The line numbers of vocab.txt must match the ids of the vocabulary in the tokenizer definition.
+	var buf bytes.Buffer
+	require.NoError(t, tk.WriteVocab(&buf, tokenizers.VocabFormatTxt))
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, int(tk.VocabSize()))
+	contents, err := os.ReadFile(bertJson)
+	require.NoError(t, err)
+	var definition struct {
+		Model struct {
+			Vocab map[string]int `json:"vocab"`
+		} `json:"model"`
+	}
+	require.NoError(t, json.Unmarshal(contents, &definition))
+	for token, id := range definition.Model.Vocab {
+		require.Equalf(t, token, lines[id], "token of id %d", id)
+	}
+
+	buf.Reset()
+	require.NoError(t, tk.WriteVocab(&buf, tokenizers.VocabFormatTSV))
+	assert.Contains(t, buf.String(), "[CLS]\t101\n")
+}
+
+// llamaLikeJson is a minimal BPE tokenizer with byte fallback, as used by Llama.
+const llamaLikeJson = `{
+  "version": "1.0", "truncation": null, "padding": null, "normalizer": null, "pre_tokenizer": null,
+  "added_tokens": [{"id": 0, "content": "<unk>", "single_word": false, "lstrip": false, "rstrip": false,
+    "normalized": false, "special": true}],
+  "post_processor": null,
+  "decoder": {"type": "Sequence", "decoders": [
+    {"type": "Replace", "pattern": {"String": "\u2581"}, "content": " "},
+    {"type": "ByteFallback"}, {"type": "Fuse"}]},
+  "model": {"type": "BPE", "dropout": null, "unk_token": "<unk>", "continuing_subword_prefix": null,
+    "end_of_word_suffix": null, "fuse_unk": true, "byte_fallback": true,
+    "vocab": {"<unk>": 0, "<0x41>": 1, "<0xC3>": 2, "<0xA9>": 3, "\u2581": 4, "c": 5, "a": 6, "f": 7},
+    "merges": []}
+}`
+
+func TestDecodeByteFallback(t *testing.T) {
+	tk, err := tokenizers.FromBytes([]byte(llamaLikeJson))
+	require.NoError(t, err)
+	defer tk.Finalize()
+
+	// Consecutive byte tokens are reassembled into their UTF-8 character.
+	assert.Equal(t, "é", tk.Decode([]uint32{2, 3}, false))
+	assert.Equal(t, "café", tk.Decode([]uint32{5, 6, 7, 2, 3}, false))
+	assert.Equal(t, "A", tk.Decode([]uint32{1}, false))
+
+	// Round trip: characters not in the vocabulary are encoded as byte tokens.
+	encoding, err := tk.Encode("café")
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{5, 6, 7, 2, 3}, encoding.TokenIds)
+}
+
+func TestAdditiveAttentionMask(t *testing.T) {
+	encodings := []tokenizers.Encoding{
+		{TokenIds: []uint32{101, 2829, 102}, AttentionMask: []uint32{1, 1, 1}},
+		{TokenIds: []uint32{101, 102, 0}, AttentionMask: []uint32{1, 1, 0}},
+	}
+	mask, shape, err := tokenizers.AdditiveAttentionMask[float32](encodings)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 1, 1, 3}, shape)
+	assert.Equal(t, []float32{0, 0, 0, 0, 0, -math.MaxFloat32}, mask)
+
+	mask64, _, err := tokenizers.AdditiveAttentionMask[float64](encodings)
+	require.NoError(t, err)
+	assert.Equal(t, []float64{0, 0, 0, 0, 0, -math.MaxFloat64}, mask64)
+
+	// Unpadded batch.
+	encodings[1].TokenIds, encodings[1].AttentionMask = encodings[1].TokenIds[:2], encodings[1].AttentionMask[:2]
+	_, _, err = tokenizers.AdditiveAttentionMask[float32](encodings)
+	require.Error(t, err)
+
+	// Attention mask not returned.
+	_, _, err = tokenizers.AdditiveAttentionMask[float32]([]tokenizers.Encoding{{TokenIds: []uint32{101}}})
+	require.Error(t, err)
+}
+
+func TestEncodeBatchDedup(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	tk.AddSpecialTokens(true).WithPadToLongest()
+	sentences := []string{"brown fox", "lazy dog", "brown fox", "brown fox jumps", "lazy dog"}
+	want, err := tk.EncodeBatch(sentences)
+	require.NoError(t, err)
+
+	encodings, err := tk.EncodeBatchDedup(sentences)
+	require.NoError(t, err)
+	require.Len(t, encodings, len(sentences))
+	assert.Equal(t, want, encodings)
+	assert.Equal(t, encodings[0], encodings[2])
+	assert.Equal(t, encodings[1], encodings[4])
+
+	// Shared slices by default.
+	encodings[0].TokenIds[1] = 0
+	assert.Equal(t, uint32(0), encodings[2].TokenIds[1])
+
+	// Independent copies.
+	tk.WithDedupCopies(true)
+	encodings, err = tk.EncodeBatchDedup(sentences)
+	require.NoError(t, err)
+	encodings[0].TokenIds[1] = 0
+	assert.Equal(t, want[2].TokenIds, encodings[2].TokenIds)
+
+	// Errors refer to the index of the sentence in the original batch.
+	tk.WithRejectUnknown(true)
+	_, err = tk.EncodeBatchDedup([]string{"brown fox", "brown fox", "lazy \U0001F600"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "sentence #2")
+}
+
+func TestPaddedLengthFor(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	sentences := []string{"lazy dog", "brown fox jumps over the lazy dog"} // 2 and 7 tokens.
+
+	length, err := tk.PaddedLengthFor(sentences)
+	require.NoError(t, err)
+	assert.Equal(t, 7, length)
+
+	tk.WithPadToLongest().WithPaddingToMultipleOf(8)
+	length, err = tk.PaddedLengthFor(sentences)
+	require.NoError(t, err)
+	assert.Equal(t, 8, length)
+
+	// Matches the length of the actual encodings.
+	encodings, err := tk.EncodeBatch(sentences)
+	require.NoError(t, err)
+	assert.Len(t, encodings[0].TokenIds, length)
+
+	tk.WithPadToLength(12)
+	length, err = tk.PaddedLengthFor(sentences)
+	require.NoError(t, err)
+	assert.Equal(t, 16, length)
+}
+
+func TestEncodeBatchToArrays(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	tk.AddSpecialTokens(true)
+	sentences := []string{"brown fox", "lazy dog jumps"}
+
+	// Padding not configured: padded on the right to the longest.
+	ids, typeIds, attentionMask, batch, seqLen, err := tk.EncodeBatchToArrays(sentences)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 5}, []int{batch, seqLen})
+	assert.Equal(t, []uint32{101, 2829, 4419, 102, 0, 101, 13971, 3899, 14523, 102}, ids)
+	assert.Equal(t, make([]uint32, 10), typeIds)
+	assert.Equal(t, []uint32{1, 1, 1, 1, 0, 1, 1, 1, 1, 1}, attentionMask)
+
+	// Fixed length, on the left.
+	tk.WithPadToLength(6).WithPaddingDirection(tokenizers.Left)
+	ids, _, attentionMask, batch, seqLen, err = tk.EncodeBatchToArrays(sentences)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 6}, []int{batch, seqLen})
+	assert.Equal(t, []uint32{0, 0, 101, 2829, 4419, 102, 0, 101, 13971, 3899, 14523, 102}, ids)
+	assert.Equal(t, []uint32{0, 0, 1, 1, 1, 1, 0, 1, 1, 1, 1, 1}, attentionMask)
+}
+
+func TestBPEDropout(t *testing.T) {
+	tk, err := tokenizers.FromBytes([]byte(gpt2LikeJson))
+	require.NoError(t, err)
+	defer tk.Finalize()
+	encodeAll := func() map[string]int {
+		seen := make(map[string]int)
+		for ii := 0; ii < 100; ii++ {
+			encoding, err := tk.Encode("ab")
+			require.NoError(t, err)
+			seen[strings.Join(encoding.Tokens, " ")]++
+		}
+		return seen
+	}
+
+	// No dropout: always merged.
+	assert.Equal(t, map[string]int{"ab": 100}, encodeAll())
+
+	// With dropout both tokenizations show up.
+	tk.WithBPEDropout(0.5)
+	seen := encodeAll()
+	assert.Len(t, seen, 2)
+	assert.Contains(t, seen, "ab")
+	assert.Contains(t, seen, "a b")
+
+	// Disabled again.
+	tk.WithBPEDropout(0)
+	assert.Equal(t, map[string]int{"ab": 100}, encodeAll())
+
+	// Only BPE models support dropout.
+	bert, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer bert.Finalize()
+	assert.Panics(t, func() { bert.WithBPEDropout(0.1) })
+}
+
+func TestBPEDropoutSeed(t *testing.T) {
+	encodeAll := func(tk *tokenizers.Tokenizer) []string {
+		var results []string
+		for ii := 0; ii < 50; ii++ {
+			encoding, err := tk.Encode("ab")
+			require.NoError(t, err)
+			results = append(results, strings.Join(encoding.Tokens, " "))
+		}
+		encodings, err := tk.EncodeBatch([]string{"ab", "ab", "ab", "ab"})
+		require.NoError(t, err)
+		for _, encoding := range encodings {
+			results = append(results, strings.Join(encoding.Tokens, " "))
+		}
+		return results
+	}
+	newTokenizer := func(seed uint64) *tokenizers.Tokenizer {
+		tk, err := tokenizers.FromBytes([]byte(gpt2LikeJson))
+		require.NoError(t, err)
+		return tk.WithBPEDropout(0.5).WithSeed(seed)
+	}
+
+	// Same seed, same sequence of encodings, with both tokenizations showing up.
+	tk1, tk2 := newTokenizer(42), newTokenizer(42)
+	defer tk1.Finalize()
+	defer tk2.Finalize()
+	want := encodeAll(tk1)
+	assert.Equal(t, want, encodeAll(tk2))
+	assert.Contains(t, want, "ab")
+	assert.Contains(t, want, "a b")
+
+	// Seeding again restarts the sequence.
+	tk1.WithSeed(42)
+	assert.Equal(t, want, encodeAll(tk1))
+
+	// A different seed gives a different sequence.
+	tk3 := newTokenizer(7)
+	defer tk3.Finalize()
+	assert.NotEqual(t, want, encodeAll(tk3))
+}
+
+func TestFromBytesStrict(t *testing.T) {
+	contents, err := os.ReadFile(bertJson)
+	require.NoError(t, err)
+	tk, err := tokenizers.FromBytesStrict(contents)
+	require.NoError(t, err)
+	tk.Finalize()
+
+	// Padding with a "[PAD]" id out of range.
+	var definition map[string]any
+	require.NoError(t, json.Unmarshal(contents, &definition))
+	definition["padding"] = map[string]any{
+		"strategy": "BatchLongest", "direction": "Right", "pad_to_multiple_of": nil,
+		"pad_id": 99999, "pad_type_id": 0, "pad_token": "[PAD]",
+	}
+	mismatched, err := json.Marshal(definition)
+	require.NoError(t, err)
+	_, err = tokenizers.FromBytesStrict(mismatched)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `"[PAD]"`)
+	assert.Contains(t, err.Error(), "99999")
+
+	// Not validated by default.
+	tk, err = tokenizers.FromBytes(mismatched)
+	require.NoError(t, err)
+	tk.Finalize()
+}
+
+func TestFromBytesLenient(t *testing.T) {
+	// Leading BOM and trailing whitespace are ignored.
+	data := append([]byte("\uFEFF"), []byte(gpt2LikeJson+"\n\r\n  ")...)
+	tk, err := tokenizers.FromBytes(data)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	assert.Equal(t, uint32(3), tk.VocabSize())
+
+	// Errors include the offending content.
+	_, err = tokenizers.FromBytes([]byte(`{"version": "1.0", "model": {"type": "BPE",, }}`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid JSON at byte")
+	assert.Contains(t, err.Error(), `\"BPE\",,`)
+}
+
+// cancelAfterContext is a context that reports being cancelled after Err is called numChecks times.
+type cancelAfterContext struct {
+	context.Context
+	numChecks int
+}
+
+func (c *cancelAfterContext) Err() error {
+	if c.numChecks <= 0 {
+		return context.Canceled
+	}
+	c.numChecks--
+	return nil
+}
+
+func TestEncodeBatchContext(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	tk.WithEncodeBatchChunkSize(2)
+	sentences := []string{"brown fox", "lazy dog", "jumps over", "the lazy dog", "fox"}
+
+	encodings, err := tk.EncodeBatchContext(context.Background(), sentences)
+	require.NoError(t, err)
+	require.Len(t, encodings, len(sentences))
+	assert.Equal(t, []uint32{2829, 4419}, encodings[0].TokenIds)
+	assert.Equal(t, []uint32{13971, 3899}, encodings[1].TokenIds)
+	assert.Equal(t, []uint32{4419}, encodings[4].TokenIds)
+
+	// Cancelled after the first chunk: partial work is discarded.
+	encodings, err = tk.EncodeBatchContext(&cancelAfterContext{Context: context.Background(), numChecks: 1}, sentences)
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Contains(t, err.Error(), "cancelled after encoding 2 of 5 sentences")
+	assert.Nil(t, encodings)
+
+	// The deprecated EncodeBatchCtx is the same.
+	encodings, err = tk.EncodeBatchCtx(context.Background(), sentences)
+	require.NoError(t, err)
+	require.Len(t, encodings, len(sentences))
+	_, err = tk.EncodeBatchCtx(&cancelAfterContext{Context: context.Background(), numChecks: 1}, sentences)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestEncodeBatchWithProgress(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	tk.WithEncodeBatchChunkSize(2)
+	sentences := []string{"brown fox", "lazy dog", "jumps over", "the lazy dog", "fox"}
+
+	var dones []int
+	encodings, err := tk.EncodeBatchWithProgress(sentences, func(done, total int) {
+		assert.Equal(t, len(sentences), total)
+		dones = append(dones, done)
+	})
+	require.NoError(t, err)
+	require.Len(t, encodings, len(sentences))
+	assert.Equal(t, []uint32{13971, 3899}, encodings[1].TokenIds)
+	assert.Equal(t, []uint32{4419}, encodings[4].TokenIds)
+	assert.Equal(t, []int{2, 4, 5}, dones)
+}
+
+func TestEncodeBatchChunkedPadding(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	tk.WithEncodeBatchChunkSize(2)
+	sentences := []string{"brown fox", "lazy dog", "jumps over", "the lazy dog", "fox"}
+
+	// Chunks are padded to the longest sentence of all chunks, as EncodeBatch does.
+	for _, direction := range []tokenizers.Direction{tokenizers.Right, tokenizers.Left} {
+		tk.AddSpecialTokens(true).WithPadToLongest().WithPaddingDirection(direction).
+			ReturnTokens(true).ReturnTypeIds(true).ReturnAttentionMask(true).ReturnSpecialTokensMask(true).
+			ReturnOffsets(true).ReturnWordIds(true).ReturnTokenKinds(true)
+		want, err := tk.EncodeBatch(sentences)
+		require.NoError(t, err)
+		got, err := tk.EncodeBatchContext(context.Background(), sentences)
+		require.NoError(t, err)
+		assert.Equal(t, want, got, "direction=%s", direction)
+		got, err = tk.EncodeBatchWithProgress(sentences, func(done, total int) {})
+		require.NoError(t, err)
+		assert.Equal(t, want, got, "direction=%s", direction)
+	}
+}
+
+func TestEncodeStream(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	tk.WithEncodeStreamChunkSize(2)
+	corpus := "brown fox\nlazy dog\r\njumps over\nthe lazy dog\nfox"
+
+	var lineNums []int
+	var tokenIds [][]uint32
+	err = tk.EncodeStream(strings.NewReader(corpus), func(lineNum int, enc *tokenizers.Encoding) error {
+		lineNums = append(lineNums, lineNum)
+		tokenIds = append(tokenIds, enc.TokenIds)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, lineNums)
+	assert.Equal(t, []uint32{13971, 3899}, tokenIds[1])
+	assert.Equal(t, []uint32{4419}, tokenIds[4])
+
+	// The first error of the callback stops it.
+	errStop := errors.New("stop")
+	lineNums = nil
+	err = tk.EncodeStream(strings.NewReader(corpus), func(lineNum int, enc *tokenizers.Encoding) error {
+		lineNums = append(lineNums, lineNum)
+		if lineNum == 3 {
+			return errStop
+		}
+		return nil
+	})
+	require.ErrorIs(t, err, errStop)
+	assert.Equal(t, []int{1, 2, 3}, lineNums)
+
+	// Lines too long.
+	tk.WithEncodeStreamMaxLineSize(10)
+	lineNums = nil
+	err = tk.EncodeStream(strings.NewReader(corpus), func(lineNum int, enc *tokenizers.Encoding) error {
+		lineNums = append(lineNums, lineNum)
+		return nil
+	})
+	require.ErrorIs(t, err, bufio.ErrTooLong)
+	assert.Contains(t, err.Error(), "reading line 3")
+	assert.Equal(t, []int{1, 2}, lineNums)
+}
+
+func TestEncodePair(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	tk.AddSpecialTokens(true)
+
+	encoding, err := tk.EncodePair("brown fox jumps", "lazy dog")
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{101, 2829, 4419, 14523, 102, 13971, 3899, 102}, encoding.TokenIds)
+	assert.Equal(t, []uint32{0, 0, 0, 0, 0, 1, 1, 1}, encoding.TypeIds)
+
+	// Truncating only the second sentence.
+	tk.WithTruncation(7).WithTruncationDirection(tokenizers.Right).
+		WithTruncationStrategy(tokenizers.TruncateOnlySecond)
+	encoding, err = tk.EncodePair("brown fox jumps", "lazy dog")
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{101, 2829, 4419, 14523, 102, 13971, 102}, encoding.TokenIds)
+	assert.Equal(t, []uint32{0, 0, 0, 0, 0, 1, 1}, encoding.TypeIds)
+
+	// Truncating only the first sentence.
+	tk.WithTruncationStrategy(tokenizers.TruncateOnlyFirst)
+	encoding, err = tk.EncodePair("brown fox jumps", "lazy dog")
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{101, 2829, 4419, 102, 13971, 3899, 102}, encoding.TokenIds)
+	assert.Equal(t, []uint32{0, 0, 0, 0, 1, 1, 1}, encoding.TypeIds)
+}
+
+func TestReturnLengths(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	tk.AddSpecialTokens(true).WithPadToLength(10)
+
+	encoding, err := tk.Encode("brown fox")
+	require.NoError(t, err)
+	require.Len(t, encoding.TokenIds, 10)
+	assert.Equal(t, 0, encoding.Length)
+
+	tk.ReturnLengths(true)
+	encoding, err = tk.Encode("brown fox")
+	require.NoError(t, err)
+	require.Len(t, encoding.TokenIds, 10)
+	assert.Equal(t, 4, encoding.Length) // [CLS] brown fox [SEP]
+
+	encodings, err := tk.EncodeBatch([]string{"brown fox jumps", "lazy dog"})
+	require.NoError(t, err)
+	assert.Equal(t, 5, encodings[0].Length)
+	assert.Equal(t, 4, encodings[1].Length)
+}
+
+func TestSequenceIds(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	tk.AddSpecialTokens(true)
+
+	// Not returned by default.
+	encoding, err := tk.EncodePair("brown fox", "lazy dog")
+	require.NoError(t, err)
+	assert.Empty(t, encoding.SequenceIds)
+
+	tk.ReturnSequenceIds(true)
+	encoding, err = tk.EncodePair("brown fox", "lazy dog")
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{101, 2829, 4419, 102, 13971, 3899, 102}, encoding.TokenIds)
+	assert.Equal(t, []int32{-1, 0, 0, -1, 1, 1, -1}, encoding.SequenceIds)
+
+	// Single sentences only have sequence 0.
+	encoding, err = tk.Encode("brown fox")
+	require.NoError(t, err)
+	assert.Equal(t, []int32{-1, 0, 0, -1}, encoding.SequenceIds)
+}
+
+func TestPipeline(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	pipeline := tk.Pipeline()
+	assert.Equal(t, []string{"BertNormalizer"}, pipeline.Normalizers)
+	assert.Equal(t, []string{"BertPreTokenizer"}, pipeline.PreTokenizers)
+
+	// Sequences are flattened, in order.
+	contents, err := os.ReadFile(bertJson)
+	require.NoError(t, err)
+	var definition map[string]any
+	require.NoError(t, json.Unmarshal(contents, &definition))
+	definition["normalizer"] = map[string]any{"type": "Sequence", "normalizers": []any{
+		map[string]any{"type": "NFD"}, map[string]any{"type": "Lowercase"}, map[string]any{"type": "StripAccents"}}}
+	definition["pre_tokenizer"] = map[string]any{"type": "Sequence", "pretokenizers": []any{
+		map[string]any{"type": "Whitespace"}, map[string]any{"type": "Punctuation", "behavior": "Isolated"}}}
+	contents, err = json.Marshal(definition)
+	require.NoError(t, err)
+	tk2, err := tokenizers.FromBytes(contents)
+	require.NoError(t, err)
+	defer tk2.Finalize()
+	pipeline = tk2.Pipeline()
+	assert.Equal(t, []string{"NFD", "Lowercase", "StripAccents"}, pipeline.Normalizers)
+	assert.Equal(t, []string{"Whitespace", "Punctuation"}, pipeline.PreTokenizers)
+	assert.Equal(t, "normalizers: NFD → Lowercase → StripAccents; pre-tokenizers: Whitespace → Punctuation",
+		pipeline.String())
+}
+
+func TestByteLevelAlphabet(t *testing.T) {
+	gpt2, err := tokenizers.FromBytes([]byte(gpt2LikeJson))
+	require.NoError(t, err)
+	defer gpt2.Finalize()
+	alphabet, ok := gpt2.ByteLevelAlphabet()
+	require.True(t, ok)
+	require.Len(t, alphabet, 256)
+	assert.Equal(t, '\u0120', alphabet[' '])
+	assert.Equal(t, '\u0100', alphabet[0])
+	assert.Equal(t, '\u010a', alphabet['\n'])
+	assert.Equal(t, 'a', alphabet['a'])
+	assert.Equal(t, '\u00e9', alphabet[0xe9])
+	assert.Equal(t, '\u0143', alphabet[0xad]) // Soft hyphen, the last non-printable byte.
+	runes := make(map[rune]bool)
+	for _, r := range alphabet {
+		runes[r] = true
+	}
+	assert.Len(t, runes, 256)
+
+	bert, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer bert.Finalize()
+	_, ok = bert.ByteLevelAlphabet()
+	assert.False(t, ok)
+}
+
+func TestAddsPrefixSpace(t *testing.T) {
+	// RoBERTa-style: ByteLevel pre-tokenizer adding a prefix space.
+	roberta, err := tokenizers.FromBytes([]byte(strings.Replace(gpt2LikeJson,
+		`"pre_tokenizer": {"type": "ByteLevel", "add_prefix_space": false`,
+		`"pre_tokenizer": {"type": "ByteLevel", "add_prefix_space": true`, 1)))
+	require.NoError(t, err)
+	defer roberta.Finalize()
+	value, applicable := roberta.AddsPrefixSpace()
+	assert.True(t, applicable)
+	assert.True(t, value)
+
+	gpt2, err := tokenizers.FromBytes([]byte(gpt2LikeJson))
+	require.NoError(t, err)
+	defer gpt2.Finalize()
+	value, applicable = gpt2.AddsPrefixSpace()
+	assert.True(t, applicable)
+	assert.False(t, value)
+
+	// Not applicable to BERT.
+	bert, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer bert.Finalize()
+	_, applicable = bert.AddsPrefixSpace()
+	assert.False(t, applicable)
+}
+
+func TestFirstSubwordMask(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	tk.AddSpecialTokens(true).ReturnFirstSubwordMask(true)
+
+	// "unhappiness" is split in subwords: only the first is marked.
+	encoding, err := tk.Encode("unhappiness")
+	require.NoError(t, err)
+	numTokens := len(encoding.TokenIds)
+	require.Greater(t, numTokens, 3, "expected [CLS], subwords of \"unhappiness\" and [SEP], got %q", encoding.Tokens)
+	want := make([]bool, numTokens)
+	want[1] = true
+	assert.Equal(t, want, encoding.FirstSubwordMask)
+	assert.Nil(t, encoding.WordIds)
+
+	// Tokens: "[CLS]", "new", "york", "oh", "##ne", "ka", "##se", "!", "[SEP]"
+	encoding, err = tk.Encode("New York ohne Käse!")
+	require.NoError(t, err)
+	assert.Equal(t, []bool{false, true, true, true, false, true, false, true, false}, encoding.FirstSubwordMask)
+}
+
+func TestReturnBothOffsets(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	tk.AddSpecialTokens(true).ReturnTokens(true).ReturnBothOffsets(true)
+	assert.Contains(t, tk.String(), "ReturnBothOffsets=true")
+
+	// Offsets and CharOffsets must select the same text.
+	checkOffsets := func(encoding *tokenizers.Encoding, texts ...string) {
+		require.Len(t, encoding.Offsets, len(encoding.TokenIds))
+		require.Len(t, encoding.CharOffsets, len(encoding.TokenIds))
+		for ii, offset := range encoding.Offsets {
+			text := texts[0]
+			if len(encoding.SequenceIds) > 0 && encoding.SequenceIds[ii] == 1 {
+				text = texts[1]
+			}
+			charOffset := encoding.CharOffsets[ii]
+			assert.Equal(t, text[offset.Start:offset.End], string([]rune(text)[charOffset.Start:charOffset.End]),
+				"token #%d %q", ii, encoding.Tokens[ii])
+		}
+	}
+	const sentence = "Ohne K\u00e4se, ohne mich!"
+	encoding, err := tk.Encode(sentence)
+	require.NoError(t, err)
+	checkOffsets(encoding, sentence)
+	assert.Empty(t, encoding.SequenceIds) // Only requested internally.
+	last := len(encoding.Offsets) - 2     // Before [SEP].
+	assert.Equal(t, tokenizers.Offset{Start: 21, End: 22}, encoding.Offsets[last])
+	assert.Equal(t, tokenizers.Offset{Start: 20, End: 21}, encoding.CharOffsets[last])
+
+	// Offsets are in bytes, regardless of the char mode.
+	tk.ReturnOffsets(true).WithOffsetsCharMode(tokenizers.OffsetsCharModeUnicode)
+	encoding, err = tk.Encode(sentence)
+	require.NoError(t, err)
+	assert.Equal(t, tokenizers.Offset{Start: 21, End: 22}, encoding.Offsets[last])
+
+	encodings, err := tk.EncodeBatch([]string{sentence, "K\u00e4se"})
+	require.NoError(t, err)
+	checkOffsets(&encodings[0], sentence)
+	checkOffsets(&encodings[1], "K\u00e4se")
+
+	encoding, err = tk.ReturnSequenceIds(true).EncodePair("K\u00e4se", sentence)
+	require.NoError(t, err)
+	checkOffsets(encoding, "K\u00e4se", sentence)
+}
+
+func TestTokenHashes(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	tk.ReturnTokenHashes(true)
+
+	before, err := tk.Encode("brown fox jumps over the lazy dog")
+	require.NoError(t, err)
+	assert.Nil(t, before.Tokens)
+	require.Len(t, before.TokenHashes, len(before.TokenIds))
+	// Same token, same hash.
+	again, err := tk.Encode("the brown fox")
+	require.NoError(t, err)
+	assert.Equal(t, before.TokenHashes[4], again.TokenHashes[0])
+
+	// Editing one word only changes its token hash.
+	after, err := tk.Encode("brown fox jumps over the happy dog")
+	require.NoError(t, err)
+	require.Len(t, after.TokenHashes, len(before.TokenHashes))
+	for ii := range before.TokenHashes {
+		if ii == 5 {
+			assert.NotEqual(t, before.TokenHashes[ii], after.TokenHashes[ii])
+		} else {
+			assert.Equal(t, before.TokenHashes[ii], after.TokenHashes[ii], "token #%d", ii)
+		}
+	}
+}
+
+func TestTokenKinds(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	tk.AddSpecialTokens(true).ReturnTokenKinds(true)
+	require.Equal(t, 1, tk.AddTokens([]string{"xyzzy"}))
+	require.Equal(t, 1, tk.AddSpecialTokensToVocab([]string{"<user>"}))
+
+	encoding, err := tk.Encode("<user> brown xyzzy fox")
+	require.NoError(t, err)
+	assert.Nil(t, encoding.SpecialTokensMask)
+	assert.Equal(t, []tokenizers.TokenKind{tokenizers.TokenKindSpecial, tokenizers.TokenKindSpecial,
+		tokenizers.TokenKindNormal, tokenizers.TokenKindAdded, tokenizers.TokenKindNormal,
+		tokenizers.TokenKindSpecial}, encoding.TokenKinds)
+	assert.Equal(t, "TokenKindAdded", encoding.TokenKinds[3].String())
+
+	tk.ReturnTokenKinds(false)
+	encoding, err = tk.Encode("brown xyzzy fox")
+	require.NoError(t, err)
+	assert.Nil(t, encoding.TokenKinds)
+}
+
+func TestEncodeTimeout(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	tk.WithEncodeTimeout(time.Minute)
+	assert.Contains(t, tk.String(), "EncodeTimeout=1m0s")
+	encoding, err := tk.Encode("Brown fox")
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{2829, 4419}, encoding.TokenIds)
+	assert.Panics(t, func() { tk.WithEncodeTimeout(-time.Second) })
+}
+
+// TestConcurrentEncode should be run with `go test -race`.
+func TestConcurrentEncode(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+
+	const numWorkers, numIterations = 8, 50
+	var wg sync.WaitGroup
+	for worker := 0; worker < numWorkers; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ii := 0; ii < numIterations; ii++ {
+				encoding, err := tk.Encode("Brown fox")
+				if !assert.NoError(t, err) {
+					return
+				}
+				// Padding may or may not be set, depending on when the configuration changed.
+				assert.Contains(t, []int{2, 16}, len(encoding.TokenIds))
+				assert.Equal(t, []uint32{2829, 4419}, encoding.TokenIds[:2])
+				encodings, err := tk.EncodeBatch([]string{"brown fox", "lazy dog"})
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.Len(t, encodings, 2)
+				assert.Equal(t, "brown fox", tk.Decode(encoding.TokenIds[:2], true))
+			}
+		}()
+	}
+
+	// Concurrently change the configuration.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ii := 0; ii < numIterations; ii++ {
+			if ii%2 == 0 {
+				tk.WithPadToLength(16)
+			} else {
+				tk.WithNoPadding()
+			}
+			tk.ReturnTokens(ii%2 == 0).ReturnOffsets(ii%3 == 0)
+			_ = tk.String()
+		}
+	}()
+	wg.Wait()
+}
+
+func TestFromWordPieceVocab(t *testing.T) {
+	vocabPath := path.Join(t.TempDir(), "vocab.txt")
+	require.NoError(t, os.WriteFile(vocabPath, bertVocabTxt(t), 0644))
+	tk, err := tokenizers.FromWordPieceVocab(vocabPath, tokenizers.WordPieceOptions{LowerCase: true})
+	require.NoError(t, err)
+	defer tk.Finalize()
+	want, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer want.Finalize()
+	assert.Equal(t, want.VocabSize(), tk.VocabSize())
+
+	// Encodings match the ones of the equivalent `tokenizer.json`.
+	tk.AddSpecialTokens(true)
+	want.AddSpecialTokens(true)
+	for _, sentence := range []string{"Brown fox jumps over the lazy dog", "H\u00e9llo, w\u00f6rld! Tokenization"} {
+		got, err := tk.Encode(sentence)
+		require.NoError(t, err)
+		wantEncoding, err := want.Encode(sentence)
+		require.NoError(t, err)
+		assert.Equal(t, wantEncoding.TokenIds, got.TokenIds, "sentence %q", sentence)
+		assert.Equal(t, wantEncoding.Tokens, got.Tokens, "sentence %q", sentence)
+	}
+
+	// Missing file.
+	_, err = tokenizers.FromWordPieceVocab(path.Join(t.TempDir(), "missing.txt"), tokenizers.WordPieceOptions{})
+	require.Error(t, err)
+}
+
+func TestExportMinimal(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	tk.WithTruncation(5)
+	full, err := tk.ToBytes()
+	require.NoError(t, err)
+
+	// Encode only: the decoder is dropped, and it encodes identically.
+	data, err := tk.ExportMinimal(tokenizers.TaskEncode)
+	require.NoError(t, err)
+	assert.Less(t, len(data), len(full))
+	var fields map[string]any
+	require.NoError(t, json.Unmarshal(data, &fields))
+	assert.Nil(t, fields["decoder"])
+	assert.NotNil(t, fields["normalizer"])
+	minimal, err := tokenizers.FromBytes(data)
+	require.NoError(t, err)
+	defer minimal.Finalize()
+	for _, sentence := range []string{"brown fox jumps over the lazy dog", "H\u00e9llo, [MASK] world!"} {
+		want, err := tk.Encode(sentence)
+		require.NoError(t, err)
+		got, err := minimal.Encode(sentence)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	// Decode only.
+	data, err = tk.ExportMinimal(tokenizers.TaskDecode)
+	require.NoError(t, err)
+	minimal, err = tokenizers.FromBytes(data)
+	require.NoError(t, err)
+	defer minimal.Finalize()
+	ids := []uint32{2829, 4419, 14523, 2058, 1996, 13971, 3899}
+	assert.Equal(t, tk.Decode(ids, true), minimal.Decode(ids, true))
+
+	_, err = tk.ExportMinimal(tokenizers.Task(100))
+	require.Error(t, err)
+}
+
+func TestBenchmark(t *testing.T) {
+	tk, err := tokenizers.FromFile(bertJson)
+	require.NoError(t, err)
+	defer tk.Finalize()
+	const minDuration = 10 * time.Millisecond
+
+	inputs := []string{"brown fox jumps over the lazy dog", "the lazy dog sleeps"}
+	result := tokenizers.Benchmark(tk, inputs, minDuration)
+	require.NoError(t, result.Err)
+	t.Logf("Benchmark: %s", result)
+	assert.GreaterOrEqual(t, result.Encodes, len(inputs))
+	assert.Greater(t, result.Tokens, 0)
+	assert.GreaterOrEqual(t, result.Elapsed, minDuration)
+	assert.Greater(t, result.EncodesPerSec, 0.0)
+	assert.Greater(t, result.TokensPerSec, result.EncodesPerSec)
+	assert.Greater(t, result.AllocsPerEncode, 0.0)
+
+	assert.Equal(t, tokenizers.BenchmarkResult{}, tokenizers.Benchmark(tk, nil, 0))
+}
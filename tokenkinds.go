@@ -0,0 +1,84 @@
+package tokenizers
+
+import (
+	"encoding/json"
+	"github.com/gomlx/tokenizers/internal/rs"
+)
+
+// This file implements the TokenKinds of the Encoding, see Tokenizer.ReturnTokenKinds.
+
+// TokenKind is the kind of a token, see Encoding.TokenKinds: TokenKindNormal, TokenKindAdded or TokenKindSpecial.
+type TokenKind = rs.TokenKind
+
+const (
+	// TokenKindNormal is a token of the vocabulary of the model.
+	TokenKindNormal = rs.TokenKindNormal
+
+	// TokenKindAdded is a (non-special) token added to the vocabulary: in the "added_tokens" of the tokenizer
+	// definition, or with AddTokens.
+	TokenKindAdded = rs.TokenKindAdded
+
+	// TokenKindSpecial is a special token, e.g. "[CLS]", a padding token, or one added with
+	// AddSpecialTokensToVocab.
+	TokenKindSpecial = rs.TokenKindSpecial
+)
+
+// ReturnTokenKinds sets whether Encode (and EncodeBatch) should return the Encoding.TokenKinds, the kind of each
+// token: a token of the vocabulary of the model (TokenKindNormal), a token added to the vocabulary
+// (TokenKindAdded), or a special token (TokenKindSpecial). E.g. to render each kind differently.
+//
+// This is richer than the SpecialTokensMask, which it is derived from along with the "added_tokens" of the
+// tokenizer definition (see ToBytes). The tokens added later with AddTokens (or AddSpecialTokensToVocab) are
+// accounted for.
+// Default is false.
+//
+// It returns itself (the Tokenizer), to allow cascaded configuration calls.
+func (t *Tokenizer) ReturnTokenKinds(value bool) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	t.returnTokenKinds = value
+	if value {
+		t.addedTokenKinds = t.readAddedTokenKinds()
+	}
+	return t
+}
+
+// readAddedTokenKinds returns the kind of each of the "added_tokens" of the tokenizer definition, indexed by id.
+// It doesn't lock the Tokenizer, so it can be used while holding the lock.
+func (t *Tokenizer) readAddedTokenKinds() map[uint32]TokenKind {
+	data, err := t.toBytes()
+	if err != nil {
+		return nil
+	}
+	var definition struct {
+		AddedTokens []addedTokenJSON `json:"added_tokens"`
+	}
+	if err = json.Unmarshal(data, &definition); err != nil {
+		return nil
+	}
+	kinds := make(map[uint32]TokenKind, len(definition.AddedTokens))
+	for _, token := range definition.AddedTokens {
+		if token.Special {
+			kinds[uint32(token.Id)] = TokenKindSpecial
+		} else {
+			kinds[uint32(token.Id)] = TokenKindAdded
+		}
+	}
+	return kinds
+}
+
+// tokenKinds returns the TokenKinds of the encoding, derived from its TokenIds and SpecialTokensMask.
+func (t *Tokenizer) tokenKinds(encoding *Encoding) []TokenKind {
+	kinds := make([]TokenKind, len(encoding.TokenIds))
+	for ii, id := range encoding.TokenIds {
+		if ii < len(encoding.SpecialTokensMask) && encoding.SpecialTokensMask[ii] != 0 {
+			kinds[ii] = TokenKindSpecial
+		} else if kind, found := t.addedTokenKinds[id]; found {
+			kinds[ii] = kind
+		}
+	}
+	return kinds
+}
@@ -0,0 +1,108 @@
+package tokenizers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+)
+
+// This file implements the rejection of inputs that produce unknown tokens.
+
+// UnknownTokenError is returned by Encode (and EncodeBatch) when WithRejectUnknown is enabled and the input
+// produces an unknown token (e.g. "[UNK]").
+type UnknownTokenError struct {
+	// Text is the substring of the input that was mapped to the unknown token.
+	Text string
+
+	// Offset of Text in the input, in bytes or Unicode code points according to WithOffsetsCharMode.
+	Offset Offset
+}
+
+// Error implements the error interface.
+func (e *UnknownTokenError) Error() string {
+	return fmt.Sprintf("input %q (at offset %d to %d) maps to the unknown token", e.Text, e.Offset.Start, e.Offset.End)
+}
+
+// modelUnknownTokenId returns the id of the unknown token of the model, and whether the model has one.
+//
+// It doesn't lock the Tokenizer, so it can be used while holding the lock.
+func (t *Tokenizer) modelUnknownTokenId() (uint32, bool) {
+	data, err := t.toBytes()
+	if err != nil {
+		return 0, false
+	}
+	var definition struct {
+		Model struct {
+			UnkToken *string `json:"unk_token"` // WordPiece, BPE and WordLevel models.
+			UnkId    *uint32 `json:"unk_id"`    // Unigram models.
+		} `json:"model"`
+	}
+	if err = json.Unmarshal(data, &definition); err != nil {
+		return 0, false
+	}
+	if definition.Model.UnkId != nil {
+		return *definition.Model.UnkId, true
+	}
+	if definition.Model.UnkToken == nil {
+		return 0, false
+	}
+	ids, found := t.tokenizer.TokensToIds([]string{*definition.Model.UnkToken})
+	if len(ids) != 1 || !found[0] {
+		return 0, false
+	}
+	return ids[0], true
+}
+
+// WithRejectUnknown sets whether Encode (and EncodeBatch) should fail with an UnknownTokenError when the input
+// produces an unknown token (e.g. "[UNK]"), indicating out-of-vocabulary content.
+// It has no effect if the model doesn't have an unknown token (e.g. byte-level BPE models).
+// Default is false.
+//
+// It returns itself (the Tokenizer), to allow cascaded configuration calls.
+func (t *Tokenizer) WithRejectUnknown(value bool) *Tokenizer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	t.rejectUnknown = false
+	if value {
+		t.unknownTokenId, t.rejectUnknown = t.modelUnknownTokenId()
+	}
+	return t
+}
+
+// checkUnknown returns an UnknownTokenError if encoding of sentence has an unknown token, and rejectUnknown
+// is set.
+func (t *Tokenizer) checkUnknown(sentence string, encoding *Encoding) error {
+	if !t.rejectUnknown {
+		return nil
+	}
+	for ii, id := range encoding.TokenIds {
+		if id != t.unknownTokenId {
+			continue
+		}
+		offset := encoding.Offsets[ii]
+		start, end := int(offset.Start), int(offset.End)
+		if t.offsetsInChars() {
+			// Convert Unicode code points to bytes.
+			start, end = runeToByteIndex(sentence, start), runeToByteIndex(sentence, end)
+		}
+		end = min(end, len(sentence))
+		start = min(start, end)
+		return errors.WithStack(&UnknownTokenError{Text: sentence[start:end], Offset: offset})
+	}
+	return nil
+}
+
+// runeToByteIndex converts the index of a Unicode code point in s to its byte index.
+// It returns len(s) if runeIdx is past the end of s.
+func runeToByteIndex(s string, runeIdx int) int {
+	for byteIdx := range s {
+		if runeIdx == 0 {
+			return byteIdx
+		}
+		runeIdx--
+	}
+	return len(s)
+}
@@ -0,0 +1,134 @@
+package tokenizers
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"sort"
+)
+
+// This file implements the validation of the special tokens of a tokenizer definition.
+
+// FromBytesStrict is like FromBytes, but it also validates that every special token configured in the JSon
+// `data` (in the "added_tokens", the "padding" and the "post_processor" sections) resolves to a valid id of
+// the vocabulary, and that the id matches.
+//
+// This catches at load time corrupted or mismatched definitions (e.g. a `[PAD]` id out of range), that would
+// otherwise silently produce wrong encodings. It returns a descriptive error for the first mismatch found.
+func FromBytesStrict(data []byte) (*Tokenizer, error) {
+	data = trimTokenizerJSON(data)
+	t, err := FromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	if err = t.validateSpecialTokens(data); err != nil {
+		t.Finalize()
+		return nil, errors.WithMessage(err, "Tokenizer.FromBytesStrict(<json-data>):")
+	}
+	return t, nil
+}
+
+// specialTokenRef is a reference to a special token, with its expected id, found in a tokenizer definition.
+type specialTokenRef struct {
+	token, source string
+	id            uint32
+}
+
+// validateSpecialTokens checks that the special tokens referenced in the tokenizer definition in data resolve
+// to the ids they are configured with.
+func (t *Tokenizer) validateSpecialTokens(data []byte) error {
+	var definition struct {
+		AddedTokens []struct {
+			Id      uint32 `json:"id"`
+			Content string `json:"content"`
+			Special bool   `json:"special"`
+		} `json:"added_tokens"`
+		Padding *struct {
+			PadId    uint32 `json:"pad_id"`
+			PadToken string `json:"pad_token"`
+		} `json:"padding"`
+		PostProcessor any `json:"post_processor"`
+	}
+	if err := json.Unmarshal(data, &definition); err != nil {
+		return errors.Wrap(err, "failed to parse tokenizer definition")
+	}
+	var refs []specialTokenRef
+	for _, added := range definition.AddedTokens {
+		if added.Special {
+			refs = append(refs, specialTokenRef{token: added.Content, id: added.Id, source: "added_tokens"})
+		}
+	}
+	if definition.Padding != nil {
+		refs = append(refs, specialTokenRef{token: definition.Padding.PadToken, id: definition.Padding.PadId, source: "padding"})
+	}
+	refs = append(refs, postProcessorSpecialTokens(definition.PostProcessor)...)
+	if len(refs) == 0 {
+		return nil
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	vocabSize := t.tokenizer.VocabSize()
+	tokens := make([]string, len(refs))
+	for ii, ref := range refs {
+		tokens[ii] = ref.token
+	}
+	ids, found := t.tokenizer.TokensToIds(tokens)
+	for ii, ref := range refs {
+		switch {
+		case ref.id >= vocabSize:
+			return errors.Errorf("special token %q in %q has id %d, out of range of the vocabulary (size %d)",
+				ref.token, ref.source, ref.id, vocabSize)
+		case !found[ii]:
+			return errors.Errorf("special token %q in %q (id %d) is not in the vocabulary", ref.token, ref.source, ref.id)
+		case ids[ii] != ref.id:
+			return errors.Errorf("special token %q in %q has id %d, but the vocabulary maps it to id %d",
+				ref.token, ref.source, ref.id, ids[ii])
+		}
+	}
+	return nil
+}
+
+// postProcessorSpecialTokens returns the special tokens referenced by a post-processor (and its sub-processors)
+// parsed from JSon: the "cls" and "sep" of BertProcessing and RobertaProcessing, and the "special_tokens" of
+// TemplateProcessing.
+func postProcessorSpecialTokens(processor any) []specialTokenRef {
+	var refs []specialTokenRef
+	switch p := processor.(type) {
+	case map[string]any:
+		for _, key := range []string{"cls", "sep"} {
+			// Given as a [token, id] pair.
+			if pair, ok := p[key].([]any); ok && len(pair) == 2 {
+				token, tokenOk := pair[0].(string)
+				id, idOk := pair[1].(float64)
+				if tokenOk && idOk {
+					refs = append(refs, specialTokenRef{token: token, id: uint32(id), source: "post_processor"})
+				}
+			}
+		}
+		if specialTokens, ok := p["special_tokens"].(map[string]any); ok {
+			names := make([]string, 0, len(specialTokens))
+			for name := range specialTokens {
+				names = append(names, name)
+			}
+			sort.Strings(names) // Deterministic order of validation.
+			for _, name := range names {
+				spec, _ := specialTokens[name].(map[string]any)
+				ids, _ := spec["ids"].([]any)
+				tokens, _ := spec["tokens"].([]any)
+				for ii := 0; ii < min(len(ids), len(tokens)); ii++ {
+					token, tokenOk := tokens[ii].(string)
+					id, idOk := ids[ii].(float64)
+					if tokenOk && idOk {
+						refs = append(refs, specialTokenRef{token: token, id: uint32(id), source: "post_processor"})
+					}
+				}
+			}
+		}
+		if processors, ok := p["processors"].([]any); ok {
+			for _, subProcessor := range processors {
+				refs = append(refs, postProcessorSpecialTokens(subProcessor)...)
+			}
+		}
+	}
+	return refs
+}
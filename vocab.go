@@ -0,0 +1,128 @@
+package tokenizers
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/gomlx/tokenizers/internal/rs"
+	"github.com/pkg/errors"
+	"io"
+)
+
+// This file holds the changes to, and the export of, the vocabulary of the Tokenizer.
+
+// AddedTokenSpec defines a token to be added to the vocabulary with Tokenizer.AddTokensWithSpecs, along with
+// its options:
+//
+//   - Content: the token string.
+//   - SingleWord: the token only matches whole words, so it is never matched inside another word.
+//   - Normalized: the token is matched against the normalized input (e.g. lower-cased), as opposed to the
+//     original input.
+type AddedTokenSpec = rs.AddedTokenSpec
+
+// AddTokens adds the tokens to the vocabulary, with the default options used by HuggingFace Transformers
+// (matched anywhere in the normalized input). Tokens already in the vocabulary are not added again.
+// The added tokens are matched in the input before the model, so they are always encoded as one token.
+//
+// It returns the number of tokens actually added. See AddTokensWithSpecs to configure how each token is matched.
+//
+// It takes a write lock, so it waits for any encoding or decoding in progress (in other goroutines) to finish.
+func (t *Tokenizer) AddTokens(tokens []string) int {
+	specs := make([]AddedTokenSpec, len(tokens))
+	for ii, token := range tokens {
+		specs[ii] = AddedTokenSpec{Content: token, Normalized: true}
+	}
+	return t.AddTokensWithSpecs(specs)
+}
+
+// AddTokensWithSpecs is like AddTokens, but each token has its own options, see AddedTokenSpec.
+//
+// For instance, a token with SingleWord set will not match inside another word. Notice the zero value of
+// Normalized is false: set it to match the token against the normalized input, as AddTokens does.
+func (t *Tokenizer) AddTokensWithSpecs(specs []AddedTokenSpec) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	added := t.tokenizer.AddTokens(specs, false)
+	if t.returnTokenKinds {
+		t.addedTokenKinds = t.readAddedTokenKinds()
+	}
+	return added
+}
+
+// AddSpecialTokensToVocab adds the tokens to the vocabulary as special tokens, e.g. the `<user>` and `<assistant>`
+// markers of a chat model. Tokens already in the vocabulary are not added again. (It is not named
+// AddSpecialTokens because that configures whether special tokens are added to the encodings.)
+//
+// As with the special tokens of the original vocabulary, they are matched in the original (not normalized) input,
+// marked in Encoding.SpecialTokensMask, and skipped by Decode if `skipSpecialTokens` is set.
+//
+// It returns the number of tokens actually added.
+//
+// It takes a write lock, so it waits for any encoding or decoding in progress (in other goroutines) to finish.
+func (t *Tokenizer) AddSpecialTokensToVocab(tokens []string) int {
+	specs := make([]AddedTokenSpec, len(tokens))
+	for ii, token := range tokens {
+		specs[ii] = AddedTokenSpec{Content: token}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	added := t.tokenizer.AddTokens(specs, true)
+	if t.returnTokenKinds {
+		t.addedTokenKinds = t.readAddedTokenKinds()
+	}
+	return added
+}
+
+// VocabFormat is the format used by Tokenizer.WriteVocab.
+type VocabFormat uint8
+
+const (
+	// VocabFormatTSV writes one line per token, with the token and its id separated by a tab ("token\tid"),
+	// ordered by id.
+	VocabFormatTSV VocabFormat = iota
+
+	// VocabFormatTxt writes one token per line, ordered by id, so the line number (starting from 0) is the id.
+	// It's the classic WordPiece `vocab.txt` format.
+	VocabFormatTxt
+)
+
+// WriteVocab writes the vocabulary of the Tokenizer, including added tokens, to w in the given format.
+//
+// Ids not assigned to any token are skipped in VocabFormatTSV, and written as empty lines in VocabFormatTxt, so
+// the line numbers still match the ids.
+func (t *Tokenizer) WriteVocab(w io.Writer, format VocabFormat) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tokenizer == nil {
+		panicf("Tokenizer already finalized, one cannot change or use it any longer")
+	}
+	if format != VocabFormatTSV && format != VocabFormatTxt {
+		return errors.Errorf("Tokenizer.WriteVocab(): invalid format %s", format)
+	}
+	ids := make([]uint32, t.tokenizer.VocabSize())
+	for ii := range ids {
+		ids[ii] = uint32(ii)
+	}
+	tokens := t.tokenizer.IdsToTokens(ids)
+	buf := bufio.NewWriter(w)
+	for id, token := range tokens {
+		var err error
+		if format == VocabFormatTxt {
+			_, err = fmt.Fprintln(buf, token)
+		} else if token != "" {
+			_, err = fmt.Fprintf(buf, "%s\t%d\n", token, id)
+		}
+		if err != nil {
+			return errors.Wrap(err, "Tokenizer.WriteVocab()")
+		}
+	}
+	if err := buf.Flush(); err != nil {
+		return errors.Wrap(err, "Tokenizer.WriteVocab()")
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package tokenizers
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"os"
+	"sort"
+	"strings"
+)
+
+// This file builds tokenizer definitions (in the `tokenizer.json` format) from the vocabulary files used by
+// the older "slow" tokenizers of HuggingFace Transformers, for repositories that don't include a
+// `tokenizer.json`.
+
+// Filenames of the vocabulary files of the "slow" tokenizers.
+const (
+	wordPieceVocabFileName = "vocab.txt"
+	bpeVocabFileName       = "vocab.json"
+	bpeMergesFileName      = "merges.txt"
+)
+
+// addedTokenJSON is an entry of the "added_tokens" of a `tokenizer.json` file.
+type addedTokenJSON struct {
+	Id         int    `json:"id"`
+	Content    string `json:"content"`
+	SingleWord bool   `json:"single_word"`
+	LStrip     bool   `json:"lstrip"`
+	RStrip     bool   `json:"rstrip"`
+	Normalized bool   `json:"normalized"`
+	Special    bool   `json:"special"`
+}
+
+// specialAddedTokens returns the added tokens entries for the given special tokens that are in the vocabulary.
+// Empty and repeated tokens are ignored.
+func specialAddedTokens(vocab map[string]int, tokens ...string) []addedTokenJSON {
+	addedTokens := []addedTokenJSON{}
+	seen := make(map[string]bool)
+	for _, token := range tokens {
+		id, found := vocab[token]
+		if token == "" || !found || seen[token] {
+			continue
+		}
+		seen[token] = true
+		addedTokens = append(addedTokens, addedTokenJSON{Id: id, Content: token, Special: true})
+	}
+	return addedTokens
+}
+
+// stringOr returns value, if it is set, or defaultValue otherwise.
+func stringOr(value, defaultValue string) string {
+	if value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// WordPieceOptions configures the WordPiece tokenizer built by FromWordPieceVocab. The zero value uses the
+// defaults of BERT (except for LowerCase).
+type WordPieceOptions struct {
+	// LowerCase the input (and strip accents) before tokenizing, as in the "uncased" BERT models.
+	LowerCase bool
+
+	// Special tokens. If empty, the BERT defaults ("[UNK]", "[CLS]", "[SEP]", "[PAD]" and "[MASK]") are used.
+	// The classifier (CLS) and separator (SEP) tokens are added around the sentences (if AddSpecialTokens is
+	// set), if they are in the vocabulary.
+	UnkToken, ClsToken, SepToken, PadToken, MaskToken string
+
+	// ContinuingSubwordPrefix marks subwords that continue a word. Default is "##".
+	ContinuingSubwordPrefix string
+
+	// MaxInputCharsPerWord is the maximum length of a word, longer words are mapped to the unknown token.
+	// Default is 100.
+	MaxInputCharsPerWord int
+}
+
+// wordPieceOptionsFromConfig returns the WordPieceOptions for the configuration read from
+// `tokenizer_config.json`.
+func wordPieceOptionsFromConfig(config *TokenizerConfig) WordPieceOptions {
+	if config == nil {
+		return WordPieceOptions{LowerCase: true}
+	}
+	return WordPieceOptions{
+		LowerCase: config.DoLowerCase,
+		UnkToken:  config.UnkToken,
+		ClsToken:  config.ClsToken,
+		SepToken:  config.SepToken,
+		PadToken:  config.PadToken,
+		MaskToken: config.MaskToken,
+	}
+}
+
+// FromWordPieceVocab builds a BERT-like WordPiece tokenizer from the `vocab.txt` file in vocabPath, as used by
+// the older "slow" tokenizers of HuggingFace Transformers, with one token per line, the line number being its
+// id.
+//
+// It uses the standard BERT normalizer, pre-tokenizer, post-processor and decoder, configured by opts.
+func FromWordPieceVocab(vocabPath string, opts WordPieceOptions) (*Tokenizer, error) {
+	vocabTxt, err := os.ReadFile(vocabPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't read WordPiece vocabulary file:")
+	}
+	data, err := wordPieceTokenizerJSON(vocabTxt, opts)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "FromWordPieceVocab(%q)", vocabPath)
+	}
+	return FromBytes(data)
+}
+
+// wordPieceTokenizerJSON builds a BERT-like WordPiece tokenizer definition from the contents of a `vocab.txt`
+// file, configured by opts.
+func wordPieceTokenizerJSON(vocabTxt []byte, opts WordPieceOptions) ([]byte, error) {
+	vocab := make(map[string]int)
+	for id, line := range strings.Split(string(vocabTxt), "\n") {
+		token := strings.TrimSuffix(line, "\r")
+		if token == "" {
+			continue
+		}
+		if _, found := vocab[token]; !found {
+			vocab[token] = id
+		}
+	}
+	if len(vocab) == 0 {
+		return nil, errors.Errorf("empty WordPiece vocabulary in %q", wordPieceVocabFileName)
+	}
+	unkToken := stringOr(opts.UnkToken, "[UNK]")
+	clsToken := stringOr(opts.ClsToken, "[CLS]")
+	sepToken := stringOr(opts.SepToken, "[SEP]")
+	padToken := stringOr(opts.PadToken, "[PAD]")
+	maskToken := stringOr(opts.MaskToken, "[MASK]")
+	prefix := stringOr(opts.ContinuingSubwordPrefix, "##")
+	maxInputCharsPerWord := opts.MaxInputCharsPerWord
+	if maxInputCharsPerWord <= 0 {
+		maxInputCharsPerWord = 100
+	}
+
+	var postProcessor any
+	clsId, clsFound := vocab[clsToken]
+	sepId, sepFound := vocab[sepToken]
+	if clsFound && sepFound {
+		postProcessor = map[string]any{
+			"type": "BertProcessing",
+			"sep":  []any{sepToken, sepId},
+			"cls":  []any{clsToken, clsId},
+		}
+	}
+	definition := map[string]any{
+		"version":      "1.0",
+		"truncation":   nil,
+		"padding":      nil,
+		"added_tokens": specialAddedTokens(vocab, padToken, unkToken, clsToken, sepToken, maskToken),
+		"normalizer": map[string]any{
+			"type":                 "BertNormalizer",
+			"clean_text":           true,
+			"handle_chinese_chars": true,
+			"strip_accents":        nil,
+			"lowercase":            opts.LowerCase,
+		},
+		"pre_tokenizer":  map[string]any{"type": "BertPreTokenizer"},
+		"post_processor": postProcessor,
+		"decoder":        map[string]any{"type": "WordPiece", "prefix": prefix, "cleanup": true},
+		"model": map[string]any{
+			"type":                      "WordPiece",
+			"unk_token":                 unkToken,
+			"continuing_subword_prefix": prefix,
+			"max_input_chars_per_word":  maxInputCharsPerWord,
+			"vocab":                     vocab,
+		},
+	}
+	return json.Marshal(definition)
+}
+
+// bpeTokenizerJSON builds a GPT-2 like byte-level BPE tokenizer definition from the contents of a `vocab.json`
+// file (mapping tokens to ids) and a `merges.txt` file (one merge per line). The special tokens are taken from
+// the config, if not nil.
+func bpeTokenizerJSON(vocabJSON, mergesTxt []byte, config *TokenizerConfig) ([]byte, error) {
+	var vocab map[string]int
+	if err := json.Unmarshal(vocabJSON, &vocab); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse BPE vocabulary in %q", bpeVocabFileName)
+	}
+	merges := []string{}
+	for ii, line := range bytes.Split(mergesTxt, []byte("\n")) {
+		merge := strings.TrimSuffix(string(line), "\r")
+		if merge == "" || (ii == 0 && strings.HasPrefix(merge, "#version")) {
+			continue
+		}
+		merges = append(merges, merge)
+	}
+	if config == nil {
+		config = &TokenizerConfig{}
+	}
+	var unkToken any
+	if _, found := vocab[config.UnkToken]; found {
+		unkToken = config.UnkToken
+	}
+	byteLevel := map[string]any{"type": "ByteLevel", "add_prefix_space": false, "trim_offsets": true, "use_regex": true}
+	definition := map[string]any{
+		"version":       "1.0",
+		"truncation":    nil,
+		"padding":       nil,
+		"added_tokens":  specialAddedTokens(vocab, config.BosToken, config.EosToken, config.UnkToken, config.PadToken),
+		"normalizer":    nil,
+		"pre_tokenizer": byteLevel,
+		"post_processor": map[string]any{
+			"type": "ByteLevel", "add_prefix_space": true, "trim_offsets": false, "use_regex": true},
+		"decoder": byteLevel,
+		"model": map[string]any{
+			"type":                      "BPE",
+			"dropout":                   nil,
+			"unk_token":                 unkToken,
+			"continuing_subword_prefix": nil,
+			"end_of_word_suffix":        nil,
+			"fuse_unk":                  false,
+			"byte_fallback":             false,
+			"vocab":                     vocab,
+			"merges":                    merges,
+		},
+	}
+	return json.Marshal(definition)
+}
+
+// addVocabFilesAddedTokens adds to the Tokenizer, built from the vocabulary files, the tokens listed in the
+// contents of an `added_tokens.json` file (a map of token to id), in the order of their ids. The tokens that are
+// special tokens of the config (including the additional ones) are added as special tokens. Finally, the
+// additional special tokens of the config are registered as special tokens, if they are not yet.
+//
+// As in HuggingFace Transformers, the ids must follow the vocabulary, and it returns an error if they don't.
+// addedTokensJSON may be nil, if there is no `added_tokens.json`.
+func (t *Tokenizer) addVocabFilesAddedTokens(addedTokensJSON []byte, config *TokenizerConfig) error {
+	isSpecial := make(map[string]bool)
+	if config != nil {
+		for _, token := range []string{config.BosToken, config.EosToken, config.UnkToken, config.SepToken,
+			config.PadToken, config.ClsToken, config.MaskToken} {
+			isSpecial[token] = true
+		}
+		for _, token := range config.AdditionalSpecialTokens {
+			isSpecial[token] = true
+		}
+	}
+
+	if addedTokensJSON != nil {
+		var addedTokens map[string]uint32
+		if err := json.Unmarshal(addedTokensJSON, &addedTokens); err != nil {
+			return errors.Wrapf(err, "failed to parse %q", addedTokensFileName)
+		}
+		tokens := make([]string, 0, len(addedTokens))
+		for token := range addedTokens {
+			tokens = append(tokens, token)
+		}
+		sort.Slice(tokens, func(i, j int) bool { return addedTokens[tokens[i]] < addedTokens[tokens[j]] })
+		for _, token := range tokens {
+			wantId := addedTokens[token]
+			if id, found := t.tokenizer.TokenToId(token); found {
+				if id != wantId {
+					return errors.Errorf("token %q has id %d in %q, but it is already in the vocabulary with id %d",
+						token, wantId, addedTokensFileName, id)
+				}
+				continue
+			}
+			t.tokenizer.AddTokens([]AddedTokenSpec{{Content: token, Normalized: !isSpecial[token]}}, isSpecial[token])
+			if id, _ := t.tokenizer.TokenToId(token); id != wantId {
+				return errors.Errorf("token %q has id %d in %q, but it was added with id %d: the ids of the "+
+					"added tokens must follow the vocabulary", token, wantId, addedTokensFileName, id)
+			}
+		}
+	}
+
+	if config != nil && len(config.AdditionalSpecialTokens) > 0 {
+		specs := make([]AddedTokenSpec, len(config.AdditionalSpecialTokens))
+		for ii, token := range config.AdditionalSpecialTokens {
+			specs[ii] = AddedTokenSpec{Content: token}
+		}
+		t.tokenizer.AddTokens(specs, true)
+	}
+	return nil
+}